@@ -978,6 +978,551 @@ func (t *AlphaFeedPlay) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
+func (t *AlphaFeedEpisode) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+	fieldCount := 9
+
+	if t.Duration == nil {
+		fieldCount--
+	}
+
+	if t.MusicServiceBaseDomain == nil {
+		fieldCount--
+	}
+
+	if t.OriginUrl == nil {
+		fieldCount--
+	}
+
+	if t.PlayedTime == nil {
+		fieldCount--
+	}
+
+	if t.Publisher == nil {
+		fieldCount--
+	}
+
+	if t.ShowName == nil {
+		fieldCount--
+	}
+
+	if t.SubmissionClientAgent == nil {
+		fieldCount--
+	}
+
+	if _, err := cw.Write(cbg.CborEncodeMajorType(cbg.MajMap, uint64(fieldCount))); err != nil {
+		return err
+	}
+
+	// t.LexiconTypeID (string) (string)
+	if len("$type") > 1000000 {
+		return xerrors.Errorf("Value in field \"$type\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("$type"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("$type")); err != nil {
+		return err
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("fm.teal.alpha.feed.episode"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("fm.teal.alpha.feed.episode")); err != nil {
+		return err
+	}
+
+	// t.Duration (int64) (int64)
+	if t.Duration != nil {
+
+		if len("duration") > 1000000 {
+			return xerrors.Errorf("Value in field \"duration\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("duration"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("duration")); err != nil {
+			return err
+		}
+
+		if t.Duration == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if *t.Duration >= 0 {
+				if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(*t.Duration)); err != nil {
+					return err
+				}
+			} else {
+				if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-*t.Duration-1)); err != nil {
+					return err
+				}
+			}
+		}
+
+	}
+
+	// t.ShowName (string) (string)
+	if t.ShowName != nil {
+
+		if len("showName") > 1000000 {
+			return xerrors.Errorf("Value in field \"showName\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("showName"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("showName")); err != nil {
+			return err
+		}
+
+		if t.ShowName == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.ShowName) > 1000000 {
+				return xerrors.Errorf("Value in field t.ShowName was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.ShowName))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.ShowName)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// t.OriginUrl (string) (string)
+	if t.OriginUrl != nil {
+
+		if len("originUrl") > 1000000 {
+			return xerrors.Errorf("Value in field \"originUrl\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("originUrl"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("originUrl")); err != nil {
+			return err
+		}
+
+		if t.OriginUrl == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.OriginUrl) > 1000000 {
+				return xerrors.Errorf("Value in field t.OriginUrl was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.OriginUrl))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.OriginUrl)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// t.Publisher (string) (string)
+	if t.Publisher != nil {
+
+		if len("publisher") > 1000000 {
+			return xerrors.Errorf("Value in field \"publisher\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("publisher"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("publisher")); err != nil {
+			return err
+		}
+
+		if t.Publisher == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.Publisher) > 1000000 {
+				return xerrors.Errorf("Value in field t.Publisher was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.Publisher))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.Publisher)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// t.PlayedTime (string) (string)
+	if t.PlayedTime != nil {
+
+		if len("playedTime") > 1000000 {
+			return xerrors.Errorf("Value in field \"playedTime\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("playedTime"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("playedTime")); err != nil {
+			return err
+		}
+
+		if t.PlayedTime == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.PlayedTime) > 1000000 {
+				return xerrors.Errorf("Value in field t.PlayedTime was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.PlayedTime))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.PlayedTime)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// t.EpisodeName (string) (string)
+	if len("episodeName") > 1000000 {
+		return xerrors.Errorf("Value in field \"episodeName\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("episodeName"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("episodeName")); err != nil {
+		return err
+	}
+
+	if len(t.EpisodeName) > 1000000 {
+		return xerrors.Errorf("Value in field t.EpisodeName was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(t.EpisodeName))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string(t.EpisodeName)); err != nil {
+		return err
+	}
+
+	// t.SubmissionClientAgent (string) (string)
+	if t.SubmissionClientAgent != nil {
+
+		if len("submissionClientAgent") > 1000000 {
+			return xerrors.Errorf("Value in field \"submissionClientAgent\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("submissionClientAgent"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("submissionClientAgent")); err != nil {
+			return err
+		}
+
+		if t.SubmissionClientAgent == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.SubmissionClientAgent) > 1000000 {
+				return xerrors.Errorf("Value in field t.SubmissionClientAgent was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.SubmissionClientAgent))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.SubmissionClientAgent)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// t.MusicServiceBaseDomain (string) (string)
+	if t.MusicServiceBaseDomain != nil {
+
+		if len("musicServiceBaseDomain") > 1000000 {
+			return xerrors.Errorf("Value in field \"musicServiceBaseDomain\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("musicServiceBaseDomain"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("musicServiceBaseDomain")); err != nil {
+			return err
+		}
+
+		if t.MusicServiceBaseDomain == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.MusicServiceBaseDomain) > 1000000 {
+				return xerrors.Errorf("Value in field t.MusicServiceBaseDomain was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.MusicServiceBaseDomain))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.MusicServiceBaseDomain)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *AlphaFeedEpisode) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = AlphaFeedEpisode{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("AlphaFeedEpisode: map struct too large (%d)", extra)
+	}
+
+	n := extra
+
+	nameBuf := make([]byte, 22)
+	for i := uint64(0); i < n; i++ {
+		nameLen, ok, err := cbg.ReadFullStringIntoBuf(cr, nameBuf, 1000000)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			// Field doesn't exist on this type, so ignore it
+			if err := cbg.ScanForLinks(cr, func(cid.Cid) {}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch string(nameBuf[:nameLen]) {
+		// t.LexiconTypeID (string) (string)
+		case "$type":
+
+			{
+				sval, err := cbg.ReadStringWithMax(cr, 1000000)
+				if err != nil {
+					return err
+				}
+
+				t.LexiconTypeID = string(sval)
+			}
+			// t.Duration (int64) (int64)
+		case "duration":
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					maj, extra, err := cr.ReadHeader()
+					if err != nil {
+						return err
+					}
+					var extraI int64
+					switch maj {
+					case cbg.MajUnsignedInt:
+						extraI = int64(extra)
+						if extraI < 0 {
+							return fmt.Errorf("int64 positive overflow")
+						}
+					case cbg.MajNegativeInt:
+						extraI = int64(extra)
+						if extraI < 0 {
+							return fmt.Errorf("int64 negative overflow")
+						}
+						extraI = -1 - extraI
+					default:
+						return fmt.Errorf("wrong type for int64 field: %d", maj)
+					}
+
+					t.Duration = (*int64)(&extraI)
+				}
+			}
+			// t.ShowName (string) (string)
+		case "showName":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.ShowName = (*string)(&sval)
+				}
+			}
+			// t.OriginUrl (string) (string)
+		case "originUrl":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.OriginUrl = (*string)(&sval)
+				}
+			}
+			// t.Publisher (string) (string)
+		case "publisher":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.Publisher = (*string)(&sval)
+				}
+			}
+			// t.PlayedTime (string) (string)
+		case "playedTime":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.PlayedTime = (*string)(&sval)
+				}
+			}
+			// t.EpisodeName (string) (string)
+		case "episodeName":
+
+			{
+				sval, err := cbg.ReadStringWithMax(cr, 1000000)
+				if err != nil {
+					return err
+				}
+
+				t.EpisodeName = string(sval)
+			}
+			// t.SubmissionClientAgent (string) (string)
+		case "submissionClientAgent":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.SubmissionClientAgent = (*string)(&sval)
+				}
+			}
+			// t.MusicServiceBaseDomain (string) (string)
+		case "musicServiceBaseDomain":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.MusicServiceBaseDomain = (*string)(&sval)
+				}
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			if err := cbg.ScanForLinks(r, func(cid.Cid) {}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
 func (t *AlphaActorProfile) MarshalCBOR(w io.Writer) error {
 	if t == nil {
 		_, err := w.Write(cbg.CborNull)
@@ -0,0 +1,32 @@
+// Code generated by cmd/lexgen (see Makefile's lexgen); DO NOT EDIT.
+
+package teal
+
+import "github.com/bluesky-social/indigo/lex/util"
+
+// schema: fm.teal.alpha.feed.episode
+
+// RECORDTYPE: AlphaFeedEpisode
+type AlphaFeedEpisode struct {
+	LexiconTypeID string `json:"$type,const=fm.teal.alpha.feed.episode" cborgen:"$type,const=fm.teal.alpha.feed.episode"`
+	// duration: The length of the episode in seconds
+	Duration *int64 `json:"duration,omitempty" cborgen:"duration,omitempty"`
+	// episodeName: The title of the episode
+	EpisodeName string `json:"episodeName" cborgen:"episodeName"`
+	// musicServiceBaseDomain: The base domain of the service it was played on. e.g. spotify.com. Defaults to 'local' if unavailable or not provided.
+	MusicServiceBaseDomain *string `json:"musicServiceBaseDomain,omitempty" cborgen:"musicServiceBaseDomain,omitempty"`
+	// originUrl: The URL associated with this episode
+	OriginUrl *string `json:"originUrl,omitempty" cborgen:"originUrl,omitempty"`
+	// playedTime: The unix timestamp of when the episode was played
+	PlayedTime *string `json:"playedTime,omitempty" cborgen:"playedTime,omitempty"`
+	// publisher: Who publishes the show
+	Publisher *string `json:"publisher,omitempty" cborgen:"publisher,omitempty"`
+	// showName: The name of the show the episode is part of
+	ShowName *string `json:"showName,omitempty" cborgen:"showName,omitempty"`
+	// submissionClientAgent: A metadata string specifying the user agent where the format is `<app-identifier>/<version> (<kernel/OS-base>; <platform/OS-version>; <device-model>)`. If string is provided, only `app-identifier` and `version` are required. `app-identifier` is recommended to be in reverse dns format. Defaults to 'manual/unknown' if unavailable or not provided.
+	SubmissionClientAgent *string `json:"submissionClientAgent,omitempty" cborgen:"submissionClientAgent,omitempty"`
+}
+
+func init() {
+	util.RegisterType("fm.teal.alpha.feed.episode", &AlphaFeedEpisode{})
+}
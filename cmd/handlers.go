@@ -1,28 +1,36 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/viper"
 	"github.com/teal-fm/piper/db"
 	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
 	"github.com/teal-fm/piper/pages"
 	"github.com/teal-fm/piper/service/applemusic"
 	atprotoservice "github.com/teal-fm/piper/service/atproto"
+	"github.com/teal-fm/piper/service/events"
 	"github.com/teal-fm/piper/service/musicbrainz"
 	"github.com/teal-fm/piper/service/playingnow"
 	"github.com/teal-fm/piper/service/spotify"
+	"github.com/teal-fm/piper/service/stamping"
 	"github.com/teal-fm/piper/session"
 )
 
 type HomeParams struct {
 	NavBar pages.NavBar
+	// Connections how polling each linked provider is going
+	Connections []*models.ConnectionStatus
 }
 
 func home(database *db.DB, pg *pages.Pages) http.HandlerFunc {
@@ -33,17 +41,22 @@ func home(database *db.DB, pg *pages.Pages) http.HandlerFunc {
 		userID, authenticated := session.GetUserID(r.Context())
 		isLoggedIn := authenticated
 		lastfmUsername := ""
+		var connections []*models.ConnectionStatus
 
 		if isLoggedIn {
 			user, err := database.GetUserByID(userID)
-			fmt.Printf("User: %+v\n", user)
 			if err == nil && user != nil && user.LastFMUsername != nil {
 				lastfmUsername = *user.LastFMUsername
 			} else if err != nil {
-				log.Printf("Error fetching user %d details for home page: %v", userID, err)
+				slog.ErrorContext(r.Context(), "error fetching user details for home page", logging.UserID(userID), logging.Err(err))
+			}
+			connections, err = database.GetUserConnectionStatuses(userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "error fetching connection statuses for home page", logging.UserID(userID), logging.Err(err))
 			}
 		}
 		params := HomeParams{
+			Connections: connections,
 			NavBar: pages.NavBar{
 				IsLoggedIn:        isLoggedIn,
 				LastFMUsername:    lastfmUsername,
@@ -54,7 +67,7 @@ func home(database *db.DB, pg *pages.Pages) http.HandlerFunc {
 		}
 		err := pg.Execute("home", w, params)
 		if err != nil {
-			log.Printf("Error executing template: %v", err)
+			slog.ErrorContext(r.Context(), "error executing template", logging.Err(err))
 		}
 	}
 }
@@ -76,14 +89,16 @@ func handleLinkLastfmForm(database *db.DB, pg *pages.Pages) http.HandlerFunc {
 
 			err := database.AddLastFMUsername(userID, lastfmUsername)
 			if err != nil {
-				log.Printf("Error saving Last.fm username for user %d: %v", userID, err)
+				slog.ErrorContext(r.Context(), "error saving Last.fm username", logging.UserID(userID), logging.Err(err))
 				http.Error(w, "Failed to save Last.fm username", http.StatusInternalServerError)
 				return
 			}
 
-			log.Printf("Successfully linked Last.fm username '%s' for user ID %d", lastfmUsername, userID)
+			slog.InfoContext(r.Context(), "linked Last.fm username", logging.UserID(userID), "lastfm_user", lastfmUsername)
+			resetConnection(r.Context(), database, userID, events.SourceLastFM)
 
 			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
 		}
 
 		currentUser, err := database.GetUserByID(userID)
@@ -91,7 +106,7 @@ func handleLinkLastfmForm(database *db.DB, pg *pages.Pages) http.HandlerFunc {
 		if err == nil && currentUser != nil && currentUser.LastFMUsername != nil {
 			currentUsername = *currentUser.LastFMUsername
 		} else if err != nil {
-			log.Printf("Error fetching user %d for Last.fm form: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error fetching user for Last.fm form", logging.UserID(userID), logging.Err(err))
 			// Don't fail, just show an empty form
 		}
 
@@ -112,7 +127,7 @@ func handleLinkLastfmForm(database *db.DB, pg *pages.Pages) http.HandlerFunc {
 		}
 		err = pg.Execute("lastFMForm", w, pageParams)
 		if err != nil {
-			log.Printf("Error executing template: %v", err)
+			slog.ErrorContext(r.Context(), "error executing template", logging.Err(err))
 		}
 	}
 }
@@ -134,40 +149,60 @@ func handleLinkLastfmSubmit(database *db.DB) http.HandlerFunc {
 
 		err := database.AddLastFMUsername(userID, lastfmUsername)
 		if err != nil {
-			log.Printf("Error saving Last.fm username for user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error saving Last.fm username", logging.UserID(userID), logging.Err(err))
 			http.Error(w, "Failed to save Last.fm username", http.StatusInternalServerError)
 			return
 		}
 
-		log.Printf("Successfully linked Last.fm username '%s' for user ID %d", lastfmUsername, userID)
+		slog.InfoContext(r.Context(), "linked Last.fm username", logging.UserID(userID), "lastfm_user", lastfmUsername)
+		resetConnection(r.Context(), database, userID, events.SourceLastFM)
 
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
-func handleAppleMusicLink(pg *pages.Pages, am *applemusic.Service) http.HandlerFunc {
+// resetConnection forgets a provider's connection status once the user links or
+// unlinks it, so polling resumes straight away
+func resetConnection(ctx context.Context, database *db.DB, userID int64, provider string) {
+	if err := database.DeleteConnectionStatus(userID, provider); err != nil {
+		slog.ErrorContext(ctx, "error resetting connection status", logging.UserID(userID), "provider", provider, logging.Err(err))
+	}
+}
+
+func handleAppleMusicLink(database *db.DB, pg *pages.Pages, am *applemusic.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := session.GetUserID(r.Context())
 		w.Header().Set("Content-Type", "text/html")
 		devToken, _, errTok := am.GenerateDeveloperToken()
 		if errTok != nil {
-			log.Printf("Error generating Apple Music developer token: %v", errTok)
+			slog.ErrorContext(r.Context(), "error generating Apple Music developer token", logging.Err(errTok))
 			http.Error(w, "Failed to prepare Apple Music", http.StatusInternalServerError)
 			return
 		}
+		// Apple stopped accepting the user's token, ask them to authorize again
+		needsReauth := false
+		status, err := database.GetConnectionStatus(userID, events.SourceAppleMusic)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error fetching Apple Music connection status", logging.UserID(userID), logging.Err(err))
+		} else if status != nil {
+			needsReauth = status.NeedsReauth
+		}
 		data := struct {
-			NavBar   pages.NavBar
-			DevToken string
+			NavBar      pages.NavBar
+			DevToken    string
+			NeedsReauth bool
 		}{
-			DevToken: devToken,
+			DevToken:    devToken,
+			NeedsReauth: needsReauth,
 			NavBar: pages.NavBar{
 				SpotifyEnabled:    viper.GetBool("enable_spotify"),
 				LastFMEnabled:     viper.GetBool("enable_lastfm"),
 				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
 			},
 		}
-		err := pg.Execute("applemusic_link", w, data)
+		err = pg.Execute("applemusic_link", w, data)
 		if err != nil {
-			log.Printf("Error executing template: %v", err)
+			slog.ErrorContext(r.Context(), "error executing template", logging.Err(err))
 		}
 	}
 }
@@ -195,35 +230,6 @@ func apiCurrentTrack(spotifyService *spotify.Service) http.HandlerFunc {
 	}
 }
 
-func apiTrackHistory(spotifyService *spotify.Service) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userID, ok := session.GetUserID(r.Context())
-		if !ok {
-			jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
-			return
-		}
-
-		limitStr := r.URL.Query().Get("limit")
-		limit := 50 // Default limit
-		if limitStr != "" {
-			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-				limit = l
-			}
-		}
-		if limit > 200 {
-			limit = 200
-		}
-
-		tracks, err := spotifyService.DB.GetRecentTracks(userID, limit)
-		if err != nil {
-			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get track history: " + err.Error()})
-			return
-		}
-
-		jsonResponse(w, http.StatusOK, tracks)
-	}
-}
-
 func apiMusicBrainzSearch(mbService *musicbrainz.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if mbService == nil {
@@ -244,7 +250,7 @@ func apiMusicBrainzSearch(mbService *musicbrainz.Service) http.HandlerFunc {
 
 		recordings, err := mbService.SearchMusicBrainz(r.Context(), params)
 		if err != nil {
-			log.Printf("Error searching MusicBrainz: %v", err) // Log the error
+			slog.ErrorContext(r.Context(), "error searching MusicBrainz", logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to search MusicBrainz"})
 			return
 		}
@@ -263,7 +269,7 @@ func apiMeHandler(database *db.DB) http.HandlerFunc {
 
 		user, err := database.GetUserByID(userID)
 		if err != nil {
-			log.Printf("apiMeHandler: Error fetching user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error fetching user", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve user information"})
 			return
 		}
@@ -296,12 +302,136 @@ func apiMeHandler(database *db.DB) http.HandlerFunc {
 	}
 }
 
+// apiConnectionsHandler reports how polling each of the user's linked providers is going
+func apiConnectionsHandler(database *db.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := session.GetUserID(r.Context())
+		connections, err := database.GetUserConnectionStatuses(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error fetching connection statuses", logging.UserID(userID), logging.Err(err))
+			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve connection statuses"})
+			return
+		}
+		if connections == nil {
+			connections = []*models.ConnectionStatus{}
+		}
+		jsonResponse(w, http.StatusOK, map[string]any{"connections": connections})
+	}
+}
+
+// apiStampingHandler shows (GET) or sets (PUT) the user's stamping policy. An
+// empty policy goes back to the instance's policy for each service
+func apiStampingHandler(resolver *stamping.Resolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := session.GetUserID(r.Context())
+
+		if r.Method == http.MethodPut {
+			var reqBody struct {
+				Policy string `json:"policy"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body: " + err.Error()})
+				return
+			}
+			err := resolver.SetUserPolicy(userID, reqBody.Policy)
+			if errors.Is(err, stamping.ErrUnknownPolicy) {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "error saving stamping policy", logging.UserID(userID), logging.Err(err))
+				jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save stamping policy"})
+				return
+			}
+		}
+
+		services := make(map[string]string)
+		for _, service := range []string{events.SourceSpotify, events.SourceLastFM, events.SourceAppleMusic, events.SourceListenBrainz} {
+			services[service] = resolver.Name(r.Context(), userID, service)
+		}
+		jsonResponse(w, http.StatusOK, map[string]any{
+			"policy":    resolver.UserPolicy(r.Context(), userID),
+			"services":  services,
+			"available": stamping.Names(),
+		})
+	}
+}
+
+// apiSpotifySettingsHandler shows (GET) or replaces (PUT) what the user leaves
+// out of Spotify tracking, along with the devices their plays were on
+func apiSpotifySettingsHandler(database *db.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := session.GetUserID(r.Context())
+
+		if r.Method == http.MethodPut {
+			settings := models.DefaultSpotifySettings()
+			if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body: " + err.Error()})
+				return
+			}
+			if !models.ValidEpisodeMode(settings.Episodes) {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "episodes must be one of " + strings.Join(models.EpisodeModes, ", ")})
+				return
+			}
+			if err := database.SetSpotifySettings(userID, settings); err != nil {
+				slog.ErrorContext(r.Context(), "error saving spotify settings", logging.UserID(userID), logging.Err(err))
+				jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save Spotify settings"})
+				return
+			}
+		}
+
+		settings, err := database.GetSpotifySettings(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error fetching spotify settings", logging.UserID(userID), logging.Err(err))
+			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve Spotify settings"})
+			return
+		}
+		devices, err := database.GetTrackDevices(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error fetching track devices", logging.UserID(userID), logging.Err(err))
+			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve Spotify settings"})
+			return
+		}
+		jsonResponse(w, http.StatusOK, map[string]any{
+			"skip_private_sessions": settings.SkipPrivateSessions,
+			"ignored_devices":       settings.IgnoredDevices,
+			"episodes":              settings.Episodes,
+			"devices":               devices,
+		})
+	}
+}
+
+// apiEpisodesHandler lists the user's latest podcast episode plays, up to limit (default 50, at most 200)
+func apiEpisodesHandler(database *db.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := session.GetUserID(r.Context())
+
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive number"})
+				return
+			}
+			limit = min(n, 200)
+		}
+
+		episodes, err := database.GetRecentEpisodes(userID, limit)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error fetching episodes", logging.UserID(userID), logging.Err(err))
+			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve episodes"})
+			return
+		}
+		jsonResponse(w, http.StatusOK, map[string]any{"episodes": episodes})
+	}
+}
+
 func apiGetLastfmUserHandler(database *db.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, _ := session.GetUserID(r.Context()) // Auth middleware ensures user is present
 		user, err := database.GetUserByID(userID)
 		if err != nil {
-			log.Printf("apiGetLastfmUserHandler: Error fetching user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error fetching user", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve user information"})
 			return
 		}
@@ -337,11 +467,12 @@ func apiLinkLastfmHandler(database *db.DB) http.HandlerFunc {
 
 		err := database.AddLastFMUsername(userID, reqBody.LastFMUsername)
 		if err != nil {
-			log.Printf("apiLinkLastfmHandler: Error saving Last.fm username for user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error saving Last.fm username", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save Last.fm username"})
 			return
 		}
-		log.Printf("API: Successfully linked Last.fm username '%s' for user ID %d", reqBody.LastFMUsername, userID)
+		slog.InfoContext(r.Context(), "linked Last.fm username", logging.UserID(userID), "lastfm_user", reqBody.LastFMUsername)
+		resetConnection(r.Context(), database, userID, events.SourceLastFM)
 		jsonResponse(w, http.StatusOK, map[string]string{"message": "Last.fm username updated successfully"})
 	}
 }
@@ -353,11 +484,12 @@ func apiUnlinkLastfmHandler(database *db.DB) http.HandlerFunc {
 		// TODO: add a clear username for user id fn
 		err := database.AddLastFMUsername(userID, "")
 		if err != nil {
-			log.Printf("apiUnlinkLastfmHandler: Error unlinking Last.fm username for user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "error unlinking Last.fm username", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Last.fm username"})
 			return
 		}
-		log.Printf("API: Successfully unlinked Last.fm username for user ID %d", userID)
+		slog.InfoContext(r.Context(), "unlinked Last.fm username", logging.UserID(userID))
+		resetConnection(r.Context(), database, userID, events.SourceLastFM)
 		jsonResponse(w, http.StatusOK, map[string]string{"message": "Last.fm username unlinked successfully"})
 	}
 }
@@ -388,10 +520,11 @@ func apiAppleMusicAuthorize(database *db.DB) http.HandlerFunc {
 		}
 
 		if err := database.UpdateAppleMusicUserToken(userID, req.UserToken); err != nil {
-			log.Printf("apiAppleMusicAuthorize: failed to save token for user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "failed to save Apple Music token", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to save token"})
 			return
 		}
+		resetConnection(r.Context(), database, userID, events.SourceAppleMusic)
 
 		jsonResponse(w, http.StatusOK, map[string]any{"status": "ok"})
 	}
@@ -411,34 +544,45 @@ func apiAppleMusicUnlink(database *db.DB) http.HandlerFunc {
 		}
 
 		if err := database.ClearAppleMusicUserToken(userID); err != nil {
-			log.Printf("apiAppleMusicUnlink: failed to clear token for user %d: %v", userID, err)
+			slog.ErrorContext(r.Context(), "failed to clear Apple Music token", logging.UserID(userID), logging.Err(err))
 			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Apple Music"})
 			return
 		}
+		resetConnection(r.Context(), database, userID, events.SourceAppleMusic)
+		// a later link may be another Apple account, whose window shouldn't be diffed against this one
+		if err := database.DeleteAppleMusicRecentSnapshot(userID); err != nil {
+			slog.ErrorContext(r.Context(), "error clearing Apple Music recently played", logging.UserID(userID), logging.Err(err))
+		}
 
 		jsonResponse(w, http.StatusOK, map[string]any{"status": "ok"})
 	}
 }
 
 // apiSubmitListensHandler handles ListenBrainz-compatible submissions
-func apiSubmitListensHandler(database *db.DB, atprotoService *atprotoauth.AuthService, playingNowService *playingnow.Service, mbService *musicbrainz.Service) http.HandlerFunc {
+func apiSubmitListensHandler(database *db.DB, atprotoService *atprotoauth.AuthService, playingNowService *playingnow.Service, mbService *musicbrainz.Service, bus *events.Bus, stampingResolver *stamping.Resolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, authenticated := session.GetUserID(r.Context())
 		if !authenticated {
-			jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			listenBrainzError(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
 		if r.Method != http.MethodPost {
-			jsonResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			listenBrainzError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 
 		// Parse the ListenBrainz submission
+		r.Body = http.MaxBytesReader(w, r.Body, models.ListenBrainzMaxPayloadSize)
 		var submission models.ListenBrainzSubmission
 		if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
-			log.Printf("apiSubmitListensHandler: Error decoding submission for user %d: %v", userID, err)
-			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON format"})
+			slog.WarnContext(r.Context(), "error decoding ListenBrainz submission", logging.UserID(userID), logging.Err(err))
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				listenBrainzError(w, http.StatusBadRequest, fmt.Sprintf("JSON document is too large. In aggregate, listens may not be larger than %d characters.", models.ListenBrainzMaxPayloadSize))
+				return
+			}
+			listenBrainzError(w, http.StatusBadRequest, "Invalid JSON format")
 			return
 		}
 
@@ -449,41 +593,54 @@ func apiSubmitListensHandler(database *db.DB, atprotoService *atprotoauth.AuthSe
 			"playing_now": true,
 		}
 		if !validListenTypes[submission.ListenType] {
-			jsonResponse(w, http.StatusBadRequest, map[string]string{
-				"error": "Invalid listen_type. Must be 'single', 'import', or 'playing_now'",
-			})
+			listenBrainzError(w, http.StatusBadRequest, "Invalid listen_type. Must be 'single', 'import', or 'playing_now'")
 			return
 		}
 
 		// Validate payload
 		if len(submission.Payload) == 0 {
-			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Payload cannot be empty"})
+			listenBrainzError(w, http.StatusBadRequest, "Payload cannot be empty")
+			return
+		}
+		if submission.ListenType != "import" && len(submission.Payload) != 1 {
+			listenBrainzError(w, http.StatusBadRequest, "JSON document should contain exactly one listen for listen_type "+submission.ListenType+".")
+			return
+		}
+		if len(submission.Payload) > models.ListenBrainzMaxListensPerRequest {
+			listenBrainzError(w, http.StatusBadRequest, fmt.Sprintf("Too many listens. You may not submit more than %d listens at once.", models.ListenBrainzMaxListensPerRequest))
+			return
+		}
+
+		// Like ListenBrainz, one invalid listen rejects the whole submission
+		var listenErrors []map[string]any
+		for i, listen := range submission.Payload {
+			if err := listen.Validate(submission.ListenType); err != nil {
+				listenErrors = append(listenErrors, map[string]any{"index": i, "error": err.Error()})
+			}
+		}
+		if len(listenErrors) > 0 {
+			jsonResponse(w, http.StatusBadRequest, map[string]any{
+				"code":   http.StatusBadRequest,
+				"error":  listenErrors[0]["error"],
+				"errors": listenErrors,
+			})
 			return
 		}
 
 		// Get user for PDS submission
 		user, err := database.GetUserByID(userID)
 		if err != nil {
-			log.Printf("apiSubmitListensHandler: Error getting user %d: %v", userID, err)
-			jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+			slog.ErrorContext(r.Context(), "error getting user", logging.UserID(userID), logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to get user")
 			return
 		}
 
 		// Process each listen in the payload
 		var processedTracks []models.Track
-		var errors []string
+		var saveErrors []string
+		var notStamped int
 
 		for i, listen := range submission.Payload {
-			// Validate required fields
-			if listen.TrackMetadata.ArtistName == "" {
-				errors = append(errors, fmt.Sprintf("payload[%d]: artist_name is required", i))
-				continue
-			}
-			if listen.TrackMetadata.TrackName == "" {
-				errors = append(errors, fmt.Sprintf("payload[%d]: track_name is required", i))
-				continue
-			}
-
 			// Convert to internal Track format
 			track := listen.ConvertToTrack()
 
@@ -491,38 +648,50 @@ func apiSubmitListensHandler(database *db.DB, atprotoService *atprotoauth.AuthSe
 			if mbService != nil && track.RecordingMBID == nil {
 				hydratedTrack, err := musicbrainz.HydrateTrack(mbService, track)
 				if err != nil {
-					log.Printf("apiSubmitListensHandler: Could not hydrate track with MusicBrainz for user %d: %v (continuing with original data)", userID, err)
+					slog.WarnContext(r.Context(), "could not hydrate track with MusicBrainz, continuing with original data", logging.UserID(userID), logging.Track(&track), logging.Err(err))
 					// Continue with non-hydrated track
 				} else if hydratedTrack != nil {
 					track = *hydratedTrack
-					log.Printf("apiSubmitListensHandler: Successfully hydrated track '%s' with MusicBrainz data", track.Name)
+					slog.DebugContext(r.Context(), "hydrated track", logging.UserID(userID), logging.Track(&track))
 				}
 			}
 
 			// For 'playing_now' type, publish to PDS as actor status
 			if submission.ListenType == "playing_now" {
-				log.Printf("Received playing_now listen for user %d: %s - %s", userID, track.Artist[0].Name, track.Name)
+				slog.InfoContext(r.Context(), "received playing_now listen", logging.UserID(userID), logging.Track(&track))
 
 				if user.ATProtoDID != nil && playingNowService != nil {
 					if err := playingNowService.PublishPlayingNow(r.Context(), userID, &track); err != nil {
-						log.Printf("apiSubmitListensHandler: Error publishing playing_now to PDS for user %d: %v", userID, err)
+						slog.ErrorContext(r.Context(), "error publishing playing_now to PDS", logging.UserID(userID), logging.Err(err))
 						// Don't fail the request, just log the error
 					}
 				}
 				continue
 			}
 
+			// listens are finished plays, judged as full plays of whatever
+			// duration the client or MusicBrainz gave. Listens that fall short are
+			// still kept in history, so the client doesn't need to resubmit them
+			track.HasStamped = stamping.Stamps(stampingResolver.For(r.Context(), userID, events.SourceListenBrainz), track.DurationMs, track.DurationMs)
+
 			// Store the track
 			if _, err := database.SaveTrack(userID, &track); err != nil {
-				log.Printf("apiSubmitListensHandler: Error saving track for user %d: %v", userID, err)
-				errors = append(errors, fmt.Sprintf("payload[%d]: failed to save track", i))
+				slog.ErrorContext(r.Context(), "error saving track", logging.UserID(userID), logging.Err(err))
+				saveErrors = append(saveErrors, fmt.Sprintf("payload[%d]: failed to save track", i))
 				continue
 			}
+			if !track.HasStamped {
+				slog.DebugContext(r.Context(), "listen not stamped under stamping policy", logging.UserID(userID), logging.Track(&track))
+				notStamped++
+				processedTracks = append(processedTracks, track)
+				continue
+			}
+			bus.PublishStamped(userID, &track, events.SourceListenBrainz)
 
 			// Submit to PDS as feed.play record
 			if user.ATProtoDID != nil && atprotoService != nil {
 				if err := atprotoservice.SubmitPlayToPDS(r.Context(), *user.ATProtoDID, *user.MostRecentAtProtoSessionID, &track, atprotoService); err != nil {
-					log.Printf("apiSubmitListensHandler: Error submitting play to PDS for user %d: %v", userID, err)
+					slog.ErrorContext(r.Context(), "error submitting play to PDS", logging.UserID(userID), logging.Err(err))
 					// Don't fail the request, just log the error
 				}
 			}
@@ -535,17 +704,20 @@ func apiSubmitListensHandler(database *db.DB, atprotoService *atprotoauth.AuthSe
 			"status":    "ok",
 			"processed": len(processedTracks),
 		}
+		// saved, but not stamped or published
+		if notStamped > 0 {
+			response["not_stamped"] = notStamped
+		}
 
-		if len(errors) > 0 {
-			response["errors"] = errors
+		if len(saveErrors) > 0 {
+			response["errors"] = saveErrors
 			if len(processedTracks) == 0 {
 				jsonResponse(w, http.StatusBadRequest, response)
 				return
 			}
 		}
 
-		log.Printf("Successfully processed %d ListenBrainz submissions for user %d (type: %s)",
-			len(processedTracks), userID, submission.ListenType)
+		slog.InfoContext(r.Context(), "processed ListenBrainz submissions", logging.UserID(userID), "count", len(processedTracks), "listen_type", submission.ListenType)
 
 		jsonResponse(w, http.StatusOK, response)
 	}
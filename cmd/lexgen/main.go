@@ -40,6 +40,7 @@ var modules = []moduleConfig{
 		cborOut:    "api/teal/cbor_gen.go",
 		cborTypes: []string{
 			"teal.AlphaFeedPlay",
+			"teal.AlphaFeedEpisode",
 			"teal.AlphaActorProfile",
 			"teal.AlphaActorStatus",
 			"teal.AlphaActorProfile_FeaturedItem",
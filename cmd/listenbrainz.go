@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/service/playingnow"
+	"github.com/teal-fm/piper/session"
+)
+
+const (
+	// listenBrainzDefaultCount and listenBrainzMaxCount match ListenBrainz's DEFAULT_ITEMS_PER_GET and MAX_ITEMS_PER_GET
+	listenBrainzDefaultCount = 25
+	listenBrainzMaxCount     = 1000
+)
+
+// listenBrainzError writes an error in the ListenBrainz envelope
+func listenBrainzError(w http.ResponseWriter, status int, message string) {
+	jsonResponse(w, status, map[string]any{"code": status, "error": message})
+}
+
+// resolveListenBrainzUser maps the {user} path segment to a piper user.
+// ListenBrainz clients address users by the user_name returned from
+// validate-token, which is the name of their API key, so that only resolves
+// when the same token is sent along. Users with a public profile can also be
+// looked up by DID, public is then true and only their stamped plays may be shown
+func resolveListenBrainzUser(r *http.Request, database *db.DB, sm *session.Manager) (userID int64, public bool, found bool, err error) {
+	name := r.PathValue("user")
+
+	if apiKeyStr, err := apikey.ExtractApiKey(r); err == nil && apiKeyStr != "" {
+		if key, valid := sm.ApiKeyMgr.GetApiKey(apiKeyStr); valid && (key.Name == name || name == userDID(database, key.UserID)) {
+			return key.UserID, false, true, nil
+		}
+	}
+
+	if !strings.HasPrefix(name, "did:") {
+		return 0, false, false, nil
+	}
+	user, err := database.GetUserByDID(name)
+	if err != nil || user == nil {
+		return 0, false, false, err
+	}
+	isPublic, err := database.IsPublicProfile(user.ID)
+	if err != nil || !isPublic {
+		return 0, false, false, err
+	}
+	return user.ID, true, true, nil
+}
+
+// userDID the user's DID, "" if they have none
+func userDID(database *db.DB, userID int64) string {
+	user, err := database.GetUserByID(userID)
+	if err != nil || user == nil || user.ATProtoDID == nil {
+		return ""
+	}
+	return *user.ATProtoDID
+}
+
+// parseListenBrainzTimestamp parses an optional unix timestamp query parameter
+func parseListenBrainzTimestamp(r *http.Request, name string) (*int64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	ts, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || ts < 0 {
+		return nil, fmt.Errorf("Invalid %s argument: %s", name, raw)
+	}
+	return &ts, nil
+}
+
+// apiListenBrainzListensHandler handles GET /1/user/{user}/listens
+func apiListenBrainzListensHandler(database *db.DB, sm *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userName := r.PathValue("user")
+		userID, public, found, err := resolveListenBrainzUser(r, database, sm)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error resolving user", "user_name", userName, logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to look up user")
+			return
+		}
+		if !found {
+			listenBrainzError(w, http.StatusNotFound, "Cannot find user: "+userName)
+			return
+		}
+
+		maxTs, err := parseListenBrainzTimestamp(r, "max_ts")
+		if err != nil {
+			listenBrainzError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		minTs, err := parseListenBrainzTimestamp(r, "min_ts")
+		if err != nil {
+			listenBrainzError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if maxTs != nil && minTs != nil && *minTs >= *maxTs {
+			listenBrainzError(w, http.StatusBadRequest, "min_ts should be less than max_ts")
+			return
+		}
+
+		count := listenBrainzDefaultCount
+		if raw := r.URL.Query().Get("count"); raw != "" {
+			count, err = strconv.Atoi(raw)
+			if err != nil || count < 0 {
+				listenBrainzError(w, http.StatusBadRequest, "Invalid count argument: "+raw)
+				return
+			}
+		}
+		count = min(count, listenBrainzMaxCount)
+
+		// both bounds are exclusive, as in ListenBrainz
+		q := db.TrackQuery{Limit: count}
+		if public {
+			stamped := true
+			q.Stamped = &stamped
+		}
+		if maxTs != nil {
+			q.To = time.Unix(*maxTs, 0)
+		}
+		if minTs != nil {
+			q.From = time.Unix(*minTs+1, 0)
+			// with only a lower bound ListenBrainz returns the listens right after it
+			q.OldestFirst = maxTs == nil
+		}
+
+		var tracks []*models.Track
+		if count > 0 {
+			tracks, err = database.QueryTracks(userID, q)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "error querying tracks", logging.UserID(userID), logging.Err(err))
+				listenBrainzError(w, http.StatusInternalServerError, "Failed to get listens")
+				return
+			}
+		}
+		if q.OldestFirst {
+			slices.Reverse(tracks)
+		}
+
+		timeRange := database.GetTrackTimeRange
+		if public {
+			timeRange = database.GetStampedTrackTimeRange
+		}
+		oldest, latest, err := timeRange(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error getting listen range", logging.UserID(userID), logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to get listens")
+			return
+		}
+		var oldestTs, latestTs int64
+		if oldest != nil {
+			oldestTs = oldest.Unix()
+		}
+		if latest != nil {
+			latestTs = latest.Unix()
+		}
+
+		listens := make([]models.ListenBrainzListen, len(tracks))
+		for i, track := range tracks {
+			listens[i] = models.ListenFromTrack(track, userName)
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]any{
+			"payload": map[string]any{
+				"count":            len(listens),
+				"latest_listen_ts": latestTs,
+				"oldest_listen_ts": oldestTs,
+				"user_id":          userName,
+				"listens":          listens,
+			},
+		})
+	}
+}
+
+// apiListenBrainzListenCountHandler handles GET /1/user/{user}/listen-count
+func apiListenBrainzListenCountHandler(database *db.DB, sm *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userName := r.PathValue("user")
+		userID, public, found, err := resolveListenBrainzUser(r, database, sm)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error resolving user", "user_name", userName, logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to look up user")
+			return
+		}
+		if !found {
+			listenBrainzError(w, http.StatusNotFound, "Cannot find user: "+userName)
+			return
+		}
+
+		countTracks := database.CountTracks
+		if public {
+			countTracks = database.CountStampedTracks
+		}
+		count, err := countTracks(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error counting tracks", logging.UserID(userID), logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to get listen count")
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]any{
+			"payload": map[string]any{"count": count},
+		})
+	}
+}
+
+// apiListenBrainzPlayingNowHandler handles GET /1/user/{user}/playing-now
+func apiListenBrainzPlayingNowHandler(database *db.DB, sm *session.Manager, playingNowService *playingnow.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userName := r.PathValue("user")
+		userID, _, found, err := resolveListenBrainzUser(r, database, sm)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error resolving user", "user_name", userName, logging.Err(err))
+			listenBrainzError(w, http.StatusInternalServerError, "Failed to look up user")
+			return
+		}
+		if !found {
+			listenBrainzError(w, http.StatusNotFound, "Cannot find user: "+userName)
+			return
+		}
+
+		listens := []models.ListenBrainzListen{}
+		if playingNowService != nil {
+			if track, ok := playingNowService.GetPlayingNow(userID); ok {
+				listen := models.ListenFromTrack(track, userName)
+				// a playing now listen has no timestamps in ListenBrainz
+				listen.ListenedAt = nil
+				listen.InsertedAt = nil
+				listen.PlayingNow = true
+				listens = append(listens, listen)
+			}
+		}
+
+		jsonResponse(w, http.StatusOK, map[string]any{
+			"payload": map[string]any{
+				"count":       len(listens),
+				"listens":     listens,
+				"playing_now": len(listens) > 0,
+				"user_id":     userName,
+			},
+		})
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/teal-fm/piper/db"
 	"github.com/teal-fm/piper/models"
 	"github.com/teal-fm/piper/service/musicbrainz"
+	"github.com/teal-fm/piper/service/playingnow"
 	"github.com/teal-fm/piper/session"
 )
 
@@ -103,7 +106,7 @@ func TestListenBrainzSubmission_Success(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call handler
-	handler := apiSubmitListensHandler(database, nil, nil, nil)
+	handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 	handler(rr, req)
 
 	// Check response
@@ -166,6 +169,7 @@ func TestListenBrainzSubmission_MinimalPayload(t *testing.T) {
 		ListenType: "single",
 		Payload: []models.ListenBrainzPayload{
 			{
+				ListenedAt: func() *int64 { i := int64(1704067200); return &i }(),
 				TrackMetadata: models.ListenBrainzTrackMetadata{
 					ArtistName: "The Beatles",
 					TrackName:  "Hey Jude",
@@ -187,7 +191,7 @@ func TestListenBrainzSubmission_MinimalPayload(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
-	handler := apiSubmitListensHandler(database, nil, nil, nil)
+	handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 	handler(rr, req)
 
 	if rr.Code != http.StatusOK {
@@ -211,9 +215,69 @@ func TestListenBrainzSubmission_MinimalPayload(t *testing.T) {
 	if len(track.Artist) == 0 || track.Artist[0].Name != "The Beatles" {
 		t.Errorf("Expected artist 'The Beatles', got %v", track.Artist)
 	}
-	// Timestamp should be set to current time if not provided
-	if track.Timestamp.IsZero() {
-		t.Error("Expected timestamp to be set")
+	if track.Timestamp.Unix() != 1704067200 {
+		t.Errorf("Expected timestamp 1704067200, got %d", track.Timestamp.Unix())
+	}
+}
+
+func TestListenBrainzSubmission_KeepsListensNotStamped(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, apiKey := createTestUser(t, database)
+
+	listen := func(name string, listenedAt, durationMs int64) models.ListenBrainzPayload {
+		return models.ListenBrainzPayload{
+			ListenedAt: &listenedAt,
+			TrackMetadata: models.ListenBrainzTrackMetadata{
+				ArtistName:     "Daft Punk",
+				TrackName:      name,
+				AdditionalInfo: &models.ListenBrainzAdditionalInfo{DurationMs: &durationMs},
+			},
+		}
+	}
+	// the default policy doesn't stamp plays under 30 seconds
+	submission := models.ListenBrainzSubmission{
+		ListenType: "import",
+		Payload: []models.ListenBrainzPayload{
+			listen("Short Circuit", 1704067200, 20000),
+			listen("One More Time", 1704067500, 320000),
+		},
+	}
+	jsonData, err := json.Marshal(submission)
+	if err != nil {
+		t.Fatalf("Failed to marshal submission: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/1/submit-listens", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+apiKey)
+	req = req.WithContext(withUserContext(req.Context(), userID))
+	rr := httptest.NewRecorder()
+	apiSubmitListensHandler(database, nil, nil, nil, nil, nil)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Processed  int `json:"processed"`
+		NotStamped int `json:"not_stamped"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Processed != 2 || resp.NotStamped != 1 {
+		t.Errorf("Expected 2 processed listens with 1 not stamped, got %+v", resp)
+	}
+
+	tracks, err := database.GetRecentTracks(userID, 10)
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("Expected both listens to be saved, got %d (%v)", len(tracks), err)
+	}
+	for _, track := range tracks {
+		if want := track.Name == "One More Time"; track.HasStamped != want {
+			t.Errorf("%s: HasStamped = %v, want %v", track.Name, track.HasStamped, want)
+		}
 	}
 }
 
@@ -264,7 +328,7 @@ func TestListenBrainzSubmission_BulkImport(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
-	handler := apiSubmitListensHandler(database, nil, nil, nil)
+	handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 	handler(rr, req)
 
 	if rr.Code != http.StatusOK {
@@ -325,7 +389,7 @@ func TestListenBrainzSubmission_PlayingNow(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
-	handler := apiSubmitListensHandler(database, nil, nil, nil)
+	handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 	handler(rr, req)
 
 	if rr.Code != http.StatusOK {
@@ -349,6 +413,9 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 
 	userID, apiKey := createTestUser(t, database)
 
+	listenedAt := func() *int64 { i := int64(1704067200); return &i }()
+	validMetadata := models.ListenBrainzTrackMetadata{ArtistName: "Artist", TrackName: "Track"}
+
 	testCases := []struct {
 		name           string
 		submission     models.ListenBrainzSubmission
@@ -379,6 +446,7 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 				ListenType: "single",
 				Payload: []models.ListenBrainzPayload{
 					{
+						ListenedAt: listenedAt,
 						TrackMetadata: models.ListenBrainzTrackMetadata{
 							TrackName: "Track Without Artist",
 						},
@@ -386,7 +454,7 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 				},
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "artist_name is required",
+			expectedError:  "required track_metadata.artist_name",
 		},
 		{
 			name: "missing_track_name",
@@ -394,6 +462,7 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 				ListenType: "single",
 				Payload: []models.ListenBrainzPayload{
 					{
+						ListenedAt: listenedAt,
 						TrackMetadata: models.ListenBrainzTrackMetadata{
 							ArtistName: "Artist Without Track",
 						},
@@ -401,7 +470,74 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 				},
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedError:  "track_name is required",
+			expectedError:  "required track_metadata.track_name",
+		},
+		{
+			name: "single_missing_listened_at",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "single",
+				Payload:    []models.ListenBrainzPayload{{TrackMetadata: validMetadata}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "must contain the key listened_at",
+		},
+		{
+			name: "listened_at_too_low",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "import",
+				Payload: []models.ListenBrainzPayload{{
+					ListenedAt:    func() *int64 { i := int64(1000); return &i }(),
+					TrackMetadata: validMetadata,
+				}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "listened_at is too low",
+		},
+		{
+			name: "single_with_several_listens",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "single",
+				Payload: []models.ListenBrainzPayload{
+					{ListenedAt: listenedAt, TrackMetadata: validMetadata},
+					{ListenedAt: listenedAt, TrackMetadata: validMetadata},
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "exactly one listen",
+		},
+		{
+			name: "playing_now_with_listened_at",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "playing_now",
+				Payload:    []models.ListenBrainzPayload{{ListenedAt: listenedAt, TrackMetadata: validMetadata}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "must not contain listened_at",
+		},
+		{
+			name: "too_many_listens",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "import",
+				Payload:    make([]models.ListenBrainzPayload, models.ListenBrainzMaxListensPerRequest+1),
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Too many listens",
+		},
+		{
+			name: "too_many_tags",
+			submission: models.ListenBrainzSubmission{
+				ListenType: "single",
+				Payload: []models.ListenBrainzPayload{{
+					ListenedAt: listenedAt,
+					TrackMetadata: models.ListenBrainzTrackMetadata{
+						ArtistName:     "Artist",
+						TrackName:      "Track",
+						AdditionalInfo: &models.ListenBrainzAdditionalInfo{Tags: make([]string, models.ListenBrainzMaxTagsPerListen+1)},
+					},
+				}},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "additional_info.tags",
 		},
 	}
 
@@ -420,7 +556,7 @@ func TestListenBrainzSubmission_ValidationErrors(t *testing.T) {
 			req = req.WithContext(ctx)
 
 			rr := httptest.NewRecorder()
-			handler := apiSubmitListensHandler(database, nil, nil, nil)
+			handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 			handler(rr, req)
 
 			if rr.Code != tc.expectedStatus {
@@ -463,7 +599,7 @@ func TestListenBrainzSubmission_Unauthorized(t *testing.T) {
 	// No Authorization header
 
 	rr := httptest.NewRecorder()
-	handler := apiSubmitListensHandler(database, nil, nil, nil)
+	handler := apiSubmitListensHandler(database, nil, nil, nil, nil, nil)
 	handler(rr, req)
 
 	if rr.Code != http.StatusUnauthorized {
@@ -527,15 +663,58 @@ func TestListenBrainzDataConversion(t *testing.T) {
 		t.Error("Expected HasStamped to be true for external submissions")
 	}
 
-	// Check artists
-	if len(track.Artist) != 2 {
-		t.Errorf("Expected 2 artists, got %d", len(track.Artist))
+	// Check artists, one credit can't be split across two MBIDs so it stays whole
+	if len(track.Artist) != 1 || track.Artist[0].Name != "Test Artist" {
+		t.Fatalf("Expected the whole credit as a single artist, got %+v", track.Artist)
 	}
-	if track.Artist[0].MBID == nil || *track.Artist[0].MBID != "test-artist-mbid-1" {
-		t.Errorf("First artist MBID not set correctly")
+	if track.Artist[0].MBID != nil {
+		t.Errorf("Expected no MBID on an unsplit credit, got %s", *track.Artist[0].MBID)
 	}
-	if track.Artist[1].MBID == nil || *track.Artist[1].MBID != "test-artist-mbid-2" {
-		t.Errorf("Second artist MBID not set correctly")
+}
+
+func TestListenBrainzArtistsAlwaysNamed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		credit   string
+		info     *models.ListenBrainzAdditionalInfo
+		expected []string
+		mbids    bool
+	}{
+		{"no mbids", "Daft Punk", nil, []string{"Daft Punk"}, false},
+		{"one mbid", "Daft Punk", &models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a"}}, []string{"Daft Punk"}, true},
+		{"one mbid, credit splits", "Simon & Garfunkel",
+			&models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a"}}, []string{"Simon & Garfunkel"}, false},
+		{"split credit", "Daft Punk feat. Pharrell Williams",
+			&models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a", "b"}}, []string{"Daft Punk", "Pharrell Williams"}, true},
+		{"credit doesn't split", "Simon & Garfunkel & Friends",
+			&models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a", "b"}}, []string{"Simon & Garfunkel & Friends"}, false},
+		{"artist names", "A and B",
+			&models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a", "b"}, ArtistNames: []string{"A", "B"}}, []string{"A", "B"}, true},
+		{"empty artist name", "A and B",
+			&models.ListenBrainzAdditionalInfo{ArtistMBIDs: []string{"a", "b"}, ArtistNames: []string{"A", ""}}, []string{"A and B"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := models.ListenBrainzPayload{TrackMetadata: models.ListenBrainzTrackMetadata{
+				ArtistName: tc.credit, TrackName: "Track", AdditionalInfo: tc.info,
+			}}
+			track := payload.ConvertToTrack()
+			if len(track.Artist) != len(tc.expected) {
+				t.Fatalf("Expected artists %v, got %+v", tc.expected, track.Artist)
+			}
+			for i, artist := range track.Artist {
+				if artist.Name == "" {
+					t.Errorf("Artist %d has no name", i)
+				}
+				if artist.Name != tc.expected[i] {
+					t.Errorf("Expected artist %d to be %q, got %q", i, tc.expected[i], artist.Name)
+				}
+				if (artist.MBID != nil) != tc.mbids {
+					t.Errorf("Expected artist %d to have an MBID: %v, got %v", i, tc.mbids, artist.MBID)
+				}
+			}
+		})
 	}
 }
 
@@ -578,7 +757,7 @@ func TestListenBrainzSubmission_WithMusicBrainzHydration(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call handler with MusicBrainz service
-	handler := apiSubmitListensHandler(database, nil, nil, mbService)
+	handler := apiSubmitListensHandler(database, nil, nil, mbService, nil, nil)
 	handler(rr, req)
 
 	if rr.Code != http.StatusOK {
@@ -616,3 +795,312 @@ func TestListenBrainzSubmission_WithMusicBrainzHydration(t *testing.T) {
 		t.Errorf("Expected artist 'Daft Punk', got %v", track.Artist)
 	}
 }
+
+// newListenBrainzReadMux registers the ListenBrainz read endpoints so path values resolve
+func newListenBrainzReadMux(database *db.DB, sm *session.Manager, pn *playingnow.Service) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /1/user/{user}/listens", apiListenBrainzListensHandler(database, sm))
+	mux.HandleFunc("GET /1/user/{user}/listen-count", apiListenBrainzListenCountHandler(database, sm))
+	mux.HandleFunc("GET /1/user/{user}/playing-now", apiListenBrainzPlayingNowHandler(database, sm, pn))
+	return mux
+}
+
+type listenBrainzListensResponse struct {
+	Payload struct {
+		Count          int                         `json:"count"`
+		LatestListenTs int64                       `json:"latest_listen_ts"`
+		OldestListenTs int64                       `json:"oldest_listen_ts"`
+		UserID         string                      `json:"user_id"`
+		PlayingNow     bool                        `json:"playing_now"`
+		Listens        []models.ListenBrainzListen `json:"listens"`
+	} `json:"payload"`
+}
+
+func TestListenBrainzGetListens(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, apiKey := createTestUser(t, database)
+	// CreateUser does not store the DID
+	if _, err := database.Exec(`UPDATE users SET atproto_did = ? WHERE id = ?`, "did:test:user", userID); err != nil {
+		t.Fatalf("Failed to set DID: %v", err)
+	}
+	for i := int64(1); i <= 5; i++ {
+		track := &models.Track{
+			Name:      fmt.Sprintf("Track %d", i),
+			Artist:    []models.Artist{{Name: "Daft Punk"}, {Name: "Pharrell Williams"}},
+			Album:     "Random Access Memories",
+			Timestamp: time.Unix(i*1000, 0),
+			// only tracks 1, 3 and 5 are stamped
+			HasStamped: i%2 == 1,
+		}
+		if _, err := database.SaveTrack(userID, track); err != nil {
+			t.Fatalf("Failed to save track: %v", err)
+		}
+	}
+
+	sm := session.NewSessionManager(database)
+	mux := newListenBrainzReadMux(database, sm, nil)
+
+	get := func(path string, token string) (*httptest.ResponseRecorder, listenBrainzListensResponse) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		var resp listenBrainzListensResponse
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return rr, resp
+	}
+	listenedAt := func(resp listenBrainzListensResponse) []int64 {
+		var out []int64
+		for _, l := range resp.Payload.Listens {
+			out = append(out, *l.ListenedAt)
+		}
+		return out
+	}
+
+	rr, resp := get("/1/user/test-key/listens", apiKey)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if resp.Payload.Count != 5 || resp.Payload.UserID != "test-key" {
+		t.Errorf("Unexpected payload: %+v", resp.Payload)
+	}
+	if resp.Payload.LatestListenTs != 5000 || resp.Payload.OldestListenTs != 1000 {
+		t.Errorf("Expected listen range 1000-5000, got %d-%d", resp.Payload.OldestListenTs, resp.Payload.LatestListenTs)
+	}
+	first := resp.Payload.Listens[0]
+	if first.TrackMetadata.TrackName != "Track 5" || first.TrackMetadata.ArtistName != "Daft Punk, Pharrell Williams" {
+		t.Errorf("Unexpected first listen: %+v", first.TrackMetadata)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []int64
+	}{
+		{"count", "?count=2", []int64{5000, 4000}},
+		{"max_ts is exclusive", "?max_ts=4000", []int64{3000, 2000, 1000}},
+		{"min_ts returns the listens right after it", "?min_ts=1000&count=2", []int64{3000, 2000}},
+		{"both bounds", "?min_ts=1000&max_ts=5000", []int64{4000, 3000, 2000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, resp := get("/1/user/test-key/listens"+tt.query, apiKey)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			if got := listenedAt(resp); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected listens %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// key names need the token, DIDs too unless the profile is public
+	if rr, _ := get("/1/user/test-key/listens", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without a token, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr, _ := get("/1/user/did:test:user/listens", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a private profile's DID to 404 without a token, got %d", rr.Code)
+	}
+	if rr, resp := get("/1/user/did:test:user/listens", apiKey); rr.Code != http.StatusOK || resp.Payload.Count != 5 {
+		t.Errorf("Expected the owner's token to see every listen by DID, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := database.SetPublicProfile(userID, true); err != nil {
+		t.Fatalf("SetPublicProfile failed: %v", err)
+	}
+	rr, resp = get("/1/user/did:test:user/listens", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected a public profile's DID to resolve, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := listenedAt(resp); fmt.Sprint(got) != "[5000 3000 1000]" {
+		t.Errorf("Expected only stamped listens, got %v", got)
+	}
+	for _, query := range []string{"?count=abc", "?max_ts=-1", "?min_ts=5000&max_ts=1000"} {
+		if rr, _ := get("/1/user/test-key/listens"+query, apiKey); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, rr.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/1/user/did:test:user/listen-count", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	var countResp struct {
+		Payload struct {
+			Count int64 `json:"count"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&countResp); err != nil {
+		t.Fatalf("Failed to decode listen-count response: %v", err)
+	}
+	if countResp.Payload.Count != 3 {
+		t.Errorf("Expected a stamped listen count of 3, got %d", countResp.Payload.Count)
+	}
+}
+
+func TestListenBrainzPlayingNow(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	// no DID, so publishing only records the state and never reaches a PDS
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	sm := session.NewSessionManager(database)
+	apiKey, err := sm.CreateAPIKey(userID, "scrobbler", 30)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	pn := playingnow.NewPlayingNowService(database, nil, nil)
+	mux := newListenBrainzReadMux(database, sm, pn)
+
+	get := func() listenBrainzListensResponse {
+		req := httptest.NewRequest(http.MethodGet, "/1/user/scrobbler/playing-now", nil)
+		req.Header.Set("Authorization", "Token "+apiKey.ID)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp listenBrainzListensResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	if resp := get(); resp.Payload.PlayingNow || resp.Payload.Count != 0 {
+		t.Errorf("Expected nothing playing, got %+v", resp.Payload)
+	}
+
+	track := &models.Track{Name: "Get Lucky", Artist: []models.Artist{{Name: "Daft Punk"}}, DurationMs: 60000}
+	if err := pn.PublishPlayingNow(context.Background(), userID, track); err != nil {
+		t.Fatalf("Failed to publish playing now: %v", err)
+	}
+
+	resp := get()
+	if !resp.Payload.PlayingNow || resp.Payload.Count != 1 {
+		t.Fatalf("Expected a playing now listen, got %+v", resp.Payload)
+	}
+	listen := resp.Payload.Listens[0]
+	if !listen.PlayingNow || listen.ListenedAt != nil || listen.TrackMetadata.TrackName != "Get Lucky" {
+		t.Errorf("Unexpected playing now listen: %+v", listen)
+	}
+
+	if err := pn.ClearPlayingNow(context.Background(), userID); err != nil {
+		t.Fatalf("Failed to clear playing now: %v", err)
+	}
+	if resp := get(); resp.Payload.PlayingNow {
+		t.Errorf("Expected playing now to be cleared, got %+v", resp.Payload)
+	}
+}
+
+func TestListenBrainzSubmission_PayloadTooLarge(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, apiKey := createTestUser(t, database)
+
+	body := `{"listen_type":"import","payload":[{"track_metadata":{"artist_name":"` + strings.Repeat("a", models.ListenBrainzMaxPayloadSize) + `"}}]}`
+	req := httptest.NewRequest(http.MethodPost, "/1/submit-listens", strings.NewReader(body))
+	req.Header.Set("Authorization", "Token "+apiKey)
+	req = req.WithContext(withUserContext(req.Context(), userID))
+
+	rr := httptest.NewRecorder()
+	apiSubmitListensHandler(database, nil, nil, nil, nil, nil)(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "too large") {
+		t.Errorf("Expected a too large error, got: %s", rr.Body.String())
+	}
+}
+
+func TestListenBrainzSubmission_PreservesAdditionalInfo(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, apiKey := createTestUser(t, database)
+
+	body := `{
+		"listen_type": "single",
+		"payload": [{
+			"listened_at": 1704067200,
+			"track_metadata": {
+				"artist_name": "Daft Punk feat. Pharrell Williams & Nile Rodgers",
+				"track_name": "Get Lucky",
+				"release_name": "Random Access Memories",
+				"additional_info": {
+					"artist_mbids": ["056e4f3e-d505-4dad-8ec1-d04f521cbb56", "149f91ef-1287-46fd-9a74-e5bb5b5c7b67", "a2b5a3e5-a1e7-4a6b-8a2c-5e7bb3d6e2c1"],
+					"recording_mbid": "a8ac6b5a-8b5c-4b5f-9c3e-8d0b1b2f4e4e",
+					"release_group_mbid": "2ad89f0b-7f2c-4b10-bb5b-0cb8eb8b1a0c",
+					"track_mbid": "f3b1a4d2-8c9e-4a3b-b6d1-9e2f7c5a0b1d",
+					"work_mbids": ["5d7e2c4a-1b3f-4e6a-9c8d-0f2b4a6c8e1d"],
+					"tracknumber": "8/13",
+					"discnumber": 1,
+					"tags": ["disco", "funk"],
+					"submission_client": "Pano Scrobbler",
+					"submission_client_version": "3.5",
+					"media_player": "YouTube Music",
+					"youtube_id": "5NV6Rdv1a3I"
+				}
+			}
+		}]
+	}`
+
+	req := httptest.NewRequest(http.MethodPost, "/1/submit-listens", strings.NewReader(body))
+	req.Header.Set("Authorization", "Token "+apiKey)
+	req = req.WithContext(withUserContext(req.Context(), userID))
+
+	rr := httptest.NewRecorder()
+	apiSubmitListensHandler(database, nil, nil, nil, nil, nil)(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d. Body: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	tracks, err := database.GetRecentTracks(userID, 10)
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("Expected 1 saved track, got %d (%v)", len(tracks), err)
+	}
+	track := tracks[0]
+
+	var names []string
+	for _, artist := range track.Artist {
+		names = append(names, artist.Name)
+	}
+	if fmt.Sprint(names) != "[Daft Punk Pharrell Williams Nile Rodgers]" {
+		t.Errorf("Expected the credit split across the artists, got %q", names)
+	}
+	if track.ReleaseGroupMBID == nil || *track.ReleaseGroupMBID != "2ad89f0b-7f2c-4b10-bb5b-0cb8eb8b1a0c" {
+		t.Errorf("Release group MBID not preserved")
+	}
+	if track.ReleaseTrackMBID == nil || *track.ReleaseTrackMBID != "f3b1a4d2-8c9e-4a3b-b6d1-9e2f7c5a0b1d" {
+		t.Errorf("Track MBID not preserved")
+	}
+	if len(track.WorkMBIDs) != 1 || fmt.Sprint(track.Tags) != "[disco funk]" {
+		t.Errorf("Expected work MBIDs and tags to be preserved, got %v and %v", track.WorkMBIDs, track.Tags)
+	}
+	if track.TrackNumber == nil || *track.TrackNumber != 8 || track.DiscNumber == nil || *track.DiscNumber != 1 {
+		t.Errorf("Expected track 8 on disc 1, got %v and %v", track.TrackNumber, track.DiscNumber)
+	}
+	if track.SubmissionClient != "Pano Scrobbler" || track.SubmissionClientVersion != "3.5" ||
+		track.MediaPlayer != "YouTube Music" || track.YouTubeID != "5NV6Rdv1a3I" {
+		t.Errorf("Client metadata not preserved: %+v", track)
+	}
+
+	// and it is handed back out through the read API
+	listen := models.ListenFromTrack(track, "test-key")
+	info := listen.TrackMetadata.AdditionalInfo
+	if info.TrackNumber == nil || *info.TrackNumber != 8 || info.YoutubeID == nil || len(info.ArtistNames) != 3 {
+		t.Errorf("Expected additional_info to round trip, got %+v", info)
+	}
+}
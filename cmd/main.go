@@ -1,26 +1,39 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
-	"strings"
+	"os"
 	"time"
 
+	"github.com/teal-fm/piper/health"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/service/account"
 	"github.com/teal-fm/piper/service/applemusic"
+	"github.com/teal-fm/piper/service/events"
+	"github.com/teal-fm/piper/service/export"
 	"github.com/teal-fm/piper/service/lastfm"
 	"github.com/teal-fm/piper/service/playingnow"
+	"github.com/teal-fm/piper/service/profile"
+	"github.com/teal-fm/piper/service/relay"
+	"github.com/teal-fm/piper/service/stamping"
+	"github.com/teal-fm/piper/service/webhook"
 
 	"github.com/spf13/viper"
 	"github.com/teal-fm/piper/config"
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/oauth"
 	"github.com/teal-fm/piper/oauth/atproto"
 	"github.com/teal-fm/piper/pages"
 	apikeyService "github.com/teal-fm/piper/service/apikey"
 	"github.com/teal-fm/piper/service/musicbrainz"
 	"github.com/teal-fm/piper/service/spotify"
+	"github.com/teal-fm/piper/service/stats"
 	"github.com/teal-fm/piper/session"
 )
 
@@ -34,6 +47,15 @@ type application struct {
 	atprotoService    *atproto.AuthService
 	playingNowService *playingnow.Service
 	appleMusicService *applemusic.Service
+	exportService     *export.Service
+	accountService    *account.Service
+	statsService      *stats.Service
+	relayService      *relay.Service
+	webhookService    *webhook.Service
+	profileService    *profile.Service
+	events            *events.Bus
+	health            *health.Checker
+	stamping          *stamping.Resolver
 	pages             *pages.Pages
 }
 
@@ -45,22 +67,46 @@ func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
 	if data != nil {
 		err := json.NewEncoder(w).Encode(data)
 		if err != nil {
-			log.Printf("Error encoding JSON response: %v", err)
+			slog.Error("error encoding JSON response", logging.Err(err))
 			return
 		}
 	}
 }
 
+// fatal logs msg and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// setupLogging configures slog from log.format, log.level and log.levels
+func setupLogging() {
+	level, err := logging.ParseLevel(viper.GetString("log.level"))
+	if err != nil {
+		log.Fatalf("Error reading LOG_LEVEL: %v", err)
+	}
+	levels, err := logging.ParseLevels(viper.GetString("log.levels"))
+	if err != nil {
+		log.Fatalf("Error reading LOG_LEVELS: %v", err)
+	}
+	logging.Setup(os.Stdout, logging.Options{
+		Format: viper.GetString("log.format"),
+		Level:  level,
+		Levels: levels,
+	})
+}
+
 func main() {
 	config.Load()
+	setupLogging()
 
 	database, err := db.New(viper.GetString("db.path"))
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		fatal("error connecting to database", logging.Err(err))
 	}
 
 	if err := database.Initialize(); err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+		fatal("error initializing database", logging.Err(err))
 	}
 
 	sessionManager := session.NewSessionManager(database)
@@ -80,7 +126,7 @@ func main() {
 
 	var allowedDids = viper.GetStringSlice("allowed_dids")
 	if len(allowedDids) > 0 {
-		log.Printf("Allowed DIDs provided. Only allowing %s\n", strings.Join(allowedDids, ", "))
+		slog.Info("allowed DIDs provided, only allowing those", "dids", allowedDids)
 	}
 
 	atprotoService, err := atproto.NewATprotoAuthService(
@@ -93,11 +139,30 @@ func main() {
 		allowedDids,
 	)
 	if err != nil {
-		log.Fatalf("Error creating ATproto auth service: %v", err)
+		fatal("error creating ATProto auth service", logging.Err(err))
 	}
 
 	mbService := musicbrainz.NewMusicBrainzService(database)
-	playingNowService := playingnow.NewPlayingNowService(database, atprotoService, mbService)
+
+	servicePolicies, err := stamping.ParseServicePolicies(viper.GetString("stamping.policies"))
+	if err != nil {
+		fatal("invalid stamping.policies", logging.Err(err))
+	}
+	stampingResolver, err := stamping.NewResolver(database, viper.GetString("stamping.policy"), servicePolicies)
+	if err != nil {
+		fatal("invalid stamping.policy", logging.Err(err))
+	}
+	eventBus := events.NewBus()
+	eventBus.Subscribe(metrics.HandleEvent)
+
+	// MusicBrainz shares the search rate limit and only degrades the instance,
+	// so it is checked at most once a minute
+	healthChecker := health.NewChecker().
+		Critical("database", database.PingContext).
+		Critical("migrations", database.CheckSchema).
+		Critical("atproto_signing_key", func(ctx context.Context) error { return atprotoService.CheckSigningKey() }).
+		Optional("musicbrainz", health.Cached(time.Minute, mbService.Ping))
+	playingNowService := playingnow.NewPlayingNowService(database, atprotoService, mbService).WithEvents(eventBus)
 
 	// Check feature toggles for music services
 	enableSpotify := viper.GetBool("enable_spotify")
@@ -114,13 +179,21 @@ func main() {
 		clientSecret := viper.GetString("spotify.client_secret")
 
 		if clientID != "" && clientSecret != "" {
-			spotifyService = spotify.NewSpotifyService(database, atprotoService, mbService, playingNowService)
-			log.Println("Spotify service enabled and configured")
+			spotifyService = spotify.NewSpotifyService(database, atprotoService, mbService, playingNowService).
+				WithEvents(eventBus).
+				WithHealth(healthChecker.Tracker(events.SourceSpotify)).
+				WithStamping(stampingResolver).
+				WithPolling(spotify.Polling{
+					IdleAfter:    time.Duration(viper.GetInt("tracker.idle_after_seconds")) * time.Second,
+					IdleInterval: time.Duration(viper.GetInt("tracker.idle_interval_seconds")) * time.Second,
+					Workers:      viper.GetInt("tracker.workers"),
+				})
+			slog.Info("Spotify service enabled and configured")
 		} else {
-			log.Println("Spotify enabled but credentials missing (client_id or client_secret). Spotify features will be disabled.")
+			slog.Warn("Spotify enabled but credentials missing (client_id or client_secret), Spotify features will be disabled")
 		}
 	} else {
-		log.Println("Spotify service disabled via ENABLE_SPOTIFY=false")
+		slog.Info("Spotify service disabled via ENABLE_SPOTIFY=false")
 	}
 
 	// Initialize Last.fm service if enabled and API key is present
@@ -128,13 +201,17 @@ func main() {
 		apiKey := viper.GetString("lastfm.api_key")
 
 		if apiKey != "" {
-			lastfmService = lastfm.NewLastFMService(database, apiKey, mbService, atprotoService, playingNowService)
-			log.Println("Last.fm service enabled and configured")
+			lastfmService = lastfm.NewLastFMService(database, apiKey, mbService, atprotoService, playingNowService).
+				WithEvents(eventBus).
+				WithHealth(healthChecker.Tracker(events.SourceLastFM)).
+				WithStamping(stampingResolver).
+				WithWorkers(viper.GetInt("tracker.workers"))
+			slog.Info("Last.fm service enabled and configured")
 		} else {
-			log.Println("Last.fm enabled but API key missing. Last.fm features will be disabled.")
+			slog.Warn("Last.fm enabled but API key missing, Last.fm features will be disabled")
 		}
 	} else {
-		log.Println("Last.fm service disabled via ENABLE_LASTFM=false")
+		slog.Info("Last.fm service disabled via ENABLE_LASTFM=false")
 	}
 
 	// Initialize Apple Music service if enabled and credentials are present
@@ -166,34 +243,81 @@ func main() {
 				func(token string, exp time.Time) error {
 					return database.SaveAppleMusicDeveloperToken(token, exp)
 				},
-			).WithDeps(database, atprotoService, mbService, playingNowService)
-			log.Println("Apple Music service enabled and configured")
+			).WithDeps(database, atprotoService, mbService, playingNowService).WithEvents(eventBus).WithHealth(healthChecker.Tracker(events.SourceAppleMusic)).WithStamping(stampingResolver).WithStorefront(viper.GetString("applemusic.storefront"))
+			slog.Info("Apple Music service enabled and configured")
 		} else {
-			log.Println("Apple Music enabled but credentials missing (team_id, key_id, or private_key_path). Apple Music features will be disabled.")
+			slog.Warn("Apple Music enabled but credentials missing (team_id, key_id, or private_key_path), Apple Music features will be disabled")
 		}
 	} else {
-		log.Println("Apple Music service disabled via ENABLE_APPLEMUSIC=false")
+		slog.Info("Apple Music service disabled via ENABLE_APPLEMUSIC=false")
 	}
 
 	oauthManager := oauth.NewOAuthServiceManager()
 
 	// Register Spotify OAuth service only if Spotify is enabled and configured
 	if spotifyService != nil {
-		spotifyOAuth := oauth.NewOAuth2Service(
+		spotifyOAuth, err := oauth.NewOAuth2Service(
 			viper.GetString("spotify.client_id"),
 			viper.GetString("spotify.client_secret"),
 			viper.GetString("callback.spotify"),
 			viper.GetStringSlice("spotify.scopes"),
 			"spotify",
 			spotifyService,
+			database,
 		)
+		if err != nil {
+			fatal("error creating Spotify OAuth service", logging.Err(err))
+		}
 		oauthManager.RegisterService("spotify", spotifyOAuth)
-		log.Println("Spotify OAuth service registered")
+		slog.Info("Spotify OAuth service registered")
+	}
+
+	// Any other OAuth2 providers, configured with endpoints under oauth2.<provider>
+	for _, provider := range viper.GetStringSlice("oauth2.providers") {
+		prefix := "oauth2." + provider
+		providerOAuth, err := oauth.NewOAuth2Service(
+			viper.GetString(prefix+".client_id"),
+			viper.GetString(prefix+".client_secret"),
+			viper.GetString(prefix+".callback_url"),
+			viper.GetStringSlice(prefix+".scopes"),
+			provider,
+			// no service polls these yet, their tokens are kept for the signed in user
+			oauth.NewStoringReceiver(provider, database),
+			database,
+		)
+		if err != nil {
+			slog.Warn("skipping OAuth2 provider", "provider", provider, logging.Err(err))
+			continue
+		}
+		oauthManager.RegisterService(provider, providerOAuth)
+		slog.Info("OAuth2 provider registered", "provider", provider)
 	}
 
 	oauthManager.RegisterService("atproto", atprotoService)
 
 	apiKeyService := apikeyService.NewAPIKeyService(database, sessionManager)
+	exportService := export.NewExportService(database, sessionManager.GetAPIKeyManager(), viper.GetString("export.dir"))
+	exportService.FailInterrupted()
+
+	// Last.fm and GNU FM relays need the API secret to sign requests
+	relayService := relay.NewRelayService(database, viper.GetString("lastfm.api_key"), viper.GetString("lastfm.secret_key"), viper.GetBool("relay.allow_private"))
+	eventBus.Subscribe(relayService.HandleEvent)
+
+	webhookService := webhook.NewWebhookService(database, viper.GetBool("webhooks.allow_private"))
+	eventBus.Subscribe(webhookService.HandleEvent)
+
+	statsService := stats.NewStatsService(database, time.Duration(viper.GetInt("stats.cache_ttl_seconds"))*time.Second).WithATProto(atprotoService)
+	profileService := profile.NewProfileService(database, atprotoService, playingNowService, statsService).WithStamping(stampingResolver)
+
+	// services holding per-user state that has to be dropped when an account is deleted
+	userCaches := []account.UserCache{playingNowService, statsService, stampingResolver}
+	if spotifyService != nil {
+		userCaches = append(userCaches, spotifyService)
+	}
+	if lastfmService != nil {
+		userCaches = append(userCaches, lastfmService)
+	}
+	accountService := account.NewAccountService(database, sessionManager, atprotoService, exportService, oauthManager, userCaches...)
 
 	app := &application{
 		database:          database,
@@ -205,6 +329,15 @@ func main() {
 		atprotoService:    atprotoService,
 		playingNowService: playingNowService,
 		appleMusicService: appleMusicService,
+		exportService:     exportService,
+		accountService:    accountService,
+		statsService:      statsService,
+		relayService:      relayService,
+		webhookService:    webhookService,
+		profileService:    profileService,
+		events:            eventBus,
+		health:            healthChecker,
+		stamping:          stampingResolver,
 		pages:             pages.NewPages(),
 	}
 
@@ -213,7 +346,7 @@ func main() {
 	// Start Spotify listening tracker if service is configured
 	if spotifyService != nil {
 		go spotifyService.StartListeningTracker(trackerInterval)
-		log.Println("Spotify listening tracker started")
+		slog.Info("Spotify listening tracker started")
 	}
 
 	// Start Last.fm listening tracker if service is configured
@@ -223,14 +356,26 @@ func main() {
 			lastfmInterval = 30 * time.Second
 		}
 		go lastfmService.StartListeningTracker(lastfmInterval)
-		log.Println("Last.fm listening tracker started")
+		slog.Info("Last.fm listening tracker started")
 	}
 
 	// Start Apple Music tracker if service is configured
 	if appleMusicService != nil {
 		go appleMusicService.StartListeningTracker(trackerInterval)
-		log.Println("Apple Music listening tracker started")
+		slog.Info("Apple Music listening tracker started")
+	}
+
+	relayInterval := time.Duration(viper.GetInt("relay.interval_seconds")) * time.Second
+	if relayInterval <= 0 {
+		relayInterval = time.Minute
+	}
+	relayService.StartWorker(relayInterval)
+
+	webhookInterval := time.Duration(viper.GetInt("webhooks.interval_seconds")) * time.Second
+	if webhookInterval <= 0 {
+		webhookInterval = 30 * time.Second
 	}
+	webhookService.StartWorker(webhookInterval)
 
 	serverAddr := fmt.Sprintf("%s:%s", viper.GetString("server.host"), viper.GetString("server.port"))
 	server := &http.Server{
@@ -240,6 +385,6 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
-	fmt.Printf("Server running at: http://%s\n", serverAddr)
-	log.Fatal(server.ListenAndServe())
+	slog.Info("server running", "url", "http://"+serverAddr)
+	fatal("server stopped", logging.Err(server.ListenAndServe()))
 }
@@ -2,9 +2,14 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/justinas/alice"
 	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
+	"github.com/teal-fm/piper/ratelimit"
+	"github.com/teal-fm/piper/service/history"
 	"github.com/teal-fm/piper/session"
 )
 
@@ -17,10 +22,17 @@ func (app *application) routes() http.Handler {
 	mux.HandleFunc("/", session.WithPossibleAuth(home(app.database, app.pages), app.sessionManager))
 
 	// OAuth Routes
-	mux.HandleFunc("/login/spotify", app.oauthManager.HandleLogin("spotify"))
+	mux.HandleFunc("/login/spotify", session.WithPossibleAuth(app.oauthManager.HandleLogin("spotify"), app.sessionManager))       // login state is bound to the session
 	mux.HandleFunc("/callback/spotify", session.WithPossibleAuth(app.oauthManager.HandleCallback("spotify"), app.sessionManager)) // Use possible auth
 	mux.HandleFunc("/login/atproto", app.oauthManager.HandleLogin("atproto"))
 	mux.HandleFunc("/callback/atproto", session.WithPossibleAuth(app.oauthManager.HandleCallback("atproto"), app.sessionManager)) // Use possible auth
+	// Generic OAuth2 providers from config, unknown providers 404 in the manager
+	mux.HandleFunc("/login/{provider}", session.WithPossibleAuth(func(w http.ResponseWriter, r *http.Request) {
+		app.oauthManager.HandleLogin(r.PathValue("provider"))(w, r)
+	}, app.sessionManager))
+	mux.HandleFunc("/callback/{provider}", session.WithPossibleAuth(func(w http.ResponseWriter, r *http.Request) {
+		app.oauthManager.HandleCallback(r.PathValue("provider"))(w, r)
+	}, app.sessionManager))
 
 	// Authenticated Web Routes
 	mux.HandleFunc("/current-track", session.WithAuth(app.spotifyService.HandleCurrentTrack, app.sessionManager))
@@ -28,25 +40,63 @@ func (app *application) routes() http.Handler {
 	mux.HandleFunc("/api-keys", session.WithAuth(app.apiKeyService.HandleAPIKeyManagement(app.database, app.pages), app.sessionManager))
 	mux.HandleFunc("/link-lastfm", session.WithAuth(handleLinkLastfmForm(app.database, app.pages), app.sessionManager)) // GET form
 	mux.HandleFunc("/link-lastfm/submit", session.WithAuth(handleLinkLastfmSubmit(app.database), app.sessionManager))   // POST submit - Changed route slightly
-	mux.HandleFunc("/link-applemusic", session.WithAuth(handleAppleMusicLink(app.pages, app.appleMusicService), app.sessionManager))
+	mux.HandleFunc("/link-applemusic", session.WithAuth(handleAppleMusicLink(app.database, app.pages, app.appleMusicService), app.sessionManager))
+	mux.HandleFunc("/relays", session.WithAuth(app.relayService.HandleRelays(app.pages), app.sessionManager))
+	mux.HandleFunc("/recap", session.WithAuth(app.statsService.HandleRecapPage(app.pages), app.sessionManager))
+	mux.HandleFunc("/profile", session.WithAuth(app.profileService.HandleSettings(app.pages), app.sessionManager))
+	mux.HandleFunc("/webhooks", session.WithAuth(app.webhookService.HandleWebhooks(app.pages), app.sessionManager))
+	mux.HandleFunc("/export", session.WithAuth(app.exportService.HandleExport(app.pages), app.sessionManager))
+	mux.HandleFunc("/export/download", session.WithAuth(app.exportService.HandleDownload, app.sessionManager))
+	mux.HandleFunc("/account/delete", session.WithAuth(app.accountService.HandleDeleteAccount(app.pages), app.sessionManager))
 	mux.HandleFunc("/logout", app.oauthManager.HandleLogout("atproto"))
 	mux.HandleFunc("/debug/", session.WithAuth(app.sessionManager.HandleDebug, app.sessionManager))
 
+	// Public profiles, by handle or DID, only for users who opted in
+	mux.HandleFunc("GET /u/{id}", session.WithPossibleAuth(app.profileService.HandleProfile(app.pages), app.sessionManager))
+	mux.HandleFunc("GET /u/{id}/now-playing.svg", session.WithPossibleAuth(app.profileService.HandleBadge(app.pages), app.sessionManager))
+	mux.HandleFunc("GET /u/{id}/embed", session.WithPossibleAuth(app.profileService.HandleEmbed(app.pages), app.sessionManager))
+	mux.HandleFunc("GET /u/{id}/feed.atom", session.WithPossibleAuth(app.profileService.HandleAtomFeed(), app.sessionManager))
+	mux.HandleFunc("GET /u/{id}/feed.rss", session.WithPossibleAuth(app.profileService.HandleRSSFeed(), app.sessionManager))
+	mux.HandleFunc("GET /u/{id}/feed.json", session.WithPossibleAuth(app.profileService.HandleJSONFeed(), app.sessionManager))
+
 	mux.HandleFunc("/api/v1/me", session.WithAPIAuth(apiMeHandler(app.database), app.sessionManager))
+	mux.HandleFunc("GET /api/v1/connections", session.WithAPIAuth(apiConnectionsHandler(app.database), app.sessionManager)) // Per-provider polling status
+	mux.HandleFunc("GET /api/v1/stamping", session.WithAPIAuth(apiStampingHandler(app.stamping), app.sessionManager))
+	mux.HandleFunc("PUT /api/v1/stamping", session.WithAPIAuth(apiStampingHandler(app.stamping), app.sessionManager)) // {"policy": "lexicon"}, "" for the instance default
+	mux.HandleFunc("GET /api/v1/spotify/settings", session.WithAPIAuth(apiSpotifySettingsHandler(app.database), app.sessionManager))
+	mux.HandleFunc("PUT /api/v1/spotify/settings", session.WithAPIAuth(apiSpotifySettingsHandler(app.database), app.sessionManager)) // {"skip_private_sessions": true, "ignored_devices": ["Living Room"], "episodes": "local"}
+	mux.HandleFunc("GET /api/v1/episodes", session.WithAPIAuth(apiEpisodesHandler(app.database), app.sessionManager))                // Podcast episodes, newest first
 	mux.HandleFunc("/api/v1/lastfm", session.WithAPIAuth(apiGetLastfmUserHandler(app.database), app.sessionManager))
 	mux.HandleFunc("/api/v1/lastfm/set", session.WithAPIAuth(apiLinkLastfmHandler(app.database), app.sessionManager))
 	mux.HandleFunc("/api/v1/lastfm/unset", session.WithAPIAuth(apiUnlinkLastfmHandler(app.database), app.sessionManager))
-	mux.HandleFunc("/api/v1/current-track", session.WithAPIAuth(apiCurrentTrack(app.spotifyService), app.sessionManager)) // Spotify Current
-	mux.HandleFunc("/api/v1/history", session.WithAPIAuth(apiTrackHistory(app.spotifyService), app.sessionManager))       // Spotify History
-	mux.HandleFunc("/api/v1/musicbrainz/search", apiMusicBrainzSearch(app.mbService))                                     // MusicBrainz (public?)
+	mux.HandleFunc("/api/v1/current-track", session.WithAPIAuth(apiCurrentTrack(app.spotifyService), app.sessionManager))     // Spotify Current
+	mux.HandleFunc("/api/v1/now-playing", session.WithAPIAuth(app.playingNowService.HandleNowPlaying, app.sessionManager))    // Any service
+	mux.HandleFunc("/api/v1/now-playing/stream", session.WithAPIAuth(app.playingNowService.HandleStream, app.sessionManager)) // Server-Sent Events
+	mux.HandleFunc("/api/v1/history", session.WithAPIAuth(history.Handler(app.database), app.sessionManager))                 // Paginated and filterable history
+	mux.HandleFunc("/api/v1/musicbrainz/search", apiMusicBrainzSearch(app.mbService))                                         // MusicBrainz (public?)
+
+	// Listening statistics, ?range=7d|30d|90d|1y|all&tz=<IANA zone>
+	mux.HandleFunc("/api/v1/stats", session.WithAPIAuth(app.statsService.HandleOverview, app.sessionManager))
+	mux.HandleFunc("/api/v1/stats/activity", session.WithAPIAuth(app.statsService.HandleActivity, app.sessionManager))
+	mux.HandleFunc("/api/v1/stats/recap/{period}", session.WithAPIAuth(app.statsService.HandleRecap, app.sessionManager)) // year (2024) or month (2024-05)
+	mux.HandleFunc("/api/v1/stats/{kind}", session.WithAPIAuth(app.statsService.HandleTop, app.sessionManager))           // artists, tracks or releases
+
+	// Data export, archives are built in the background
+	mux.HandleFunc("/api/v1/export", session.WithAPIAuth(app.exportService.HandleExport(app.pages), app.sessionManager))
+	mux.HandleFunc("/api/v1/export/download", session.WithAPIAuth(app.exportService.HandleDownload, app.sessionManager))
 
 	// Apple Music user authorization (protected with session auth)
 	mux.HandleFunc("/api/v1/applemusic/authorize", session.WithAuth(apiAppleMusicAuthorize(app.database), app.sessionManager))
 	mux.HandleFunc("/api/v1/applemusic/unlink", session.WithAuth(apiAppleMusicUnlink(app.database), app.sessionManager))
 
-	// ListenBrainz-compatible endpoint
-	mux.HandleFunc("/1/submit-listens", session.WithAPIAuth(apiSubmitListensHandler(app.database, app.atprotoService, app.playingNowService, app.mbService), app.sessionManager))
-	mux.HandleFunc("/1/validate-token", apiMbTokenValidateHandler(app.sessionManager))
+	// ListenBrainz-compatible endpoint, rate limited per token with X-RateLimit-* headers
+	lbLimiter := ratelimit.NewLimiter(viper.GetInt("listenbrainz.rate_limit"), time.Duration(viper.GetInt("listenbrainz.rate_limit_window_seconds"))*time.Second).
+		WithKeys(app.sessionManager.GetAPIKeyManager())
+	mux.HandleFunc("/1/submit-listens", ratelimit.WithRateLimit(session.WithAPIAuth(apiSubmitListensHandler(app.database, app.atprotoService, app.playingNowService, app.mbService, app.events, app.stamping), app.sessionManager), lbLimiter))
+	mux.HandleFunc("/1/validate-token", ratelimit.WithRateLimit(apiMbTokenValidateHandler(app.sessionManager), lbLimiter))
+	mux.HandleFunc("GET /1/user/{user}/listens", ratelimit.WithRateLimit(apiListenBrainzListensHandler(app.database, app.sessionManager), lbLimiter))
+	mux.HandleFunc("GET /1/user/{user}/listen-count", ratelimit.WithRateLimit(apiListenBrainzListenCountHandler(app.database, app.sessionManager), lbLimiter))
+	mux.HandleFunc("GET /1/user/{user}/playing-now", ratelimit.WithRateLimit(apiListenBrainzPlayingNowHandler(app.database, app.sessionManager, app.playingNowService), lbLimiter))
 
 	serverUrlRoot := viper.GetString("server.root_url")
 	mux.HandleFunc("/oauth-client-metadata.json", func(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +104,13 @@ func (app *application) routes() http.Handler {
 	})
 	mux.HandleFunc("/oauth/jwks.json", app.atprotoService.HandleJwks)
 
+	// Liveness and readiness probes
+	mux.HandleFunc("GET /healthz", app.health.HandleHealthz)
+	mux.HandleFunc("GET /readyz", app.health.HandleReadyz)
+
+	// Prometheus metrics, optionally behind METRICS_TOKEN
+	mux.Handle("GET /metrics", metrics.Handler(viper.GetString("metrics.token")))
+
 	standard := alice.New()
-	return standard.Then(mux)
+	return standard.Then(logging.WithRequestID(metrics.WithMetrics(mux)))
 }
@@ -20,9 +20,34 @@ func Load() {
 	viper.SetDefault("callback.spotify", "http://localhost:8080/callback/spotify")
 	viper.SetDefault("spotify.auth_url", "https://accounts.spotify.com/authorize")
 	viper.SetDefault("spotify.token_url", "https://accounts.spotify.com/api/token")
-	viper.SetDefault("spotify.scopes", "user-read-currently-playing user-read-email")
+	viper.SetDefault("spotify.scopes", "user-read-currently-playing user-read-playback-state user-read-email")
+	// extra OAuth2 providers, each needs oauth2.<name>.auth_url, token_url, client_id, client_secret, callback_url and scopes
+	viper.SetDefault("oauth2.providers", []string{})
 	viper.SetDefault("tracker.interval", 30)
+	// Spotify users idle for idle_after_seconds are polled every idle_interval_seconds, workers users at once
+	viper.SetDefault("tracker.idle_after_seconds", 3600)
+	viper.SetDefault("tracker.idle_interval_seconds", 300)
+	viper.SetDefault("tracker.workers", 4)
+	viper.SetDefault("stamping.policy", "default")
+	viper.SetDefault("stamping.policies", "")
 	viper.SetDefault("db.path", "./data/piper.db")
+	viper.SetDefault("export.dir", "./data/exports")
+	viper.SetDefault("stats.cache_ttl_seconds", 300)
+	// ListenBrainz-compatible API requests allowed per token (or IP) in each window, ListenBrainz's own defaults
+	viper.SetDefault("listenbrainz.rate_limit", 50)
+	viper.SetDefault("listenbrainz.rate_limit_window_seconds", 10)
+	// how often queued relay deliveries are checked for retries, and whether relays may target private network addresses
+	viper.SetDefault("relay.interval_seconds", 60)
+	viper.SetDefault("relay.allow_private", false)
+	// how often webhook retries are checked, and whether webhooks may target private network addresses
+	viper.SetDefault("webhooks.interval_seconds", 30)
+	viper.SetDefault("webhooks.allow_private", false)
+	// log output as text or json, the default level and per subsystem overrides such as "spotify=debug,db=warn"
+	viper.SetDefault("log.format", "text")
+	viper.SetDefault("log.level", "info")
+	viper.SetDefault("log.levels", "")
+	// bearer token required to scrape /metrics, empty leaves it open
+	viper.SetDefault("metrics.token", "")
 
 	// Feature toggles for music services (default to true for backwards compatibility)
 	viper.SetDefault("enable_spotify", true)
@@ -33,6 +58,8 @@ func Load() {
 	viper.SetDefault("applemusic.team_id", "")
 	viper.SetDefault("applemusic.key_id", "")
 	viper.SetDefault("applemusic.private_key_path", "./AM_AUTHKEY.p8")
+	// catalog storefront used for metadata when a user's own can't be looked up
+	viper.SetDefault("applemusic.storefront", "us")
 
 	// server metadata
 	viper.SetDefault("server.root_url", "http://localhost:8080")
@@ -45,6 +72,7 @@ func Load() {
 	_ = viper.BindEnv("applemusic.team_id", "APPLE_MUSIC_TEAM_ID")
 	_ = viper.BindEnv("applemusic.key_id", "APPLE_MUSIC_KEY_ID")
 	_ = viper.BindEnv("applemusic.private_key_path", "APPLE_MUSIC_PRIVATE_KEY_PATH")
+	_ = viper.BindEnv("applemusic.storefront", "APPLE_MUSIC_STOREFRONT")
 
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DeleteUser removes a user and everything stored for them in a single transaction:
+// tracks, web sessions, pending logins, API keys, export jobs, relays, webhooks, connection statuses,
+// the Apple Music recently played window and ATProto sessions.
+// Export archives on disk are not touched, callers should remove those first
+func (db *DB) DeleteUser(userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		// no-op once committed
+		_ = tx.Rollback()
+	}(tx)
+
+	var did sql.NullString
+	err = tx.QueryRow(`SELECT atproto_did FROM users WHERE id = ?`, userID).Scan(&did)
+	if err != nil {
+		return fmt.Errorf("failed to load user %d: %w", userID, err)
+	}
+
+	statements := []struct {
+		table string
+		query string
+	}{
+		// before sessions, pending logins are only linked to the user through their session
+		{"oauth2_state", `DELETE FROM oauth2_state WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ?)`},
+		{"tracks", `DELETE FROM tracks WHERE user_id = ?`},
+		{"oauth2_tokens", `DELETE FROM oauth2_tokens WHERE user_id = ?`},
+		{"episodes", `DELETE FROM episodes WHERE user_id = ?`},
+		{"sessions", `DELETE FROM sessions WHERE user_id = ?`},
+		{"api_keys", `DELETE FROM api_keys WHERE user_id = ?`},
+		{"export_jobs", `DELETE FROM export_jobs WHERE user_id = ?`},
+		{"relay_queue", `DELETE FROM relay_queue WHERE user_id = ?`},
+		{"relay_targets", `DELETE FROM relay_targets WHERE user_id = ?`},
+		{"webhook_deliveries", `DELETE FROM webhook_deliveries WHERE user_id = ?`},
+		{"webhooks", `DELETE FROM webhooks WHERE user_id = ?`},
+		{"connection_status", `DELETE FROM connection_status WHERE user_id = ?`},
+		{"applemusic_recent", `DELETE FROM applemusic_recent WHERE user_id = ?`},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, userID); err != nil {
+			return fmt.Errorf("failed to delete %s for user %d: %w", stmt.table, userID, err)
+		}
+	}
+
+	if did.Valid && did.String != "" {
+		if _, err := tx.Exec(`DELETE FROM atproto_sessions WHERE account_did = ?`, did.String); err != nil {
+			return fmt.Errorf("failed to delete atproto sessions for user %d: %w", userID, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM atproto_state WHERE account_did = ?`, did.String); err != nil {
+			return fmt.Errorf("failed to delete atproto state for user %d: %w", userID, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete user %d: %w", userID, err)
+	}
+
+	return tx.Commit()
+}
@@ -5,14 +5,14 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
 )
 
 // ApiKey represents an API key for authenticating requests
@@ -45,7 +45,7 @@ func NewApiKeyManager(database *db.DB) *Manager {
 	)`)
 
 	if err != nil {
-		log.Printf("Error creating api_keys table: %v", err)
+		slog.Error("error creating api_keys table", logging.Err(err))
 	}
 
 	return &Manager{
@@ -103,10 +103,8 @@ func (am *Manager) GetApiKey(apiKeyID string) (*ApiKey, bool) {
 	if exists {
 		// Check if API key is expired
 		if time.Now().UTC().After(apiKey.ExpiresAt) {
-			err := am.DeleteApiKey(apiKeyID)
-			fmt.Println("Error deleting an expired API key: %w", err)
-			if err != nil {
-				return nil, false
+			if err := am.DeleteApiKey(apiKeyID); err != nil {
+				slog.Error("error deleting an expired API key", logging.Err(err))
 			}
 			return nil, false
 		}
@@ -125,10 +123,8 @@ func (am *Manager) GetApiKey(apiKeyID string) (*ApiKey, bool) {
 	}
 
 	if time.Now().UTC().After(apiKey.ExpiresAt) {
-		err := am.DeleteApiKey(apiKeyID)
-		fmt.Println("Error deleting an expired API key: %w", err)
-		if err != nil {
-			return nil, false
+		if err := am.DeleteApiKey(apiKeyID); err != nil {
+			slog.Error("error deleting an expired API key", logging.Err(err))
 		}
 		return nil, false
 	}
@@ -151,6 +147,17 @@ func (am *Manager) DeleteApiKey(apiKeyID string) error {
 	return err
 }
 
+// EvictUser drops every cached API key belonging to a user. Used when the user's rows are already gone
+func (am *Manager) EvictUser(userID int64) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	for id, apiKey := range am.apiKeys {
+		if apiKey.UserID == userID {
+			delete(am.apiKeys, id)
+		}
+	}
+}
+
 // GetUserApiKeys retrieves all API keys for a user
 func (am *Manager) GetUserApiKeys(userID int64) ([]*ApiKey, error) {
 	rows, err := am.db.Query(`
@@ -165,7 +172,7 @@ func (am *Manager) GetUserApiKeys(userID int64) ([]*ApiKey, error) {
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
-			fmt.Println("Error closing API keys rows: %w", err)
+			slog.Error("error closing API keys rows", logging.Err(err))
 		}
 	}(rows)
 
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// AppleMusicRecent the recently played window seen on a user's last poll
+type AppleMusicRecent struct {
+	// Keys the entries of the window, newest first
+	Keys     []string
+	PolledAt time.Time
+	// HeldSince when the newest entry, held back while it may still be
+	// playing, was first seen. nil when it has been recorded
+	HeldSince *time.Time
+}
+
+// GetAppleMusicRecentSnapshot returns the recently played window seen on the
+// user's last poll, nil before the first poll
+func (db *DB) GetAppleMusicRecentSnapshot(userID int64) (*AppleMusicRecent, error) {
+	var raw string
+	recent := &AppleMusicRecent{}
+	err := db.QueryRow(`SELECT track_keys, polled_at, held_since FROM applemusic_recent WHERE user_id = ?`, userID).
+		Scan(&raw, &recent.PolledAt, &recent.HeldSince)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(raw), &recent.Keys); err != nil {
+		return nil, err
+	}
+	return recent, nil
+}
+
+// SaveAppleMusicRecentSnapshot replaces the user's recently played window
+func (db *DB) SaveAppleMusicRecentSnapshot(userID int64, recent *AppleMusicRecent) error {
+	keys := recent.Keys
+	if keys == nil {
+		keys = []string{}
+	}
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	var heldSince *time.Time
+	if recent.HeldSince != nil {
+		t := recent.HeldSince.UTC()
+		heldSince = &t
+	}
+	_, err = db.Exec(`
+	INSERT INTO applemusic_recent (user_id, track_keys, polled_at, held_since)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET
+		track_keys = excluded.track_keys,
+		polled_at = excluded.polled_at,
+		held_since = excluded.held_since`,
+		userID, string(raw), recent.PolledAt.UTC(), heldSince)
+	return err
+}
+
+// DeleteAppleMusicRecentSnapshot forgets the user's recently played window, e.g. once they unlink
+func (db *DB) DeleteAppleMusicRecentSnapshot(userID int64) error {
+	_, err := db.Exec(`DELETE FROM applemusic_recent WHERE user_id = ?`, userID)
+	return err
+}
+
+// GetAppleMusicCatalogSong returns a cached catalog lookup of an Apple Music
+// song as JSON, "" when Apple didn't know the song. ok is false if it was never looked up
+func (db *DB) GetAppleMusicCatalogSong(storefront, songID string) (data string, fetchedAt time.Time, ok bool, err error) {
+	var raw sql.NullString
+	err = db.QueryRow(`SELECT data, fetched_at FROM applemusic_catalog WHERE storefront = ? AND song_id = ?`,
+		storefront, songID).Scan(&raw, &fetchedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	return raw.String, fetchedAt, true, nil
+}
+
+// SaveAppleMusicCatalogSong caches a catalog lookup, "" to remember Apple didn't know the song
+func (db *DB) SaveAppleMusicCatalogSong(storefront, songID, data string) error {
+	var value *string
+	if data != "" {
+		value = &data
+	}
+	_, err := db.Exec(`
+	INSERT INTO applemusic_catalog (storefront, song_id, data, fetched_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(storefront, song_id) DO UPDATE SET
+		data = excluded.data,
+		fetched_at = excluded.fetched_at`,
+		storefront, songID, value, time.Now().UTC())
+	return err
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/models"
 )
 
@@ -50,8 +51,21 @@ func (db *DB) FindOrCreateUserByDID(did string) (*models.User, error) {
 	return &user, err
 }
 
+// GetUserByDID looks up the user for a DID without creating one. Returns nil if there is none
+func (db *DB) GetUserByDID(did string) (*models.User, error) {
+	var userID int64
+	err := db.QueryRow(`SELECT id FROM users WHERE atproto_did = ?`, did).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by DID: %w", err)
+	}
+	return db.GetUserByID(userID)
+}
+
 func (db *DB) SetLatestATProtoSessionId(did string, atProtoSessionID string) error {
-	db.logger.Printf("Setting latest atproto session id for did %s to %s", did, atProtoSessionID)
+	db.logger.Debug("setting latest atproto session id", logging.DID(did), "session_id", atProtoSessionID)
 	now := time.Now().UTC()
 
 	result, err := db.Exec(`
@@ -65,7 +79,7 @@ func (db *DB) SetLatestATProtoSessionId(did string, atProtoSessionID string) err
 		did,
 	)
 	if err != nil {
-		db.logger.Printf("%v", err)
+		db.logger.Error("error updating atproto session id", logging.DID(did), logging.Err(err))
 		return fmt.Errorf("failed to update atproto session for did %s: %s", did, atProtoSessionID)
 	}
 
@@ -349,3 +363,22 @@ func (s *SqliteATProtoStore) DeleteAuthRequestInfo(ctx context.Context, state st
 	_, err := s.db.Exec(`DELETE FROM atproto_state WHERE state = ?`, state)
 	return err
 }
+
+// GetATProtoSessionIDs returns the ids of every stored ATProto OAuth session for a DID
+func (db *DB) GetATProtoSessionIDs(did string) ([]string, error) {
+	rows, err := db.Query(`SELECT session_id FROM atproto_sessions WHERE account_did = ?`, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query atproto sessions for did %s: %w", did, err)
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs, rows.Err()
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+)
+
+const connectionStatusColumns = `user_id, provider, last_success_at, last_error, last_error_at, error_count, needs_reauth, next_poll_at, updated_at`
+
+func scanConnectionStatus(scan func(dest ...any) error) (*models.ConnectionStatus, error) {
+	status := &models.ConnectionStatus{}
+	err := scan(&status.UserID, &status.Provider, &status.LastSuccessAt, &status.LastError, &status.LastErrorAt,
+		&status.ErrorCount, &status.NeedsReauth, &status.NextPollAt, &status.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetConnectionStatus returns nil if provider has never been polled for the user
+func (db *DB) GetConnectionStatus(userID int64, provider string) (*models.ConnectionStatus, error) {
+	status, err := scanConnectionStatus(db.QueryRow(`
+	SELECT `+connectionStatusColumns+`
+	FROM connection_status WHERE user_id = ? AND provider = ?`, userID, provider).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return status, err
+}
+
+// GetUserConnectionStatuses returns the status of each of a user's providers, by provider name
+func (db *DB) GetUserConnectionStatuses(userID int64) ([]*models.ConnectionStatus, error) {
+	rows, err := db.Query(`
+	SELECT `+connectionStatusColumns+`
+	FROM connection_status
+	WHERE user_id = ?
+	ORDER BY provider`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []*models.ConnectionStatus
+	for rows.Next() {
+		status, err := scanConnectionStatus(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+// RecordConnectionSuccess marks a poll as successful, clearing any backoff
+func (db *DB) RecordConnectionSuccess(userID int64, provider string) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+	INSERT INTO connection_status (user_id, provider, last_success_at, updated_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(user_id, provider) DO UPDATE SET
+		last_success_at = excluded.last_success_at,
+		error_count = 0,
+		needs_reauth = 0,
+		next_poll_at = NULL,
+		updated_at = excluded.updated_at`,
+		userID, provider, now, now)
+	return err
+}
+
+// RecordConnectionFailure saves why a poll failed and when to poll again. When
+// needsReauth is set the provider isn't polled again until the user relinks it
+func (db *DB) RecordConnectionFailure(userID int64, provider, message string, needsReauth bool, nextPollAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+	INSERT INTO connection_status (user_id, provider, last_error, last_error_at, error_count, needs_reauth, next_poll_at, updated_at)
+	VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+	ON CONFLICT(user_id, provider) DO UPDATE SET
+		last_error = excluded.last_error,
+		last_error_at = excluded.last_error_at,
+		error_count = error_count + 1,
+		needs_reauth = excluded.needs_reauth,
+		next_poll_at = excluded.next_poll_at,
+		updated_at = excluded.updated_at`,
+		userID, provider, message, now, needsReauth, nextPollAt.UTC(), now)
+	return err
+}
+
+// GetPausedConnections returns the users provider shouldn't be polled for at now,
+// either backing off after failures or waiting for the user to relink
+func (db *DB) GetPausedConnections(provider string, now time.Time) (map[int64]bool, error) {
+	rows, err := db.Query(`
+	SELECT user_id FROM connection_status
+	WHERE provider = ? AND (needs_reauth OR next_poll_at > ?)`, provider, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paused := make(map[int64]bool)
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		paused[userID] = true
+	}
+	return paused, rows.Err()
+}
+
+// DeleteConnectionStatus forgets a provider's status, e.g. once the user links or unlinks it
+func (db *DB) DeleteConnectionStatus(userID int64, provider string) error {
+	_, err := db.Exec(`DELETE FROM connection_status WHERE user_id = ? AND provider = ?`, userID, provider)
+	return err
+}
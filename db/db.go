@@ -1,22 +1,24 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/models"
 )
 
 type DB struct {
 	*sql.DB
-	logger *log.Logger
+	logger *slog.Logger
 }
 
 func New(dbPath string) (*DB, error) {
@@ -24,8 +26,7 @@ func New(dbPath string) (*DB, error) {
 	if dir != "." && dir != "/" {
 		err := os.MkdirAll(dir, 0755)
 		if err != nil {
-			fmt.Println("Failed to create directory for database:", err)
-			return nil, err
+			return nil, fmt.Errorf("failed to create directory for database: %w", err)
 		}
 	}
 
@@ -38,9 +39,7 @@ func New(dbPath string) (*DB, error) {
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
-	logger := log.New(os.Stdout, "db: ", log.LstdFlags|log.Lmsgprefix)
-
-	return &DB{db, logger}, nil
+	return &DB{db, logging.For("db")}, nil
 }
 
 func (db *DB) Initialize() error {
@@ -70,6 +69,33 @@ func (db *DB) Initialize() error {
 		return err
 	}
 
+	// opt-in public profile page and embeds
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN public_profile BOOLEAN NOT NULL DEFAULT 0`)
+	if err != nil && err.Error() != "duplicate column name: public_profile" {
+		return err
+	}
+
+	// stamping policy picked by the user, NULL follows the instance default
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN stamp_policy TEXT`)
+	if err != nil && err.Error() != "duplicate column name: stamp_policy" {
+		return err
+	}
+
+	// which Spotify playback is left out of tracking, ignored devices as a JSON array
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN spotify_skip_private_sessions BOOLEAN NOT NULL DEFAULT 1`)
+	if err != nil && err.Error() != "duplicate column name: spotify_skip_private_sessions" {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN spotify_ignored_devices TEXT`)
+	if err != nil && err.Error() != "duplicate column name: spotify_ignored_devices" {
+		return err
+	}
+	// whether podcast episodes are ignored, saved or saved and published, see models.EpisodeModes
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN spotify_episodes TEXT NOT NULL DEFAULT 'ignore'`)
+	if err != nil && err.Error() != "duplicate column name: spotify_episodes" {
+		return err
+	}
+
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS tracks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -86,6 +112,18 @@ func (db *DB) Initialize() error {
 		service_base_url TEXT,
 		isrc TEXT,
 		has_stamped BOOLEAN,
+		release_group_mbid TEXT,
+		release_track_mbid TEXT,
+		work_mbids TEXT, -- JSON array
+		track_number INTEGER,
+		disc_number INTEGER,
+		tags TEXT, -- JSON array
+		submission_client TEXT,
+		submission_client_version TEXT,
+		media_player TEXT,
+		youtube_id TEXT,
+		device_name TEXT,
+		device_type TEXT,
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	)`)
 	if err != nil {
@@ -138,6 +176,204 @@ func (db *DB) Initialize() error {
 		return err
 	}
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS export_jobs (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT,
+			file_path TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	CREATE INDEX IF NOT EXISTS idx_export_jobs_user_id ON export_jobs(user_id);
+`)
+	if err != nil {
+		return err
+	}
+
+	// other scrobblers a user's plays are forwarded to, and the deliveries still to be made
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS relay_targets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			url TEXT NOT NULL,
+			username TEXT,
+			secret TEXT NOT NULL, -- ListenBrainz token or Last.fm session key
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			last_error TEXT,
+			last_success_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	CREATE INDEX IF NOT EXISTS idx_relay_targets_user_id ON relay_targets(user_id);
+		CREATE TABLE IF NOT EXISTS relay_queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			track TEXT NOT NULL, -- JSON
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (target_id) REFERENCES relay_targets(id)
+		);
+	CREATE INDEX IF NOT EXISTS idx_relay_queue_next_attempt_at ON relay_queue(next_attempt_at);
+`)
+	if err != nil {
+		return err
+	}
+
+	// user configured webhooks and the log of what was sent to them
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '', -- comma separated, empty for all
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+	CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMP NOT NULL,
+			response_code INTEGER,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP,
+			FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+		);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+`)
+	if err != nil {
+		return err
+	}
+
+	// pending logins for the generic OAuth2 providers (spotify etc.)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oauth2_state (
+			state TEXT PRIMARY KEY,
+			provider TEXT NOT NULL,
+			session_id TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		);
+`)
+	if err != nil {
+		return err
+	}
+
+	// tokens of generic OAuth2 providers, which no piper service polls yet
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oauth2_tokens (
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			access_token TEXT NOT NULL,
+			refresh_token TEXT,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, provider),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+`)
+	if err != nil {
+		return err
+	}
+
+	// how polling each linked provider is going per user, so broken links can be surfaced and backed off
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS connection_status (
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			last_success_at TIMESTAMP,
+			last_error TEXT,
+			last_error_at TIMESTAMP,
+			error_count INTEGER NOT NULL DEFAULT 0, -- consecutive, reset by a success
+			needs_reauth BOOLEAN NOT NULL DEFAULT 0,
+			next_poll_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, provider),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+`)
+	if err != nil {
+		return err
+	}
+
+	// the Apple Music recently played window seen on each user's last poll, to tell new plays apart
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS applemusic_recent (
+			user_id INTEGER PRIMARY KEY,
+			track_keys TEXT NOT NULL, -- JSON array, newest first
+			polled_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+`)
+	if err != nil {
+		return err
+	}
+	// when the newest entry of track_keys, not recorded while it may still be playing, was first seen
+	_, err = db.Exec(`ALTER TABLE applemusic_recent ADD COLUMN held_since TIMESTAMP`)
+	if err != nil && err.Error() != "duplicate column name: held_since" {
+		return err
+	}
+
+	// Apple Music catalog lookups shared by every user, data is NULL for songs Apple didn't know
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS applemusic_catalog (
+			storefront TEXT NOT NULL,
+			song_id TEXT NOT NULL,
+			data TEXT, -- JSON
+			fetched_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (storefront, song_id)
+		);
+`)
+	if err != nil {
+		return err
+	}
+
+	// podcast episode plays, kept apart from tracks
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS episodes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			show_name TEXT NOT NULL,
+			publisher TEXT,
+			url TEXT NOT NULL,
+			timestamp TIMESTAMP,
+			duration_ms INTEGER,
+			progress_ms INTEGER,
+			service_base_url TEXT,
+			has_stamped BOOLEAN,
+			device_name TEXT,
+			device_type TEXT,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_episodes_user_timestamp ON episodes(user_id, timestamp);
+`)
+	if err != nil {
+		return err
+	}
+
+	// history is always read per user, newest first
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_tracks_user_timestamp ON tracks(user_id, timestamp)`)
+	if err != nil {
+		return err
+	}
+
 	// Add columns recording_mbid and release_mbid to tracks table if they don't exist
 	_, err = db.Exec(`ALTER TABLE tracks ADD COLUMN recording_mbid TEXT`)
 	if err != nil && err.Error() != "duplicate column name: recording_mbid" {
@@ -150,6 +386,130 @@ func (db *DB) Initialize() error {
 		return err
 	}
 
+	// extra ListenBrainz metadata
+	for _, column := range []struct{ name, kind string }{
+		{"release_group_mbid", "TEXT"},
+		{"release_track_mbid", "TEXT"},
+		{"work_mbids", "TEXT"},
+		{"track_number", "INTEGER"},
+		{"disc_number", "INTEGER"},
+		{"tags", "TEXT"},
+		{"submission_client", "TEXT"},
+		{"submission_client_version", "TEXT"},
+		{"media_player", "TEXT"},
+		{"youtube_id", "TEXT"},
+		// the device a play was heard on
+		{"device_name", "TEXT"},
+		{"device_type", "TEXT"},
+	} {
+		_, err = db.Exec(`ALTER TABLE tracks ADD COLUMN ` + column.name + ` ` + column.kind)
+		if err != nil && err.Error() != "duplicate column name: "+column.name {
+			return err
+		}
+	}
+
+	return db.migrateTrackTimestampsToUTC()
+}
+
+// migrateTrackTimestampsToUTC rewrites timestamps saved with a local offset
+// before tracks were stored in UTC, so history sorts and pages by instant
+func (db *DB) migrateTrackTimestampsToUTC() error {
+	rows, err := db.Query(`
+	SELECT id, timestamp
+	FROM tracks
+	WHERE typeof(timestamp) = 'text' AND substr(timestamp, -6) != '+00:00'`)
+	if err != nil {
+		return err
+	}
+	timestamps := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var timestamp time.Time
+		if err := rows.Scan(&id, &timestamp); err != nil {
+			rows.Close()
+			return err
+		}
+		timestamps[id] = timestamp
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(timestamps) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		// no-op once committed
+		_ = tx.Rollback()
+	}(tx)
+	for id, timestamp := range timestamps {
+		if _, err := tx.Exec(`UPDATE tracks SET timestamp = ? WHERE id = ?`, timestamp.UTC(), id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.logger.Info("migrated track timestamps to UTC", "tracks", len(timestamps))
+	return nil
+}
+
+// expectedSchema lists the tables Initialize creates with the columns added by
+// its later migrations, used to tell whether a database is fully migrated
+var expectedSchema = map[string][]string{
+	"users":              {"applemusic_user_token", "public_profile", "stamp_policy", "spotify_skip_private_sessions", "spotify_ignored_devices", "spotify_episodes"},
+	"tracks":             {"recording_mbid", "release_mbid", "release_group_mbid", "youtube_id", "device_name"},
+	"atproto_state":      nil,
+	"atproto_sessions":   nil,
+	"export_jobs":        nil,
+	"relay_targets":      nil,
+	"relay_queue":        nil,
+	"webhooks":           nil,
+	"webhook_deliveries": nil,
+	"oauth2_state":       nil,
+	"oauth2_tokens":      nil,
+	"connection_status":  nil,
+	"applemusic_recent":  nil,
+	"applemusic_catalog": nil,
+	"episodes":           nil,
+}
+
+// CheckSchema returns an error naming the first missing table or column when
+// the database has not been migrated by Initialize
+func (db *DB) CheckSchema(ctx context.Context) error {
+	for table, columns := range expectedSchema {
+		rows, err := db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			existing[name] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(existing) == 0 {
+			return fmt.Errorf("missing table %s", table)
+		}
+		for _, column := range columns {
+			if !existing[column] {
+				return fmt.Errorf("missing column %s.%s", table, column)
+			}
+		}
+	}
 	return nil
 }
 
@@ -333,7 +693,7 @@ func (db *DB) GetAllAppleMusicLinkedUsers() ([]*models.User, error) {
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
-			log.Println(err)
+			db.logger.Error("error closing rows", logging.Err(err))
 		}
 	}(rows)
 
@@ -367,14 +727,29 @@ func (db *DB) SaveTrack(userID int64, track *models.Track) (int64, error) {
 		artistString = string(bytes)
 	}
 
+	workMBIDs, err := marshalStrings(track.WorkMBIDs)
+	if err != nil {
+		return 0, err
+	}
+	tags, err := marshalStrings(track.Tags)
+	if err != nil {
+		return 0, err
+	}
+
 	var trackID int64
 
-	err := db.QueryRow(`
-	INSERT INTO tracks (user_id, name, recording_mbid, artist, album, release_mbid, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	// timestamps are stored in UTC so they sort and compare as text
+	err = db.QueryRow(`
+	INSERT INTO tracks (user_id, name, recording_mbid, artist, album, release_mbid, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped,
+		release_group_mbid, release_track_mbid, work_mbids, track_number, disc_number, tags, submission_client, submission_client_version, media_player, youtube_id,
+		device_name, device_type)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	RETURNING id`,
-		userID, track.Name, track.RecordingMBID, artistString, track.Album, track.ReleaseMBID, track.URL, track.Timestamp,
-		track.DurationMs, track.ProgressMs, track.ServiceBaseUrl, track.ISRC, track.HasStamped).Scan(&trackID)
+		userID, track.Name, track.RecordingMBID, artistString, track.Album, track.ReleaseMBID, track.URL, track.Timestamp.UTC(),
+		track.DurationMs, track.ProgressMs, track.ServiceBaseUrl, track.ISRC, track.HasStamped,
+		track.ReleaseGroupMBID, track.ReleaseTrackMBID, workMBIDs, track.TrackNumber, track.DiscNumber, tags,
+		track.SubmissionClient, track.SubmissionClientVersion, track.MediaPlayer, track.YouTubeID,
+		nullIfEmpty(track.DeviceName), nullIfEmpty(track.DeviceType)).Scan(&trackID)
 
 	return trackID, err
 }
@@ -391,7 +766,16 @@ func (db *DB) UpdateTrack(trackID int64, track *models.Track) error {
 		artistString = string(bytes)
 	}
 
-	_, err := db.Exec(`
+	workMBIDs, err := marshalStrings(track.WorkMBIDs)
+	if err != nil {
+		return err
+	}
+	tags, err := marshalStrings(track.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
 	UPDATE tracks
 	SET name = ?,
 	    recording_mbid = ?,
@@ -404,18 +788,129 @@ func (db *DB) UpdateTrack(trackID int64, track *models.Track) error {
 		progress_ms = ?,
 		service_base_url = ?,
 		isrc = ?,
-		has_stamped = ?
+		has_stamped = ?,
+		release_group_mbid = ?,
+		release_track_mbid = ?,
+		work_mbids = ?,
+		track_number = ?,
+		disc_number = ?,
+		tags = ?,
+		submission_client = ?,
+		submission_client_version = ?,
+		media_player = ?,
+		youtube_id = ?,
+		device_name = ?,
+		device_type = ?
 	WHERE id = ?`,
-		track.Name, track.RecordingMBID, artistString, track.Album, track.ReleaseMBID, track.URL, track.Timestamp,
+		track.Name, track.RecordingMBID, artistString, track.Album, track.ReleaseMBID, track.URL, track.Timestamp.UTC(),
 		track.DurationMs, track.ProgressMs, track.ServiceBaseUrl, track.ISRC, track.HasStamped,
+		track.ReleaseGroupMBID, track.ReleaseTrackMBID, workMBIDs, track.TrackNumber, track.DiscNumber, tags,
+		track.SubmissionClient, track.SubmissionClientVersion, track.MediaPlayer, track.YouTubeID,
+		nullIfEmpty(track.DeviceName), nullIfEmpty(track.DeviceType),
 		trackID)
 
 	return err
 }
 
+// marshalStrings encodes a list column as JSON, NULL when empty
+func marshalStrings(values []string) (*string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	s := string(bytes)
+	return &s, nil
+}
+
+// nullIfEmpty stores an empty string as NULL
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// trackColumns the tracks columns scanTrack expects, in order
+const trackColumns = `id, name, recording_mbid, artist, album, release_mbid, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped,
+	release_group_mbid, release_track_mbid, work_mbids, track_number, disc_number, tags, submission_client, submission_client_version, media_player, youtube_id,
+	device_name, device_type`
+
+// scanTrack scans a row selected with trackColumns into a track
+func scanTrack(rows *sql.Rows) (*models.Track, error) {
+	var artistString string
+	var workMBIDs, tags, submissionClient, submissionClientVersion, mediaPlayer, youtubeID, deviceName, deviceType sql.NullString
+	var trackNumber, discNumber sql.NullInt64
+	track := &models.Track{}
+	err := rows.Scan(
+		&track.PlayID,
+		&track.Name,
+		&track.RecordingMBID, // Scan new field
+		&artistString,        // scan to be unmarshaled later
+		&track.Album,
+		&track.ReleaseMBID, // Scan new field
+		&track.URL,
+		&track.Timestamp,
+		&track.DurationMs,
+		&track.ProgressMs,
+		&track.ServiceBaseUrl,
+		&track.ISRC,
+		&track.HasStamped,
+		&track.ReleaseGroupMBID,
+		&track.ReleaseTrackMBID,
+		&workMBIDs,
+		&trackNumber,
+		&discNumber,
+		&tags,
+		&submissionClient,
+		&submissionClientVersion,
+		&mediaPlayer,
+		&youtubeID,
+		&deviceName,
+		&deviceType,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if workMBIDs.Valid {
+		_ = json.Unmarshal([]byte(workMBIDs.String), &track.WorkMBIDs)
+	}
+	if tags.Valid {
+		_ = json.Unmarshal([]byte(tags.String), &track.Tags)
+	}
+	if trackNumber.Valid {
+		n := int(trackNumber.Int64)
+		track.TrackNumber = &n
+	}
+	if discNumber.Valid {
+		n := int(discNumber.Int64)
+		track.DiscNumber = &n
+	}
+	track.SubmissionClient = submissionClient.String
+	track.SubmissionClientVersion = submissionClientVersion.String
+	track.MediaPlayer = mediaPlayer.String
+	track.YouTubeID = youtubeID.String
+	track.DeviceName = deviceName.String
+	track.DeviceType = deviceType.String
+
+	// unmarshal artist json
+	var artists []models.Artist
+	err = json.Unmarshal([]byte(artistString), &artists)
+	if err != nil {
+		// fallback to previous format
+		artists = []models.Artist{{Name: artistString}}
+	}
+	track.Artist = artists
+	return track, nil
+}
+
 func (db *DB) GetRecentTracks(userID int64, limit int) ([]*models.Track, error) {
 	rows, err := db.Query(`
-    SELECT id, name, recording_mbid, artist, album, release_mbid, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped
+    SELECT `+trackColumns+`
     FROM tracks
     WHERE user_id = ?
     ORDER BY timestamp DESC
@@ -427,47 +922,67 @@ func (db *DB) GetRecentTracks(userID int64, limit int) ([]*models.Track, error)
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
-			log.Println(err)
+			db.logger.Error("error closing rows", logging.Err(err))
 		}
 	}(rows)
 
 	var tracks []*models.Track
 
 	for rows.Next() {
-		var artistString string
-		track := &models.Track{}
-		err := rows.Scan(
-			&track.PlayID,
-			&track.Name,
-			&track.RecordingMBID, // Scan new field
-			&artistString,        // scan to be unmarshaled later
-			&track.Album,
-			&track.ReleaseMBID, // Scan new field
-			&track.URL,
-			&track.Timestamp,
-			&track.DurationMs,
-			&track.ProgressMs,
-			&track.ServiceBaseUrl,
-			&track.ISRC,
-			&track.HasStamped,
-		)
-
+		track, err := scanTrack(rows)
 		if err != nil {
 			return nil, err
 		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, nil
+}
 
-		// unmarshal artist json
-		var artists []models.Artist
-		err = json.Unmarshal([]byte(artistString), &artists)
+// ForEachTrack calls fn for every track of a user, oldest first, without loading the whole history
+// into memory. Stops at the first error fn returns
+func (db *DB) ForEachTrack(userID int64, fn func(track *models.Track) error) error {
+	return db.ForEachTrackSince(userID, time.Time{}, fn)
+}
+
+// ForEachTrackSince is ForEachTrack limited to tracks played at or after since
+func (db *DB) ForEachTrackSince(userID int64, since time.Time, fn func(track *models.Track) error) error {
+	query := `
+    SELECT ` + trackColumns + `
+    FROM tracks
+    WHERE user_id = ?`
+	args := []any{userID}
+	// tracks without a timestamp only show up in the full history
+	if !since.IsZero() {
+		query += ` AND timestamp >= ?`
+		args = append(args, since.UTC())
+	}
+	query += `
+    ORDER BY timestamp ASC, id ASC`
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
 		if err != nil {
-			// fallback to previous format
-			artists = []models.Artist{{Name: artistString}}
+			db.logger.Error("error closing rows", logging.Err(err))
+		}
+	}(rows)
+
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(track); err != nil {
+			return err
 		}
-		track.Artist = artists
-		tracks = append(tracks, track)
 	}
 
-	return tracks, nil
+	return rows.Err()
 }
 
 // SpotifyQueryMapping maps Spotify sql query results to user structs
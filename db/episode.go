@@ -0,0 +1,87 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// SaveEpisode saves a podcast episode play, returning its id
+func (db *DB) SaveEpisode(userID int64, episode *models.Episode) (int64, error) {
+	var id int64
+	err := db.QueryRow(`
+	INSERT INTO episodes (user_id, name, show_name, publisher, url, timestamp, duration_ms, progress_ms, service_base_url, has_stamped,
+		device_name, device_type)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`,
+		userID, episode.Name, episode.Show, nullIfEmpty(episode.Publisher), episode.URL, episode.Timestamp.UTC(),
+		episode.DurationMs, episode.ProgressMs, episode.ServiceBaseUrl, episode.HasStamped,
+		nullIfEmpty(episode.DeviceName), nullIfEmpty(episode.DeviceType)).Scan(&id)
+	return id, err
+}
+
+const episodeColumns = `id, name, show_name, publisher, url, timestamp, duration_ms, progress_ms, service_base_url, has_stamped,
+		device_name, device_type`
+
+func scanEpisode(rows *sql.Rows) (*models.Episode, error) {
+	var episode models.Episode
+	var publisher, deviceName, deviceType sql.NullString
+	err := rows.Scan(&episode.PlayID, &episode.Name, &episode.Show, &publisher, &episode.URL, &episode.Timestamp,
+		&episode.DurationMs, &episode.ProgressMs, &episode.ServiceBaseUrl, &episode.HasStamped,
+		&deviceName, &deviceType)
+	if err != nil {
+		return nil, err
+	}
+	episode.Publisher = publisher.String
+	episode.DeviceName = deviceName.String
+	episode.DeviceType = deviceType.String
+	return &episode, nil
+}
+
+// GetRecentEpisodes returns the user's latest podcast episode plays, newest first
+func (db *DB) GetRecentEpisodes(userID int64, limit int) ([]*models.Episode, error) {
+	rows, err := db.Query(`
+	SELECT `+episodeColumns+`
+	FROM episodes
+	WHERE user_id = ?
+	ORDER BY timestamp DESC
+	LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	episodes := []*models.Episode{}
+	for rows.Next() {
+		episode, err := scanEpisode(rows)
+		if err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, episode)
+	}
+	return episodes, rows.Err()
+}
+
+// ForEachEpisode calls fn for each of the user's podcast episode plays, oldest first, without loading them all at once
+func (db *DB) ForEachEpisode(userID int64, fn func(episode *models.Episode) error) error {
+	rows, err := db.Query(`
+	SELECT `+episodeColumns+`
+	FROM episodes
+	WHERE user_id = ?
+	ORDER BY timestamp ASC, id ASC`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		episode, err := scanEpisode(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(episode); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
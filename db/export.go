@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+const exportJobColumns = `id, user_id, status, error, file_path, created_at, completed_at`
+
+func scanExportJob(scan func(dest ...any) error) (*models.ExportJob, error) {
+	job := &models.ExportJob{}
+	err := scan(&job.ID, &job.UserID, &job.Status, &job.Error, &job.FilePath, &job.CreatedAt, &job.CompletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (db *DB) CreateExportJob(job *models.ExportJob) error {
+	_, err := db.Exec(`
+	INSERT INTO export_jobs (id, user_id, status, created_at)
+	VALUES (?, ?, ?, ?)`,
+		job.ID, job.UserID, job.Status, job.CreatedAt)
+	return err
+}
+
+// UpdateExportJob saves the status, error, file path and completion time of a job
+func (db *DB) UpdateExportJob(job *models.ExportJob) error {
+	_, err := db.Exec(`
+	UPDATE export_jobs
+	SET status = ?, error = ?, file_path = ?, completed_at = ?
+	WHERE id = ?`,
+		job.Status, job.Error, job.FilePath, job.CompletedAt, job.ID)
+	return err
+}
+
+// GetExportJob returns nil if the job doesn't exist
+func (db *DB) GetExportJob(jobID string) (*models.ExportJob, error) {
+	job, err := scanExportJob(db.QueryRow(`
+	SELECT `+exportJobColumns+`
+	FROM export_jobs WHERE id = ?`, jobID).Scan)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetUserExportJobs returns a user's export jobs, newest first
+func (db *DB) GetUserExportJobs(userID int64) ([]*models.ExportJob, error) {
+	return db.queryExportJobs(`
+	SELECT `+exportJobColumns+`
+	FROM export_jobs
+	WHERE user_id = ?
+	ORDER BY created_at DESC`, userID)
+}
+
+// GetExportJobsCreatedBefore returns jobs of every user created before the cutoff
+func (db *DB) GetExportJobsCreatedBefore(cutoff time.Time) ([]*models.ExportJob, error) {
+	return db.queryExportJobs(`
+	SELECT `+exportJobColumns+`
+	FROM export_jobs
+	WHERE created_at < ?`, cutoff)
+}
+
+// GetUnfinishedExportJobs returns jobs of every user that are pending or running
+func (db *DB) GetUnfinishedExportJobs() ([]*models.ExportJob, error) {
+	return db.queryExportJobs(`
+	SELECT `+exportJobColumns+`
+	FROM export_jobs
+	WHERE status IN (?, ?)`, models.ExportStatusPending, models.ExportStatusRunning)
+}
+
+func (db *DB) DeleteExportJob(jobID string) error {
+	_, err := db.Exec(`DELETE FROM export_jobs WHERE id = ?`, jobID)
+	return err
+}
+
+func (db *DB) queryExportJobs(query string, args ...any) ([]*models.ExportJob, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			db.logger.Error("error closing rows", logging.Err(err))
+		}
+	}(rows)
+
+	var jobs []*models.ExportJob
+	for rows.Next() {
+		job, err := scanExportJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+// serviceAliases the service_base_url values each service has been stored under
+var serviceAliases = map[string][]string{
+	"open.spotify.com": {"open.spotify.com", "spotify"},
+	"last.fm":          {"last.fm", "lastfm"},
+	"music.apple.com":  {"music.apple.com"},
+	"listenbrainz":     {"listenbrainz"},
+}
+
+// TrackCursor the position of a track in a user's history, newest first
+type TrackCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// Encode returns the cursor as an opaque string for the API
+func (c TrackCursor) Encode() string {
+	raw := c.Timestamp.Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeTrackCursor parses a cursor created with TrackCursor.Encode
+func DecodeTrackCursor(s string) (*TrackCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	timestamp, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	cursor := &TrackCursor{}
+	if cursor.Timestamp, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// TrackQuery filters a user's history. Zero values don't filter
+type TrackQuery struct {
+	From time.Time // inclusive
+	To   time.Time // exclusive
+	// Services service base urls, see serviceAliases. Unknown values are matched as is
+	Services      []string
+	ArtistMBID    string
+	RecordingMBID string
+	// Search matches track, album and artist names
+	Search string
+	// Device the name of the device tracks were played on, case-insensitive
+	Device  string
+	Stamped *bool
+	// After continue after this track, in the direction of the query
+	After *TrackCursor
+	// OldestFirst returns the oldest matching tracks first instead of the newest
+	OldestFirst bool
+	Limit       int
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// QueryTracks returns a page of a user's history matching q, newest first unless q.OldestFirst
+func (db *DB) QueryTracks(userID int64, q TrackQuery) ([]*models.Track, error) {
+	where := []string{"user_id = ?"}
+	args := []any{userID}
+
+	if !q.From.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, q.To.UTC())
+	}
+	if len(q.Services) > 0 {
+		var services []any
+		for _, service := range q.Services {
+			aliases, ok := serviceAliases[service]
+			if !ok {
+				aliases = []string{service}
+			}
+			for _, alias := range aliases {
+				services = append(services, alias)
+			}
+		}
+		where = append(where, "service_base_url IN ("+placeholders(len(services))+")")
+		args = append(args, services...)
+	}
+	if q.ArtistMBID != "" {
+		// older rows store a plain artist name instead of json
+		where = append(where, `CASE WHEN json_valid(artist) THEN EXISTS (
+			SELECT 1 FROM json_each(tracks.artist) WHERE json_extract(json_each.value, '$.mbid') = ?
+		) ELSE 0 END`)
+		args = append(args, q.ArtistMBID)
+	}
+	if q.RecordingMBID != "" {
+		where = append(where, "recording_mbid = ?")
+		args = append(args, q.RecordingMBID)
+	}
+	if q.Search != "" {
+		pattern := "%" + escapeLike(q.Search) + "%"
+		where = append(where, `(name LIKE ? ESCAPE '\' OR album LIKE ? ESCAPE '\' OR artist LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if q.Device != "" {
+		where = append(where, "device_name = ? COLLATE NOCASE")
+		args = append(args, q.Device)
+	}
+	if q.Stamped != nil {
+		where = append(where, "has_stamped = ?")
+		args = append(args, *q.Stamped)
+	}
+	order := "DESC"
+	if q.OldestFirst {
+		order = "ASC"
+	}
+	if q.After != nil {
+		comparison := "<"
+		if q.OldestFirst {
+			comparison = ">"
+		}
+		where = append(where, "(timestamp "+comparison+" ? OR (timestamp = ? AND id "+comparison+" ?))")
+		args = append(args, q.After.Timestamp.UTC(), q.After.Timestamp.UTC(), q.After.ID)
+	}
+
+	query := `
+    SELECT ` + trackColumns + `
+    FROM tracks
+    WHERE ` + strings.Join(where, " AND ") + `
+    ORDER BY timestamp ` + order + `, id ` + order
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tracks: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			db.logger.Error("error closing rows", logging.Err(err))
+		}
+	}(rows)
+
+	tracks := []*models.Track{}
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// CountTracks returns how many tracks a user has
+func (db *DB) CountTracks(userID int64) (int64, error) {
+	var count int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM tracks WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+// CountStampedTracks counts a user's stamped tracks
+func (db *DB) CountStampedTracks(userID int64) (int64, error) {
+	var count int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM tracks WHERE user_id = ? AND has_stamped = 1`, userID).Scan(&count)
+	return count, err
+}
+
+// GetTrackTimeRange returns the timestamps of a user's oldest and latest track, nil if they have none
+func (db *DB) GetTrackTimeRange(userID int64) (oldest *time.Time, latest *time.Time, err error) {
+	return db.trackTimeRange(userID, false)
+}
+
+// GetStampedTrackTimeRange returns the timestamps of a user's oldest and latest stamped track, nil if they have none
+func (db *DB) GetStampedTrackTimeRange(userID int64) (oldest *time.Time, latest *time.Time, err error) {
+	return db.trackTimeRange(userID, true)
+}
+
+func (db *DB) trackTimeRange(userID int64, stampedOnly bool) (oldest *time.Time, latest *time.Time, err error) {
+	where := `user_id = ? AND timestamp IS NOT NULL`
+	if stampedOnly {
+		where += ` AND has_stamped = 1`
+	}
+	// ORDER BY instead of MIN/MAX so the driver still parses the column as a timestamp
+	for _, q := range []struct {
+		order string
+		dst   **time.Time
+	}{{"ASC", &oldest}, {"DESC", &latest}} {
+		var ts time.Time
+		err := db.QueryRow(`SELECT timestamp FROM tracks WHERE `+where+` ORDER BY timestamp `+q.order+` LIMIT 1`, userID).Scan(&ts)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		*q.dst = &ts
+	}
+	return oldest, latest, nil
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/models"
 )
 
@@ -28,7 +29,7 @@ func (db *DB) GetAllUsersWithLastFM() ([]*models.User, error) {
 	defer func(rows *sql.Rows) {
 		err := rows.Close()
 		if err != nil {
-			db.logger.Printf("Error closing rows: %s", err)
+			db.logger.Error("error closing rows", logging.Err(err))
 		}
 	}(rows)
 
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+// SaveOAuth2State stores a pending OAuth2 login. Expired logins are pruned on the way in
+// so the table doesn't grow with abandoned attempts.
+func (db *DB) SaveOAuth2State(state *models.OAuth2State) error {
+	now := time.Now().UTC()
+
+	if _, err := db.Exec(`DELETE FROM oauth2_state WHERE expires_at < ?`, now); err != nil {
+		db.logger.Error("error pruning expired oauth2 states", logging.Err(err))
+	}
+
+	_, err := db.Exec(`
+	INSERT INTO oauth2_state (state, provider, session_id, code_verifier, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)`,
+		state.State, state.Provider, state.SessionID, state.CodeVerifier, state.CreatedAt, state.ExpiresAt)
+
+	return err
+}
+
+// ConsumeOAuth2State looks up a pending OAuth2 login and deletes it so a state can only be used once.
+// Returns nil if no login exists for the provider and state.
+func (db *DB) ConsumeOAuth2State(provider, state string) (*models.OAuth2State, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func(tx *sql.Tx) {
+		// no-op once committed
+		_ = tx.Rollback()
+	}(tx)
+
+	pending := &models.OAuth2State{}
+	err = tx.QueryRow(`
+	SELECT state, provider, session_id, code_verifier, created_at, expires_at
+	FROM oauth2_state
+	WHERE provider = ? AND state = ?`, provider, state).Scan(
+		&pending.State, &pending.Provider, &pending.SessionID, &pending.CodeVerifier,
+		&pending.CreatedAt, &pending.ExpiresAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query oauth2 state: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM oauth2_state WHERE provider = ? AND state = ?`, provider, state); err != nil {
+		return nil, fmt.Errorf("failed to delete oauth2 state: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// SaveOAuth2Token stores a user's tokens for a generic OAuth2 provider, replacing any from an earlier link
+func (db *DB) SaveOAuth2Token(userID int64, provider, accessToken, refreshToken string) error {
+	_, err := db.Exec(`
+	INSERT INTO oauth2_tokens (user_id, provider, access_token, refresh_token, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(user_id, provider) DO UPDATE SET
+		access_token = excluded.access_token,
+		refresh_token = excluded.refresh_token,
+		updated_at = excluded.updated_at`,
+		userID, provider, accessToken, nullIfEmpty(refreshToken), time.Now().UTC())
+	return err
+}
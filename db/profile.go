@@ -0,0 +1,22 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SetPublicProfile turns a user's public profile page and embeds on or off
+func (db *DB) SetPublicProfile(userID int64, public bool) error {
+	_, err := db.Exec(`UPDATE users SET public_profile = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, public, userID)
+	return err
+}
+
+// IsPublicProfile reports whether a user opted in to a public profile. Unknown users are private
+func (db *DB) IsPublicProfile(userID int64) (bool, error) {
+	var public bool
+	err := db.QueryRow(`SELECT public_profile FROM users WHERE id = ?`, userID).Scan(&public)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return public, err
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+)
+
+const relayTargetColumns = `id, user_id, kind, url, username, secret, enabled, last_error, last_success_at, created_at`
+
+func scanRelayTarget(scan func(dest ...any) error) (*models.RelayTarget, error) {
+	target := &models.RelayTarget{}
+	var username sql.NullString
+	err := scan(&target.ID, &target.UserID, &target.Kind, &target.URL, &username, &target.Secret,
+		&target.Enabled, &target.LastError, &target.LastSuccessAt, &target.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	target.Username = username.String
+	return target, nil
+}
+
+// CreateRelayTarget stores a new target, setting its ID
+func (db *DB) CreateRelayTarget(target *models.RelayTarget) error {
+	if target.CreatedAt.IsZero() {
+		target.CreatedAt = time.Now().UTC()
+	}
+	return db.QueryRow(`
+	INSERT INTO relay_targets (user_id, kind, url, username, secret, enabled, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`,
+		target.UserID, target.Kind, target.URL, target.Username, target.Secret, target.Enabled, target.CreatedAt).Scan(&target.ID)
+}
+
+// GetRelayTarget returns nil if the target doesn't exist
+func (db *DB) GetRelayTarget(targetID int64) (*models.RelayTarget, error) {
+	target, err := scanRelayTarget(db.QueryRow(`
+	SELECT `+relayTargetColumns+`
+	FROM relay_targets WHERE id = ?`, targetID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return target, err
+}
+
+// GetUserRelayTargets returns all of a user's targets, oldest first
+func (db *DB) GetUserRelayTargets(userID int64) ([]*models.RelayTarget, error) {
+	rows, err := db.Query(`
+	SELECT `+relayTargetColumns+`
+	FROM relay_targets
+	WHERE user_id = ?
+	ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []*models.RelayTarget
+	for rows.Next() {
+		target, err := scanRelayTarget(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// DeleteRelayTarget removes one of a user's targets along with its pending deliveries
+func (db *DB) DeleteRelayTarget(userID, targetID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if _, err := tx.Exec(`DELETE FROM relay_queue WHERE target_id = ? AND user_id = ?`, targetID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM relay_targets WHERE id = ? AND user_id = ?`, targetID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordRelaySuccess clears a target's last error
+func (db *DB) RecordRelaySuccess(targetID int64) error {
+	_, err := db.Exec(`UPDATE relay_targets SET last_error = NULL, last_success_at = ? WHERE id = ?`, time.Now().UTC(), targetID)
+	return err
+}
+
+// RecordRelayFailure saves why a delivery to a target failed, disabling the target if it can't recover on its own
+func (db *DB) RecordRelayFailure(targetID int64, message string, disable bool) error {
+	_, err := db.Exec(`UPDATE relay_targets SET last_error = ?, enabled = enabled AND NOT ? WHERE id = ?`, message, disable, targetID)
+	return err
+}
+
+// EnqueueRelayDelivery queues a track for a target, due immediately
+func (db *DB) EnqueueRelayDelivery(targetID, userID int64, track *models.Track) error {
+	trackJSON, err := json.Marshal(track)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = db.Exec(`
+	INSERT INTO relay_queue (target_id, user_id, track, next_attempt_at, created_at)
+	VALUES (?, ?, ?, ?, ?)`,
+		targetID, userID, string(trackJSON), now, now)
+	return err
+}
+
+// GetDueRelayDeliveries returns up to limit deliveries due at or before now, oldest first
+func (db *DB) GetDueRelayDeliveries(now time.Time, limit int) ([]*models.RelayDelivery, error) {
+	rows, err := db.Query(`
+	SELECT id, target_id, user_id, track, attempts, next_attempt_at, last_error, created_at
+	FROM relay_queue
+	WHERE next_attempt_at <= ?
+	ORDER BY next_attempt_at, id
+	LIMIT ?`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.RelayDelivery
+	for rows.Next() {
+		d := &models.RelayDelivery{}
+		var trackJSON string
+		if err := rows.Scan(&d.ID, &d.TargetID, &d.UserID, &trackJSON, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(trackJSON), &d.Track); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RescheduleRelayDelivery records a failed attempt and when to try again
+func (db *DB) RescheduleRelayDelivery(deliveryID int64, attempts int, next time.Time, lastError string) error {
+	_, err := db.Exec(`UPDATE relay_queue SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		attempts, next.UTC(), lastError, deliveryID)
+	return err
+}
+
+func (db *DB) DeleteRelayDelivery(deliveryID int64) error {
+	_, err := db.Exec(`DELETE FROM relay_queue WHERE id = ?`, deliveryID)
+	return err
+}
+
+// CountPendingRelayDeliveries returns how many deliveries are queued for a target
+func (db *DB) CountPendingRelayDeliveries(targetID int64) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM relay_queue WHERE target_id = ?`, targetID).Scan(&count)
+	return count, err
+}
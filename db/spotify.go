@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// GetSpotifySettings returns what a user left out of Spotify tracking, the
+// defaults for unknown users
+func (db *DB) GetSpotifySettings(userID int64) (*models.SpotifySettings, error) {
+	settings := models.DefaultSpotifySettings()
+	var ignored sql.NullString
+	err := db.QueryRow(`SELECT spotify_skip_private_sessions, spotify_ignored_devices, spotify_episodes FROM users WHERE id = ?`, userID).
+		Scan(&settings.SkipPrivateSessions, &ignored, &settings.Episodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ignored.Valid {
+		if err := json.Unmarshal([]byte(ignored.String), &settings.IgnoredDevices); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+// SetSpotifySettings saves what a user leaves out of Spotify tracking
+func (db *DB) SetSpotifySettings(userID int64, settings *models.SpotifySettings) error {
+	ignored, err := marshalStrings(settings.IgnoredDevices)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+	UPDATE users SET spotify_skip_private_sessions = ?, spotify_ignored_devices = ?, spotify_episodes = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?`, settings.SkipPrivateSessions, ignored, settings.Episodes, userID)
+	return err
+}
+
+// GetTrackDevices returns the names of the devices a user's tracks were played on, most used first
+func (db *DB) GetTrackDevices(userID int64) ([]string, error) {
+	rows, err := db.Query(`
+	SELECT device_name FROM tracks
+	WHERE user_id = ? AND device_name IS NOT NULL
+	GROUP BY device_name
+	ORDER BY COUNT(*) DESC, device_name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		devices = append(devices, name)
+	}
+	return devices, rows.Err()
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// SetStampPolicy saves the stamping policy a user picked, "" to use the instance's
+func (db *DB) SetStampPolicy(userID int64, policy string) error {
+	var value *string
+	if policy != "" {
+		value = &policy
+	}
+	_, err := db.Exec(`UPDATE users SET stamp_policy = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, value, userID)
+	return err
+}
+
+// GetStampPolicy returns the stamping policy a user picked, "" when they haven't
+func (db *DB) GetStampPolicy(userID int64) (string, error) {
+	var policy sql.NullString
+	err := db.QueryRow(`SELECT stamp_policy FROM users WHERE id = ?`, userID).Scan(&policy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return policy.String, err
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+)
+
+const webhookColumns = `id, user_id, url, secret, events, enabled, created_at`
+
+func scanWebhook(scan func(dest ...any) error) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+	var eventList string
+	if err := scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Secret, &eventList, &hook.Enabled, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	if eventList != "" {
+		hook.Events = strings.Split(eventList, ",")
+	}
+	return hook, nil
+}
+
+// CreateWebhook stores a new webhook, setting its ID
+func (db *DB) CreateWebhook(hook *models.Webhook) error {
+	if hook.CreatedAt.IsZero() {
+		hook.CreatedAt = time.Now().UTC()
+	}
+	return db.QueryRow(`
+	INSERT INTO webhooks (user_id, url, secret, events, enabled, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	RETURNING id`,
+		hook.UserID, hook.URL, hook.Secret, strings.Join(hook.Events, ","), hook.Enabled, hook.CreatedAt).Scan(&hook.ID)
+}
+
+// GetWebhook returns nil if the webhook doesn't exist
+func (db *DB) GetWebhook(webhookID int64) (*models.Webhook, error) {
+	hook, err := scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, webhookID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return hook, err
+}
+
+// GetUserWebhooks returns a user's webhooks, oldest first
+func (db *DB) GetUserWebhooks(userID int64) ([]*models.Webhook, error) {
+	rows, err := db.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []*models.Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// SetWebhookEnabled pauses or resumes one of a user's webhooks
+func (db *DB) SetWebhookEnabled(userID, webhookID int64, enabled bool) error {
+	_, err := db.Exec(`UPDATE webhooks SET enabled = ? WHERE id = ? AND user_id = ?`, enabled, webhookID, userID)
+	return err
+}
+
+// DeleteWebhook removes one of a user's webhooks and its delivery log
+func (db *DB) DeleteWebhook(userID, webhookID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ? AND user_id = ?`, webhookID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, webhookID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = `id, webhook_id, user_id, event, payload, status, attempts, next_attempt_at, response_code, last_error, created_at, delivered_at`
+
+func (db *DB) queryWebhookDeliveries(query string, args ...any) ([]*models.WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		d := &models.WebhookDelivery{}
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.UserID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CreateWebhookDelivery queues a delivery, due immediately, setting its ID.
+// It is pending unless another status is already set
+func (db *DB) CreateWebhookDelivery(d *models.WebhookDelivery) error {
+	now := time.Now().UTC()
+	if d.Status == "" {
+		d.Status = models.WebhookDeliveryPending
+	}
+	d.CreatedAt = now
+	d.NextAttemptAt = now
+	return db.QueryRow(`
+	INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, status, next_attempt_at, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	RETURNING id`,
+		d.WebhookID, d.UserID, d.Event, d.Payload, d.Status, d.NextAttemptAt, d.CreatedAt).Scan(&d.ID)
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries due at or before now, oldest first
+func (db *DB) GetDueWebhookDeliveries(now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	return db.queryWebhookDeliveries(`
+	SELECT `+webhookDeliveryColumns+`
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at, id
+	LIMIT ?`, models.WebhookDeliveryPending, now.UTC(), limit)
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook, newest first
+func (db *DB) GetWebhookDeliveries(webhookID int64, limit int) ([]*models.WebhookDelivery, error) {
+	return db.queryWebhookDeliveries(`
+	SELECT `+webhookDeliveryColumns+`
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?`, webhookID, limit)
+}
+
+// UpdateWebhookDelivery saves the outcome of an attempt
+func (db *DB) UpdateWebhookDelivery(d *models.WebhookDelivery) error {
+	_, err := db.Exec(`
+	UPDATE webhook_deliveries
+	SET status = ?, attempts = ?, next_attempt_at = ?, response_code = ?, last_error = ?, delivered_at = ?
+	WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt.UTC(), d.ResponseCode, d.LastError, d.DeliveredAt, d.ID)
+	return err
+}
+
+// DeleteWebhookDeliveriesBefore prunes finished deliveries from the log
+func (db *DB) DeleteWebhookDeliveriesBefore(cutoff time.Time) error {
+	_, err := db.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`, models.WebhookDeliveryPending, cutoff.UTC())
+	return err
+}
@@ -0,0 +1,191 @@
+// Package health serves the liveness and readiness endpoints used by Docker,
+// compose and load balancers, and keeps track of how the trackers are polling
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+)
+
+// Statuses reported for checks, trackers and the instance as a whole
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// maxConsecutiveFailures is how many polls in a row a tracker can fail before
+// it is reported as degraded
+const maxConsecutiveFailures = 3
+
+// CheckFunc checks a single dependency, returning why it is unhealthy
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	// critical checks take the instance out of rotation when they fail,
+	// others only mark it as degraded
+	critical bool
+	run      CheckFunc
+}
+
+// Checker runs the readiness checks and reports tracker status
+type Checker struct {
+	checks   []check
+	trackers []*Tracker
+	timeout  time.Duration
+	logger   *slog.Logger
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		timeout: 5 * time.Second,
+		logger:  logging.For("health"),
+	}
+}
+
+// Critical adds a check that makes the instance unready when it fails
+func (c *Checker) Critical(name string, fn CheckFunc) *Checker {
+	c.checks = append(c.checks, check{name: name, critical: true, run: fn})
+	return c
+}
+
+// Optional adds a check that only marks the instance as degraded when it fails
+func (c *Checker) Optional(name string, fn CheckFunc) *Checker {
+	c.checks = append(c.checks, check{name: name, run: fn})
+	return c
+}
+
+// Tracker registers a tracker to report on, pass it to the tracker's service
+func (c *Checker) Tracker(name string) *Tracker {
+	t := &Tracker{name: name}
+	c.trackers = append(c.trackers, t)
+	return t
+}
+
+// Cached runs fn at most once per ttl, for checks against rate limited or
+// remote services that would otherwise be hit on every probe
+func Cached(ttl time.Duration, fn CheckFunc) CheckFunc {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		last      error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return last
+		}
+		last = fn(ctx)
+		checkedAt = time.Now()
+		return last
+	}
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Report is the body of /readyz
+type Report struct {
+	Status   string                   `json:"status"`
+	Checks   map[string]CheckResult   `json:"checks"`
+	Trackers map[string]TrackerStatus `json:"trackers"`
+}
+
+// Run runs every check concurrently and collects the tracker statuses
+func (c *Checker) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{
+		Status:   StatusOK,
+		Checks:   make(map[string]CheckResult, len(c.checks)),
+		Trackers: make(map[string]TrackerStatus, len(c.trackers)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, chk := range c.checks {
+		wg.Add(1)
+		go func(chk check) {
+			defer wg.Done()
+			start := time.Now()
+			err := chk.run(ctx)
+			result := CheckResult{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusDegraded
+				if chk.critical {
+					result.Status = StatusDown
+				}
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			report.Checks[chk.name] = result
+			mu.Unlock()
+		}(chk)
+	}
+	wg.Wait()
+
+	for _, t := range c.trackers {
+		report.Trackers[t.name] = t.Status()
+	}
+
+	for _, result := range report.Checks {
+		report.Status = worse(report.Status, result.Status)
+	}
+	for _, status := range report.Trackers {
+		report.Status = worse(report.Status, status.Status)
+	}
+	return report
+}
+
+func worse(a, b string) string {
+	rank := map[string]int{StatusOK: 0, StatusDegraded: 1, StatusDown: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if data != nil {
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// HandleHealthz reports that the process is up and serving requests
+func (c *Checker) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+// HandleReadyz runs the checks, answering 503 when a critical one fails. A
+// degraded instance still answers 200 so an upstream outage doesn't take every
+// instance out of rotation
+func (c *Checker) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	code := http.StatusOK
+	if report.Status == StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	if report.Status != StatusOK {
+		c.logger.WarnContext(r.Context(), "readiness check not ok", "status", report.Status)
+	}
+	jsonResponse(w, code, report)
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/service/connection"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func readyz(t *testing.T, c *Checker) (int, Report) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	c.HandleReadyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("invalid readiness report: %v", err)
+	}
+	return rr.Code, report
+}
+
+func TestReadyzOK(t *testing.T) {
+	database := setupTestDB(t)
+	c := NewChecker().
+		Critical("database", database.PingContext).
+		Critical("migrations", database.CheckSchema)
+	c.Tracker("spotify").Observe(nil)
+
+	code, report := readyz(t, c)
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Fatalf("expected 200 ok, got %d %+v", code, report)
+	}
+	if report.Checks["migrations"].Status != StatusOK {
+		t.Errorf("expected migrations to pass, got %+v", report.Checks["migrations"])
+	}
+	if report.Trackers["spotify"].LastSuccess == nil {
+		t.Error("expected the tracker's last success to be reported")
+	}
+}
+
+func TestReadyzStatusCodes(t *testing.T) {
+	failing := func(ctx context.Context) error { return errors.New("unreachable") }
+	passing := func(ctx context.Context) error { return nil }
+
+	code, report := readyz(t, NewChecker().Critical("database", passing).Optional("musicbrainz", failing))
+	if code != http.StatusOK || report.Status != StatusDegraded {
+		t.Errorf("expected a failing optional check to degrade with 200, got %d %s", code, report.Status)
+	}
+	if report.Checks["musicbrainz"].Error != "unreachable" {
+		t.Errorf("expected the check error in the report, got %+v", report.Checks["musicbrainz"])
+	}
+
+	code, report = readyz(t, NewChecker().Critical("database", failing).Optional("musicbrainz", passing))
+	if code != http.StatusServiceUnavailable || report.Status != StatusDown {
+		t.Errorf("expected a failing critical check to give 503, got %d %s", code, report.Status)
+	}
+}
+
+func TestCheckSchemaNotMigrated(t *testing.T) {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CheckSchema(context.Background()); err == nil {
+		t.Error("expected an unmigrated database to fail the schema check")
+	}
+}
+
+func TestTrackerConsecutiveFailures(t *testing.T) {
+	tracker := NewChecker().Tracker("lastfm")
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		tracker.Observe(errors.New("rate limited"))
+	}
+
+	status := tracker.Status()
+	if status.Status != StatusDegraded || status.ConsecutiveFailures != maxConsecutiveFailures || status.LastError != "rate limited" {
+		t.Errorf("expected a degraded tracker, got %+v", status)
+	}
+
+	tracker.Observe(nil)
+	if status := tracker.Status(); status.Status != StatusOK || status.ConsecutiveFailures != 0 || status.LastError != "" {
+		t.Errorf("expected a success to reset the failures, got %+v", status)
+	}
+
+	// one user's revoked token says nothing about the tracker itself
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		tracker.Observe(fmt.Errorf("%w: token revoked", connection.ErrReauthRequired))
+	}
+	if status := tracker.Status(); status.Status != StatusOK || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected reauth errors to be skipped, got %+v", status)
+	}
+
+	// services without health reporting hold a nil tracker
+	var none *Tracker
+	none.Observe(errors.New("ignored"))
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	check := Cached(time.Minute, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	check(context.Background())
+	check(context.Background())
+	if calls != 1 {
+		t.Errorf("expected the check to run once within the ttl, ran %d times", calls)
+	}
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/service/connection"
+)
+
+// Tracker records the outcome of a tracker's polls. A nil Tracker ignores them,
+// so services don't need to check whether health reporting is wired up
+type Tracker struct {
+	name string
+
+	mu          sync.Mutex
+	lastPoll    time.Time
+	lastSuccess time.Time
+	lastError   string
+	failures    int
+}
+
+// TrackerStatus is how a tracker is reported in /readyz
+type TrackerStatus struct {
+	Status              string     `json:"status"`
+	LastPoll            *time.Time `json:"lastPoll,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+// Observe records a single poll. A user needing to link the provider again is
+// their problem rather than the tracker's, so it isn't counted as a failure
+func (t *Tracker) Observe(err error) {
+	if t == nil {
+		return
+	}
+	if errors.Is(err, connection.ErrReauthRequired) {
+		err = nil
+	}
+	now := time.Now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastPoll = now
+	if err != nil {
+		t.failures++
+		t.lastError = err.Error()
+		return
+	}
+	t.failures = 0
+	t.lastError = ""
+	t.lastSuccess = now
+}
+
+// Status reports the tracker as degraded once enough polls in a row have failed
+func (t *Tracker) Status() TrackerStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := TrackerStatus{
+		Status:              StatusOK,
+		ConsecutiveFailures: t.failures,
+		LastError:           t.lastError,
+	}
+	if t.failures >= maxConsecutiveFailures {
+		status.Status = StatusDegraded
+	}
+	if !t.lastPoll.IsZero() {
+		lastPoll := t.lastPoll
+		status.LastPoll = &lastPoll
+	}
+	if !t.lastSuccess.IsZero() {
+		lastSuccess := t.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	return status
+}
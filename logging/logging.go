@@ -0,0 +1,292 @@
+// Package logging sets up structured logging with log/slog. Every subsystem gets
+// its own logger tagged with a service field, can have its own level, and has
+// tokens and secrets redacted before anything is written
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// Field names shared across subsystems
+const (
+	KeyService   = "service"
+	KeyUserID    = "user_id"
+	KeyDID       = "did"
+	KeyTrack     = "track"
+	KeyRequestID = "request_id"
+	KeyError     = "error"
+)
+
+// Redacted replaces the value of sensitive attributes
+const Redacted = "[REDACTED]"
+
+// Options configure the process wide logging
+type Options struct {
+	// Format is "text" or "json"
+	Format string
+	// Level is the default minimum level
+	Level slog.Level
+	// Levels overrides the level for named subsystems
+	Levels map[string]slog.Level
+}
+
+var (
+	mu     sync.RWMutex
+	base   slog.Handler = newHandler(os.Stdout, "text")
+	level  slog.Level   = slog.LevelInfo
+	levels              = map[string]slog.Level{}
+)
+
+// Setup installs the handler used by every logger, including the default slog
+// and log package loggers
+func Setup(w io.Writer, opts Options) {
+	mu.Lock()
+	base = newHandler(w, opts.Format)
+	level = opts.Level
+	levels = make(map[string]slog.Level, len(opts.Levels))
+	for name, l := range opts.Levels {
+		levels[name] = l
+	}
+	mu.Unlock()
+
+	slog.SetDefault(slog.New(&subsystemHandler{subsystem: ""}))
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{
+		// levels are filtered per subsystem, the base handler lets everything through
+		Level:       slog.Level(-100),
+		ReplaceAttr: redact,
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// For returns the logger for a subsystem, e.g. "spotify" or "db"
+func For(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem}).With(KeyService, subsystem)
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", s)
+	}
+	return l, nil
+}
+
+// ParseLevels parses per subsystem levels such as "spotify=debug,db=warn",
+// separated by commas or spaces
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	out := make(map[string]slog.Level)
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid subsystem level %q, expected name=level", part)
+		}
+		l, err := ParseLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = l
+	}
+	return out, nil
+}
+
+// subsystemHandler looks up the current base handler and level on every call,
+// so loggers created before Setup still follow its configuration
+type subsystemHandler struct {
+	subsystem string
+	ops       []func(slog.Handler) slog.Handler
+	// built is ops applied to the base handler they were last applied to
+	built atomic.Pointer[builtHandler]
+}
+
+type builtHandler struct {
+	base    slog.Handler
+	handler slog.Handler
+}
+
+func (h *subsystemHandler) handler() (slog.Handler, slog.Level) {
+	mu.RLock()
+	b, min := base, level
+	if l, ok := levels[h.subsystem]; ok {
+		min = l
+	}
+	mu.RUnlock()
+
+	if built := h.built.Load(); built != nil && built.base == b {
+		return built.handler, min
+	}
+	out := b
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	h.built.Store(&builtHandler{base: b, handler: out})
+	return out, min
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, l slog.Level) bool {
+	_, min := h.handler()
+	return l >= min
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	b, _ := h.handler()
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(KeyRequestID, id))
+	}
+	return b.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) with(op func(slog.Handler) slog.Handler) *subsystemHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &subsystemHandler{subsystem: h.subsystem, ops: append(ops, op)}
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(b slog.Handler) slog.Handler { return b.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(b slog.Handler) slog.Handler { return b.WithGroup(name) })
+}
+
+// sensitive reports whether an attribute key names a credential
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"token", "secret", "password", "authorization", "cookie", "jwt"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return key == "key" || strings.HasSuffix(key, "_key") || strings.HasSuffix(key, "apikey")
+}
+
+// credentialParam matches credentials passed in a URL query, such as the
+// api_key and sk Last.fm requests carry, wherever a URL ends up in a value
+var credentialParam = regexp.MustCompile(`(?i)([?&](?:api_key|apikey|key|token|access_token|refresh_token|id_token|sk|api_sig|secret|client_secret|password|code|code_verifier)=)[^&#\s"']*`)
+
+// scrub redacts credentials in URL queries within s
+func scrub(s string) string {
+	return credentialParam.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitive(a.Key) && a.Value.Kind() != slog.KindGroup {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if strings.HasPrefix(strings.ToLower(a.Value.String()), "bearer ") {
+			return slog.String(a.Key, Redacted)
+		}
+		if v := a.Value.String(); credentialParam.MatchString(v) {
+			return slog.String(a.Key, scrub(v))
+		}
+	case slog.KindAny:
+		// e.g. a *url.Error logged as is, its text has the request URL
+		if err, ok := a.Value.Any().(error); ok {
+			if v := err.Error(); credentialParam.MatchString(v) {
+				return slog.String(a.Key, scrub(v))
+			}
+		}
+	}
+	return a
+}
+
+// UserID is the attribute for a piper user
+func UserID(id int64) slog.Attr {
+	return slog.Int64(KeyUserID, id)
+}
+
+// DID is the attribute for an ATProto DID
+func DID(did string) slog.Attr {
+	return slog.String(KeyDID, did)
+}
+
+// Track is the attribute for a track, as "artist - name"
+func Track(track *models.Track) slog.Attr {
+	if track == nil {
+		return slog.String(KeyTrack, "")
+	}
+	if len(track.Artist) == 0 {
+		return slog.String(KeyTrack, track.Name)
+	}
+	return slog.String(KeyTrack, track.Artist[0].Name+" - "+track.Name)
+}
+
+// Err is the attribute for an error
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+	return slog.String(KeyError, err.Error())
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID stores a request ID in ctx, loggers add it to every record
+// logged with that context
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs a proxy might set, without letting clients inject
+// arbitrary text into the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID gives every request an ID, reusing X-Request-ID from a proxy
+// when it looks sane, and echoes it back in the response
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
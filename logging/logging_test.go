@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func setupTestLogging(t *testing.T, opts Options) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	opts.Format = "json"
+	Setup(&buf, opts)
+	t.Cleanup(func() { Setup(os.Stdout, Options{Level: slog.LevelInfo}) })
+	return &buf
+}
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	buf := setupTestLogging(t, Options{
+		Level:  slog.LevelInfo,
+		Levels: map[string]slog.Level{"spotify": slog.LevelDebug, "db": slog.LevelError},
+	})
+
+	For("spotify").Debug("polled", UserID(1))
+	For("db").Warn("slow query")
+	For("lastfm").Debug("hidden")
+	For("lastfm").Info("shown")
+
+	lines := decodeLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0][KeyService] != "spotify" || lines[0][KeyUserID] != float64(1) {
+		t.Errorf("unexpected first line: %v", lines[0])
+	}
+	if lines[1][KeyService] != "lastfm" {
+		t.Errorf("unexpected second line: %v", lines[1])
+	}
+}
+
+func TestLoggersFollowLaterSetup(t *testing.T) {
+	logger := For("relay").With("target_id", 4)
+	buf := setupTestLogging(t, Options{Level: slog.LevelInfo})
+
+	logger.Info("queued")
+	lines := decodeLines(t, buf)
+	if len(lines) != 1 || lines[0]["target_id"] != float64(4) {
+		t.Errorf("expected a logger made before Setup to write to the new handler, got %s", buf.String())
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	buf := setupTestLogging(t, Options{Level: slog.LevelInfo})
+
+	For("oauth").Info("token exchange",
+		"access_token", "abc",
+		"client_secret", "def",
+		"api_key", "ghi",
+		"header", "Bearer jkl",
+		"provider", "spotify",
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"abc", "def", "ghi", "jkl"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, `"provider":"spotify"`) {
+		t.Errorf("expected other fields to be kept: %s", out)
+	}
+}
+
+func TestRedactionOfURLErrors(t *testing.T) {
+	buf := setupTestLogging(t, Options{Level: slog.LevelInfo})
+
+	// a request that fails the way a network error does, its error carries the URL
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL + "/2.0/?method=user.getrecenttracks&user=rj&api_key=lastfmkey123&sk=sessionkey456&format=json"
+	server.Close()
+	_, err := http.Get(endpoint)
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("expected a *url.Error, got %T: %v", err, err)
+	}
+
+	For("lastfm").Error("error fetching recent tracks", Err(err), "cause", err)
+
+	out := buf.String()
+	for _, secret := range []string{"lastfmkey123", "sessionkey456"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("expected %q to be redacted: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "method=user.getrecenttracks") || !strings.Contains(out, "api_key="+Redacted) {
+		t.Errorf("expected the rest of the URL to be kept: %s", out)
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("spotify=debug, db=warn")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if levels["spotify"] != slog.LevelDebug || levels["db"] != slog.LevelWarn {
+		t.Errorf("unexpected levels: %v", levels)
+	}
+
+	if _, err := ParseLevels("spotify"); err == nil {
+		t.Error("expected an error without a level")
+	}
+	if _, err := ParseLevels("spotify=loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	buf := setupTestLogging(t, Options{Level: slog.LevelInfo})
+	logger := For("http")
+
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handled")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "from-proxy-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("X-Request-ID"); got != "from-proxy-1" {
+		t.Errorf("expected the proxy's request ID to be kept, got %q", got)
+	}
+	lines := decodeLines(t, buf)
+	if len(lines) != 1 || lines[0][KeyRequestID] != "from-proxy-1" {
+		t.Errorf("expected the request ID on the log line, got %s", buf.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "bad id\nwith newline")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("X-Request-ID"); got == "" || strings.Contains(got, " ") {
+		t.Errorf("expected an invalid request ID to be replaced, got %q", got)
+	}
+}
@@ -0,0 +1,216 @@
+// Package metrics exposes Prometheus counters and histograms for the trackers,
+// stamping, MusicBrainz, PDS submissions and the HTTP server
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/teal-fm/piper/service/events"
+)
+
+const namespace = "piper"
+
+// Results used as label values
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultHit     = "hit"
+	ResultMiss    = "miss"
+)
+
+var (
+	providerPolls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_polls_total",
+		Help:      "Polls of a music provider for a user, by result.",
+	}, []string{"service", "result"})
+
+	providerPollDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_poll_duration_seconds",
+		Help:      "Time taken to poll a music provider for a user.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service"})
+
+	upstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Responses from upstream APIs by status code, code is \"error\" when no response was received.",
+	}, []string{"service", "code"})
+
+	tracksStamped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tracks_stamped_total",
+		Help:      "Tracks stamped, by the source they came from.",
+	}, []string{"source"})
+
+	musicBrainzCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "musicbrainz_cache_total",
+		Help:      "MusicBrainz search cache lookups, by hit or miss.",
+	}, []string{"result"})
+
+	musicBrainzDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "musicbrainz_request_duration_seconds",
+		Help:      "Latency of MusicBrainz API requests, including time spent rate limited.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	pdsSubmissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pds_submissions_total",
+		Help:      "Play records submitted to users' PDSes, by result.",
+	}, []string{"result"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	activeUsers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_users",
+		Help:      "Users polled by each provider in the last cycle.",
+	}, []string{"provider"})
+)
+
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// ObservePoll records a single poll of service for a user that began at start
+func ObservePoll(service string, start time.Time, err error) {
+	providerPolls.WithLabelValues(service, result(err)).Inc()
+	providerPollDuration.WithLabelValues(service).Observe(time.Since(start).Seconds())
+}
+
+// ObserveMusicBrainzCache records a search cache lookup
+func ObserveMusicBrainzCache(hit bool) {
+	if hit {
+		musicBrainzCache.WithLabelValues(ResultHit).Inc()
+		return
+	}
+	musicBrainzCache.WithLabelValues(ResultMiss).Inc()
+}
+
+// ObserveMusicBrainzRequest records a MusicBrainz API request that began at start
+func ObserveMusicBrainzRequest(start time.Time, err error) {
+	musicBrainzDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObservePDSSubmission records a play record submission
+func ObservePDSSubmission(err error) {
+	pdsSubmissions.WithLabelValues(result(err)).Inc()
+}
+
+// SetActiveUsers sets the number of users a provider is polling
+func SetActiveUsers(provider string, n int) {
+	activeUsers.WithLabelValues(provider).Set(float64(n))
+}
+
+// HandleEvent counts stamped tracks, subscribe it to the event bus
+func HandleEvent(e events.Event) {
+	if e.Type == events.TrackStamped {
+		tracksStamped.WithLabelValues(e.Source).Inc()
+	}
+}
+
+// transport counts upstream responses by status code
+type transport struct {
+	service string
+	base    http.RoundTripper
+}
+
+// NewTransport wraps base, or http.DefaultTransport when nil, to count responses
+// from service's API by status code
+func NewTransport(service string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{service: service, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		upstreamResponses.WithLabelValues(t.service, ResultError).Inc()
+		return resp, err
+	}
+	upstreamResponses.WithLabelValues(t.service, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streams
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// WithMetrics records the latency of every request handled by mux, labelled
+// with the matched route pattern rather than the path to keep cardinality low
+func WithMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		mux.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler serves the metrics. When token is set requests need it as a Bearer token
+func Handler(token string) http.Handler {
+	h := promhttp.Handler()
+	if token == "" {
+		return h
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/teal-fm/piper/service/events"
+)
+
+func TestObservePoll(t *testing.T) {
+	before := testutil.ToFloat64(providerPolls.WithLabelValues("spotify", ResultError))
+	ObservePoll("spotify", time.Now(), errors.New("boom"))
+	ObservePoll("spotify", time.Now(), nil)
+
+	if got := testutil.ToFloat64(providerPolls.WithLabelValues("spotify", ResultError)); got != before+1 {
+		t.Errorf("expected error polls to go up by one, got %v from %v", got, before)
+	}
+}
+
+func TestHandleEventCountsStamps(t *testing.T) {
+	before := testutil.ToFloat64(tracksStamped.WithLabelValues(events.SourceLastFM))
+	HandleEvent(events.Event{Type: events.TrackStamped, Source: events.SourceLastFM})
+	HandleEvent(events.Event{Type: events.NowPlayingChanged, Source: events.SourceLastFM})
+
+	if got := testutil.ToFloat64(tracksStamped.WithLabelValues(events.SourceLastFM)); got != before+1 {
+		t.Errorf("expected only the stamp to be counted, got %v from %v", got, before)
+	}
+}
+
+func TestTransportCountsStatusCodes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: NewTransport("test", nil)}
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(upstreamResponses.WithLabelValues("test", "429")); got != 1 {
+		t.Errorf("expected one 429, got %v", got)
+	}
+}
+
+func TestWithMetricsUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /u/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// streaming handlers need to reach the real writer
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected flush to reach the underlying writer: %v", err)
+		}
+	})
+	handler := WithMetrics(mux)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/u/alice", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/u/bob", nil))
+
+	if got := testutil.CollectAndCount(httpDuration, "piper_http_request_duration_seconds"); got != 1 {
+		t.Errorf("expected a single series for the route, got %d", got)
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	handler := Handler("secret")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with the token, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "piper_provider_polls_total") {
+		t.Error("expected piper metrics in the output")
+	}
+}
@@ -52,7 +52,6 @@ func (tfmTrack TealFmFeedPlay) MarshalCBOR(w io.Writer) error {
 	// if tfmTrack == nil {
 	// 	return fmt.Errorf("cannot marshal nil TealFmFeedPlay")
 	// }
-	fmt.Println("Marshalling", tfmTrack)
 	trackBytes, err := json.Marshal(tfmTrack)
 	if err != nil {
 		return fmt.Errorf("failed to marshal trackMap to bytes: %w", err)
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ConnectionStatus how polling a linked provider is going for a user
+type ConnectionStatus struct {
+	UserID        int64      `json:"-"`
+	Provider      string     `json:"provider"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastError     *string    `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	// ErrorCount consecutive failed polls, reset by a successful one
+	ErrorCount int `json:"error_count"`
+	// NeedsReauth the provider rejected the link, it won't be polled until the user links it again
+	NeedsReauth bool `json:"needs_reauth"`
+	// NextPollAt when polling resumes after repeated failures
+	NextPollAt *time.Time `json:"next_poll_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// Healthy reports whether the last poll succeeded
+func (c *ConnectionStatus) Healthy() bool {
+	return c.ErrorCount == 0 && !c.NeedsReauth
+}
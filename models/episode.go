@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Episode a podcast episode played on a music service. Kept apart from tracks
+// so stats, feeds and feed.play records stay about music
+type Episode struct {
+	PlayID int64 `json:"playId"`
+	// Name the episode title
+	Name string `json:"name"`
+	// Show the podcast it is an episode of
+	Show           string    `json:"show"`
+	Publisher      string    `json:"publisher,omitempty"`
+	URL            string    `json:"url"`
+	Timestamp      time.Time `json:"timestamp"`
+	DurationMs     int64     `json:"durationMs"`
+	ProgressMs     int64     `json:"progressMs"`
+	ServiceBaseUrl string    `json:"serviceBaseUrl"`
+	HasStamped     bool      `json:"hasStamped"`
+
+	DeviceName string `json:"deviceName,omitempty"`
+	DeviceType string `json:"deviceType,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Export job statuses
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+// ExportJob a background job building a data export archive for a user
+type ExportJob struct {
+	ID          string     `json:"id"`
+	UserID      int64      `json:"-"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error,omitempty"`
+	FilePath    *string    `json:"-"` // archive on disk, only set once done
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
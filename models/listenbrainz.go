@@ -1,6 +1,15 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ListenBrainzSubmission represents the top-level submission format
 type ListenBrainzSubmission struct {
@@ -24,33 +33,90 @@ type ListenBrainzTrackMetadata struct {
 
 // ListenBrainzAdditionalInfo contains optional metadata
 type ListenBrainzAdditionalInfo struct {
-	MediaPlayer             *string  `json:"media_player,omitempty"`
-	SubmissionClient        *string  `json:"submission_client,omitempty"`
-	SubmissionClientVersion *string  `json:"submission_client_version,omitempty"`
-	RecordingMBID           *string  `json:"recording_mbid,omitempty"`
-	ArtistMBIDs             []string `json:"artist_mbids,omitempty"`
-	ReleaseMBID             *string  `json:"release_mbid,omitempty"`
-	ReleaseGroupMBID        *string  `json:"release_group_mbid,omitempty"`
-	TrackMBID               *string  `json:"track_mbid,omitempty"`
-	WorkMBIDs               []string `json:"work_mbids,omitempty"`
-	Tags                    []string `json:"tags,omitempty"`
-	DurationMs              *int64   `json:"duration_ms,omitempty"`
-	SpotifyID               *string  `json:"spotify_id,omitempty"`
-	ISRC                    *string  `json:"isrc,omitempty"`
-	TrackNumber             *int     `json:"tracknumber,omitempty"`
-	DiscNumber              *int     `json:"discnumber,omitempty"`
-	MusicService            *string  `json:"music_service,omitempty"`
-	MusicServiceName        *string  `json:"music_service_name,omitempty"`
-	OriginURL               *string  `json:"origin_url,omitempty"`
-	LastFMTrackURL          *string  `json:"lastfm_track_url,omitempty"`
-	YoutubeID               *string  `json:"youtube_id,omitempty"`
+	MediaPlayer             *string             `json:"media_player,omitempty"`
+	SubmissionClient        *string             `json:"submission_client,omitempty"`
+	SubmissionClientVersion *string             `json:"submission_client_version,omitempty"`
+	RecordingMBID           *string             `json:"recording_mbid,omitempty"`
+	ArtistMBIDs             []string            `json:"artist_mbids,omitempty"`
+	ArtistNames             []string            `json:"artist_names,omitempty"`
+	ReleaseMBID             *string             `json:"release_mbid,omitempty"`
+	ReleaseGroupMBID        *string             `json:"release_group_mbid,omitempty"`
+	TrackMBID               *string             `json:"track_mbid,omitempty"`
+	WorkMBIDs               []string            `json:"work_mbids,omitempty"`
+	Tags                    []string            `json:"tags,omitempty"`
+	DurationMs              *int64              `json:"duration_ms,omitempty"`
+	SpotifyID               *string             `json:"spotify_id,omitempty"`
+	ISRC                    *string             `json:"isrc,omitempty"`
+	TrackNumber             *ListenBrainzNumber `json:"tracknumber,omitempty"`
+	DiscNumber              *ListenBrainzNumber `json:"discnumber,omitempty"`
+	MusicService            *string             `json:"music_service,omitempty"`
+	MusicServiceName        *string             `json:"music_service_name,omitempty"`
+	OriginURL               *string             `json:"origin_url,omitempty"`
+	LastFMTrackURL          *string             `json:"lastfm_track_url,omitempty"`
+	YoutubeID               *string             `json:"youtube_id,omitempty"`
+}
+
+// ListenBrainzNumber is a track or disc number, which clients send as either a
+// number or a string such as "3" or "3/12"
+type ListenBrainzNumber int
+
+func (n *ListenBrainzNumber) UnmarshalJSON(data []byte) error {
+	var i int
+	if err := json.Unmarshal(data, &i); err == nil {
+		*n = ListenBrainzNumber(i)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "/")
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*n = ListenBrainzNumber(i)
+	return nil
+}
+
+// artistCreditSeparator splits an artist credit such as "A feat. B & C" into its artists
+var artistCreditSeparator = regexp.MustCompile(`(?i)\s*(?:,|&|;|\s(?:feat\.?|ft\.?|featuring|with|and|x|vs\.?)\s)\s*`)
+
+// listenBrainzArtists builds the artist list for a listen, one per artist MBID.
+// artist_name is a single credit string, so it is only split across the
+// artists when the names can be matched up with artist_mbids. Otherwise the
+// whole credit is a single artist without an MBID, since it can't be told
+// which MBID it belongs to
+func listenBrainzArtists(credit string, info *ListenBrainzAdditionalInfo) []Artist {
+	if info == nil || len(info.ArtistMBIDs) == 0 {
+		return []Artist{{Name: credit}}
+	}
+
+	names := info.ArtistNames
+	if len(names) != len(info.ArtistMBIDs) {
+		names = artistCreditSeparator.Split(credit, -1)
+	}
+
+	artists := make([]Artist, len(info.ArtistMBIDs))
+	for i, mbid := range info.ArtistMBIDs {
+		name := ""
+		if len(names) == len(info.ArtistMBIDs) {
+			name = strings.TrimSpace(names[i])
+		}
+		if name == "" {
+			// can't tell which name belongs to which MBID
+			return []Artist{{Name: credit}}
+		}
+		artists[i] = Artist{Name: name, MBID: &mbid}
+	}
+	return artists
 }
 
 // ConvertToTrack converts ListenBrainz format to internal Track format
 func (lbp *ListenBrainzPayload) ConvertToTrack() Track {
 	track := Track{
 		Name:   lbp.TrackMetadata.TrackName,
-		Artist: []Artist{{Name: lbp.TrackMetadata.ArtistName}},
+		Artist: listenBrainzArtists(lbp.TrackMetadata.ArtistName, lbp.TrackMetadata.AdditionalInfo),
 	}
 
 	// Set timestamp
@@ -85,16 +151,29 @@ func (lbp *ListenBrainzPayload) ConvertToTrack() Track {
 			track.ISRC = *info.ISRC
 		}
 
-		// Handle multiple artists from MBIDs
-		if len(info.ArtistMBIDs) > 0 {
-			artists := make([]Artist, len(info.ArtistMBIDs))
-			for i, mbid := range info.ArtistMBIDs {
-				artists[i] = Artist{
-					Name: lbp.TrackMetadata.ArtistName, // Use main artist name
-					MBID: &mbid,
-				}
-			}
-			track.Artist = artists
+		track.ReleaseGroupMBID = info.ReleaseGroupMBID
+		track.ReleaseTrackMBID = info.TrackMBID
+		track.WorkMBIDs = info.WorkMBIDs
+		track.Tags = info.Tags
+		if info.TrackNumber != nil {
+			n := int(*info.TrackNumber)
+			track.TrackNumber = &n
+		}
+		if info.DiscNumber != nil {
+			n := int(*info.DiscNumber)
+			track.DiscNumber = &n
+		}
+		if info.SubmissionClient != nil {
+			track.SubmissionClient = *info.SubmissionClient
+		}
+		if info.SubmissionClientVersion != nil {
+			track.SubmissionClientVersion = *info.SubmissionClientVersion
+		}
+		if info.MediaPlayer != nil {
+			track.MediaPlayer = *info.MediaPlayer
+		}
+		if info.YoutubeID != nil {
+			track.YouTubeID = *info.YoutubeID
 		}
 
 		// Set service information
@@ -120,3 +199,144 @@ func (lbp *ListenBrainzPayload) ConvertToTrack() Track {
 
 	return track
 }
+
+// ListenBrainzListen is a listen as returned by the ListenBrainz read API
+type ListenBrainzListen struct {
+	InsertedAt    *int64                    `json:"inserted_at,omitempty"`
+	ListenedAt    *int64                    `json:"listened_at,omitempty"`
+	PlayingNow    bool                      `json:"playing_now,omitempty"`
+	UserName      string                    `json:"user_name"`
+	TrackMetadata ListenBrainzTrackMetadata `json:"track_metadata"`
+}
+
+// ListenFromTrack converts an internal Track to a ListenBrainz listen
+func ListenFromTrack(track *Track, userName string) ListenBrainzListen {
+	artistNames := make([]string, 0, len(track.Artist))
+	var artistMBIDs []string
+	for _, artist := range track.Artist {
+		if artist.Name != "" && !slices.Contains(artistNames, artist.Name) {
+			artistNames = append(artistNames, artist.Name)
+		}
+		if artist.MBID != nil && *artist.MBID != "" {
+			artistMBIDs = append(artistMBIDs, *artist.MBID)
+		}
+	}
+
+	info := &ListenBrainzAdditionalInfo{
+		RecordingMBID: track.RecordingMBID,
+		ReleaseMBID:   track.ReleaseMBID,
+		ArtistMBIDs:   artistMBIDs,
+	}
+	if track.DurationMs > 0 {
+		info.DurationMs = &track.DurationMs
+	}
+	if track.ISRC != "" {
+		info.ISRC = &track.ISRC
+	}
+	if track.URL != "" {
+		info.OriginURL = &track.URL
+	}
+	if track.ServiceBaseUrl != "" {
+		info.MusicService = &track.ServiceBaseUrl
+	}
+	if len(artistNames) == len(artistMBIDs) && len(artistNames) > 1 {
+		info.ArtistNames = artistNames
+	}
+	info.ReleaseGroupMBID = track.ReleaseGroupMBID
+	info.TrackMBID = track.ReleaseTrackMBID
+	info.WorkMBIDs = track.WorkMBIDs
+	info.Tags = track.Tags
+	if track.TrackNumber != nil {
+		n := ListenBrainzNumber(*track.TrackNumber)
+		info.TrackNumber = &n
+	}
+	if track.DiscNumber != nil {
+		n := ListenBrainzNumber(*track.DiscNumber)
+		info.DiscNumber = &n
+	}
+	if track.SubmissionClient != "" {
+		info.SubmissionClient = &track.SubmissionClient
+	}
+	if track.SubmissionClientVersion != "" {
+		info.SubmissionClientVersion = &track.SubmissionClientVersion
+	}
+	if track.MediaPlayer != "" {
+		info.MediaPlayer = &track.MediaPlayer
+	}
+	if track.YouTubeID != "" {
+		info.YoutubeID = &track.YouTubeID
+	}
+
+	listen := ListenBrainzListen{
+		UserName: userName,
+		TrackMetadata: ListenBrainzTrackMetadata{
+			ArtistName:     strings.Join(artistNames, ", "),
+			TrackName:      track.Name,
+			AdditionalInfo: info,
+		},
+	}
+	if track.Album != "" {
+		listen.TrackMetadata.ReleaseName = &track.Album
+	}
+	if !track.Timestamp.IsZero() {
+		listenedAt := track.Timestamp.Unix()
+		listen.ListenedAt = &listenedAt
+		listen.InsertedAt = &listenedAt
+	}
+	return listen
+}
+
+// Limits ListenBrainz applies to submissions
+const (
+	ListenBrainzMaxListensPerRequest = 1000
+	ListenBrainzMaxListenSize        = 10240
+	ListenBrainzMaxPayloadSize       = ListenBrainzMaxListenSize * ListenBrainzMaxListensPerRequest
+	ListenBrainzMaxTagsPerListen     = 50
+	ListenBrainzMaxTagSize           = 64
+	// ListenBrainzMinimumTimestamp 2002-10-01, older listens are rejected
+	ListenBrainzMinimumTimestamp = 1033410000
+)
+
+// Validate checks a single listen the way ListenBrainz does, returning its error message
+func (lbp *ListenBrainzPayload) Validate(listenType string) error {
+	encoded, err := json.Marshal(lbp)
+	if err != nil {
+		return fmt.Errorf("JSON document could not be encoded: %w", err)
+	}
+	if len(encoded) > ListenBrainzMaxListenSize {
+		return fmt.Errorf("JSON document is too large. In aggregate, listens may not be larger than %d characters.", ListenBrainzMaxListenSize)
+	}
+
+	switch listenType {
+	case "playing_now":
+		if lbp.ListenedAt != nil {
+			return errors.New("JSON document must not contain listened_at while submitting playing_now.")
+		}
+	default:
+		if lbp.ListenedAt == nil {
+			return errors.New("JSON document must contain the key listened_at at the top level.")
+		}
+		if *lbp.ListenedAt < ListenBrainzMinimumTimestamp {
+			return fmt.Errorf("Value for key listened_at is too low. listened_at timestamp should be greater than %d (2002-10-01 00:00:00 UTC).", ListenBrainzMinimumTimestamp)
+		}
+	}
+
+	if strings.TrimSpace(lbp.TrackMetadata.ArtistName) == "" {
+		return errors.New("JSON document does not contain required track_metadata.artist_name.")
+	}
+	if strings.TrimSpace(lbp.TrackMetadata.TrackName) == "" {
+		return errors.New("JSON document does not contain required track_metadata.track_name.")
+	}
+
+	if info := lbp.TrackMetadata.AdditionalInfo; info != nil {
+		if len(info.Tags) > ListenBrainzMaxTagsPerListen {
+			return fmt.Errorf("JSON document may not contain more than %d items in track_metadata.additional_info.tags.", ListenBrainzMaxTagsPerListen)
+		}
+		for _, tag := range info.Tags {
+			if len(tag) > ListenBrainzMaxTagSize {
+				return fmt.Errorf("JSON document may not contain track_metadata.additional_info.tags longer than %d characters.", ListenBrainzMaxTagSize)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OAuth2State a pending OAuth2 login, created when the user is redirected to the
+// provider and consumed when the provider redirects back
+type OAuth2State struct {
+	State        string
+	Provider     string
+	SessionID    string // piper web session that started the login
+	CodeVerifier string // PKCE verifier, the challenge is derived from this
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
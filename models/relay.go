@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Kinds of scrobbling services plays can be relayed to
+const (
+	// RelayKindListenBrainz ListenBrainz or anything speaking its API, such as Maloja
+	RelayKindListenBrainz = "listenbrainz"
+	RelayKindLastFM       = "lastfm"
+	// RelayKindGNUFM Libre.fm or another GNU FM server, same API as Last.fm
+	RelayKindGNUFM = "gnufm"
+)
+
+// RelayTarget another scrobbling service a user's plays are forwarded to
+type RelayTarget struct {
+	ID       int64  `json:"id"`
+	UserID   int64  `json:"-"`
+	Kind     string `json:"kind"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	// Secret the ListenBrainz token or Last.fm session key
+	Secret        string     `json:"-"`
+	Enabled       bool       `json:"enabled"`
+	LastError     *string    `json:"last_error,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// RelayDelivery a play waiting to be sent to a relay target
+type RelayDelivery struct {
+	ID            int64
+	TargetID      int64
+	UserID        int64
+	Track         Track
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	CreatedAt     time.Time
+}
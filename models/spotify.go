@@ -0,0 +1,56 @@
+package models
+
+import "strings"
+
+// SpotifySettings what a user's Spotify playback is left out of tracking
+type SpotifySettings struct {
+	// SkipPrivateSessions don't track anything played in a Spotify private session
+	SkipPrivateSessions bool `json:"skip_private_sessions"`
+	// IgnoredDevices names or ids of Spotify Connect devices whose playback isn't tracked
+	IgnoredDevices []string `json:"ignored_devices"`
+	// Episodes what happens to podcast episodes, one of EpisodeModes
+	Episodes string `json:"episodes"`
+}
+
+const (
+	// EpisodesIgnore podcast episodes aren't tracked
+	EpisodesIgnore = "ignore"
+	// EpisodesLocal podcast episodes are saved to piper but not published
+	EpisodesLocal = "local"
+	// EpisodesPublish podcast episodes are saved and published as fm.teal.alpha.feed.episode records
+	EpisodesPublish = "publish"
+)
+
+// EpisodeModes what users can pick for podcast episodes
+var EpisodeModes = []string{EpisodesIgnore, EpisodesLocal, EpisodesPublish}
+
+// ValidEpisodeMode reports whether mode is one of EpisodeModes
+func ValidEpisodeMode(mode string) bool {
+	for _, m := range EpisodeModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultSpotifySettings settings of users who haven't changed any
+func DefaultSpotifySettings() *SpotifySettings {
+	return &SpotifySettings{SkipPrivateSessions: true, IgnoredDevices: []string{}, Episodes: EpisodesIgnore}
+}
+
+// IgnoresDevice reports whether the device with id and name is ignored, names
+// are matched case-insensitively
+func (s *SpotifySettings) IgnoresDevice(id, name string) bool {
+	for _, ignored := range s.IgnoredDevices {
+		if (id != "" && ignored == id) || (name != "" && strings.EqualFold(ignored, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// TracksEpisodes reports whether podcast episodes are saved at all
+func (s *SpotifySettings) TracksEpisodes() bool {
+	return s.Episodes == EpisodesLocal || s.Episodes == EpisodesPublish
+}
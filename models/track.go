@@ -19,6 +19,23 @@ type Track struct {
 	ServiceBaseUrl string    `json:"serviceBaseUrl"`
 	ISRC           string    `json:"isrc"`
 	HasStamped     bool      `json:"hasStamped"`
+
+	// extra metadata, mostly from ListenBrainz submissions
+	ReleaseGroupMBID *string `json:"releaseGroupMBID,omitempty"`
+	// the MusicBrainz track, the recording's position on a release
+	ReleaseTrackMBID        *string  `json:"releaseTrackMBID,omitempty"`
+	WorkMBIDs               []string `json:"workMBIDs,omitempty"`
+	TrackNumber             *int     `json:"trackNumber,omitempty"`
+	DiscNumber              *int     `json:"discNumber,omitempty"`
+	Tags                    []string `json:"tags,omitempty"`
+	SubmissionClient        string   `json:"submissionClient,omitempty"`
+	SubmissionClientVersion string   `json:"submissionClientVersion,omitempty"`
+	MediaPlayer             string   `json:"mediaPlayer,omitempty"`
+	YouTubeID               string   `json:"youtubeId,omitempty"`
+
+	// the device it was played on, as the service names it, e.g. a Spotify Connect speaker
+	DeviceName string `json:"deviceName,omitempty"`
+	DeviceType string `json:"deviceType,omitempty"`
 }
 
 type Artist struct {
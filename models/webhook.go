@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+	// WebhookDeliverySending is being sent inline, so the worker leaves it alone
+	WebhookDeliverySending = "sending"
+)
+
+// Webhook a URL a user wants piper events posted to
+type Webhook struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"-"`
+	URL    string `json:"url"`
+	// Secret signs every delivery, shown to the user so they can verify them
+	Secret string `json:"-"`
+	// Events the event types to send, all of them when empty
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether the webhook is subscribed to an event type
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery one event sent, or still to be sent, to a webhook
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	UserID        int64      `json:"-"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	ResponseCode  *int       `json:"response_code,omitempty"`
+	LastError     *string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
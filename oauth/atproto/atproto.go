@@ -3,36 +3,47 @@ package atproto
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
 	"github.com/bluesky-social/indigo/atproto/client"
 	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	piperoauth "github.com/teal-fm/piper/oauth"
 
 	"github.com/teal-fm/piper/session"
 
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"slices"
 )
 
+// loginScopes the scopes requested when signing in
+var loginScopes = []string{"atproto", "repo:fm.teal.alpha.feed.play", "repo:fm.teal.alpha.feed.episode", "repo:fm.teal.alpha.actor.status"}
+
+// PostingScope lets piper create Bluesky posts. It's only requested, in a separate
+// sign in, once a user chooses to share their recap
+const PostingScope = "repo:app.bsky.feed.post?action=create"
+
 type AuthService struct {
 	clientApp      *oauth.ClientApp
 	DB             *db.DB
 	sessionManager *session.Manager
 	clientId       string
 	callbackUrl    string
-	logger         *log.Logger
+	logger         *slog.Logger
 	allowedDids    []string
 }
 
 func NewATprotoAuthService(database *db.DB, sessionManager *session.Manager, clientSecretKey string, clientId string, callbackUrl string, clientSecretId string, allowedDids []string) (*AuthService, error) {
-	fmt.Println(clientId, callbackUrl)
+	logger := logging.For("atproto")
+	logger.Debug("configuring ATProto OAuth client", "client_id", clientId, "callback_url", callbackUrl)
 
-	scopes := []string{"atproto", "repo:fm.teal.alpha.feed.play", "repo:fm.teal.alpha.actor.status"}
+	// the client metadata lists every scope piper may ask for, logins only request loginScopes
+	scopes := append(slices.Clone(loginScopes), PostingScope)
 
 	var config oauth.ClientConfig
 	config = oauth.NewPublicConfig(clientId, callbackUrl, scopes)
@@ -47,8 +58,6 @@ func NewATprotoAuthService(database *db.DB, sessionManager *session.Manager, cli
 
 	oauthClient := oauth.NewClientApp(&config, db.NewSqliteATProtoStore(database.DB))
 
-	logger := log.New(os.Stdout, "ATProto oauth: ", log.LstdFlags|log.Lmsgprefix)
-
 	svc := &AuthService{
 		clientApp:      oauthClient,
 		callbackUrl:    callbackUrl,
@@ -76,16 +85,73 @@ func (a *AuthService) GetATProtoClient(accountDID string, sessionID string, ctx
 
 }
 
+// ResumeAccountSessions resumes every stored ATProto session for an account. The returned sessions
+// don't persist refreshed tokens, so they stay usable once the account's rows have been deleted
+func (a *AuthService) ResumeAccountSessions(ctx context.Context, accountDID string) ([]*oauth.ClientSession, error) {
+	did, err := syntax.ParseDID(accountDID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionIDs, err := a.DB.GetATProtoSessionIDs(accountDID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*oauth.ClientSession, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		sess, err := a.clientApp.ResumeSession(ctx, did, sessionID)
+		if err != nil {
+			a.logger.WarnContext(ctx, "could not resume session", logging.DID(accountDID), "session_id", sessionID, logging.Err(err))
+			continue
+		}
+		sess.PersistSessionCallback = nil
+		sessions = append(sessions, sess)
+	}
+	return sessions, nil
+}
+
+// LookupIdentity resolves a handle or DID to the account's identity, with both verified
+func (a *AuthService) LookupIdentity(ctx context.Context, identifier string) (*identity.Identity, error) {
+	atid, err := syntax.ParseAtIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	return a.clientApp.Dir.Lookup(ctx, *atid)
+}
+
+// CheckSigningKey makes sure the client secret key can still sign client
+// assertions, by signing a payload and verifying it with the published key
+func (a *AuthService) CheckSigningKey() error {
+	config := a.clientApp.Config
+	if !config.IsConfidential() || config.PrivateKey == nil {
+		return fmt.Errorf("no client signing key configured")
+	}
+	pub, err := config.PrivateKey.PublicKey()
+	if err != nil {
+		return fmt.Errorf("deriving public key: %w", err)
+	}
+	payload := []byte("piper signing key check")
+	sig, err := config.PrivateKey.HashAndSign(payload)
+	if err != nil {
+		return fmt.Errorf("signing: %w", err)
+	}
+	if err := pub.HashAndVerify(payload, sig); err != nil {
+		return fmt.Errorf("verifying signature: %w", err)
+	}
+	return nil
+}
+
 func (a *AuthService) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	handle := r.URL.Query().Get("handle")
 	if handle == "" {
-		a.logger.Printf("ATProto Login Error: handle is required")
+		a.logger.WarnContext(r.Context(), "login without a handle")
 		http.Error(w, "handle query parameter is required", http.StatusBadRequest)
 		return
 	}
 	ctx := r.Context()
 
-	a.logger.Printf("Attempting login for handle %s", handle)
+	a.logger.InfoContext(ctx, "attempting login", "handle", handle)
 
 	atid, err := syntax.ParseAtIdentifier(handle)
 	if err != nil {
@@ -100,12 +166,12 @@ func (a *AuthService) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	accountDid := ident.DID.String()
 
 	if len(a.allowedDids) > 0 && !slices.Contains(a.allowedDids, accountDid) {
-		a.logger.Printf("ATProto Login Error: DID %s for handle %s is not in the allowed list", accountDid, handle)
+		a.logger.WarnContext(ctx, "login for a DID that is not in the allowed list", logging.DID(accountDid), "handle", handle)
 		http.Error(w, "Unauthorized", http.StatusForbidden)
 		return
 	}
 
-	redirectURL, err := a.clientApp.StartAuthFlow(ctx, accountDid)
+	redirectURL, err := a.startAuthFlow(ctx, ident, loginScopes)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error initiating login: %v", err), http.StatusInternalServerError)
 		return
@@ -116,10 +182,84 @@ func (a *AuthService) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.logger.Printf("ATProto Login: Redirecting user %s to %s", handle, authUrl.String())
+	a.logger.DebugContext(ctx, "redirecting to authorization server", "handle", handle, "url", authUrl.String())
 	http.Redirect(w, r, authUrl.String(), http.StatusFound)
 }
 
+// startAuthFlow sends an auth request for the scopes and returns the URL to send the user to.
+// It's StartAuthFlow with the scopes chosen per sign in, instead of the configured ones
+func (a *AuthService) startAuthFlow(ctx context.Context, ident *identity.Identity, scopes []string) (string, error) {
+	host := ident.PDSEndpoint()
+	if host == "" {
+		return "", fmt.Errorf("identity does not link to an atproto host (PDS)")
+	}
+	authserverURL, err := a.clientApp.Resolver.ResolveAuthServerURL(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("resolving auth server: %w", err)
+	}
+	authserverMeta, err := a.clientApp.Resolver.ResolveAuthServerMetadata(ctx, authserverURL)
+	if err != nil {
+		return "", fmt.Errorf("fetching auth server metadata: %w", err)
+	}
+
+	info, err := a.clientApp.SendAuthRequest(ctx, authserverMeta, scopes, ident.DID.String())
+	if err != nil {
+		return "", fmt.Errorf("auth request failed: %w", err)
+	}
+	info.AccountDID = &ident.DID
+	if err := a.clientApp.Store.SaveAuthRequestInfo(ctx, *info); err != nil {
+		return "", fmt.Errorf("saving auth request: %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("client_id", a.clientApp.Config.ClientID)
+	params.Set("request_uri", info.RequestURI)
+	return fmt.Sprintf("%s?%s", authserverMeta.AuthorizationEndpoint, params.Encode()), nil
+}
+
+// hasScopes reports whether every wanted scope was granted
+func hasScopes(granted []string, wanted []string) bool {
+	for _, scope := range wanted {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasScope reports whether an ATProto session was granted the scope
+func (a *AuthService) HasScope(ctx context.Context, accountDID string, sessionID string, scope string) (bool, error) {
+	did, err := syntax.ParseDID(accountDID)
+	if err != nil {
+		return false, err
+	}
+	sess, err := a.clientApp.ResumeSession(ctx, did, sessionID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(sess.Data.Scopes, scope), nil
+}
+
+// RequestScopes starts a new sign in for a signed in account asking for the extra scopes
+// on top of the login ones. The user is sent back to returnTo, a local path, afterwards
+func (a *AuthService) RequestScopes(w http.ResponseWriter, r *http.Request, accountDID string, returnTo string, scopes ...string) {
+	ctx := r.Context()
+	ident, err := a.LookupIdentity(ctx, accountDID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resolving DID (%s): %v", accountDID, err), http.StatusInternalServerError)
+		return
+	}
+	redirectURL, err := a.startAuthFlow(ctx, ident, append(slices.Clone(loginScopes), scopes...))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error initiating login: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	piperoauth.SetReturnPath(w, returnTo)
+	a.logger.DebugContext(ctx, "asking for more scopes", logging.DID(accountDID), "scopes", scopes)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
 func (a *AuthService) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session")
 
@@ -143,7 +283,7 @@ func (a *AuthService) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		did, err := syntax.ParseDID(*dbUser.ATProtoDID)
 
 		if err != nil {
-			a.logger.Printf("Should not happen: %s", err)
+			a.logger.ErrorContext(r.Context(), "stored DID is invalid", logging.UserID(dbUser.ID), logging.Err(err))
 			a.sessionManager.ClearSessionCookie(w)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		}
@@ -151,7 +291,7 @@ func (a *AuthService) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		err = a.clientApp.Logout(ctx, did, webSession.ATProtoSessionID)
 		if err != nil {
-			a.logger.Printf("Error logging the user: %s out: %s", did, err)
+			a.logger.ErrorContext(ctx, "error logging out", logging.DID(did.String()), logging.Err(err))
 		}
 		a.sessionManager.DeleteSession(cookie.Value)
 	}
@@ -173,13 +313,13 @@ func (a *AuthService) HandleCallback(w http.ResponseWriter, r *http.Request) (in
 
 	// It's in the example repo and leaving for some debugging cause i've seen different scopes cause issues before
 	// so may be some nice debugging info to have
-	if !slices.Equal(sessData.Scopes, a.clientApp.Config.Scopes) {
-		a.logger.Printf("session auth scopes did not match those requested")
+	if !hasScopes(sessData.Scopes, loginScopes) {
+		a.logger.WarnContext(ctx, "session auth scopes did not match those requested", "granted", sessData.Scopes)
 	}
 
 	user, err := a.DB.FindOrCreateUserByDID(sessData.AccountDID.String())
 	if err != nil {
-		a.logger.Printf("ATProto Callback Error: Failed to find or create user for DID %s: %v", sessData.AccountDID.String(), err)
+		a.logger.ErrorContext(ctx, "failed to find or create user", logging.DID(sessData.AccountDID.String()), logging.Err(err))
 		http.Error(w, "Failed to process user information.", http.StatusInternalServerError)
 		return 0, fmt.Errorf("failed to find or create user")
 	}
@@ -187,13 +327,11 @@ func (a *AuthService) HandleCallback(w http.ResponseWriter, r *http.Request) (in
 	//This is piper's session for manging piper, not atproto sessions
 	createdSession := a.sessionManager.CreateSession(user.ID, sessData.SessionID)
 	a.sessionManager.SetSessionCookie(w, createdSession)
-	a.logger.Printf("Created session for user %d via service atproto", user.ATProtoDID)
-
 	err = a.DB.SetLatestATProtoSessionId(sessData.AccountDID.String(), sessData.SessionID)
 	if err != nil {
-		a.logger.Printf("Failed to set latest atproto session id for user %d: %v", user.ID, err)
+		a.logger.ErrorContext(ctx, "failed to set latest atproto session id", logging.UserID(user.ID), logging.Err(err))
 	}
 
-	a.logger.Printf("ATProto Callback Success: User %d (DID: %v) authenticated.", user.ID, user.ATProtoDID)
+	a.logger.InfoContext(ctx, "user authenticated", logging.UserID(user.ID), logging.DID(sessData.AccountDID.String()))
 	return user.ID, nil
 }
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/teal-fm/piper/logging"
 )
 
 func strPtr(raw string) *string {
@@ -31,7 +33,7 @@ func (a *AuthService) HandleClientMetadata(w http.ResponseWriter, r *http.Reques
 
 	// internal consistency check
 	if err := meta.Validate(a.clientApp.Config.ClientID); err != nil {
-		a.logger.Printf("validating client metadata: %s", err)
+		a.logger.ErrorContext(r.Context(), "invalid client metadata", logging.Err(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
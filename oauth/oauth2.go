@@ -7,21 +7,36 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
 	"github.com/teal-fm/piper/session"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/spotify"
 )
 
+// stateTTL how long a user has to finish a login with the provider
+const stateTTL = 10 * time.Minute
+
+// StateStore persists pending logins between HandleLogin and HandleCallback
+type StateStore interface {
+	SaveOAuth2State(state *models.OAuth2State) error
+	// ConsumeOAuth2State returns the pending login and removes it. nil if there isn't one
+	ConsumeOAuth2State(provider, state string) (*models.OAuth2State, error)
+}
+
 type Service struct {
+	provider      string
 	config        oauth2.Config
-	state         string
-	codeVerifier  string
-	codeChallenge string
+	stateStore    StateStore
 	tokenReceiver TokenReceiver
+	logger        *slog.Logger
 }
 
 func GenerateRandomState() string {
@@ -31,25 +46,37 @@ func GenerateRandomState() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func NewOAuth2Service(clientID, clientSecret, redirectURI string, scopes []string, provider string, tokenReceiver TokenReceiver) *Service {
-	var endpoint oauth2.Endpoint
-
+// ProviderEndpoint returns the OAuth2 endpoints for a provider. Spotify is built in, any other
+// provider needs oauth2.<provider>.auth_url and oauth2.<provider>.token_url configured
+func ProviderEndpoint(provider string) (oauth2.Endpoint, error) {
 	switch strings.ToLower(provider) {
 	case "spotify":
-		endpoint = spotify.Endpoint
+		return spotify.Endpoint, nil
 	default:
-		// placeholder
-		log.Printf("Warning: OAuth2 provider '%s' not explicitly configured. Using placeholder endpoints.", provider)
-		endpoint = oauth2.Endpoint{
-			AuthURL:  "https://example.com/auth",
-			TokenURL: "https://example.com/token",
+		authURL := viper.GetString("oauth2." + provider + ".auth_url")
+		tokenURL := viper.GetString("oauth2." + provider + ".token_url")
+		if authURL == "" || tokenURL == "" {
+			return oauth2.Endpoint{}, fmt.Errorf("oauth2 provider '%s' is missing auth_url or token_url", provider)
 		}
+		return oauth2.Endpoint{
+			AuthURL:  authURL,
+			TokenURL: tokenURL,
+		}, nil
 	}
+}
 
-	codeVerifier := GenerateCodeVerifier()
-	codeChallenge := GenerateCodeChallenge(codeVerifier)
+func NewOAuth2Service(clientID, clientSecret, redirectURI string, scopes []string, provider string, tokenReceiver TokenReceiver, stateStore StateStore) (*Service, error) {
+	endpoint, err := ProviderEndpoint(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateStore == nil {
+		return nil, errors.New("oauth2 state store is required")
+	}
 
 	return &Service{
+		provider: provider,
 		config: oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
@@ -57,11 +84,10 @@ func NewOAuth2Service(clientID, clientSecret, redirectURI string, scopes []strin
 			Scopes:       scopes,
 			Endpoint:     endpoint,
 		},
-		state:         GenerateRandomState(),
-		codeVerifier:  codeVerifier,
-		codeChallenge: codeChallenge,
+		stateStore:    stateStore,
 		tokenReceiver: tokenReceiver,
-	}
+		logger:        logging.For("oauth").With("provider", provider),
+	}, nil
 }
 
 // GenerateCodeVerifier generate a random code verifier, for PKCE
@@ -79,12 +105,38 @@ func GenerateCodeChallenge(verifier string) string {
 	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 }
 
+// HandleLogin starts a login with a fresh state and PKCE pair, bound to the user's piper session
 func (o *Service) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	sessionID, hasSession := session.GetSessionID(r.Context())
+	if !hasSession {
+		// linking a provider always happens on top of an ATProto login
+		o.logger.WarnContext(r.Context(), "login without a piper session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	now := time.Now().UTC()
+	codeVerifier := GenerateCodeVerifier()
+	pending := &models.OAuth2State{
+		State:        GenerateRandomState(),
+		Provider:     o.provider,
+		SessionID:    sessionID,
+		CodeVerifier: codeVerifier,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(stateTTL),
+	}
+
+	if err := o.stateStore.SaveOAuth2State(pending); err != nil {
+		o.logger.ErrorContext(r.Context(), "failed to save login state", logging.Err(err))
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
 	opts := []oauth2.AuthCodeOption{
-		oauth2.SetAuthURLParam("code_challenge", o.codeChallenge),
+		oauth2.SetAuthURLParam("code_challenge", GenerateCodeChallenge(codeVerifier)),
 		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
 	}
-	authURL := o.config.AuthCodeURL(o.state, opts...)
+	authURL := o.config.AuthCodeURL(pending.State, opts...)
 	http.Redirect(w, r, authURL, http.StatusSeeOther)
 }
 
@@ -95,36 +147,56 @@ func (o *Service) HandleLogout(w http.ResponseWriter, r *http.Request) {
 
 func (o *Service) HandleCallback(w http.ResponseWriter, r *http.Request) (int64, error) {
 	state := r.URL.Query().Get("state")
-	if state != o.state {
-		log.Printf("OAuth2 Callback Error: State mismatch. Expected '%s', got '%s'", o.state, state)
+	if state == "" {
+		o.logger.WarnContext(r.Context(), "callback without state")
 		http.Error(w, "State mismatch", http.StatusBadRequest)
 		return 0, errors.New("state mismatch")
 	}
 
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		errMsg := r.URL.Query().Get("error")
-		errDesc := r.URL.Query().Get("error_description")
-		log.Printf("OAuth2 Callback Error: No code provided. Error: '%s', Description: '%s'", errMsg, errDesc)
-		http.Error(w, fmt.Sprintf("Authorization failed: %s (%s)", errMsg, errDesc), http.StatusBadRequest)
-		return 0, errors.New("no code provided")
-	}
-
+	// checked before the state is consumed, a misconfigured provider shouldn't burn the login
 	if o.tokenReceiver == nil {
-		log.Printf("OAuth2 Callback Error: TokenReceiver is not configured for this service.")
+		o.logger.ErrorContext(r.Context(), "token receiver is not configured")
 		http.Error(w, "Internal server configuration error", http.StatusInternalServerError)
 		return 0, errors.New("token receiver not configured")
 	}
 
-	opts := []oauth2.AuthCodeOption{
-		oauth2.SetAuthURLParam("code_verifier", o.codeVerifier),
+	// consumed up front so a state can't be replayed, even if the rest of the callback fails
+	pending, err := o.stateStore.ConsumeOAuth2State(o.provider, state)
+	if err != nil {
+		o.logger.ErrorContext(r.Context(), "failed to look up login state", logging.Err(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return 0, errors.New("failed to look up state")
+	}
+	if pending == nil {
+		o.logger.WarnContext(r.Context(), "callback with unknown or already used state")
+		http.Error(w, "State mismatch", http.StatusBadRequest)
+		return 0, errors.New("state mismatch")
+	}
+	if time.Now().UTC().After(pending.ExpiresAt) {
+		o.logger.WarnContext(r.Context(), "callback with expired state", "expired_at", pending.ExpiresAt)
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return 0, errors.New("state expired")
 	}
 
-	log.Println(code)
+	sessionID, _ := session.GetSessionID(r.Context())
+	if sessionID != pending.SessionID {
+		o.logger.WarnContext(r.Context(), "callback state was issued to a different session")
+		http.Error(w, "State mismatch", http.StatusBadRequest)
+		return 0, errors.New("state session mismatch")
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		errMsg := r.URL.Query().Get("error")
+		errDesc := r.URL.Query().Get("error_description")
+		o.logger.WarnContext(r.Context(), "callback without code", "oauth_error", errMsg, "description", errDesc)
+		http.Error(w, fmt.Sprintf("Authorization failed: %s (%s)", errMsg, errDesc), http.StatusBadRequest)
+		return 0, errors.New("no code provided")
+	}
 
-	token, err := o.config.Exchange(context.Background(), code, opts...)
+	token, err := o.GetToken(r.Context(), code, pending.CodeVerifier)
 	if err != nil {
-		log.Printf("OAuth2 Callback Error: Failed to exchange code for token: %v", err)
+		o.logger.ErrorContext(r.Context(), "failed to exchange code for token", logging.Err(err))
 		http.Error(w, fmt.Sprintf("Error exchanging code for token: %v", err), http.StatusInternalServerError)
 		return 0, errors.New("failed to exchange code for token")
 	}
@@ -133,18 +205,19 @@ func (o *Service) HandleCallback(w http.ResponseWriter, r *http.Request) (int64,
 	// store token and get uid
 	userID, err := o.tokenReceiver.SetAccessToken(token.AccessToken, token.RefreshToken, userId, hasSession)
 	if err != nil {
-		log.Printf("OAuth2 Callback Info: TokenReceiver did not return a valid user ID for token: %s...", token.AccessToken[:customMin(10, len(token.AccessToken))])
+		o.logger.ErrorContext(r.Context(), "token receiver did not return a valid user", logging.Err(err))
 	}
 
-	log.Printf("OAuth2 Callback Success: Exchanged code for token, UserID: %d", userID)
+	o.logger.InfoContext(r.Context(), "exchanged code for token", logging.UserID(userID))
 	return userID, nil
 }
 
-func (o *Service) GetToken(code string) (*oauth2.Token, error) {
+// GetToken exchanges an authorization code using the PKCE verifier the login was started with
+func (o *Service) GetToken(ctx context.Context, code string, codeVerifier string) (*oauth2.Token, error) {
 	opts := []oauth2.AuthCodeOption{
-		oauth2.SetAuthURLParam("code_verifier", o.codeVerifier),
+		oauth2.SetAuthURLParam("code_verifier", codeVerifier),
 	}
-	return o.config.Exchange(context.Background(), code, opts...)
+	return o.config.Exchange(ctx, code, opts...)
 }
 
 func (o *Service) GetClient(token *oauth2.Token) *http.Client {
@@ -156,9 +229,36 @@ func (o *Service) RefreshToken(token *oauth2.Token) (*oauth2.Token, error) {
 	return oauth2.ReuseTokenSource(token, source).Token()
 }
 
-func customMin(a, b int) int {
-	if a < b {
-		return a
+// RevokeToken revokes an access or refresh token at the provider (RFC 7009). Providers need
+// oauth2.<provider>.revocation_url configured, Spotify doesn't offer one
+func (o *Service) RevokeToken(ctx context.Context, token string, tokenTypeHint string) error {
+	revocationURL := viper.GetString("oauth2." + o.provider + ".revocation_url")
+	if revocationURL == "" {
+		return ErrRevocationUnsupported
+	}
+
+	form := url.Values{}
+	form.Set("token", token)
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revocationURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revocation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s token revocation failed with status %d", o.provider, resp.StatusCode)
 	}
-	return b
+	return nil
 }
@@ -0,0 +1,242 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/session"
+)
+
+// mockTokenReceiver records the token handed over after a successful exchange
+type mockTokenReceiver struct {
+	accessToken string
+}
+
+func (m *mockTokenReceiver) SetAccessToken(token string, refreshToken string, currentId int64, hasSession bool) (int64, error) {
+	m.accessToken = token
+	return currentId, nil
+}
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+// newTestService creates a generic provider pointed at a fake token endpoint that checks the PKCE verifier
+func newTestService(t *testing.T, database *db.DB, receiver TokenReceiver) (*Service, *string) {
+	var gotVerifier string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Failed to parse token request: %v", err)
+		}
+		gotVerifier = r.FormValue("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-token-value",
+			"refresh_token": "refresh-token-value",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	viper.Set("oauth2.testprovider.auth_url", "https://provider.example/authorize")
+	viper.Set("oauth2.testprovider.token_url", tokenServer.URL)
+
+	svc, err := NewOAuth2Service("client", "secret", "http://localhost/callback/testprovider", []string{"read"}, "testprovider", receiver, database)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	return svc, &gotVerifier
+}
+
+// startLogin runs HandleLogin for a session and returns the state from the provider redirect
+func startLogin(t *testing.T, svc *Service, sessionID string) string {
+	req := httptest.NewRequest(http.MethodGet, "/login/testprovider", nil)
+	ctx := session.WithUserID(req.Context(), 1)
+	ctx = session.WithSessionID(ctx, sessionID)
+	rr := httptest.NewRecorder()
+	svc.HandleLogin(rr, req.WithContext(ctx))
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected redirect to provider, got %d", rr.Code)
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid redirect location: %v", err)
+	}
+	if location.Query().Get("code_challenge") == "" {
+		t.Error("Expected a PKCE code_challenge in the redirect")
+	}
+	return location.Query().Get("state")
+}
+
+func callback(svc *Service, sessionID, state string) (*httptest.ResponseRecorder, int64, error) {
+	req := httptest.NewRequest(http.MethodGet, "/callback/testprovider?code=abc&state="+url.QueryEscape(state), nil)
+	ctx := session.WithUserID(req.Context(), 1)
+	ctx = session.WithSessionID(ctx, sessionID)
+	rr := httptest.NewRecorder()
+	userID, err := svc.HandleCallback(rr, req.WithContext(ctx))
+	return rr, userID, err
+}
+
+func TestOAuth2LoginStatePerRequest(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	svc, _ := newTestService(t, database, &mockTokenReceiver{})
+
+	first := startLogin(t, svc, "session-a")
+	second := startLogin(t, svc, "session-b")
+	if first == "" || second == "" {
+		t.Fatal("Expected a state for every login")
+	}
+	if first == second {
+		t.Error("Expected concurrent logins to get different states")
+	}
+}
+
+func TestOAuth2LoginRequiresSession(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	svc, _ := newTestService(t, database, &mockTokenReceiver{})
+
+	req := httptest.NewRequest(http.MethodGet, "/login/testprovider", nil)
+	rr := httptest.NewRecorder()
+	svc.HandleLogin(rr, req)
+
+	if location := rr.Header().Get("Location"); location != "/" {
+		t.Errorf("Expected redirect home without a session, got %q", location)
+	}
+}
+
+func TestOAuth2CallbackSuccess(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	receiver := &mockTokenReceiver{}
+	svc, gotVerifier := newTestService(t, database, receiver)
+
+	state := startLogin(t, svc, "session-a")
+	_, userID, err := callback(svc, "session-a", state)
+	if err != nil {
+		t.Fatalf("Expected callback to succeed, got %v", err)
+	}
+	if userID != 1 {
+		t.Errorf("Expected user ID 1, got %d", userID)
+	}
+	if receiver.accessToken != "access-token-value" {
+		t.Errorf("Expected access token to be handed to the receiver, got %q", receiver.accessToken)
+	}
+	if *gotVerifier == "" {
+		t.Error("Expected the PKCE verifier to be sent with the token exchange")
+	}
+}
+
+func TestOAuth2CallbackRejectsBadState(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	svc, _ := newTestService(t, database, &mockTokenReceiver{})
+
+	t.Run("unknown state", func(t *testing.T) {
+		rr, _, err := callback(svc, "session-a", "not-a-real-state")
+		if err == nil || rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for unknown state, got %d (%v)", rr.Code, err)
+		}
+	})
+
+	t.Run("different session", func(t *testing.T) {
+		state := startLogin(t, svc, "session-a")
+		rr, _, err := callback(svc, "session-b", state)
+		if err == nil || rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for state from another session, got %d (%v)", rr.Code, err)
+		}
+	})
+
+	t.Run("replayed state", func(t *testing.T) {
+		state := startLogin(t, svc, "session-a")
+		if _, _, err := callback(svc, "session-a", state); err != nil {
+			t.Fatalf("Expected first callback to succeed, got %v", err)
+		}
+		rr, _, err := callback(svc, "session-a", state)
+		if err == nil || rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for replayed state, got %d (%v)", rr.Code, err)
+		}
+	})
+}
+
+func TestProviderEndpointRequiresConfig(t *testing.T) {
+	if _, err := ProviderEndpoint("unconfigured"); err == nil {
+		t.Error("Expected an error for a provider without endpoints")
+	}
+	if _, err := ProviderEndpoint("spotify"); err != nil {
+		t.Errorf("Expected spotify to be built in, got %v", err)
+	}
+}
+
+func TestOAuth2GenericProviderStoresTokens(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	svc, _ := newTestService(t, database, NewStoringReceiver("testprovider", database))
+
+	state := startLogin(t, svc, "session-a")
+	req := httptest.NewRequest(http.MethodGet, "/callback/testprovider?code=abc&state="+url.QueryEscape(state), nil)
+	ctx := session.WithUserID(req.Context(), userID)
+	ctx = session.WithSessionID(ctx, "session-a")
+	got, err := svc.HandleCallback(httptest.NewRecorder(), req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Expected callback to succeed, got %v", err)
+	}
+	if got != userID {
+		t.Errorf("Expected user ID %d, got %d", userID, got)
+	}
+
+	var accessToken, refreshToken string
+	err = database.QueryRow(`SELECT access_token, refresh_token FROM oauth2_tokens WHERE user_id = ? AND provider = ?`,
+		userID, "testprovider").Scan(&accessToken, &refreshToken)
+	if err != nil {
+		t.Fatalf("Expected the tokens to be stored: %v", err)
+	}
+	if accessToken != "access-token-value" || refreshToken != "refresh-token-value" {
+		t.Errorf("Unexpected stored tokens %q, %q", accessToken, refreshToken)
+	}
+}
+
+func TestOAuth2CallbackWithoutReceiverKeepsState(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	svc, _ := newTestService(t, database, nil)
+	state := startLogin(t, svc, "session-a")
+	rr, _, err := callback(svc, "session-a", state)
+	if err == nil || rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 without a token receiver, got %d (%v)", rr.Code, err)
+	}
+
+	pending, err := database.ConsumeOAuth2State("testprovider", state)
+	if err != nil {
+		t.Fatalf("ConsumeOAuth2State: %v", err)
+	}
+	if pending == nil {
+		t.Error("Expected the state to survive a callback that failed on configuration")
+	}
+}
@@ -2,23 +2,27 @@
 package oauth
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"sync"
+
+	"github.com/teal-fm/piper/logging"
 )
 
 // ServiceManager OAuthServiceManager manages multiple oauth client services
 type ServiceManager struct {
 	services map[string]AuthService
 	mu       sync.RWMutex
-	logger   *log.Logger
+	logger   *slog.Logger
 }
 
 func NewOAuthServiceManager() *ServiceManager {
 	return &ServiceManager{
 		services: make(map[string]AuthService),
-		logger:   log.New(log.Writer(), "oauth: ", log.LstdFlags|log.Lmsgprefix),
+		logger:   logging.For("oauth"),
 	}
 }
 
@@ -27,7 +31,7 @@ func (m *ServiceManager) RegisterService(name string, service AuthService) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.services[name] = service
-	m.logger.Printf("Registered auth service: %s", name)
+	m.logger.Info("registered auth service", "provider", name)
 }
 
 // GetService get an AuthService by registered name
@@ -38,6 +42,20 @@ func (m *ServiceManager) GetService(name string) (AuthService, bool) {
 	return service, exists
 }
 
+// RevokeToken revokes a token with the named service. Returns ErrRevocationUnsupported if the
+// service isn't registered or can't revoke tokens
+func (m *ServiceManager) RevokeToken(ctx context.Context, serviceName string, token string, tokenTypeHint string) error {
+	service, exists := m.GetService(serviceName)
+	if !exists {
+		return ErrRevocationUnsupported
+	}
+	revoker, ok := service.(TokenRevoker)
+	if !ok {
+		return ErrRevocationUnsupported
+	}
+	return revoker.RevokeToken(ctx, token, tokenTypeHint)
+}
+
 func (m *ServiceManager) HandleLogin(serviceName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		m.mu.RLock()
@@ -49,7 +67,7 @@ func (m *ServiceManager) HandleLogin(serviceName string) http.HandlerFunc {
 			return
 		}
 
-		m.logger.Printf("Auth service '%s' not found for login request", serviceName)
+		m.logger.WarnContext(r.Context(), "auth service not found for login request", "provider", serviceName)
 		http.Error(w, fmt.Sprintf("Auth service '%s' not found", serviceName), http.StatusNotFound)
 	}
 }
@@ -65,7 +83,7 @@ func (m *ServiceManager) HandleLogout(serviceName string) http.HandlerFunc {
 			return
 		}
 
-		m.logger.Printf("Auth service '%s' not found for login request", serviceName)
+		m.logger.WarnContext(r.Context(), "auth service not found for logout request", "provider", serviceName)
 		http.Error(w, fmt.Sprintf("Auth service '%s' not found", serviceName), http.StatusNotFound)
 	}
 }
@@ -76,10 +94,10 @@ func (m *ServiceManager) HandleCallback(serviceName string) http.HandlerFunc {
 		service, exists := m.services[serviceName]
 		m.mu.RUnlock()
 
-		m.logger.Printf("Logging in with service %s", serviceName)
+		m.logger.DebugContext(r.Context(), "handling callback", "provider", serviceName)
 
 		if !exists {
-			m.logger.Printf("Auth service '%s' not found for callback request", serviceName)
+			m.logger.WarnContext(r.Context(), "auth service not found for callback request", "provider", serviceName)
 			http.Error(w, fmt.Sprintf("OAuth service '%s' not found", serviceName), http.StatusNotFound)
 			return
 		}
@@ -87,19 +105,49 @@ func (m *ServiceManager) HandleCallback(serviceName string) http.HandlerFunc {
 		userID, err := service.HandleCallback(w, r)
 
 		if err != nil {
-			m.logger.Printf("Error handling callback for service '%s': %v", serviceName, err)
+			m.logger.ErrorContext(r.Context(), "error handling callback", "provider", serviceName, logging.Err(err))
 			http.Error(w, fmt.Sprintf("Error handling callback for service '%s'", serviceName), http.StatusInternalServerError)
 			return
 		}
 
 		if userID > 0 {
-
+			if path := returnPath(w, r); path != "" {
+				http.Redirect(w, r, path, http.StatusSeeOther)
+				return
+			}
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		} else {
-			m.logger.Printf("Callback for service '%s' did not result in a valid user ID.", serviceName)
+			m.logger.WarnContext(r.Context(), "callback did not result in a valid user", "provider", serviceName)
 			// todo: redirect to an error page
 			// right now this just redirects home but we don't want this behaviour ideally
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 		}
 	}
 }
+
+// returnCookie where to send the user once a callback succeeds
+const returnCookie = "login_return"
+
+// SetReturnPath sends the user back to path, a local path, after the next successful callback
+func SetReturnPath(w http.ResponseWriter, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     returnCookie,
+		Value:    path,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   600,
+	})
+}
+
+// returnPath reads and clears the path set by SetReturnPath, empty unless it's a local path
+func returnPath(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(returnCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: returnCookie, Path: "/", HttpOnly: true, MaxAge: -1})
+	if !strings.HasPrefix(cookie.Value, "/") || strings.HasPrefix(cookie.Value, "//") || strings.HasPrefix(cookie.Value, "/\\") {
+		return ""
+	}
+	return cookie.Value
+}
@@ -1,6 +1,8 @@
 package oauth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 )
 
@@ -20,3 +22,38 @@ type TokenReceiver interface {
 	// if there is a session, will associate the token with the session
 	SetAccessToken(token string, refreshToken string, currentId int64, hasSession bool) (int64, error)
 }
+
+// TokenStore keeps the tokens of providers no piper service consumes
+type TokenStore interface {
+	SaveOAuth2Token(userID int64, provider, accessToken, refreshToken string) error
+}
+
+// storingReceiver hands a generic provider's tokens to a TokenStore for the signed in user
+type storingReceiver struct {
+	provider string
+	store    TokenStore
+}
+
+// NewStoringReceiver returns a TokenReceiver that saves provider's tokens for the signed in user
+func NewStoringReceiver(provider string, store TokenStore) TokenReceiver {
+	return &storingReceiver{provider: provider, store: store}
+}
+
+func (r *storingReceiver) SetAccessToken(token string, refreshToken string, currentId int64, hasSession bool) (int64, error) {
+	if !hasSession {
+		return 0, errors.New("linking " + r.provider + " needs a signed in user")
+	}
+	if err := r.store.SaveOAuth2Token(currentId, r.provider, token, refreshToken); err != nil {
+		return 0, err
+	}
+	return currentId, nil
+}
+
+// ErrRevocationUnsupported returned when a provider has no way to revoke tokens
+var ErrRevocationUnsupported = errors.New("provider does not support token revocation")
+
+// TokenRevoker optional, implemented by services that can revoke tokens at the provider
+type TokenRevoker interface {
+	// RevokeToken revokes an access or refresh token, tokenTypeHint may be empty
+	RevokeToken(ctx context.Context, token string, tokenTypeHint string) error
+}
@@ -107,6 +107,8 @@ func (p *Pages) funcMap() template.FuncMap {
 			}
 			return t.Format("Jan 02, 2006 15:04")
 		},
+		"add":  func(a, b int) int { return a + b },
+		"half": func(a int) int { return a / 2 },
 	}
 }
 
@@ -152,6 +154,17 @@ func (p *Pages) Execute(name string, w io.Writer, params any) error {
 	return tpl.ExecuteTemplate(w, "layouts/base", params)
 }
 
+// ExecuteFragment renders a template on its own, without the base layout. Used
+// for standalone documents such as embeds and images
+func (p *Pages) ExecuteFragment(name string, w io.Writer, params any) error {
+	tpl, err := p.parse(name)
+	if err != nil {
+		return err
+	}
+
+	return tpl.ExecuteTemplate(w, name, params)
+}
+
 // Shared view/template params
 
 type NavBar struct {
@@ -0,0 +1,141 @@
+// Package ratelimit provides fixed window rate limiting with ListenBrainz style headers
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/logging"
+)
+
+// Limiter allows a number of requests per key in each fixed window
+type Limiter struct {
+	limit     int
+	window    time.Duration
+	mu        sync.Mutex
+	windows   map[string]*window
+	nextSweep time.Time
+	now       func() time.Time
+	keys      KeyValidator
+}
+
+// KeyValidator tells real API keys apart from made up ones
+type KeyValidator interface {
+	GetApiKey(apiKeyID string) (*apikey.ApiKey, bool)
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Result is the outcome of a single Allow call
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// NewLimiter creates a limiter allowing limit requests per key in each period
+func NewLimiter(limit int, per time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		window:  per,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// WithKeys limits requests carrying an API key that keys knows per key. Without
+// it, and for unknown keys, requests are limited per client address
+func (l *Limiter) WithKeys(keys KeyValidator) *Limiter {
+	l.keys = keys
+	return l
+}
+
+// Allow counts a request for key and reports whether it is within the limit
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.After(l.nextSweep) {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.nextSweep = now.Add(l.window)
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+
+	result := Result{Limit: l.limit, Reset: w.start.Add(l.window)}
+	if w.count >= l.limit {
+		return result
+	}
+	w.count++
+	result.Allowed = true
+	result.Remaining = l.limit - w.count
+	return result
+}
+
+// SetHeaders writes the X-RateLimit-* headers ListenBrainz clients read
+func (r Result) SetHeaders(w http.ResponseWriter, now time.Time) {
+	resetIn := int64(r.Reset.Sub(now).Round(time.Second) / time.Second)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	w.Header().Set("X-RateLimit-Reset-In", strconv.FormatInt(max(resetIn, 0), 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(r.Reset.Unix(), 10))
+}
+
+// requestKey limits per API token once it is validated, falling back to the
+// client address so rotating made up tokens doesn't get around the limit
+func (l *Limiter) requestKey(r *http.Request) string {
+	if token, err := apikey.ExtractApiKey(r); err == nil && token != "" && l.keys != nil {
+		if _, ok := l.keys.GetApiKey(token); ok {
+			return "token:" + token
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// WithRateLimit middleware that rejects requests over the limit with a 429
+func WithRateLimit(handler http.HandlerFunc, l *Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := l.Allow(l.requestKey(r))
+		now := l.now()
+		result.SetHeaders(w, now)
+		if result.Allowed {
+			handler(w, r)
+			return
+		}
+
+		retryAfter := w.Header().Get("X-RateLimit-Reset-In")
+		w.Header().Set("Retry-After", retryAfter)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		if err := json.NewEncoder(w).Encode(map[string]any{
+			"code":  http.StatusTooManyRequests,
+			"error": fmt.Sprintf("Too many requests, retry in %s seconds", retryAfter),
+		}); err != nil {
+			slog.ErrorContext(r.Context(), "error writing rate limit response", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db/apikey"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(2, 10*time.Second)
+	l.now = func() time.Time { return now }
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.Allow("a").Allowed; got != want {
+			t.Errorf("request %d: expected allowed=%v, got %v", i, want, got)
+		}
+	}
+	if !l.Allow("b").Allowed {
+		t.Error("expected other keys to have their own window")
+	}
+
+	now = now.Add(10 * time.Second)
+	result := l.Allow("a")
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("expected a fresh window after reset, got %+v", result)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLimiter(1, 10*time.Second)
+	l.now = func() time.Time { return now }
+
+	handler := WithRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, l)
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/1/validate-token", nil)
+		req.Header.Set("Authorization", "Token abc")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := do()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("X-RateLimit-Limit") != "1" || rr.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected rate limit headers: %v", rr.Header())
+	}
+
+	now = now.Add(3 * time.Second)
+	rr = do()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("X-RateLimit-Reset-In"); got != "7" {
+		t.Errorf("expected X-RateLimit-Reset-In 7, got %s", got)
+	}
+	if got := rr.Header().Get("X-RateLimit-Reset"); got != "1700000010" {
+		t.Errorf("expected X-RateLimit-Reset 1700000010, got %s", got)
+	}
+}
+
+// knownKeys validates the keys it holds
+type knownKeys map[string]bool
+
+func (k knownKeys) GetApiKey(apiKeyID string) (*apikey.ApiKey, bool) {
+	if !k[apiKeyID] {
+		return nil, false
+	}
+	return &apikey.ApiKey{ID: apiKeyID}, true
+}
+
+func TestWithRateLimitKeysOnValidTokens(t *testing.T) {
+	l := NewLimiter(1, 10*time.Second).WithKeys(knownKeys{"real": true})
+	handler := WithRateLimit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, l)
+
+	do := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/1/validate-token", nil)
+		req.Header.Set("Authorization", "Token "+token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := do("made-up-1"); code != http.StatusOK {
+		t.Fatalf("expected the first request to pass, got %d", code)
+	}
+	if code := do("made-up-2"); code != http.StatusTooManyRequests {
+		t.Errorf("expected rotating unknown tokens to share the client's limit, got %d", code)
+	}
+	if code := do("real"); code != http.StatusOK {
+		t.Errorf("expected a valid token to get its own limit, got %d", code)
+	}
+	if len(l.windows) != 2 {
+		t.Errorf("expected one window for the address and one for the valid token, got %d", len(l.windows))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", time.Minute},
+		{"120", 2 * time.Minute},
+		{"0", time.Minute},
+		{"soon", time.Minute},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), time.Minute},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.header, now, time.Minute); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterError is returned when an upstream API answered 429, RetryAfter is
+// how long it asked us to wait before the next request
+type RetryAfterError struct {
+	Service    string
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %s", e.Service, e.RetryAfter)
+}
+
+// NewRetryAfterError reads the Retry-After header of a 429 response from
+// service, using fallback when the header is missing or unreadable
+func NewRetryAfterError(service string, resp *http.Response, fallback time.Duration) *RetryAfterError {
+	return &RetryAfterError{
+		Service:    service,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now(), fallback),
+	}
+}
+
+// ParseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, returning fallback when it is missing, unreadable or already past
+func ParseRetryAfter(header string, now time.Time, fallback time.Duration) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds <= 0 {
+			return fallback
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return fallback
+}
@@ -0,0 +1,265 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	piperoauth "github.com/teal-fm/piper/oauth"
+	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/pages"
+	atprotoservice "github.com/teal-fm/piper/service/atproto"
+	"github.com/teal-fm/piper/service/export"
+	"github.com/teal-fm/piper/session"
+)
+
+// cleanupTimeout bounds the remote cleanup (PDS records, token revocation) after an account is deleted
+const cleanupTimeout = 10 * time.Minute
+
+// confirmPhrase has to be typed by the user to delete their account
+const confirmPhrase = "DELETE"
+
+var ErrUserNotFound = errors.New("user not found")
+
+// UserCache is implemented by services that keep per-user state in memory
+type UserCache interface {
+	ForgetUser(user *models.User)
+}
+
+// TokenRevoker revokes provider tokens, see oauth.ServiceManager
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, serviceName string, token string, tokenTypeHint string) error
+}
+
+type Service struct {
+	db             *db.DB
+	sessionManager *session.Manager
+	atprotoService *atprotoauth.AuthService
+	exportService  *export.Service
+	tokenRevoker   TokenRevoker
+	caches         []UserCache
+	cleanup        sync.WaitGroup
+	logger         *slog.Logger
+}
+
+func NewAccountService(database *db.DB, sessionManager *session.Manager, atprotoService *atprotoauth.AuthService, exportService *export.Service, tokenRevoker TokenRevoker, caches ...UserCache) *Service {
+	return &Service{
+		db:             database,
+		sessionManager: sessionManager,
+		atprotoService: atprotoService,
+		exportService:  exportService,
+		tokenRevoker:   tokenRevoker,
+		caches:         caches,
+		logger:         logging.For("account"),
+	}
+}
+
+// DeleteAccount removes the user and all their data. Rows and caches are gone when this returns,
+// revoking tokens and (if deleteRecords is set) removing fm.teal records from the PDS continue in the background
+func (s *Service) DeleteAccount(ctx context.Context, userID int64, deleteRecords bool) error {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user %d: %w", userID, err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	// resumed before the rows are deleted, these stay usable for the remote cleanup
+	var atprotoSessions []*oauth.ClientSession
+	if user.ATProtoDID != nil && s.atprotoService != nil {
+		atprotoSessions, err = s.atprotoService.ResumeAccountSessions(ctx, *user.ATProtoDID)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error resuming ATProto sessions", logging.UserID(userID), logging.Err(err))
+		}
+	}
+
+	if s.exportService != nil {
+		jobs, err := s.db.GetUserExportJobs(userID)
+		if err != nil {
+			return fmt.Errorf("failed to load exports for user %d: %w", userID, err)
+		}
+		for _, job := range jobs {
+			if err := s.exportService.RemoveJob(job); err != nil {
+				return fmt.Errorf("failed to remove export %s: %w", job.ID, err)
+			}
+		}
+	}
+
+	if err := s.db.DeleteUser(userID); err != nil {
+		return err
+	}
+
+	s.sessionManager.EvictUser(userID)
+	s.sessionManager.GetAPIKeyManager().EvictUser(userID)
+	for _, cache := range s.caches {
+		cache.ForgetUser(user)
+	}
+	s.logger.InfoContext(ctx, "deleted account", logging.UserID(userID))
+
+	s.cleanup.Add(1)
+	go func() {
+		defer s.cleanup.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		s.revokeProviderTokens(ctx, user)
+		s.cleanupATProto(ctx, user, atprotoSessions, deleteRecords)
+	}()
+
+	return nil
+}
+
+// revokeProviderTokens revokes the Spotify tokens where the provider supports it, they are deleted either way
+func (s *Service) revokeProviderTokens(ctx context.Context, user *models.User) {
+	if s.tokenRevoker == nil {
+		return
+	}
+	tokens := []struct {
+		value *string
+		hint  string
+	}{
+		{user.RefreshToken, "refresh_token"},
+		{user.AccessToken, "access_token"},
+	}
+	for _, token := range tokens {
+		if token.value == nil || *token.value == "" {
+			continue
+		}
+		err := s.tokenRevoker.RevokeToken(ctx, "spotify", *token.value, token.hint)
+		if errors.Is(err, piperoauth.ErrRevocationUnsupported) {
+			s.logger.InfoContext(ctx, "spotify tokens can't be revoked, they were deleted", logging.UserID(user.ID))
+			return
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error revoking spotify token", "hint", token.hint, logging.UserID(user.ID), logging.Err(err))
+		}
+	}
+}
+
+// cleanupATProto optionally deletes the user's fm.teal records, then revokes every ATProto session
+func (s *Service) cleanupATProto(ctx context.Context, user *models.User, sessions []*oauth.ClientSession, deleteRecords bool) {
+	if deleteRecords {
+		deleted := false
+		for _, sess := range sessions {
+			count, err := atprotoservice.DeleteTealRecords(ctx, sess.APIClient())
+			if err != nil {
+				s.logger.ErrorContext(ctx, "error deleting PDS records", logging.UserID(user.ID), "session_id", sess.Data.SessionID, logging.Err(err))
+				continue
+			}
+			s.logger.InfoContext(ctx, "deleted play records from the PDS", logging.UserID(user.ID), "count", count)
+			deleted = true
+			break
+		}
+		if !deleted && user.ATProtoDID != nil {
+			s.logger.WarnContext(ctx, "could not delete PDS records, no usable ATProto session", logging.UserID(user.ID))
+		}
+	}
+
+	for _, sess := range sessions {
+		if err := sess.RevokeSession(ctx); err != nil {
+			s.logger.ErrorContext(ctx, "error revoking ATProto session", logging.UserID(user.ID), "session_id", sess.Data.SessionID, logging.Err(err))
+		}
+	}
+}
+
+// sameOrigin reports whether a browser request came from one of piper's own pages.
+// Browsers send Origin with every POST, requests without one aren't from another site
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// HandleDeleteAccount shows the confirmation page (GET) and deletes the account (POST).
+// Only available to browser sessions, an API key can't delete the account it belongs to
+func (s *Service) HandleDeleteAccount(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := session.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if _, hasSession := session.GetSessionID(r.Context()); !hasSession {
+			http.Error(w, "Accounts can only be deleted from the website", http.StatusForbidden)
+			return
+		}
+
+		errorMessage := ""
+		status := http.StatusOK
+
+		switch r.Method {
+		case http.MethodPost:
+			if !sameOrigin(r) {
+				http.Error(w, "Cross-site requests can't delete an account", http.StatusForbidden)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "Failed to parse form", http.StatusBadRequest)
+				return
+			}
+			if r.FormValue("confirm") != confirmPhrase {
+				errorMessage = fmt.Sprintf("Type %s to confirm deleting your account.", confirmPhrase)
+				status = http.StatusBadRequest
+				break
+			}
+
+			deleteRecords := r.FormValue("delete_records") == "on"
+			if err := s.DeleteAccount(r.Context(), userID, deleteRecords); err != nil {
+				s.logger.ErrorContext(r.Context(), "error deleting account", logging.UserID(userID), logging.Err(err))
+				http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+				return
+			}
+
+			s.sessionManager.ClearSessionCookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		case http.MethodGet:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		lastfmUsername := ""
+		user, err := s.db.GetUserByID(userID)
+		if err == nil && user != nil && user.LastFMUsername != nil {
+			lastfmUsername = *user.LastFMUsername
+		} else if err != nil {
+			s.logger.ErrorContext(r.Context(), "error fetching user details for delete account page", logging.UserID(userID), logging.Err(err))
+		}
+
+		data := struct {
+			ConfirmPhrase string
+			Error         string
+			NavBar        pages.NavBar
+		}{
+			ConfirmPhrase: confirmPhrase,
+			Error:         errorMessage,
+			NavBar: pages.NavBar{
+				IsLoggedIn:        true,
+				LastFMUsername:    lastfmUsername,
+				SpotifyEnabled:    viper.GetBool("enable_spotify"),
+				LastFMEnabled:     viper.GetBool("enable_lastfm"),
+				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		if err := pg.Execute("deleteAccount", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
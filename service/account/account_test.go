@@ -0,0 +1,242 @@
+package account
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/session"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+// mockRevoker records the tokens it was asked to revoke
+type mockRevoker struct {
+	revoked []string
+}
+
+func (m *mockRevoker) RevokeToken(ctx context.Context, serviceName string, token string, tokenTypeHint string) error {
+	m.revoked = append(m.revoked, serviceName+":"+token)
+	return nil
+}
+
+// mockCache records which users it was told to forget
+type mockCache struct {
+	forgotten []int64
+}
+
+func (m *mockCache) ForgetUser(user *models.User) {
+	m.forgotten = append(m.forgotten, user.ID)
+}
+
+// createTestUser creates a user with a row in every table that references them
+func createTestUser(t *testing.T, database *db.DB, sm *session.Manager, did string) (int64, *session.Session, string) {
+	user, err := database.FindOrCreateUserByDID(did)
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	if _, err := database.AddSpotifySession(user.ID, "tester", did+"@example.com", "spotify-"+did, "access-"+did, "refresh-"+did, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to add spotify session: %v", err)
+	}
+	track := &models.Track{
+		Name:      "Song",
+		Artist:    []models.Artist{{Name: "Artist"}},
+		Album:     "Album",
+		URL:       "https://open.spotify.com/track/song",
+		Timestamp: time.Now().UTC(),
+	}
+	if _, err := database.SaveTrack(user.ID, track); err != nil {
+		t.Fatalf("Failed to save track: %v", err)
+	}
+
+	webSession := sm.CreateSession(user.ID, "atproto-session")
+	key, err := sm.CreateAPIKey(user.ID, "key", 30)
+	if err != nil {
+		t.Fatalf("Failed to create api key: %v", err)
+	}
+	if err := database.SaveOAuth2State(&models.OAuth2State{
+		State:        "state-" + did,
+		Provider:     "spotify",
+		SessionID:    webSession.ID,
+		CodeVerifier: "verifier",
+		CreatedAt:    time.Now().UTC(),
+		ExpiresAt:    time.Now().UTC().Add(time.Hour),
+	}); err != nil {
+		t.Fatalf("Failed to save oauth2 state: %v", err)
+	}
+	if err := database.CreateExportJob(&models.ExportJob{
+		ID:        "export-" + did,
+		UserID:    user.ID,
+		Status:    models.ExportStatusFailed,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		t.Fatalf("Failed to create export job: %v", err)
+	}
+
+	store := db.NewSqliteATProtoStore(database.DB)
+	if err := store.SaveSession(context.Background(), oauth.ClientSessionData{
+		AccountDID: syntax.DID(did),
+		SessionID:  "atproto-session",
+	}); err != nil {
+		t.Fatalf("Failed to save atproto session: %v", err)
+	}
+	accountDID := syntax.DID(did)
+	if err := store.SaveAuthRequestInfo(context.Background(), oauth.AuthRequestData{
+		State:      "atproto-state-" + did,
+		AccountDID: &accountDID,
+	}); err != nil {
+		t.Fatalf("Failed to save atproto state: %v", err)
+	}
+
+	return user.ID, webSession, key.ID
+}
+
+func countRows(t *testing.T, database *db.DB, query string, args ...any) int {
+	var count int
+	if err := database.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatalf("Failed to count rows (%s): %v", query, err)
+	}
+	return count
+}
+
+func TestDeleteAccount(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	sm := session.NewSessionManager(database)
+	userID, webSession, apiKey := createTestUser(t, database, sm, "did:plc:deleted")
+	otherID, _, _ := createTestUser(t, database, sm, "did:plc:other")
+
+	revoker := &mockRevoker{}
+	cache := &mockCache{}
+	svc := NewAccountService(database, sm, nil, nil, revoker, cache)
+
+	if err := svc.DeleteAccount(context.Background(), userID, false); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+	svc.cleanup.Wait()
+
+	tables := map[string]string{
+		"users":       `SELECT COUNT(*) FROM users WHERE id = ?`,
+		"tracks":      `SELECT COUNT(*) FROM tracks WHERE user_id = ?`,
+		"sessions":    `SELECT COUNT(*) FROM sessions WHERE user_id = ?`,
+		"api_keys":    `SELECT COUNT(*) FROM api_keys WHERE user_id = ?`,
+		"export_jobs": `SELECT COUNT(*) FROM export_jobs WHERE user_id = ?`,
+	}
+	for table, query := range tables {
+		if n := countRows(t, database, query, userID); n != 0 {
+			t.Errorf("Expected no %s rows for the deleted user, got %d", table, n)
+		}
+		if n := countRows(t, database, query, otherID); n == 0 {
+			t.Errorf("Expected %s rows of the other user to be kept", table)
+		}
+	}
+	if n := countRows(t, database, `SELECT COUNT(*) FROM oauth2_state`); n != 1 {
+		t.Errorf("Expected only the other user's oauth2 state to remain, got %d", n)
+	}
+	for _, table := range []string{"atproto_sessions", "atproto_state"} {
+		if n := countRows(t, database, `SELECT COUNT(*) FROM `+table+` WHERE account_did = ?`, "did:plc:deleted"); n != 0 {
+			t.Errorf("Expected no %s rows for the deleted DID, got %d", table, n)
+		}
+		if n := countRows(t, database, `SELECT COUNT(*) FROM `+table+` WHERE account_did = ?`, "did:plc:other"); n != 1 {
+			t.Errorf("Expected %s rows of the other DID to be kept, got %d", table, n)
+		}
+	}
+
+	if _, ok := sm.GetSession(webSession.ID); ok {
+		t.Error("Expected the cached web session to be evicted")
+	}
+	if _, ok := sm.GetAPIKeyManager().GetApiKey(apiKey); ok {
+		t.Error("Expected the cached API key to be evicted")
+	}
+	if len(cache.forgotten) != 1 || cache.forgotten[0] != userID {
+		t.Errorf("Expected the provider cache to forget user %d, got %v", userID, cache.forgotten)
+	}
+	if len(revoker.revoked) != 2 || revoker.revoked[0] != "spotify:refresh-did:plc:deleted" || revoker.revoked[1] != "spotify:access-did:plc:deleted" {
+		t.Errorf("Expected the user's spotify tokens to be revoked, got %v", revoker.revoked)
+	}
+
+	if err := svc.DeleteAccount(context.Background(), userID, false); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestHandleDeleteAccount(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	sm := session.NewSessionManager(database)
+	userID, webSession, _ := createTestUser(t, database, sm, "did:plc:handler")
+	svc := NewAccountService(database, sm, nil, nil, nil)
+	handler := svc.HandleDeleteAccount(pages.NewPages())
+
+	postFrom := func(ctx context.Context, origin string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/account/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		handler(rr, req.WithContext(ctx))
+		return rr
+	}
+	post := func(ctx context.Context, form url.Values) *httptest.ResponseRecorder {
+		return postFrom(ctx, "http://example.com", form)
+	}
+	sessionCtx := session.WithSessionID(session.WithUserID(context.Background(), userID), webSession.ID)
+
+	t.Run("api key", func(t *testing.T) {
+		ctx := session.WithAPIRequest(session.WithUserID(context.Background(), userID), true)
+		if rr := post(ctx, url.Values{"confirm": {"DELETE"}}); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for API key requests, got %d", rr.Code)
+		}
+	})
+
+	t.Run("cross-site", func(t *testing.T) {
+		if rr := postFrom(sessionCtx, "https://attacker.example", url.Values{"confirm": {"DELETE"}}); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a cross-site request, got %d", rr.Code)
+		}
+		if user, _ := database.GetUserByID(userID); user == nil {
+			t.Error("Expected user to be kept after a cross-site request")
+		}
+	})
+
+	t.Run("missing confirmation", func(t *testing.T) {
+		if rr := post(sessionCtx, url.Values{"confirm": {"delete"}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without confirmation, got %d", rr.Code)
+		}
+		if user, _ := database.GetUserByID(userID); user == nil {
+			t.Error("Expected user to be kept without confirmation")
+		}
+	})
+
+	t.Run("confirmed", func(t *testing.T) {
+		rr := post(sessionCtx, url.Values{"confirm": {"DELETE"}})
+		svc.cleanup.Wait()
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected redirect after deleting, got %d", rr.Code)
+		}
+		if user, _ := database.GetUserByID(userID); user != nil {
+			t.Error("Expected user to be deleted")
+		}
+		if cookie := rr.Header().Get("Set-Cookie"); !strings.Contains(cookie, "session=;") || !strings.Contains(cookie, "SameSite=Lax") {
+			t.Errorf("Expected session cookie to be cleared, got %q", cookie)
+		}
+	})
+}
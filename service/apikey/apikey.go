@@ -3,12 +3,13 @@ package apikey
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/teal-fm/piper/db"
 	dbapikey "github.com/teal-fm/piper/db/apikey" // Assuming this is the package for ApiKey struct
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/pages"
 	"github.com/teal-fm/piper/session"
 )
@@ -16,12 +17,14 @@ import (
 type Service struct {
 	db       *db.DB
 	sessions *session.Manager
+	logger   *slog.Logger
 }
 
 func NewAPIKeyService(database *db.DB, sessionManager *session.Manager) *Service {
 	return &Service{
 		db:       database,
 		sessions: sessionManager,
+		logger:   logging.For("apikey"),
 	}
 }
 
@@ -31,7 +34,7 @@ func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
 	w.WriteHeader(statusCode)
 	if data != nil {
 		if err := json.NewEncoder(w).Encode(data); err != nil {
-			log.Printf("Error encoding JSON response: %v", err)
+			slog.Error("error encoding JSON response", logging.Err(err))
 		}
 	}
 }
@@ -61,7 +64,7 @@ func (s *Service) HandleAPIKeyManagement(database *db.DB, pg *pages.Pages) http.
 		if err == nil && user != nil && user.LastFMUsername != nil {
 			lastfmUsername = *user.LastFMUsername
 		} else if err != nil {
-			log.Printf("Error fetching user %d details for home page: %v", userID, err)
+			s.logger.ErrorContext(r.Context(), "error fetching user details", logging.UserID(userID), logging.Err(err))
 		}
 		isAPI := session.IsAPIRequest(r.Context())
 
@@ -220,7 +223,7 @@ func (s *Service) HandleAPIKeyManagement(database *db.DB, pg *pages.Pages) http.
 		w.Header().Set("Content-Type", "text/html")
 		err = pg.Execute("apiKeys", w, data)
 		if err != nil {
-			log.Printf("Error executing template: %v", err)
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
 		}
 	}
 }
@@ -10,10 +10,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -22,10 +23,16 @@ import (
 	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/health"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
 	atprotoservice "github.com/teal-fm/piper/service/atproto"
+	"github.com/teal-fm/piper/service/connection"
+	"github.com/teal-fm/piper/service/events"
 	"github.com/teal-fm/piper/service/musicbrainz"
+	"github.com/teal-fm/piper/service/stamping"
 )
 
 type Service struct {
@@ -49,8 +56,17 @@ type Service struct {
 		PublishPlayingNow(ctx context.Context, userID int64, track *models.Track) error
 		ClearPlayingNow(ctx context.Context, userID int64) error
 	}
-	httpClient *http.Client
-	logger     *log.Logger
+	httpClient  *http.Client
+	events      *events.Bus
+	health      *health.Tracker
+	connections *connection.Monitor
+	stamping    *stamping.Resolver
+	logger      *slog.Logger
+
+	defaultStorefront string
+	storefrontMu      sync.Mutex
+	// storefronts each user's storefront, looked up once per run
+	storefronts map[int64]string
 }
 
 func NewService(teamID, keyID, privateKeyPath string) *Service {
@@ -58,8 +74,11 @@ func NewService(teamID, keyID, privateKeyPath string) *Service {
 		teamID:         teamID,
 		keyID:          keyID,
 		privateKeyPath: privateKeyPath,
-		httpClient:     &http.Client{Timeout: 10 * time.Second},
-		logger:         log.New(os.Stdout, "applemusic: ", log.LstdFlags|log.Lmsgprefix),
+		httpClient:     &http.Client{Timeout: 10 * time.Second, Transport: metrics.NewTransport(events.SourceAppleMusic, nil)},
+		logger:         logging.For("applemusic"),
+
+		defaultStorefront: DefaultStorefront,
+		storefronts:       make(map[int64]string),
 	}
 }
 
@@ -79,12 +98,39 @@ func (s *Service) WithDeps(database *db.DB, atproto *atprotoauth.AuthService, mb
 	ClearPlayingNow(ctx context.Context, userID int64) error
 }) *Service {
 	s.DB = database
+	s.connections = connection.NewMonitor(database, events.SourceAppleMusic)
 	s.atprotoService = atproto
 	s.mbService = mb
 	s.playingNowService = playingNowService
 	return s
 }
 
+// WithEvents publishes stamped tracks to bus
+func (s *Service) WithEvents(bus *events.Bus) *Service {
+	s.events = bus
+	return s
+}
+
+// WithHealth reports the outcome of each poll to t
+func (s *Service) WithHealth(t *health.Tracker) *Service {
+	s.health = t
+	return s
+}
+
+// WithStamping judges plays under each user's policy from r instead of the default
+func (s *Service) WithStamping(r *stamping.Resolver) *Service {
+	s.stamping = r
+	return s
+}
+
+// WithStorefront looks catalog metadata up in storefront when a user's own is unknown
+func (s *Service) WithStorefront(storefront string) *Service {
+	if storefront != "" {
+		s.defaultStorefront = storefront
+	}
+	return s
+}
+
 func (s *Service) HandleDeveloperToken(w http.ResponseWriter, r *http.Request) {
 	force := r.URL.Query().Get("refresh") == "1"
 	token, exp, err := s.GenerateDeveloperTokenWithForce(force)
@@ -97,7 +143,7 @@ func (s *Service) HandleDeveloperToken(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write([]byte(fmt.Sprintf(`{"token":"%s","expiresAt":"%s"}`, token, exp.UTC().Format(time.RFC3339))))
 	if err != nil {
-		s.logger.Printf("failed to write response: %v", err)
+		s.logger.ErrorContext(r.Context(), "failed to write response", logging.Err(err))
 	}
 }
 
@@ -282,6 +328,8 @@ type AppleRecentTrack struct {
 		PlayParams       *struct {
 			ID   string `json:"id"`
 			Kind string `json:"kind"`
+			// CatalogID set on library songs that are also in the catalog
+			CatalogID string `json:"catalogId"`
 		} `json:"playParams"`
 	} `json:"attributes"`
 }
@@ -298,46 +346,87 @@ type recentPlayedResponse struct {
 	Data []AppleRecentTrack `json:"data"`
 }
 
-// FetchRecentPlayedTracks calls Apple Music API for a user token
-func (s *Service) FetchRecentPlayedTracks(ctx context.Context, userToken string, limit int) ([]AppleRecentTrack, error) {
-	if limit <= 0 || limit > 50 {
-		limit = 25
-	}
+// ErrUserTokenRejected Apple no longer accepts the user's MusicKit token, it
+// expired or was revoked, and polling waits until they authorize again
+var ErrUserTokenRejected = fmt.Errorf("apple music user token rejected: %w", connection.ErrReauthRequired)
+
+var errNotFound = errors.New("apple music api error: not found")
+
+// apiGet returns the body of a successful GET of endpoint. A 401 is retried
+// once with a freshly signed developer token in case ours went stale; when
+// Apple still refuses a request made with userToken, the user token is to blame
+func (s *Service) apiGet(ctx context.Context, endpoint, userToken string) ([]byte, error) {
 	devToken, _, err := s.GenerateDeveloperToken()
 	if err != nil {
 		return nil, err
 	}
-	endpoint := &url.URL{Scheme: "https", Host: "api.music.apple.com", Path: "/v1/me/recent/played/tracks"}
-	q := endpoint.Query()
-	q.Set("limit", fmt.Sprintf("%d", limit))
-	endpoint.RawQuery = q.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	body, status, err := s.doGet(ctx, endpoint, devToken, userToken)
 	if err != nil {
 		return nil, err
 	}
+	if status == http.StatusUnauthorized {
+		devToken, _, err = s.GenerateDeveloperTokenWithForce(true)
+		if err != nil {
+			return nil, fmt.Errorf("apple music api error: %s, refreshing developer token: %w", http.StatusText(status), err)
+		}
+		if body, status, err = s.doGet(ctx, endpoint, devToken, userToken); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case status == http.StatusOK:
+		return body, nil
+	case userToken != "" && (status == http.StatusUnauthorized || status == http.StatusForbidden):
+		return nil, fmt.Errorf("%w (%d %s)", ErrUserTokenRejected, status, http.StatusText(status))
+	case status == http.StatusNotFound:
+		return nil, errNotFound
+	default:
+		return nil, fmt.Errorf("apple music api error: %d %s", status, http.StatusText(status))
+	}
+}
+
+func (s *Service) doGet(ctx context.Context, endpoint, devToken, userToken string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
 	req.Header.Set("Authorization", "Bearer "+devToken)
-	req.Header.Set("Music-User-Token", userToken)
+	if userToken != "" {
+		req.Header.Set("Music-User-Token", userToken)
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			s.logger.Printf("failed to close response body: %v", err)
+			s.logger.ErrorContext(ctx, "failed to close response body", logging.Err(err))
 		}
 	}(resp.Body)
 
-	// Read the full response body to log it
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, 0, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return bodyBytes, resp.StatusCode, nil
+}
+
+// FetchRecentPlayedTracks calls Apple Music API for a user token
+func (s *Service) FetchRecentPlayedTracks(ctx context.Context, userToken string, limit int) ([]AppleRecentTrack, error) {
+	if limit <= 0 || limit > recentWindow {
+		limit = 25
 	}
+	endpoint := &url.URL{Scheme: "https", Host: "api.music.apple.com", Path: "/v1/me/recent/played/tracks"}
+	q := endpoint.Query()
+	q.Set("limit", fmt.Sprintf("%d", limit))
+	endpoint.RawQuery = q.Encode()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("apple music api error: %s", resp.Status)
+	bodyBytes, err := s.apiGet(ctx, endpoint.String(), userToken)
+	if err != nil {
+		return nil, err
 	}
 
 	var parsed recentPlayedResponse
@@ -347,8 +436,9 @@ func (s *Service) FetchRecentPlayedTracks(ctx context.Context, userToken string,
 	return parsed.Data, nil
 }
 
-// toTrack converts AppleRecentTrack to internal models.Track
-func (s *Service) toTrack(t AppleRecentTrack) *models.Track {
+// toTrack converts AppleRecentTrack to internal models.Track, filled in from
+// the catalog when catalog isn't nil
+func (s *Service) toTrack(t AppleRecentTrack, catalog *CatalogSong) *models.Track {
 	var duration int64
 	if t.Attributes.DurationInMillis != nil {
 		duration = *t.Attributes.DurationInMillis
@@ -358,10 +448,6 @@ func (s *Service) toTrack(t AppleRecentTrack) *models.Track {
 		isrc = *t.Attributes.Isrc
 	}
 
-	// Similar stamping logic to Spotify: stamp if played more than half (or 30 seconds whichever is greater)
-	// Since Apple Music recent played tracks don't provide play progress, we assume full plays
-	isStamped := duration > 30000 && duration >= duration/2
-
 	track := &models.Track{
 		Name:           t.Attributes.Name,
 		Artist:         []models.Artist{{Name: t.Attributes.ArtistName}},
@@ -371,7 +457,6 @@ func (s *Service) toTrack(t AppleRecentTrack) *models.Track {
 		ProgressMs:     duration, // Assume full play since Apple Music doesn't provide partial plays
 		ServiceBaseUrl: "music.apple.com",
 		ISRC:           isrc,
-		HasStamped:     isStamped,
 		Timestamp:      time.Now().UTC(),
 	}
 
@@ -380,6 +465,7 @@ func (s *Service) toTrack(t AppleRecentTrack) *models.Track {
 	if track.URL == "" {
 		track.URL = generateUploadHash(&t)
 	}
+	applyCatalog(track, catalog)
 
 	if s.mbService != nil {
 		hydrated, err := musicbrainz.HydrateTrack(s.mbService, *track)
@@ -409,80 +495,267 @@ func (s *Service) GetCurrentAppleMusicTrack(ctx context.Context, user *models.Us
 	return &items[0], nil
 }
 
-// ProcessUser checks for new Apple Music tracks and processes them
+// recentWindow how many recently played tracks are fetched on each poll, the most Apple returns
+const recentWindow = 30
+
+// recentKey identifies an entry of the recently played window. Repeats of a
+// track share a key, it is their position in the window that tells them apart
+func recentKey(t *AppleRecentTrack) string {
+	if t.ID != "" {
+		return t.ID
+	}
+	if t.Attributes.URL != "" {
+		return t.Attributes.URL
+	}
+	return generateUploadHash(t)
+}
+
+// newPlayCount returns how many entries at the start of current, newest first,
+// were played since previous was seen. New plays push older ones down and off
+// the end of the window, so the rest of current should line up with the start
+// of previous
+func newPlayCount(previous, current []string) int {
+	for n := 0; n < len(current); n++ {
+		rest := current[n:]
+		overlap := min(len(rest), len(previous))
+		if overlap == 0 {
+			break
+		}
+		if slices.Equal(rest[:overlap], previous[:overlap]) {
+			return n
+		}
+	}
+	// Apple drops the older entry of a track that's replayed, so the window
+	// doesn't always line up; count everything above the previous newest entry
+	if len(previous) > 0 {
+		if i := slices.Index(current, previous[0]); i >= 0 {
+			return i
+		}
+	}
+	return len(current)
+}
+
+// playEstimate when a play from the recently played window started and how much of it was heard
+type playEstimate struct {
+	playedAt   time.Time
+	listenedMs int64
+}
+
+// estimatePlays guesses timestamps for plays that have been pushed down the
+// window, newest first, as Apple doesn't say when tracks were played. They're
+// laid back to back up to now and, when they wouldn't fit in the time since
+// since, squeezed in proportionally as if each was skipped partway. A zero
+// since skips squeezing
+func estimatePlays(durationsMs []int64, since, now time.Time) []playEstimate {
+	if len(durationsMs) == 0 {
+		return nil
+	}
+	estimates := make([]playEstimate, len(durationsMs))
+
+	var totalMs int64
+	for _, d := range durationsMs {
+		totalMs += d
+	}
+	scale := 1.0
+	if !since.IsZero() && totalMs > 0 {
+		if windowMs := max(now.Sub(since).Milliseconds(), 0); windowMs < totalMs {
+			scale = float64(windowMs) / float64(totalMs)
+		}
+	}
+
+	end := now
+	for i := range durationsMs {
+		listened := int64(float64(durationsMs[i]) * scale)
+		end = end.Add(-time.Duration(listened) * time.Millisecond)
+		estimates[i] = playEstimate{playedAt: end, listenedMs: listened}
+	}
+	return estimates
+}
+
+// ProcessUser records the Apple Music tracks played since the user's last poll
 func (s *Service) ProcessUser(ctx context.Context, user *models.User) error {
 	if user.AppleMusicUserToken == nil || *user.AppleMusicUserToken == "" {
 		return nil
 	}
 
-	// Fetch only the most recent track
-	currentAppleTrack, err := s.GetCurrentAppleMusicTrack(ctx, user)
+	start := time.Now()
+	items, err := s.FetchRecentPlayedTracks(ctx, *user.AppleMusicUserToken, recentWindow)
+	metrics.ObservePoll(events.SourceAppleMusic, start, err)
+	s.health.Observe(err)
 	if err != nil {
-		s.logger.Printf("failed to get current Apple Music track for user %d: %v", user.ID, err)
+		s.logger.ErrorContext(ctx, "failed to get recently played Apple Music tracks", logging.UserID(user.ID), logging.Err(err))
+		s.connections.Failure(ctx, user.ID, err)
 		return err
 	}
+	s.connections.Success(ctx, user.ID)
 
-	if currentAppleTrack == nil {
-		s.logger.Printf("no current Apple Music track for user %d", user.ID)
+	if len(items) == 0 {
+		s.logger.DebugContext(ctx, "no recently played Apple Music tracks", logging.UserID(user.ID))
 		// Clear playing now status if no track is playing
 		if s.playingNowService != nil {
 			if err := s.playingNowService.ClearPlayingNow(ctx, user.ID); err != nil {
-				s.logger.Printf("Error clearing playing now for user %d: %v", user.ID, err)
+				s.logger.ErrorContext(ctx, "error clearing playing now", logging.UserID(user.ID), logging.Err(err))
 			}
 		}
 		return nil
 	}
 
-	// Get the last saved track to compare PlayParams.id
-	lastTracks, err := s.DB.GetRecentTracks(user.ID, 1)
+	keys := make([]string, len(items))
+	for i := range items {
+		keys[i] = recentKey(&items[i])
+	}
+
+	var fresh int
+	recent, err := s.DB.GetAppleMusicRecentSnapshot(user.ID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get recently played snapshot", logging.UserID(user.ID), logging.Err(err))
+	}
+	var since time.Time
+	var wasHeld *time.Time
+	if recent != nil {
+		fresh = newPlayCount(recent.Keys, keys)
+		since = recent.PolledAt
+		if recent.HeldSince != nil {
+			wasHeld = recent.HeldSince
+			since = *wasHeld
+		}
+	} else if s.isNewHead(ctx, user.ID, &items[0]) {
+		// nothing to diff against yet, only the newest track can be told apart
+		// from what's already stored
+		fresh = 1
+	}
+
+	// The newest entry may still be playing, so it's held back until a later
+	// poll pushes it down the window, and the time since it was first seen
+	// tells how long it played. If it stays on top for longer than it lasts,
+	// it's taken as a full play from when it was first seen
+	now := start.UTC()
+	var heldSince *time.Time
+	// played are the entries of items from playedFrom on that get recorded
+	var played []AppleRecentTrack
+	var playedFrom int
+	var estimates []playEstimate
+	switch {
+	case fresh > 0:
+		playedFrom = 1
+		played = items[1:fresh]
+		if wasHeld != nil && fresh < len(items) {
+			played = items[1 : fresh+1]
+		}
+		durations := make([]int64, len(played))
+		for i := range played {
+			if d := played[i].Attributes.DurationInMillis; d != nil {
+				durations[i] = *d
+			}
+		}
+		estimates = estimatePlays(durations, since, now)
+
+		// it wasn't there on the last poll
+		firstSeen := now
+		if recent != nil {
+			firstSeen = recent.PolledAt
+		}
+		heldSince = &firstSeen
+		s.publishPlayingNow(ctx, user, items[0])
+	case wasHeld != nil:
+		heldSince = wasHeld
+		if d := items[0].Attributes.DurationInMillis; d != nil && *d > 0 && now.Sub(*wasHeld) >= time.Duration(*d)*time.Millisecond {
+			played = items[:1]
+			estimates = []playEstimate{{playedAt: wasHeld.UTC(), listenedMs: *d}}
+			heldSince = nil
+		}
+	}
+
+	// oldest first, so history and the PDS see plays in the order they happened
+	snapshot := &db.AppleMusicRecent{Keys: keys, PolledAt: start, HeldSince: heldSince}
+	var saveErr error
+	for i := len(played) - 1; i >= 0; i-- {
+		if err := s.recordPlay(ctx, user, played[i], estimates[i]); err != nil {
+			// only advance the snapshot up to the plays that were recorded, the
+			// next poll picks up from the one that failed with the same timing
+			saveErr = err
+			snapshot = &db.AppleMusicRecent{Keys: keys[playedFrom+i+1:], PolledAt: since}
+			break
+		}
+	}
+
+	if err := s.DB.SaveAppleMusicRecentSnapshot(user.ID, snapshot); err != nil {
+		s.logger.ErrorContext(ctx, "failed to save recently played snapshot", logging.UserID(user.ID), logging.Err(err))
+		if saveErr == nil {
+			saveErr = err
+		}
+	}
+	return saveErr
+}
+
+// isNewHead reports whether the newest recently played track differs from the
+// last stored one (by URL / upload hash)
+func (s *Service) isNewHead(ctx context.Context, userID int64, head *AppleRecentTrack) bool {
+	lastTracks, err := s.DB.GetRecentTracks(userID, 1)
 	if err != nil {
-		s.logger.Printf("failed to get last tracks for user %d: %v", user.ID, err)
+		s.logger.ErrorContext(ctx, "failed to get last tracks", logging.UserID(userID), logging.Err(err))
 	}
 
 	// Pre-compute the hash for uploaded tracks so comparisons against stored
 	// latest tracks will work
-	currentURL := currentAppleTrack.Attributes.URL
+	currentURL := head.Attributes.URL
 	if currentURL == "" {
-		currentURL = generateUploadHash(currentAppleTrack)
+		currentURL = generateUploadHash(head)
 	}
+	if len(lastTracks) > 0 && lastTracks[0].URL == currentURL {
+		s.logger.DebugContext(ctx, "track unchanged", logging.UserID(userID), "track", head.Attributes.ArtistName+" - "+head.Attributes.Name)
+		return false
+	}
+	return true
+}
 
-	// Check if this is a new track (by URL / upload hash)
-	if len(lastTracks) > 0 {
-		lastTrack := lastTracks[0]
-		if lastTrack.URL == currentURL {
-			s.logger.Printf("track unchanged for user %d: %s by %s", user.ID, currentAppleTrack.Attributes.Name, currentAppleTrack.Attributes.ArtistName)
-			return nil
-		}
+// publishPlayingNow publishes the held back newest entry as playing now
+func (s *Service) publishPlayingNow(ctx context.Context, user *models.User, item AppleRecentTrack) {
+	if s.playingNowService == nil {
+		return
 	}
+	track := s.toTrack(item, s.lookupCatalog(ctx, user, &item))
+	if track == nil || strings.TrimSpace(track.Name) == "" || len(track.Artist) == 0 {
+		return
+	}
+	track.Timestamp = time.Now().UTC()
+	if err := s.playingNowService.PublishPlayingNow(ctx, user.ID, track); err != nil {
+		s.logger.ErrorContext(ctx, "error publishing playing now", logging.UserID(user.ID), logging.Err(err))
+	}
+}
 
+// recordPlay saves, stamps and publishes one new play
+func (s *Service) recordPlay(ctx context.Context, user *models.User, item AppleRecentTrack, estimate playEstimate) error {
 	// Convert to internal track format
-	track := s.toTrack(*currentAppleTrack)
+	track := s.toTrack(item, s.lookupCatalog(ctx, user, &item))
 	if track == nil || strings.TrimSpace(track.Name) == "" || len(track.Artist) == 0 {
-		s.logger.Printf("invalid track data for user %d", user.ID)
+		s.logger.WarnContext(ctx, "invalid track data", logging.UserID(user.ID))
 		return nil
 	}
+	track.Timestamp = estimate.playedAt
+	track.ProgressMs = min(estimate.listenedMs, track.DurationMs)
 
-	// Hydration is handled in toTrack() using MusicBrainz search; no ISRC-only hydration here
+	// Hydration is handled in toTrack() using MusicBrainz search, after the catalog filled in any ISRC
+
+	track.HasStamped = stamping.Stamps(s.stamping.For(ctx, user.ID, events.SourceAppleMusic), track.DurationMs, track.ProgressMs)
+	if !track.HasStamped {
+		s.logger.DebugContext(ctx, "track not stamped under stamping policy", logging.UserID(user.ID), logging.Track(track))
+		return nil
+	}
 
 	// Save the new track
 	if _, err := s.DB.SaveTrack(user.ID, track); err != nil {
-		s.logger.Printf("failed saving apple track for user %d: %v", user.ID, err)
+		s.logger.ErrorContext(ctx, "failed saving track", logging.UserID(user.ID), logging.Err(err))
 		return err
 	}
 
-	s.logger.Printf("saved new track for user %d: %s by %s", user.ID, track.Name, track.Artist[0].Name)
-
-	// Publish playing now status
-	if s.playingNowService != nil {
-		if err := s.playingNowService.PublishPlayingNow(ctx, user.ID, track); err != nil {
-			s.logger.Printf("Error publishing playing now for user %d: %v", user.ID, err)
-		}
-	}
+	s.logger.InfoContext(ctx, "saved new track", logging.UserID(user.ID), logging.Track(track))
+	s.events.PublishStamped(user.ID, track, events.SourceAppleMusic)
 
 	// Submit to PDS
 	if user.ATProtoDID != nil && user.MostRecentAtProtoSessionID != nil && s.atprotoService != nil {
 		if err := atprotoservice.SubmitPlayToPDS(ctx, *user.ATProtoDID, *user.MostRecentAtProtoSessionID, track, s.atprotoService); err != nil {
-			s.logger.Printf("failed submit to PDS for user %d: %v", user.ID, err)
+			s.logger.ErrorContext(ctx, "failed to submit to PDS", logging.UserID(user.ID), logging.Err(err))
 		}
 	}
 
@@ -493,7 +766,7 @@ func (s *Service) ProcessUser(ctx context.Context, user *models.User) error {
 func (s *Service) StartListeningTracker(interval time.Duration) {
 	if s.DB == nil {
 		if s.logger != nil {
-			s.logger.Printf("DB not configured; Apple Music tracker disabled")
+			s.logger.Warn("DB not configured, Apple Music tracker disabled")
 		}
 		return
 	}
@@ -509,15 +782,21 @@ func (s *Service) StartListeningTracker(interval time.Duration) {
 func (s *Service) runOnce(ctx context.Context) {
 	users, err := s.DB.GetAllAppleMusicLinkedUsers()
 	if err != nil {
-		s.logger.Printf("error loading Apple Music users: %v", err)
+		s.logger.ErrorContext(ctx, "error loading Apple Music users", logging.Err(err))
 		return
 	}
+	metrics.SetActiveUsers(events.SourceAppleMusic, len(users))
+	// users backing off after errors or waiting to authorize Apple Music again
+	paused := s.connections.Paused(ctx)
 	for _, u := range users {
 		if ctx.Err() != nil {
 			return
 		}
+		if paused[u.ID] {
+			continue
+		}
 		if err := s.ProcessUser(ctx, u); err != nil {
-			s.logger.Printf("error processing user %d: %v", u.ID, err)
+			s.logger.ErrorContext(ctx, "error processing user", logging.UserID(u.ID), logging.Err(err))
 		}
 	}
 }
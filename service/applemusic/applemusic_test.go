@@ -4,8 +4,9 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"testing"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/teal-fm/piper/db"
 	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/service/connection"
+	"github.com/teal-fm/piper/service/events"
 )
 
 // createTestJWT creates a minimal JWT for testing that will pass structural validation
@@ -45,12 +48,27 @@ func makeTestTrack(name, album, artist string) *AppleRecentTrack {
 // trackResponseTransport returns a fixed JSON response simulating the Apple Music API.
 type trackResponseTransport struct {
 	response string
+	// status defaults to 200
+	status int
+	// routes responses for other paths than recently played, the rest are 404
+	routes map[string]string
 }
 
 func (t *trackResponseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := t.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := t.response
+	if req.URL.Path != "/v1/me/recent/played/tracks" {
+		var ok bool
+		if body, ok = t.routes[req.URL.Path]; !ok {
+			status = http.StatusNotFound
+		}
+	}
 	return &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(strings.NewReader(t.response)),
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
 		Header:     make(http.Header),
 	}, nil
 }
@@ -93,7 +111,7 @@ func newTestService(t *testing.T, testDB *db.DB, transport http.RoundTripper) *S
 	return &Service{
 		DB:           testDB,
 		httpClient:   &http.Client{Transport: transport},
-		logger:       log.New(io.Discard, "", 0),
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
 		teamID:       "test-team",
 		keyID:        "test-key",
 		cachedToken:  createTestJWT("test-team", tokenExpiry),
@@ -156,6 +174,22 @@ func (env *processUserTestEnv) trackCount(t *testing.T) int {
 	return len(tracks)
 }
 
+// backdateHeld moves the time the held back newest entry was first seen, and
+// the last poll, back by d
+func (env *processUserTestEnv) backdateHeld(t *testing.T, d time.Duration) {
+	t.Helper()
+	recent, err := env.testDB.GetAppleMusicRecentSnapshot(env.user.ID)
+	if err != nil || recent == nil || recent.HeldSince == nil {
+		t.Fatalf("expected a held back entry, got %+v (%v)", recent, err)
+	}
+	heldSince := recent.HeldSince.Add(-d)
+	recent.HeldSince = &heldSince
+	recent.PolledAt = recent.PolledAt.Add(-d)
+	if err := env.testDB.SaveAppleMusicRecentSnapshot(env.user.ID, recent); err != nil {
+		t.Fatalf("failed to backdate snapshot: %v", err)
+	}
+}
+
 func TestProcessUserSkipsDuplicateUploadedTrack(t *testing.T) {
 	env := newProcessUserTestEnv(t, uploadedTrackJSON("My Upload", "Local Artist", "Local Album"))
 	env.seedUploadedTrack(t, "My Upload", "Local Artist", "Local Album")
@@ -172,8 +206,26 @@ func TestProcessUserSkipsDuplicateUploadedTrack(t *testing.T) {
 func TestProcessUserSavesDifferentUploadedTrack(t *testing.T) {
 	env := newProcessUserTestEnv(t, uploadedTrackJSON("New Upload", "New Artist", "New Album"))
 	env.seedUploadedTrack(t, "Old Upload", "Old Artist", "Old Album")
+	ctx := context.Background()
 
-	if err := env.svc.ProcessUser(context.Background(), env.user); err != nil {
+	// the new upload may still be playing, it's held back
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	if got := env.trackCount(t); got != 1 {
+		t.Fatalf("expected the new upload to be held back, got %d tracks", got)
+	}
+
+	// another track pushes it down the window
+	env.backdateHeld(t, 10*time.Minute)
+	var recent, upload struct {
+		Data []any `json:"data"`
+	}
+	_ = json.Unmarshal([]byte(recentTracksJSON("2")), &recent)
+	_ = json.Unmarshal([]byte(uploadedTrackJSON("New Upload", "New Artist", "New Album")), &upload)
+	data, _ := json.Marshal(map[string]any{"data": append(recent.Data, upload.Data...)})
+	env.svc.httpClient.Transport.(*trackResponseTransport).response = string(data)
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
 		t.Fatalf("ProcessUser returned error: %v", err)
 	}
 
@@ -226,3 +278,290 @@ func TestGenerateUploadHash(t *testing.T) {
 		})
 	}
 }
+
+// recentTracksJSON builds an Apple Music API response listing catalog tracks by
+// id, newest first. Every track is a minute long
+func recentTracksJSON(ids ...string) string {
+	var tracks []any
+	for _, id := range ids {
+		tracks = append(tracks, map[string]any{
+			"id": id,
+			"attributes": map[string]any{
+				"name":             "Song " + id,
+				"artistName":       "Artist",
+				"albumName":        "Album",
+				"url":              "https://music.apple.com/song/" + id,
+				"durationInMillis": 60000,
+			},
+		})
+	}
+	data, _ := json.Marshal(map[string]any{"data": tracks})
+	return string(data)
+}
+
+func TestNewPlayCount(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current []string
+		want              int
+	}{
+		{"unchanged", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 0},
+		{"two new", []string{"a", "b", "c"}, []string{"x", "y", "a"}, 2},
+		{"repeat of the newest", []string{"a", "b", "c"}, []string{"a", "a", "b"}, 1},
+		{"window not full yet", []string{"a"}, []string{"b", "a"}, 1},
+		{"replayed track moved up", []string{"a", "b", "c"}, []string{"b", "a", "c"}, 1},
+		{"window scrolled past", []string{"a", "b"}, []string{"x", "y", "z"}, 3},
+		{"empty previous", nil, []string{"a", "b"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newPlayCount(tt.previous, tt.current); got != tt.want {
+				t.Errorf("newPlayCount(%v, %v) = %d, want %d", tt.previous, tt.current, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatePlays(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// plenty of time since the last poll: back to back full plays
+	got := estimatePlays([]int64{60000, 120000}, now.Add(-time.Hour), now)
+	if !got[0].playedAt.Equal(now.Add(-time.Minute)) || got[0].listenedMs != 60000 {
+		t.Errorf("newest = %+v, want full play a minute ago", got[0])
+	}
+	if !got[1].playedAt.Equal(now.Add(-3*time.Minute)) || got[1].listenedMs != 120000 {
+		t.Errorf("second = %+v, want full play three minutes ago", got[1])
+	}
+
+	// only a minute since the last poll: the plays share it
+	got = estimatePlays([]int64{60000, 60000}, now.Add(-time.Minute), now)
+	if got[0].listenedMs != 30000 || got[1].listenedMs != 30000 {
+		t.Errorf("squeezed plays = %+v, want 30s each", got)
+	}
+	if !got[1].playedAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("oldest play at %v, want the last poll", got[1].playedAt)
+	}
+}
+
+func TestProcessUserHoldsBackNewestPlay(t *testing.T) {
+	env := newProcessUserTestEnv(t, recentTracksJSON("1", "2"))
+	transport := env.svc.httpClient.Transport.(*trackResponseTransport)
+	ctx := context.Background()
+
+	// the first poll has nothing to diff against, the newest track may still be playing
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	if got := env.trackCount(t); got != 0 {
+		t.Fatalf("expected the newest track to be held back, got %d tracks", got)
+	}
+
+	// still on top
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	if got := env.trackCount(t); got != 0 {
+		t.Fatalf("expected the newest track to still be held back, got %d tracks", got)
+	}
+
+	// 20 seconds after it was first seen, track 2 pushed it down: a skip
+	env.backdateHeld(t, 20*time.Second)
+	transport.response = recentTracksJSON("2", "1", "2")
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	if got := env.trackCount(t); got != 0 {
+		t.Fatalf("expected a 20 second play of a minute long track not to be stamped, got %d tracks", got)
+	}
+
+	// track 2 stays on top for longer than it lasts: a full play from when it was first seen
+	env.backdateHeld(t, 2*time.Minute)
+	recent, err := env.testDB.GetAppleMusicRecentSnapshot(env.user.ID)
+	if err != nil || recent == nil {
+		t.Fatalf("failed to get snapshot: %v", err)
+	}
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	tracks, err := env.testDB.GetRecentTracks(env.user.ID, 10)
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("expected 1 track, got %d (%v)", len(tracks), err)
+	}
+	if tracks[0].Name != "Song 2" || !tracks[0].Timestamp.Equal(*recent.HeldSince) {
+		t.Errorf("track = %q at %v, want Song 2 at %v", tracks[0].Name, tracks[0].Timestamp, *recent.HeldSince)
+	}
+	if recent, _ := env.testDB.GetAppleMusicRecentSnapshot(env.user.ID); recent == nil || recent.HeldSince != nil {
+		t.Errorf("expected nothing to be held back, got %+v", recent)
+	}
+}
+
+func TestProcessUserRecordsRepeatsAndPlaysBetweenPolls(t *testing.T) {
+	env := newProcessUserTestEnv(t, recentTracksJSON("1", "2"))
+	transport := env.svc.httpClient.Transport.(*trackResponseTransport)
+	ctx := context.Background()
+
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+
+	// track 3 then track 1 again, in the ten minutes since track 1 was first seen
+	env.backdateHeld(t, 10*time.Minute)
+	transport.response = recentTracksJSON("1", "3", "1", "2")
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	tracks, err := env.testDB.GetRecentTracks(env.user.ID, 10)
+	if err != nil {
+		t.Fatalf("failed to get recent tracks: %v", err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("expected 2 tracks with the repeat held back, got %d", len(tracks))
+	}
+	if tracks[0].Name != "Song 3" || tracks[1].Name != "Song 1" {
+		t.Fatalf("tracks = %q, %q, want Song 3 after Song 1", tracks[0].Name, tracks[1].Name)
+	}
+	if !tracks[1].Timestamp.Before(tracks[0].Timestamp) {
+		t.Errorf("expected Song 1 (%v) to be played before Song 3 (%v)", tracks[1].Timestamp, tracks[0].Timestamp)
+	}
+
+	// the repeat is recorded once track 4 pushes it down
+	env.backdateHeld(t, 10*time.Minute)
+	transport.response = recentTracksJSON("4", "1", "3", "1", "2")
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	tracks, err = env.testDB.GetRecentTracks(env.user.ID, 10)
+	if err != nil || len(tracks) != 3 {
+		t.Fatalf("expected 3 tracks, got %d (%v)", len(tracks), err)
+	}
+	if tracks[0].Name != "Song 1" {
+		t.Errorf("newest track = %q, want the repeat of Song 1", tracks[0].Name)
+	}
+}
+
+func TestProcessUserRetriesPlaysThatFailedToSave(t *testing.T) {
+	env := newProcessUserTestEnv(t, recentTracksJSON("1", "2"))
+	transport := env.svc.httpClient.Transport.(*trackResponseTransport)
+	ctx := context.Background()
+
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+
+	// Song 3 can't be saved, Song 1 before it can
+	if _, err := env.testDB.Exec(`CREATE TRIGGER fail_song_3 BEFORE INSERT ON tracks WHEN NEW.name = 'Song 3'
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+	env.backdateHeld(t, 10*time.Minute)
+	transport.response = recentTracksJSON("4", "3", "1", "2")
+	if err := env.svc.ProcessUser(ctx, env.user); err == nil {
+		t.Fatal("expected the failed save to be returned")
+	}
+	if got := env.trackCount(t); got != 1 {
+		t.Fatalf("expected only Song 1 to be saved, got %d tracks", got)
+	}
+
+	// the next poll records Song 3, and Song 1 only once
+	if _, err := env.testDB.Exec(`DROP TRIGGER fail_song_3`); err != nil {
+		t.Fatalf("failed to drop trigger: %v", err)
+	}
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	tracks, err := env.testDB.GetRecentTracks(env.user.ID, 10)
+	if err != nil || len(tracks) != 2 {
+		t.Fatalf("expected 2 tracks, got %d (%v)", len(tracks), err)
+	}
+	if tracks[0].Name != "Song 3" || tracks[1].Name != "Song 1" {
+		t.Errorf("tracks = %q, %q, want Song 3 after Song 1", tracks[0].Name, tracks[1].Name)
+	}
+}
+
+func TestProcessUserPausesRejectedUserToken(t *testing.T) {
+	env := newProcessUserTestEnv(t, `{"errors":[]}`)
+	env.svc.connections = connection.NewMonitor(env.testDB, events.SourceAppleMusic)
+	env.svc.httpClient.Transport.(*trackResponseTransport).status = http.StatusForbidden
+	ctx := context.Background()
+
+	err := env.svc.ProcessUser(ctx, env.user)
+	if !errors.Is(err, ErrUserTokenRejected) || !errors.Is(err, connection.ErrReauthRequired) {
+		t.Fatalf("ProcessUser error = %v, want ErrUserTokenRejected", err)
+	}
+
+	status, err := env.testDB.GetConnectionStatus(env.user.ID, events.SourceAppleMusic)
+	if err != nil {
+		t.Fatalf("failed to get connection status: %v", err)
+	}
+	if status == nil || !status.NeedsReauth {
+		t.Fatalf("connection status = %+v, want needs reauth", status)
+	}
+	if paused := env.svc.connections.Paused(ctx); !paused[env.user.ID] {
+		t.Error("expected polling to be paused until Apple Music is authorized again")
+	}
+}
+
+func TestProcessUserFillsCatalogMetadata(t *testing.T) {
+	recent := `{"data":[{"id":"1","attributes":{"name":"Duet","artistName":"A & B","albumName":"Album",
+		"url":"https://music.apple.com/song/1","durationInMillis":60000,"playParams":{"id":"1","kind":"song"}}}]}`
+	env := newProcessUserTestEnv(t, recent)
+	transport := env.svc.httpClient.Transport.(*trackResponseTransport)
+	transport.routes = map[string]string{
+		"/v1/me/storefront": `{"data":[{"id":"gb"}]}`,
+		"/v1/catalog/gb/songs/1": `{"data":[{"id":"1","attributes":{"name":"Duet","artistName":"A & B",
+			"albumName":"Album","isrc":"GBAAA0000001","genreNames":["Pop","Music"],"releaseDate":"2020-01-01",
+			"artwork":{"url":"https://is1.mzstatic.com/image/{w}x{h}bb.jpg"}},
+			"relationships":{"artists":{"data":[{"id":"10","attributes":{"name":"A"}},{"id":"11","attributes":{"name":"B"}}]},
+			"albums":{"data":[{"id":"20","attributes":{"name":"Album"}}]}}}]}`,
+	}
+	ctx := context.Background()
+
+	// held back on the first poll, then a full play once it has been on top for longer than it lasts
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	env.backdateHeld(t, 2*time.Minute)
+	if err := env.svc.ProcessUser(ctx, env.user); err != nil {
+		t.Fatalf("ProcessUser returned error: %v", err)
+	}
+	tracks, err := env.testDB.GetRecentTracks(env.user.ID, 1)
+	if err != nil || len(tracks) != 1 {
+		t.Fatalf("expected 1 track, got %d (%v)", len(tracks), err)
+	}
+	track := tracks[0]
+	if len(track.Artist) != 2 || track.Artist[0].Name != "A" || track.Artist[1].Name != "B" {
+		t.Errorf("artists = %+v, want A and B credited separately", track.Artist)
+	}
+	if track.ISRC != "GBAAA0000001" {
+		t.Errorf("ISRC = %q, want the catalog's", track.ISRC)
+	}
+
+	// later lookups are served from the DB
+	delete(transport.routes, "/v1/catalog/gb/songs/1")
+	song := env.svc.lookupCatalog(ctx, env.user, &AppleRecentTrack{ID: "1"})
+	if song == nil {
+		t.Fatal("expected the cached catalog song")
+	}
+	if song.ISRC != "GBAAA0000001" || len(song.Genres) != 1 || song.Genres[0] != "Pop" {
+		t.Errorf("cached song = %+v", song)
+	}
+}
+
+func TestCatalogID(t *testing.T) {
+	library := &AppleRecentTrack{ID: "i.abc123"}
+	if got := catalogID(library); got != "" {
+		t.Errorf("catalogID(library song) = %q, want none", got)
+	}
+	library.Attributes.PlayParams = &struct {
+		ID        string `json:"id"`
+		Kind      string `json:"kind"`
+		CatalogID string `json:"catalogId"`
+	}{ID: "i.abc123", Kind: "song", CatalogID: "42"}
+	if got := catalogID(library); got != "42" {
+		t.Errorf("catalogID(library song in the catalog) = %q, want 42", got)
+	}
+	if got := catalogID(&AppleRecentTrack{ID: "1234"}); got != "1234" {
+		t.Errorf("catalogID(catalog song) = %q, want 1234", got)
+	}
+}
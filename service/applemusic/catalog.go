@@ -0,0 +1,266 @@
+package applemusic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+const (
+	// DefaultStorefront catalog looked up when a user's own storefront is unknown
+	DefaultStorefront = "us"
+	// catalogCacheTTL how long a catalog lookup is reused before asking Apple again
+	catalogCacheTTL = 30 * 24 * time.Hour
+)
+
+// CatalogSong the catalog metadata of an Apple Music song, as cached
+type CatalogSong struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Artists     []CatalogArtist `json:"artists,omitempty"`
+	Album       string          `json:"album,omitempty"`
+	Genres      []string        `json:"genres,omitempty"`
+	ISRC        string          `json:"isrc,omitempty"`
+	DurationMs  int64           `json:"durationMs,omitempty"`
+	TrackNumber *int            `json:"trackNumber,omitempty"`
+	DiscNumber  *int            `json:"discNumber,omitempty"`
+}
+
+type CatalogArtist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// catalogSongsResponse the parts of /v1/catalog/{storefront}/songs/{id} piper uses
+type catalogSongsResponse struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Name             string   `json:"name"`
+			ArtistName       string   `json:"artistName"`
+			AlbumName        string   `json:"albumName"`
+			GenreNames       []string `json:"genreNames"`
+			Isrc             string   `json:"isrc"`
+			DurationInMillis int64    `json:"durationInMillis"`
+			TrackNumber      *int     `json:"trackNumber"`
+			DiscNumber       *int     `json:"discNumber"`
+		} `json:"attributes"`
+		Relationships struct {
+			Artists struct {
+				Data []struct {
+					ID         string `json:"id"`
+					Attributes struct {
+						Name string `json:"name"`
+					} `json:"attributes"`
+				} `json:"data"`
+			} `json:"artists"`
+			Albums struct {
+				Data []struct {
+					ID         string `json:"id"`
+					Attributes struct {
+						Name string `json:"name"`
+					} `json:"attributes"`
+				} `json:"data"`
+			} `json:"albums"`
+		} `json:"relationships"`
+	} `json:"data"`
+}
+
+// catalogID returns the catalog song behind a recently played track, "" for
+// uploads and anything else that isn't in the catalog
+func catalogID(t *AppleRecentTrack) string {
+	if p := t.Attributes.PlayParams; p != nil {
+		if p.CatalogID != "" {
+			return p.CatalogID
+		}
+		if p.Kind != "song" {
+			return ""
+		}
+	}
+	// library songs have ids like i.abc123, catalog ones are numeric
+	if _, err := strconv.ParseUint(t.ID, 10, 64); err != nil {
+		return ""
+	}
+	return t.ID
+}
+
+// storefront returns the user's Apple Music storefront, asking Apple once per
+// run and falling back to the instance default
+func (s *Service) storefront(ctx context.Context, user *models.User) string {
+	s.storefrontMu.Lock()
+	sf, ok := s.storefronts[user.ID]
+	s.storefrontMu.Unlock()
+	if ok {
+		return sf
+	}
+
+	sf = s.defaultStorefront
+	if sf == "" {
+		sf = DefaultStorefront
+	}
+	body, err := s.apiGet(ctx, "https://api.music.apple.com/v1/me/storefront", *user.AppleMusicUserToken)
+	if err == nil {
+		var parsed struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err = json.Unmarshal(body, &parsed); err == nil && len(parsed.Data) > 0 && parsed.Data[0].ID != "" {
+			sf = parsed.Data[0].ID
+		}
+	}
+	if err != nil {
+		// retried on the next run
+		s.logger.WarnContext(ctx, "failed to get Apple Music storefront, using the default", logging.UserID(user.ID), "storefront", sf, logging.Err(err))
+		return sf
+	}
+
+	s.storefrontMu.Lock()
+	if s.storefronts == nil {
+		s.storefronts = make(map[int64]string)
+	}
+	s.storefronts[user.ID] = sf
+	s.storefrontMu.Unlock()
+	return sf
+}
+
+// lookupCatalog returns the catalog metadata of a recently played track, from
+// the DB when it was looked up recently. nil when the track isn't in the
+// catalog or Apple couldn't be asked
+func (s *Service) lookupCatalog(ctx context.Context, user *models.User, t *AppleRecentTrack) *CatalogSong {
+	id := catalogID(t)
+	if id == "" || s.DB == nil {
+		return nil
+	}
+	sf := s.storefront(ctx, user)
+
+	data, fetchedAt, ok, err := s.DB.GetAppleMusicCatalogSong(sf, id)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to get cached catalog song", "song_id", id, logging.Err(err))
+	}
+	if !ok || time.Since(fetchedAt) > catalogCacheTTL {
+		song, err := s.FetchCatalogSong(ctx, sf, id)
+		if err != nil {
+			s.logger.WarnContext(ctx, "failed to look up Apple Music catalog song", "storefront", sf, "song_id", id, logging.Err(err))
+			// a stale lookup beats none
+			if !ok {
+				return nil
+			}
+		} else {
+			data = ""
+			if song != nil {
+				raw, err := json.Marshal(song)
+				if err != nil {
+					return song
+				}
+				data = string(raw)
+			}
+			if err := s.DB.SaveAppleMusicCatalogSong(sf, id, data); err != nil {
+				s.logger.ErrorContext(ctx, "failed to cache catalog song", "song_id", id, logging.Err(err))
+			}
+			return song
+		}
+	}
+
+	if data == "" {
+		return nil
+	}
+	var song CatalogSong
+	if err := json.Unmarshal([]byte(data), &song); err != nil {
+		s.logger.ErrorContext(ctx, "failed to parse cached catalog song", "song_id", id, logging.Err(err))
+		return nil
+	}
+	return &song
+}
+
+// FetchCatalogSong looks a song up in a storefront's catalog with its artists
+// and album. A nil song means Apple doesn't know it
+func (s *Service) FetchCatalogSong(ctx context.Context, storefront, id string) (*CatalogSong, error) {
+	endpoint := &url.URL{
+		Scheme:   "https",
+		Host:     "api.music.apple.com",
+		Path:     "/v1/catalog/" + url.PathEscape(storefront) + "/songs/" + url.PathEscape(id),
+		RawQuery: url.Values{"include": {"artists,albums"}}.Encode(),
+	}
+	body, err := s.apiGet(ctx, endpoint.String(), "")
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed catalogSongsResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if len(parsed.Data) == 0 {
+		return nil, nil
+	}
+
+	d := parsed.Data[0]
+	song := &CatalogSong{
+		ID:          d.ID,
+		Name:        d.Attributes.Name,
+		Album:       d.Attributes.AlbumName,
+		ISRC:        d.Attributes.Isrc,
+		DurationMs:  d.Attributes.DurationInMillis,
+		TrackNumber: d.Attributes.TrackNumber,
+		DiscNumber:  d.Attributes.DiscNumber,
+	}
+	for _, a := range d.Relationships.Artists.Data {
+		if a.Attributes.Name != "" {
+			song.Artists = append(song.Artists, CatalogArtist{ID: a.ID, Name: a.Attributes.Name})
+		}
+	}
+	if len(song.Artists) == 0 && d.Attributes.ArtistName != "" {
+		song.Artists = []CatalogArtist{{Name: d.Attributes.ArtistName}}
+	}
+	if albums := d.Relationships.Albums.Data; len(albums) > 0 && song.Album == "" {
+		song.Album = albums[0].Attributes.Name
+	}
+	for _, genre := range d.Attributes.GenreNames {
+		// every song is filed under "Music" as well
+		if genre != "Music" {
+			song.Genres = append(song.Genres, genre)
+		}
+	}
+	return song, nil
+}
+
+// applyCatalog fills what recently played leaves out: every credited artist
+// rather than a joined name, and the ISRC, album, genres and track position when missing.
+// Durations are left alone, plays were already judged against recently played's
+func applyCatalog(track *models.Track, song *CatalogSong) {
+	if song == nil {
+		return
+	}
+	if len(song.Artists) > 0 {
+		artists := make([]models.Artist, len(song.Artists))
+		for i, a := range song.Artists {
+			artists[i] = models.Artist{Name: a.Name, ID: a.ID}
+		}
+		track.Artist = artists
+	}
+	if track.ISRC == "" {
+		track.ISRC = song.ISRC
+	}
+	if track.Album == "" {
+		track.Album = song.Album
+	}
+	if track.TrackNumber == nil {
+		track.TrackNumber = song.TrackNumber
+	}
+	if track.DiscNumber == nil {
+		track.DiscNumber = song.DiscNumber
+	}
+	if len(track.Tags) == 0 {
+		track.Tags = song.Genres
+	}
+}
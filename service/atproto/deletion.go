@@ -0,0 +1,79 @@
+package atproto
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/client"
+)
+
+const (
+	playCollection    = "fm.teal.alpha.feed.play"
+	episodeCollection = "fm.teal.alpha.feed.episode"
+	statusCollection  = "fm.teal.alpha.actor.status"
+	// listRecordsLimit is the most records a PDS returns per listRecords page
+	listRecordsLimit = 100
+)
+
+// DeleteTealRecords removes every feed.play and feed.episode record and the actor status piper wrote
+// to the user's repo. Returns how many play and episode records were deleted
+func DeleteTealRecords(ctx context.Context, apiClient *client.APIClient) (int, error) {
+	repo := apiClient.AccountDID.String()
+	deleted := 0
+	for _, collection := range []string{playCollection, episodeCollection} {
+		n, err := deleteCollection(ctx, apiClient, collection)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	if _, err := comatproto.RepoDeleteRecord(ctx, apiClient, &comatproto.RepoDeleteRecord_Input{
+		Collection: statusCollection,
+		Repo:       repo,
+		Rkey:       "self",
+	}); err != nil {
+		return deleted, fmt.Errorf("failed to delete actor status for %s: %w", repo, err)
+	}
+
+	return deleted, nil
+}
+
+// deleteCollection removes every record in one of the user's collections
+func deleteCollection(ctx context.Context, apiClient *client.APIClient, collection string) (int, error) {
+	repo := apiClient.AccountDID.String()
+	deleted := 0
+
+	// records are deleted as they are listed, so always read from the start of the collection
+	for {
+		page, err := comatproto.RepoListRecords(ctx, apiClient, collection, "", listRecordsLimit, repo, false)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list %s records for %s: %w", collection, repo, err)
+		}
+		if len(page.Records) == 0 {
+			break
+		}
+
+		writes := make([]*comatproto.RepoApplyWrites_Input_Writes_Elem, 0, len(page.Records))
+		for _, record := range page.Records {
+			rkey := record.Uri[strings.LastIndex(record.Uri, "/")+1:]
+			writes = append(writes, &comatproto.RepoApplyWrites_Input_Writes_Elem{
+				RepoApplyWrites_Delete: &comatproto.RepoApplyWrites_Delete{
+					Collection: collection,
+					Rkey:       rkey,
+				},
+			})
+		}
+
+		if _, err := comatproto.RepoApplyWrites(ctx, apiClient, &comatproto.RepoApplyWrites_Input{
+			Repo:   repo,
+			Writes: writes,
+		}); err != nil {
+			return deleted, fmt.Errorf("failed to delete %s records for %s: %w", collection, repo, err)
+		}
+		deleted += len(writes)
+	}
+	return deleted, nil
+}
@@ -3,13 +3,15 @@ package atproto
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/spf13/viper"
 	"github.com/teal-fm/piper/api/teal"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
 )
@@ -39,11 +41,13 @@ func SubmitPlayToPDS(ctx context.Context, did string, mostRecentAtProtoSessionID
 		Record:     &lexutil.LexiconTypeDecoder{Val: playRecord},
 	}
 
-	if _, err := comatproto.RepoCreateRecord(ctx, client, &input); err != nil {
+	_, err = comatproto.RepoCreateRecord(ctx, client, &input)
+	metrics.ObservePDSSubmission(err)
+	if err != nil {
 		return fmt.Errorf("failed to create play record for DID %s: %w", did, err)
 	}
 
-	log.Printf("Successfully submitted play to PDS for DID %s: %s - %s", did, track.Artist[0].Name, track.Name)
+	slog.InfoContext(ctx, "submitted play to PDS", logging.DID(did), logging.Track(track))
 	return nil
 }
 
@@ -101,6 +105,13 @@ func TrackToPlayRecord(track *models.Track) (*teal.AlphaFeedPlay, error) {
 	if submissionAgent == "" {
 		submissionAgent = models.SubmissionAgent
 	}
+	// plays relayed from another scrobbler keep the client that submitted them
+	if track.SubmissionClient != "" {
+		submissionAgent = track.SubmissionClient
+		if track.SubmissionClientVersion != "" {
+			submissionAgent += "/" + track.SubmissionClientVersion
+		}
+	}
 
 	playRecord := &teal.AlphaFeedPlay{
 		LexiconTypeID:          "fm.teal.alpha.feed.play",
@@ -110,6 +121,7 @@ func TrackToPlayRecord(track *models.Track) (*teal.AlphaFeedPlay, error) {
 		PlayedTime:             playedTimeStr,
 		RecordingMbId:          track.RecordingMBID,
 		ReleaseMbId:            track.ReleaseMBID,
+		TrackMbId:              track.ReleaseTrackMBID,
 		ReleaseName:            releaseNamePtr,
 		Isrc:                   isrcPtr,
 		OriginUrl:              originUrlPtr,
@@ -119,3 +131,75 @@ func TrackToPlayRecord(track *models.Track) (*teal.AlphaFeedPlay, error) {
 
 	return playRecord, nil
 }
+
+// SubmitEpisodeToPDS submits a podcast episode play to the ATProto PDS as a feed.episode record
+func SubmitEpisodeToPDS(ctx context.Context, did string, mostRecentAtProtoSessionID string, episode *models.Episode, atprotoService *atprotoauth.AuthService) error {
+	if did == "" {
+		return fmt.Errorf("DID cannot be empty")
+	}
+
+	client, err := atprotoService.GetATProtoClient(did, mostRecentAtProtoSessionID, ctx)
+	if err != nil || client == nil {
+		return fmt.Errorf("failed to get ATProto client: %w", err)
+	}
+
+	episodeRecord, err := EpisodeToRecord(episode)
+	if err != nil {
+		return fmt.Errorf("failed to convert episode to record: %w", err)
+	}
+
+	input := comatproto.RepoCreateRecord_Input{
+		Collection: episodeCollection,
+		Repo:       client.AccountDID.String(),
+		Record:     &lexutil.LexiconTypeDecoder{Val: episodeRecord},
+	}
+
+	_, err = comatproto.RepoCreateRecord(ctx, client, &input)
+	metrics.ObservePDSSubmission(err)
+	if err != nil {
+		return fmt.Errorf("failed to create episode record for DID %s: %w", did, err)
+	}
+
+	slog.InfoContext(ctx, "submitted episode to PDS", logging.DID(did), "show", episode.Show, "episode", episode.Name)
+	return nil
+}
+
+// EpisodeToRecord converts a models.Episode to teal.AlphaFeedEpisode
+func EpisodeToRecord(episode *models.Episode) (*teal.AlphaFeedEpisode, error) {
+	if episode.Name == "" {
+		return nil, fmt.Errorf("episode name cannot be empty")
+	}
+
+	record := &teal.AlphaFeedEpisode{
+		LexiconTypeID: episodeCollection,
+		EpisodeName:   episode.Name,
+	}
+	if episode.DurationMs > 0 {
+		durationSeconds := episode.DurationMs / 1000
+		record.Duration = &durationSeconds
+	}
+	if !episode.Timestamp.IsZero() {
+		timeStr := episode.Timestamp.Format(time.RFC3339)
+		record.PlayedTime = &timeStr
+	}
+	if episode.Show != "" {
+		record.ShowName = &episode.Show
+	}
+	if episode.Publisher != "" {
+		record.Publisher = &episode.Publisher
+	}
+	if episode.URL != "" {
+		record.OriginUrl = &episode.URL
+	}
+	if episode.ServiceBaseUrl != "" {
+		record.MusicServiceBaseDomain = &episode.ServiceBaseUrl
+	}
+
+	submissionAgent := viper.GetString("app.submission_agent")
+	if submissionAgent == "" {
+		submissionAgent = models.SubmissionAgent
+	}
+	record.SubmissionClientAgent = &submissionAgent
+
+	return record, nil
+}
@@ -0,0 +1,136 @@
+// Package connection keeps per-user status records for each provider's tracker,
+// so broken links are shown to the user and polled less often
+package connection
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+)
+
+// ErrReauthRequired marks errors that won't go away until the user links the
+// provider again, such as a revoked token or an unknown username
+var ErrReauthRequired = errors.New("provider needs to be linked again")
+
+const (
+	baseRetryDelay = time.Minute
+	maxRetryDelay  = time.Hour
+	// successWriteInterval how often a healthy connection's last success is saved
+	successWriteInterval = 5 * time.Minute
+)
+
+// Monitor records poll outcomes for one provider. A nil Monitor records nothing
+// and pauses nobody
+type Monitor struct {
+	db       *db.DB
+	provider string
+	logger   *slog.Logger
+
+	mu sync.Mutex
+	// lastSuccess when a success was last saved for a user that wasn't failing,
+	// to avoid a write on every poll
+	lastSuccess map[int64]time.Time
+}
+
+func NewMonitor(database *db.DB, provider string) *Monitor {
+	return &Monitor{
+		db:          database,
+		provider:    provider,
+		logger:      logging.For("connection").With("provider", provider),
+		lastSuccess: make(map[int64]time.Time),
+	}
+}
+
+// Success records a successful poll
+func (m *Monitor) Success(ctx context.Context, userID int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	last, ok := m.lastSuccess[userID]
+	if ok && time.Since(last) < successWriteInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastSuccess[userID] = time.Now()
+	m.mu.Unlock()
+
+	if err := m.db.RecordConnectionSuccess(userID, m.provider); err != nil {
+		m.logger.ErrorContext(ctx, "error recording connection success", logging.UserID(userID), logging.Err(err))
+	}
+}
+
+// Failure records a failed poll, backing off polling for the user. Errors
+// wrapping ErrReauthRequired pause polling until the user relinks
+func (m *Monitor) Failure(ctx context.Context, userID int64, pollErr error) {
+	if m == nil || pollErr == nil {
+		return
+	}
+	m.mu.Lock()
+	delete(m.lastSuccess, userID)
+	m.mu.Unlock()
+
+	errorCount := 1
+	status, err := m.db.GetConnectionStatus(userID, m.provider)
+	if err != nil {
+		m.logger.ErrorContext(ctx, "error loading connection status", logging.UserID(userID), logging.Err(err))
+	} else if status != nil {
+		errorCount = status.ErrorCount + 1
+	}
+
+	needsReauth := errors.Is(pollErr, ErrReauthRequired)
+	nextPollAt := time.Now().Add(retryDelay(errorCount))
+	if err := m.db.RecordConnectionFailure(userID, m.provider, pollErr.Error(), needsReauth, nextPollAt); err != nil {
+		m.logger.ErrorContext(ctx, "error recording connection failure", logging.UserID(userID), logging.Err(err))
+		return
+	}
+	if needsReauth {
+		m.logger.WarnContext(ctx, "connection needs to be linked again, pausing polling", logging.UserID(userID), logging.Err(pollErr))
+	}
+}
+
+// Paused returns the users not to poll right now
+func (m *Monitor) Paused(ctx context.Context) map[int64]bool {
+	if m == nil {
+		return nil
+	}
+	paused, err := m.db.GetPausedConnections(m.provider, time.Now())
+	if err != nil {
+		// better to keep polling everyone than to stop
+		m.logger.ErrorContext(ctx, "error loading paused connections", logging.Err(err))
+		return nil
+	}
+	return paused
+}
+
+// Reset forgets the user's status, e.g. after they linked the provider again
+func (m *Monitor) Reset(ctx context.Context, userID int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	delete(m.lastSuccess, userID)
+	m.mu.Unlock()
+
+	if err := m.db.DeleteConnectionStatus(userID, m.provider); err != nil {
+		m.logger.ErrorContext(ctx, "error resetting connection status", logging.UserID(userID), logging.Err(err))
+	}
+}
+
+// retryDelay doubles with every consecutive failure up to maxRetryDelay. A
+// single failure is usually a blip, so polling only slows down from the second
+func retryDelay(errorCount int) time.Duration {
+	if errorCount < 2 {
+		return 0
+	}
+	delay := baseRetryDelay
+	for i := 2; i < errorCount && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestFailureBacksOff(t *testing.T) {
+	database := setupTestDB(t)
+	m := NewMonitor(database, "lastfm")
+	ctx := context.Background()
+
+	m.Failure(ctx, 1, errors.New("timeout"))
+	if m.Paused(ctx)[1] {
+		t.Error("expected a single failure not to pause polling")
+	}
+
+	m.Failure(ctx, 1, errors.New("timeout"))
+	if !m.Paused(ctx)[1] {
+		t.Error("expected repeated failures to pause polling")
+	}
+
+	status, err := database.GetConnectionStatus(1, "lastfm")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.ErrorCount != 2 || status.LastError == nil || *status.LastError != "timeout" || status.NeedsReauth {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	m.Success(ctx, 1)
+	status, _ = database.GetConnectionStatus(1, "lastfm")
+	if !status.Healthy() || status.LastSuccessAt == nil || m.Paused(ctx)[1] {
+		t.Errorf("expected a success to clear the failures, got %+v", status)
+	}
+}
+
+func TestReauthPausesUntilReset(t *testing.T) {
+	database := setupTestDB(t)
+	m := NewMonitor(database, "spotify")
+	ctx := context.Background()
+
+	m.Failure(ctx, 7, fmt.Errorf("%w: refresh token revoked", ErrReauthRequired))
+	if !m.Paused(ctx)[7] {
+		t.Fatal("expected a revoked token to pause polling")
+	}
+	statuses, err := database.GetUserConnectionStatuses(7)
+	if err != nil || len(statuses) != 1 || !statuses[0].NeedsReauth {
+		t.Fatalf("expected a status needing reauth, got %+v (%v)", statuses, err)
+	}
+
+	m.Reset(ctx, 7)
+	if m.Paused(ctx)[7] {
+		t.Error("expected relinking to resume polling")
+	}
+}
+
+func TestNilMonitor(t *testing.T) {
+	var m *Monitor
+	ctx := context.Background()
+	m.Success(ctx, 1)
+	m.Failure(ctx, 1, errors.New("ignored"))
+	if m.Paused(ctx)[1] {
+		t.Error("expected a nil monitor to pause nobody")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		errors int
+		want   time.Duration
+	}{
+		{1, 0},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{20, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.errors); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.errors, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package events fans out things that happen to a user's listening, such as
+// a track being stamped, to whatever wants to react to them besides the PDS
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// Type of event
+type Type string
+
+const (
+	TrackStamped      Type = "track.stamped"
+	NowPlayingChanged Type = "now_playing.changed"
+	NowPlayingCleared Type = "now_playing.cleared"
+)
+
+// Sources a track can come from
+const (
+	SourceSpotify      = "spotify"
+	SourceLastFM       = "lastfm"
+	SourceAppleMusic   = "applemusic"
+	SourceListenBrainz = "listenbrainz"
+)
+
+// Event something that happened for a user
+type Event struct {
+	Type   Type
+	UserID int64
+	// Track a copy, subscribers may keep it
+	Track  models.Track
+	Source string
+	Time   time.Time
+}
+
+// Bus delivers events to every subscriber. Publish calls subscribers
+// synchronously, so they have to hand slow work off to their own goroutines
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn to be called for every event
+func (b *Bus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish sends an event to all subscribers. A nil bus drops it
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// PublishStamped is shorthand for publishing a TrackStamped event
+func (b *Bus) PublishStamped(userID int64, track *models.Track, source string) {
+	b.Publish(Event{Type: TrackStamped, UserID: userID, Track: *track, Source: source})
+}
@@ -0,0 +1,614 @@
+package export
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/session"
+)
+
+// exportRetention how long finished archives are kept around for download
+const exportRetention = 7 * 24 * time.Hour
+
+type Service struct {
+	db        *db.DB
+	apiKeyMgr *apikey.Manager
+	dir       string
+	logger    *slog.Logger
+}
+
+func NewExportService(database *db.DB, apiKeyMgr *apikey.Manager, dir string) *Service {
+	return &Service{
+		db:        database,
+		apiKeyMgr: apiKeyMgr,
+		dir:       dir,
+		logger:    logging.For("export"),
+	}
+}
+
+// StartExport queues an export for the user and builds it in the background.
+// If the user already has an export in progress that job is returned instead
+func (s *Service) StartExport(userID int64) (*models.ExportJob, error) {
+	s.pruneExpired()
+
+	jobs, err := s.db.GetUserExportJobs(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load export jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if job.Status == models.ExportStatusPending || job.Status == models.ExportStatusRunning {
+			return job, nil
+		}
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	job := &models.ExportJob{
+		ID:        base64.RawURLEncoding.EncodeToString(b),
+		UserID:    userID,
+		Status:    models.ExportStatusPending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.db.CreateExportJob(job); err != nil {
+		return nil, fmt.Errorf("failed to create export job: %w", err)
+	}
+
+	// run works on its own copy, the returned job is handed to the caller
+	running := *job
+	go s.run(&running)
+
+	return job, nil
+}
+
+// FailInterrupted marks exports that were pending or running when the server stopped as failed,
+// so their users can start a new one. Call it at startup, before any export is started
+func (s *Service) FailInterrupted() {
+	jobs, err := s.db.GetUnfinishedExportJobs()
+	if err != nil {
+		s.logger.Error("error loading unfinished exports", logging.Err(err))
+		return
+	}
+	for _, job := range jobs {
+		// a partly written archive may have been left behind
+		if err := os.Remove(filepath.Join(s.dir, job.ID+".zip")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.logger.Error("error removing interrupted export archive", "export_id", job.ID, logging.Err(err))
+		}
+		now := time.Now().UTC()
+		errMsg := "the export was interrupted by a server restart, please start a new one"
+		job.Status = models.ExportStatusFailed
+		job.Error = &errMsg
+		job.FilePath = nil
+		job.CompletedAt = &now
+		if err := s.db.UpdateExportJob(job); err != nil {
+			s.logger.Error("error failing interrupted export", "export_id", job.ID, logging.Err(err))
+			continue
+		}
+		s.logger.Info("marked interrupted export as failed", "export_id", job.ID, logging.UserID(job.UserID))
+	}
+}
+
+// run builds the archive for a job, recording the outcome on the job
+func (s *Service) run(job *models.ExportJob) {
+	job.Status = models.ExportStatusRunning
+	if err := s.db.UpdateExportJob(job); err != nil {
+		s.logger.Error("error marking export as running", "export_id", job.ID, logging.Err(err))
+	}
+
+	path, err := s.buildArchive(job)
+	now := time.Now().UTC()
+	job.CompletedAt = &now
+	if err != nil {
+		s.logger.Error("export failed", "export_id", job.ID, logging.UserID(job.UserID), logging.Err(err))
+		errMsg := err.Error()
+		job.Status = models.ExportStatusFailed
+		job.Error = &errMsg
+	} else {
+		s.logger.Info("export finished", "export_id", job.ID, logging.UserID(job.UserID))
+		job.Status = models.ExportStatusDone
+		job.FilePath = &path
+	}
+
+	if err := s.db.UpdateExportJob(job); err != nil {
+		s.logger.Error("error saving export", "export_id", job.ID, logging.Err(err))
+	}
+}
+
+func (s *Service) buildArchive(job *models.ExportJob) (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	path := filepath.Join(s.dir, job.ID+".zip")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	err = s.writeArchive(f, job.UserID)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// pruneExpired removes archives and jobs older than exportRetention
+func (s *Service) pruneExpired() {
+	jobs, err := s.db.GetExportJobsCreatedBefore(time.Now().UTC().Add(-exportRetention))
+	if err != nil {
+		s.logger.Error("error loading expired exports", logging.Err(err))
+		return
+	}
+	for _, job := range jobs {
+		if job.Status == models.ExportStatusRunning {
+			continue
+		}
+		if err := s.RemoveJob(job); err != nil {
+			s.logger.Error("error removing expired export", "export_id", job.ID, logging.Err(err))
+		}
+	}
+}
+
+// RemoveJob deletes a job and its archive
+func (s *Service) RemoveJob(job *models.ExportJob) error {
+	if job.FilePath != nil {
+		if err := os.Remove(*job.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return s.db.DeleteExportJob(job.ID)
+}
+
+// ------- Archive contents -------
+
+type exportProfile struct {
+	ID         int64     `json:"id"`
+	Username   *string   `json:"username"`
+	Email      *string   `json:"email"`
+	ATProtoDID *string   `json:"atproto_did"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// exportServices linked music services, tokens are never included
+type exportServices struct {
+	Spotify struct {
+		Linked      bool       `json:"linked"`
+		SpotifyID   *string    `json:"spotify_id,omitempty"`
+		TokenExpiry *time.Time `json:"token_expiry,omitempty"`
+	} `json:"spotify"`
+	LastFM struct {
+		Linked   bool    `json:"linked"`
+		Username *string `json:"username,omitempty"`
+	} `json:"lastfm"`
+	AppleMusic struct {
+		Linked bool `json:"linked"`
+	} `json:"applemusic"`
+}
+
+// exportAPIKey key metadata, the id is the key itself so it's left out
+type exportAPIKey struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// exportPDS where stamped plays are submitted to
+type exportPDS struct {
+	DID             *string `json:"did"`
+	HasSession      bool    `json:"has_session"`
+	StampedTracks   int     `json:"stamped_tracks"`
+	UnstampedTracks int     `json:"unstamped_tracks"`
+}
+
+type exportAccount struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Profile    exportProfile  `json:"profile"`
+	Services   exportServices `json:"services"`
+	APIKeys    []exportAPIKey `json:"api_keys"`
+	PDS        exportPDS      `json:"pds"`
+}
+
+var trackCSVHeader = []string{
+	"play_id", "timestamp", "name", "artists", "artist_mbids", "album", "recording_mbid", "release_mbid",
+	"duration_ms", "progress_ms", "url", "service_base_url", "isrc", "has_stamped",
+}
+
+func trackCSVRow(track *models.Track) []string {
+	names := make([]string, 0, len(track.Artist))
+	mbids := make([]string, 0, len(track.Artist))
+	for _, a := range track.Artist {
+		names = append(names, a.Name)
+		if a.MBID != nil {
+			mbids = append(mbids, *a.MBID)
+		}
+	}
+	return []string{
+		strconv.FormatInt(track.PlayID, 10),
+		track.Timestamp.UTC().Format(time.RFC3339),
+		track.Name,
+		strings.Join(names, "; "),
+		strings.Join(mbids, "; "),
+		track.Album,
+		derefString(track.RecordingMBID),
+		derefString(track.ReleaseMBID),
+		strconv.FormatInt(track.DurationMs, 10),
+		strconv.FormatInt(track.ProgressMs, 10),
+		track.URL,
+		track.ServiceBaseUrl,
+		track.ISRC,
+		strconv.FormatBool(track.HasStamped),
+	}
+}
+
+var episodeCSVHeader = []string{
+	"play_id", "timestamp", "name", "show", "publisher", "duration_ms", "progress_ms", "url", "service_base_url",
+	"has_stamped", "device_name", "device_type",
+}
+
+func episodeCSVRow(episode *models.Episode) []string {
+	return []string{
+		strconv.FormatInt(episode.PlayID, 10),
+		episode.Timestamp.UTC().Format(time.RFC3339),
+		episode.Name,
+		episode.Show,
+		episode.Publisher,
+		strconv.FormatInt(episode.DurationMs, 10),
+		strconv.FormatInt(episode.ProgressMs, 10),
+		episode.URL,
+		episode.ServiceBaseUrl,
+		strconv.FormatBool(episode.HasStamped),
+		episode.DeviceName,
+		episode.DeviceType,
+	}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// writeArchive writes the zip archive for a user. Tracks are streamed from the db so large
+// histories aren't held in memory
+func (s *Service) writeArchive(w io.Writer, userID int64) error {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("user %d not found", userID)
+	}
+
+	keys, err := s.apiKeyMgr.GetUserApiKeys(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load api keys: %w", err)
+	}
+
+	account := exportAccount{
+		ExportedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			ID:         user.ID,
+			Username:   user.Username,
+			Email:      user.Email,
+			ATProtoDID: user.ATProtoDID,
+			CreatedAt:  user.CreatedAt,
+			UpdatedAt:  user.UpdatedAt,
+		},
+		APIKeys: make([]exportAPIKey, 0, len(keys)),
+		PDS: exportPDS{
+			DID:        user.ATProtoDID,
+			HasSession: user.MostRecentAtProtoSessionID != nil && *user.MostRecentAtProtoSessionID != "",
+		},
+	}
+	account.Services.Spotify.Linked = user.SpotifyID != nil && *user.SpotifyID != ""
+	account.Services.Spotify.SpotifyID = user.SpotifyID
+	account.Services.Spotify.TokenExpiry = user.TokenExpiry
+	account.Services.LastFM.Linked = user.LastFMUsername != nil && *user.LastFMUsername != ""
+	account.Services.LastFM.Username = user.LastFMUsername
+	account.Services.AppleMusic.Linked = user.AppleMusicUserToken != nil && *user.AppleMusicUserToken != ""
+	for _, key := range keys {
+		account.APIKeys = append(account.APIKeys, exportAPIKey{Name: key.Name, CreatedAt: key.CreatedAt, ExpiresAt: key.ExpiresAt})
+	}
+
+	zw := zip.NewWriter(w)
+
+	// tracks.json, written as a stream rather than marshalling the whole slice
+	tracksJSON, err := zw.Create("tracks.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(tracksJSON, "[\n"); err != nil {
+		return err
+	}
+	first := true
+	err = s.db.ForEachTrack(userID, func(track *models.Track) error {
+		if track.HasStamped {
+			account.PDS.StampedTracks++
+		} else {
+			account.PDS.UnstampedTracks++
+		}
+		if !first {
+			if _, err := io.WriteString(tracksJSON, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+		b, err := json.Marshal(track)
+		if err != nil {
+			return err
+		}
+		_, err = tracksJSON.Write(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export tracks: %w", err)
+	}
+	if _, err := io.WriteString(tracksJSON, "\n]\n"); err != nil {
+		return err
+	}
+
+	tracksCSV, err := zw.Create("tracks.csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(tracksCSV)
+	if err := cw.Write(trackCSVHeader); err != nil {
+		return err
+	}
+	err = s.db.ForEachTrack(userID, func(track *models.Track) error {
+		return cw.Write(trackCSVRow(track))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export tracks: %w", err)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	// podcast episodes, kept apart from tracks
+	episodesJSON, err := zw.Create("episodes.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(episodesJSON, "[\n"); err != nil {
+		return err
+	}
+	first = true
+	err = s.db.ForEachEpisode(userID, func(episode *models.Episode) error {
+		if !first {
+			if _, err := io.WriteString(episodesJSON, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+		b, err := json.Marshal(episode)
+		if err != nil {
+			return err
+		}
+		_, err = episodesJSON.Write(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export episodes: %w", err)
+	}
+	if _, err := io.WriteString(episodesJSON, "\n]\n"); err != nil {
+		return err
+	}
+
+	episodesCSV, err := zw.Create("episodes.csv")
+	if err != nil {
+		return err
+	}
+	cw = csv.NewWriter(episodesCSV)
+	if err := cw.Write(episodeCSVHeader); err != nil {
+		return err
+	}
+	err = s.db.ForEachEpisode(userID, func(episode *models.Episode) error {
+		return cw.Write(episodeCSVRow(episode))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export episodes: %w", err)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	keysCSV, err := zw.Create("api_keys.csv")
+	if err != nil {
+		return err
+	}
+	cw = csv.NewWriter(keysCSV)
+	if err := cw.Write([]string{"name", "created_at", "expires_at"}); err != nil {
+		return err
+	}
+	for _, key := range account.APIKeys {
+		if err := cw.Write([]string{key.Name, key.CreatedAt.UTC().Format(time.RFC3339), key.ExpiresAt.UTC().Format(time.RFC3339)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	// account.json last so it has the track counts
+	accountJSON, err := zw.Create("account.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(accountJSON)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(account); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// ------- HTTP handlers -------
+
+// jsonResponse is a helper to send JSON responses
+func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if data != nil {
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			slog.Error("error encoding JSON response", logging.Err(err))
+		}
+	}
+}
+
+// HandleExport lists a user's exports (GET) or starts a new one (POST), as JSON for API requests
+// and as the export page otherwise
+func (s *Service) HandleExport(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := session.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		isAPI := session.IsAPIRequest(r.Context())
+
+		switch r.Method {
+		case http.MethodPost:
+			job, err := s.StartExport(userID)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error starting export", logging.UserID(userID), logging.Err(err))
+				if isAPI {
+					jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to start export"})
+				} else {
+					http.Error(w, "Failed to start export", http.StatusInternalServerError)
+				}
+				return
+			}
+			if isAPI {
+				jsonResponse(w, http.StatusAccepted, job)
+				return
+			}
+			http.Redirect(w, r, "/export", http.StatusSeeOther)
+			return
+		case http.MethodGet:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jobs, err := s.db.GetUserExportJobs(userID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading exports", logging.UserID(userID), logging.Err(err))
+			if isAPI {
+				jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load exports"})
+			} else {
+				http.Error(w, "Failed to load exports", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if isAPI {
+			if jobs == nil {
+				jobs = []*models.ExportJob{}
+			}
+			jsonResponse(w, http.StatusOK, map[string]any{"exports": jobs})
+			return
+		}
+
+		lastfmUsername := ""
+		user, err := s.db.GetUserByID(userID)
+		if err == nil && user != nil && user.LastFMUsername != nil {
+			lastfmUsername = *user.LastFMUsername
+		} else if err != nil {
+			s.logger.ErrorContext(r.Context(), "error fetching user details for export page", logging.UserID(userID), logging.Err(err))
+		}
+
+		data := struct {
+			Jobs   []*models.ExportJob
+			NavBar pages.NavBar
+		}{
+			Jobs: jobs,
+			NavBar: pages.NavBar{
+				IsLoggedIn:        true,
+				LastFMUsername:    lastfmUsername,
+				SpotifyEnabled:    viper.GetBool("enable_spotify"),
+				LastFMEnabled:     viper.GetBool("enable_lastfm"),
+				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := pg.Execute("export", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
+
+// HandleDownload serves a finished export archive to its owner
+func (s *Service) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	job, err := s.db.GetExportJob(r.URL.Query().Get("job_id"))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error loading export", logging.UserID(userID), logging.Err(err))
+		http.Error(w, "Failed to load export", http.StatusInternalServerError)
+		return
+	}
+	if job == nil || job.UserID != userID {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != models.ExportStatusDone || job.FilePath == nil {
+		http.Error(w, "Export is not ready yet", http.StatusConflict)
+		return
+	}
+
+	f, err := os.Open(*job.FilePath)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error opening export", "export_id", job.ID, logging.Err(err))
+		http.Error(w, "Export archive is no longer available", http.StatusGone)
+		return
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error closing export", "export_id", job.ID, logging.Err(err))
+		}
+	}(f)
+
+	filename := fmt.Sprintf("piper-export-%s.zip", job.CreatedAt.UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	http.ServeContent(w, r, filename, *job.CompletedAt, f)
+}
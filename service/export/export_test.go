@@ -0,0 +1,299 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/models"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+// createTestUser creates a user with linked services, tracks and an API key
+func createTestUser(t *testing.T, database *db.DB, keys *apikey.Manager) (int64, string) {
+	userID, err := database.CreateUser(&models.User{
+		Email: func() *string { s := "test@example.com"; return &s }(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	if _, err := database.AddSpotifySession(userID, "tester", "test@example.com", "spotify-id", "secret-access-token", "secret-refresh-token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to add spotify session: %v", err)
+	}
+	if err := database.UpdateAppleMusicUserToken(userID, "secret-apple-token"); err != nil {
+		t.Fatalf("Failed to add apple music token: %v", err)
+	}
+
+	for i, name := range []string{"First Song", "Second Song", "Third Song"} {
+		track := &models.Track{
+			Name:           name,
+			Artist:         []models.Artist{{Name: "Artist"}},
+			Album:          "Album",
+			URL:            "https://open.spotify.com/track/" + name,
+			Timestamp:      time.Date(2025, 1, 1, 12, i, 0, 0, time.UTC),
+			DurationMs:     180000,
+			ServiceBaseUrl: "open.spotify.com",
+			HasStamped:     i != 2,
+		}
+		if _, err := database.SaveTrack(userID, track); err != nil {
+			t.Fatalf("Failed to save track: %v", err)
+		}
+	}
+
+	if _, err := database.SaveEpisode(userID, &models.Episode{
+		Name:           "Episode One",
+		Show:           "The Show",
+		Publisher:      "Publisher",
+		URL:            "https://open.spotify.com/episode/one",
+		Timestamp:      time.Date(2025, 1, 2, 8, 0, 0, 0, time.UTC),
+		DurationMs:     1800000,
+		ProgressMs:     1800000,
+		ServiceBaseUrl: "open.spotify.com",
+		DeviceName:     "Kitchen",
+	}); err != nil {
+		t.Fatalf("Failed to save episode: %v", err)
+	}
+
+	key, err := keys.CreateApiKey(userID, "my key", 30)
+	if err != nil {
+		t.Fatalf("Failed to create api key: %v", err)
+	}
+
+	return userID, key.ID
+}
+
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Name, err)
+		}
+		files[f.Name] = b
+	}
+	return files
+}
+
+func TestWriteArchive(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	keys := apikey.NewApiKeyManager(database)
+	userID, rawKey := createTestUser(t, database, keys)
+	svc := NewExportService(database, keys, t.TempDir())
+
+	var buf bytes.Buffer
+	if err := svc.writeArchive(&buf, userID); err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+	files := readZip(t, buf.Bytes())
+
+	for _, name := range []string{"account.json", "tracks.json", "tracks.csv", "episodes.json", "episodes.csv", "api_keys.csv"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in archive", name)
+		}
+	}
+
+	// nothing secret may leak into any file
+	for name, content := range files {
+		for _, secret := range []string{"secret-access-token", "secret-refresh-token", "secret-apple-token", rawKey} {
+			if strings.Contains(string(content), secret) {
+				t.Errorf("%s contains secret %q", name, secret)
+			}
+		}
+	}
+
+	var account exportAccount
+	if err := json.Unmarshal(files["account.json"], &account); err != nil {
+		t.Fatalf("Failed to decode account.json: %v", err)
+	}
+	if !account.Services.Spotify.Linked || !account.Services.AppleMusic.Linked || account.Services.LastFM.Linked {
+		t.Errorf("Unexpected linked services: %+v", account.Services)
+	}
+	if len(account.APIKeys) != 1 || account.APIKeys[0].Name != "my key" {
+		t.Errorf("Expected one api key named 'my key', got %+v", account.APIKeys)
+	}
+	if account.PDS.StampedTracks != 2 || account.PDS.UnstampedTracks != 1 {
+		t.Errorf("Expected 2 stamped and 1 unstamped track, got %+v", account.PDS)
+	}
+
+	var tracks []models.Track
+	if err := json.Unmarshal(files["tracks.json"], &tracks); err != nil {
+		t.Fatalf("Failed to decode tracks.json: %v", err)
+	}
+	if len(tracks) != 3 || tracks[0].Name != "First Song" {
+		t.Errorf("Expected 3 tracks oldest first, got %+v", tracks)
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(files["tracks.csv"])).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read tracks.csv: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Expected header and 3 rows in tracks.csv, got %d", len(rows))
+	}
+	if rows[1][2] != "First Song" {
+		t.Errorf("Expected first csv track to be 'First Song', got %q", rows[1][2])
+	}
+
+	var episodes []models.Episode
+	if err := json.Unmarshal(files["episodes.json"], &episodes); err != nil {
+		t.Fatalf("Failed to decode episodes.json: %v", err)
+	}
+	if len(episodes) != 1 || episodes[0].Show != "The Show" || episodes[0].DeviceName != "Kitchen" {
+		t.Errorf("Expected the episode, got %+v", episodes)
+	}
+	rows, err = csv.NewReader(bytes.NewReader(files["episodes.csv"])).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read episodes.csv: %v", err)
+	}
+	if len(rows) != 2 || rows[1][2] != "Episode One" || rows[1][3] != "The Show" {
+		t.Errorf("Expected header and the episode in episodes.csv, got %q", rows)
+	}
+}
+
+func TestWriteArchiveEmptyHistory(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	keys := apikey.NewApiKeyManager(database)
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	svc := NewExportService(database, keys, t.TempDir())
+
+	var buf bytes.Buffer
+	if err := svc.writeArchive(&buf, userID); err != nil {
+		t.Fatalf("writeArchive failed: %v", err)
+	}
+
+	var tracks []models.Track
+	if err := json.Unmarshal(readZip(t, buf.Bytes())["tracks.json"], &tracks); err != nil {
+		t.Fatalf("Expected tracks.json to be valid for an empty history: %v", err)
+	}
+	if len(tracks) != 0 {
+		t.Errorf("Expected no tracks, got %d", len(tracks))
+	}
+	var episodes []models.Episode
+	if err := json.Unmarshal(readZip(t, buf.Bytes())["episodes.json"], &episodes); err != nil || len(episodes) != 0 {
+		t.Errorf("Expected episodes.json to be an empty list, got %d (%v)", len(episodes), err)
+	}
+}
+
+func TestStartExport(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	keys := apikey.NewApiKeyManager(database)
+	userID, _ := createTestUser(t, database, keys)
+	svc := NewExportService(database, keys, t.TempDir())
+
+	job, err := svc.StartExport(userID)
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err = database.GetExportJob(job.ID)
+		if err != nil {
+			t.Fatalf("Failed to load job: %v", err)
+		}
+		if job.Status == models.ExportStatusDone || job.Status == models.ExportStatusFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Export did not finish, status %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job.Status != models.ExportStatusDone {
+		t.Fatalf("Expected export to finish, got %s (%v)", job.Status, job.Error)
+	}
+	if job.FilePath == nil || job.CompletedAt == nil {
+		t.Fatal("Expected finished export to have a file and completion time")
+	}
+
+	if err := svc.RemoveJob(job); err != nil {
+		t.Fatalf("RemoveJob failed: %v", err)
+	}
+	if removed, _ := database.GetExportJob(job.ID); removed != nil {
+		t.Error("Expected job to be removed")
+	}
+}
+
+func TestFailInterrupted(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	keys := apikey.NewApiKeyManager(database)
+	userID, _ := createTestUser(t, database, keys)
+	svc := NewExportService(database, keys, t.TempDir())
+
+	// jobs left behind by a server that stopped mid export
+	for _, status := range []string{models.ExportStatusPending, models.ExportStatusRunning} {
+		job := &models.ExportJob{ID: status, UserID: userID, Status: status, CreatedAt: time.Now().UTC()}
+		if err := database.CreateExportJob(job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	svc.FailInterrupted()
+
+	for _, id := range []string{models.ExportStatusPending, models.ExportStatusRunning} {
+		job, err := database.GetExportJob(id)
+		if err != nil || job == nil {
+			t.Fatalf("Failed to load job %s: %v", id, err)
+		}
+		if job.Status != models.ExportStatusFailed || job.Error == nil || job.CompletedAt == nil {
+			t.Errorf("Expected %s job to be failed, got %s", id, job.Status)
+		}
+	}
+
+	job, err := svc.StartExport(userID)
+	if err != nil {
+		t.Fatalf("StartExport failed: %v", err)
+	}
+	if job.ID == models.ExportStatusPending || job.ID == models.ExportStatusRunning {
+		t.Fatal("Expected a new export to be started")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == models.ExportStatusPending || job.Status == models.ExportStatusRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("Export did not finish, status %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if job, err = database.GetExportJob(job.ID); err != nil {
+			t.Fatalf("Failed to load job: %v", err)
+		}
+	}
+}
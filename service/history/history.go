@@ -0,0 +1,141 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/session"
+)
+
+// MaxLimit the most tracks returned per page
+const MaxLimit = 200
+
+// ParseQuery reads the history filters from query parameters:
+// limit, cursor, from and to (RFC 3339), service (repeatable or comma separated), artist_mbid,
+// recording_mbid, q (text search), device (the name of the device played on) and stamped (true/false)
+func ParseQuery(values url.Values, defaultLimit int) (db.TrackQuery, error) {
+	q := db.TrackQuery{Limit: defaultLimit}
+
+	if limitStr := values.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return q, errors.New("limit must be a positive number")
+		}
+		q.Limit = min(limit, MaxLimit)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := db.DecodeTrackCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		q.After = after
+	}
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 timestamp", param.name)
+		}
+		*param.dst = t
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, errors.New("from must be before to")
+	}
+
+	for _, service := range values["service"] {
+		for _, s := range strings.Split(service, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				q.Services = append(q.Services, s)
+			}
+		}
+	}
+
+	q.ArtistMBID = values.Get("artist_mbid")
+	q.RecordingMBID = values.Get("recording_mbid")
+	q.Search = strings.TrimSpace(values.Get("q"))
+	q.Device = strings.TrimSpace(values.Get("device"))
+
+	if stamped := values.Get("stamped"); stamped != "" {
+		b, err := strconv.ParseBool(stamped)
+		if err != nil {
+			return q, errors.New("stamped must be true or false")
+		}
+		q.Stamped = &b
+	}
+
+	return q, nil
+}
+
+func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if data != nil {
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			slog.Error("error encoding JSON response", logging.Err(err))
+		}
+	}
+}
+
+// Serve writes a page of the user's history as a JSON array of tracks. If there are more tracks
+// the cursor for the next page is sent in the X-Next-Cursor header and as a Link rel="next"
+func Serve(w http.ResponseWriter, r *http.Request, database *db.DB, defaultLimit int) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	q, err := ParseQuery(r.URL.Query(), defaultLimit)
+	if err != nil {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// one extra to know whether there is another page
+	limit := q.Limit
+	q.Limit = limit + 1
+	tracks, err := database.QueryTracks(userID, q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error querying history", logging.UserID(userID), logging.Err(err))
+		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get track history"})
+		return
+	}
+
+	if len(tracks) > limit {
+		tracks = tracks[:limit]
+		last := tracks[len(tracks)-1]
+		next := db.TrackCursor{Timestamp: last.Timestamp, ID: last.PlayID}.Encode()
+
+		nextQuery := r.URL.Query()
+		nextQuery.Set("cursor", next)
+		nextURL := url.URL{Path: r.URL.Path, RawQuery: nextQuery.Encode()}
+		w.Header().Set("X-Next-Cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+	}
+
+	jsonResponse(w, http.StatusOK, tracks)
+}
+
+// Handler serves the history API, 50 tracks per page unless a limit is given
+func Handler(database *db.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Serve(w, r, database, 50)
+	}
+}
@@ -0,0 +1,200 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/session"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+func strPtr(s string) *string { return &s }
+
+var base = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// seedHistory stores tracks from every service, two of them with the same timestamp
+func seedHistory(t *testing.T, database *db.DB) int64 {
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	tracks := []*models.Track{
+		{Name: "Spotify Song", Artist: []models.Artist{{Name: "Band", MBID: strPtr("band-mbid")}}, Album: "First", ServiceBaseUrl: "open.spotify.com", Timestamp: base, HasStamped: true, DeviceName: "Kitchen", DeviceType: "Speaker"},
+		{Name: "Submitted Song", Artist: []models.Artist{{Name: "Band", MBID: strPtr("band-mbid")}}, Album: "First", ServiceBaseUrl: "spotify", Timestamp: base.Add(time.Minute), HasStamped: true},
+		{Name: "Scrobble", Artist: []models.Artist{{Name: "Singer"}}, Album: "Second", ServiceBaseUrl: "last.fm", Timestamp: base.Add(2 * time.Minute)},
+		{Name: "Apple 100% Song", Artist: []models.Artist{{Name: "Singer"}}, Album: "Third", ServiceBaseUrl: "music.apple.com", Timestamp: base.Add(3 * time.Minute), RecordingMBID: strPtr("rec-mbid")},
+		{Name: "Listen", Artist: []models.Artist{{Name: "Other"}}, Album: "Fourth", ServiceBaseUrl: "listenbrainz", Timestamp: base.Add(3 * time.Minute), HasStamped: true},
+		// played in another zone, still has to sort by instant
+		{Name: "Far Away", Artist: []models.Artist{{Name: "Other"}}, Album: "Fifth", ServiceBaseUrl: "listenbrainz", Timestamp: base.Add(4 * time.Minute).In(time.FixedZone("X", -5*3600)), HasStamped: true},
+	}
+	for _, track := range tracks {
+		track.URL = "u"
+		if _, err := database.SaveTrack(userID, track); err != nil {
+			t.Fatalf("Failed to save track: %v", err)
+		}
+	}
+	// legacy row with a plain artist name
+	if _, err := database.Exec(`INSERT INTO tracks (user_id, name, artist, album, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped)
+		VALUES (?, 'Legacy', 'Old Artist', 'Old', 'u', ?, 0, 0, 'open.spotify.com', '', 0)`, userID, base.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to insert legacy track: %v", err)
+	}
+	return userID
+}
+
+func get(t *testing.T, database *db.DB, userID int64, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/history?"+query.Encode(), nil)
+	req = req.WithContext(session.WithUserID(req.Context(), userID))
+	rr := httptest.NewRecorder()
+	Handler(database)(rr, req)
+	return rr
+}
+
+func names(t *testing.T, rr *httptest.ResponseRecorder) []string {
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var tracks []*models.Track
+	if err := json.Unmarshal(rr.Body.Bytes(), &tracks); err != nil {
+		t.Fatalf("Failed to decode tracks: %v", err)
+	}
+	result := make([]string, len(tracks))
+	for i, track := range tracks {
+		result[i] = track.Name
+	}
+	return result
+}
+
+func TestHistoryPagination(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := seedHistory(t, database)
+
+	var seen []string
+	query := url.Values{"limit": {"2"}}
+	for page := 0; ; page++ {
+		if page > 10 {
+			t.Fatal("Pagination did not finish")
+		}
+		rr := get(t, database, userID, query)
+		seen = append(seen, names(t, rr)...)
+
+		next := rr.Header().Get("X-Next-Cursor")
+		if next == "" {
+			if rr.Header().Get("Link") != "" {
+				t.Error("Expected no Link header on the last page")
+			}
+			break
+		}
+		query.Set("cursor", next)
+	}
+
+	expected := []string{"Far Away", "Listen", "Apple 100% Song", "Scrobble", "Submitted Song", "Spotify Song", "Legacy"}
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, seen)
+			break
+		}
+	}
+}
+
+func TestHistoryFilters(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := seedHistory(t, database)
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected []string
+	}{
+		{"spotify aliases", url.Values{"service": {"open.spotify.com"}}, []string{"Submitted Song", "Spotify Song", "Legacy"}},
+		{"several services", url.Values{"service": {"last.fm,music.apple.com"}}, []string{"Apple 100% Song", "Scrobble"}},
+		{"artist mbid", url.Values{"artist_mbid": {"band-mbid"}}, []string{"Submitted Song", "Spotify Song"}},
+		{"recording mbid", url.Values{"recording_mbid": {"rec-mbid"}}, []string{"Apple 100% Song"}},
+		{"search track", url.Values{"q": {"song"}}, []string{"Apple 100% Song", "Submitted Song", "Spotify Song"}},
+		{"search artist", url.Values{"q": {"singer"}}, []string{"Apple 100% Song", "Scrobble"}},
+		{"search escapes wildcards", url.Values{"q": {"100%"}}, []string{"Apple 100% Song"}},
+		{"device", url.Values{"device": {"kitchen"}}, []string{"Spotify Song"}},
+		{"unstamped", url.Values{"stamped": {"false"}}, []string{"Apple 100% Song", "Scrobble", "Legacy"}},
+		{"date range", url.Values{"from": {base.Add(time.Minute).Format(time.RFC3339)}, "to": {base.Add(3 * time.Minute).Format(time.RFC3339)}}, []string{"Scrobble", "Submitted Song"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(t, get(t, database, userID, tt.query))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("Expected %v, got %v", tt.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryBadRequest(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := seedHistory(t, database)
+
+	for _, query := range []url.Values{
+		{"limit": {"-1"}},
+		{"cursor": {"not-a-cursor"}},
+		{"from": {"yesterday"}},
+		{"from": {base.Format(time.RFC3339)}, "to": {base.Add(-time.Hour).Format(time.RFC3339)}},
+		{"stamped": {"maybe"}},
+	} {
+		if rr := get(t, database, userID, query); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestHistoryMigratesLocalTimestamps(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := seedHistory(t, database)
+
+	// saved with its local offset before tracks were stored in UTC
+	local := base.Add(5 * time.Minute).In(time.FixedZone("X", -5*3600))
+	if _, err := database.Exec(`INSERT INTO tracks (user_id, name, artist, album, url, timestamp, duration_ms, progress_ms, service_base_url, isrc, has_stamped)
+		VALUES (?, 'Old Offset', '[]', 'Old', 'u', ?, 0, 0, 'last.fm', '', 0)`, userID, local); err != nil {
+		t.Fatalf("Failed to insert track: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	var stored string
+	if err := database.QueryRow(`SELECT CAST(timestamp AS TEXT) FROM tracks WHERE name = 'Old Offset'`).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read timestamp: %v", err)
+	}
+	if !strings.HasSuffix(stored, "+00:00") {
+		t.Errorf("Expected the timestamp to be stored in UTC, got %q", stored)
+	}
+	if got := names(t, get(t, database, userID, url.Values{"limit": {"1"}})); len(got) != 1 || got[0] != "Old Offset" {
+		t.Errorf("Expected the migrated track to sort first, got %v", got)
+	}
+}
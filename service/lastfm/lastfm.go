@@ -3,21 +3,28 @@ package lastfm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/health"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/ratelimit"
 	atprotoservice "github.com/teal-fm/piper/service/atproto"
+	"github.com/teal-fm/piper/service/connection"
+	"github.com/teal-fm/piper/service/events"
 	"github.com/teal-fm/piper/service/musicbrainz"
+	"github.com/teal-fm/piper/service/stamping"
 	"golang.org/x/time/rate"
 )
 
@@ -33,6 +40,7 @@ type Service struct {
 	limiter            *rate.Limiter
 	apiKey             string
 	Usernames          []string
+	userIDs            map[string]int64 // piper user for each of Usernames
 	musicBrainzService *musicbrainz.Service
 	atprotoService     *atprotoauth.AuthService
 	playingNowService  interface {
@@ -40,20 +48,27 @@ type Service struct {
 		ClearPlayingNow(ctx context.Context, userID int64) error
 	}
 	lastSeenNowPlaying map[string]Track
+	events             *events.Bus
+	health             *health.Tracker
+	stamping           *stamping.Resolver
+	connections        *connection.Monitor
+	workers            int       // users fetched at once
+	retryAfter         time.Time // no fetches until then, set by a 429
 	mu                 sync.Mutex
-	logger             *log.Logger
+	logger             *slog.Logger
 }
 
 func NewLastFMService(db *db.DB, apiKey string, musicBrainzService *musicbrainz.Service, atprotoService *atprotoauth.AuthService, playingNowService interface {
 	PublishPlayingNow(ctx context.Context, userID int64, track *models.Track) error
 	ClearPlayingNow(ctx context.Context, userID int64) error
 }) *Service {
-	logger := log.New(os.Stdout, "lastfm: ", log.LstdFlags|log.Lmsgprefix)
+	logger := logging.For("lastfm")
 
 	return &Service{
 		db: db,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: metrics.NewTransport(events.SourceLastFM, nil),
 		},
 		// Last.fm unofficial rate limit is ~5 requests per second
 		limiter:            rate.NewLimiter(rate.Every(200*time.Millisecond), 1),
@@ -63,6 +78,9 @@ func NewLastFMService(db *db.DB, apiKey string, musicBrainzService *musicbrainz.
 		musicBrainzService: musicBrainzService,
 		playingNowService:  playingNowService,
 		lastSeenNowPlaying: make(map[string]Track),
+		userIDs:            make(map[string]int64),
+		connections:        connection.NewMonitor(db, events.SourceLastFM),
+		workers:            4,
 		mu:                 sync.Mutex{},
 		logger:             logger,
 	}
@@ -71,33 +89,57 @@ func NewLastFMService(db *db.DB, apiKey string, musicBrainzService *musicbrainz.
 func (l *Service) loadUsernames() error {
 	u, err := l.db.GetAllUsersWithLastFM()
 	if err != nil {
-		l.logger.Printf("Error loading users with Last.fm from DB: %v", err)
+		l.logger.Error("error loading users with Last.fm", logging.Err(err))
 		return fmt.Errorf("failed to load users from database: %w", err)
 	}
-	usernames := make([]string, len(u))
-	for i, user := range u {
-		// print out user stuff
-		if user.LastFMUsername != nil { // Check if the username is set
-			usernames[i] = *user.LastFMUsername
-		} else {
-			l.logger.Printf("User ID %d has Last.fm enabled but no username set", user.ID)
-		}
-	}
+	// users backing off after errors or whose username needs fixing
+	paused := l.connections.Paused(context.Background())
 
-	// filter empty usernames (shouldn't happen?)
-	filteredUsernames := make([]string, 0, len(usernames))
-	for _, name := range usernames {
-		if name != "" {
-			filteredUsernames = append(filteredUsernames, name)
+	usernames := make([]string, 0, len(u))
+	userIDs := make(map[string]int64, len(u))
+	for _, user := range u {
+		if user.LastFMUsername == nil || *user.LastFMUsername == "" {
+			l.logger.Warn("user has Last.fm enabled but no username set", logging.UserID(user.ID))
+			continue
+		}
+		if paused[user.ID] {
+			continue
 		}
+		usernames = append(usernames, *user.LastFMUsername)
+		userIDs[*user.LastFMUsername] = user.ID
 	}
 
-	l.Usernames = filteredUsernames
-	l.logger.Printf("Loaded %d Last.fm usernames", len(l.Usernames))
+	l.Usernames = usernames
+	l.userIDs = userIDs
+	l.logger.Debug("loaded Last.fm usernames", "count", len(l.Usernames))
+	metrics.SetActiveUsers(events.SourceLastFM, len(l.Usernames))
 
 	return nil
 }
 
+// ForgetUser drops the user's now playing state. Usernames are reloaded from the db every cycle
+func (l *Service) ForgetUser(user *models.User) {
+	if user.LastFMUsername == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.lastSeenNowPlaying, *user.LastFMUsername)
+}
+
+const (
+	// errRateLimitExceeded Last.fm's error code for too many requests
+	errRateLimitExceeded = 29
+	// defaultRetryAfter is used when a 429 comes without a usable Retry-After
+	defaultRetryAfter = time.Minute
+)
+
+// needsRelink reports whether a Last.fm error code means the linked username
+// won't work until the user changes it: 6 is an unknown user, 17 a private profile
+func needsRelink(code int) bool {
+	return code == 6 || code == 17
+}
+
 // getRecentTracks fetches the most recent tracks for a given Last.fm user.
 func (l *Service) getRecentTracks(ctx context.Context, username string) (*RecentTracksResponse, error) {
 	if username == "" {
@@ -126,12 +168,12 @@ func (l *Service) getRecentTracks(ctx context.Context, username string) (*Recent
 
 	if lastKnownTimestamp == nil {
 		// If no timestamp, then just get the {noTimestampLimit} most recent tracks
-		l.logger.Printf("no previous scrobble timestamp found for user %s. retrieving last 5 tracks", username)
+		l.logger.DebugContext(ctx, "no previous scrobble timestamp found, retrieving latest tracks", "lastfm_user", username, "limit", noTimestampLimit)
 		params.Set("limit", strconv.Itoa(noTimestampLimit))
 	} else {
 		// As last.fm returns tracks >= from, this will always return at leastthe user's most recent track, but should
 		// cover the (extremely remote) edge case where a user scrobbles multiple tracks within a single second
-		l.logger.Printf("retrieving all tracks for %s since last timestamp %s", username, lastKnownTimestamp.Format(time.RFC3339))
+		l.logger.DebugContext(ctx, "retrieving tracks since last timestamp", "lastfm_user", username, "since", lastKnownTimestamp.Format(time.RFC3339))
 		params.Set("limit", strconv.Itoa(downloadLimit))
 		params.Set("from", strconv.FormatInt(lastKnownTimestamp.Unix(), 10))
 	}
@@ -147,7 +189,7 @@ func (l *Service) getRecentTracks(ctx context.Context, username string) (*Recent
 		return nil, fmt.Errorf("failed to create request for %s: %w", username, err)
 	}
 
-	l.logger.Printf("Fetching recent tracks for user: %s", username)
+	l.logger.DebugContext(ctx, "fetching recent tracks", "lastfm_user", username)
 	resp, err := l.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch recent tracks for %s: %w", username, err)
@@ -155,14 +197,24 @@ func (l *Service) getRecentTracks(ctx context.Context, username string) (*Recent
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			l.logger.Printf("Error closing response body for %s: %v", username, err)
+			l.logger.ErrorContext(ctx, "error closing response body", "lastfm_user", username, logging.Err(err))
 		}
 	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		// Handle specific Last.fm error codes if necessary
 		// e.g., {"error": 6, "message": "User not found"}
+		var apiErr struct {
+			Error   int    `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(bodyBytes, &apiErr)
+		if resp.StatusCode == http.StatusTooManyRequests || apiErr.Error == errRateLimitExceeded {
+			return nil, ratelimit.NewRetryAfterError(events.SourceLastFM, resp, defaultRetryAfter)
+		}
+		if needsRelink(apiErr.Error) {
+			return nil, fmt.Errorf("%w: last.fm API error for %s: %s", connection.ErrReauthRequired, username, apiErr.Message)
+		}
 		return nil, fmt.Errorf("last.fm API error for %s: status %d, body: %s", username, resp.StatusCode, string(bodyBytes))
 	}
 
@@ -173,18 +225,15 @@ func (l *Service) getRecentTracks(ctx context.Context, username string) (*Recent
 	}
 	if err := json.Unmarshal(bodyBytes, &recentTracksResp); err != nil {
 		// Log the body content that failed to decode
-		l.logger.Printf("Failed to decode response body for %s: %s", username, string(bodyBytes))
+		l.logger.ErrorContext(ctx, "failed to decode response body", "lastfm_user", username, "body", string(bodyBytes))
 		return nil, fmt.Errorf("failed to decode response for %s: %w", username, err)
 	}
 
 	if len(recentTracksResp.RecentTracks.Tracks) > 0 {
-		l.logger.Printf("Fetched %d tracks for %s. Most recent: %s - %s",
-			len(recentTracksResp.RecentTracks.Tracks),
-			username,
-			recentTracksResp.RecentTracks.Tracks[0].Artist.Text,
-			recentTracksResp.RecentTracks.Tracks[0].Name)
+		l.logger.DebugContext(ctx, "fetched recent tracks", "lastfm_user", username, "count", len(recentTracksResp.RecentTracks.Tracks),
+			"most_recent", recentTracksResp.RecentTracks.Tracks[0].Artist.Text+" - "+recentTracksResp.RecentTracks.Tracks[0].Name)
 	} else {
-		l.logger.Printf("No recent tracks found for %s", username)
+		l.logger.DebugContext(ctx, "no recent tracks found", "lastfm_user", username)
 	}
 
 	return &recentTracksResp, nil
@@ -192,14 +241,14 @@ func (l *Service) getRecentTracks(ctx context.Context, username string) (*Recent
 
 func (l *Service) StartListeningTracker(interval time.Duration) {
 	if err := l.loadUsernames(); err != nil {
-		l.logger.Printf("Failed to perform initial username load: %v", err)
+		l.logger.Error("failed to perform initial username load", logging.Err(err))
 		// Decide if we should proceed without initial load or return error
 	}
 
 	if len(l.Usernames) == 0 {
-		l.logger.Println("No Last.fm users configured. Tracker will run but fetch cycles will be skipped until users are added.")
+		l.logger.Info("no Last.fm users configured, fetch cycles will be skipped until users are added")
 	} else {
-		l.logger.Printf("Found %d Last.fm users.", len(l.Usernames))
+		l.logger.Info("found Last.fm users", "count", len(l.Usernames))
 	}
 
 	ticker := time.NewTicker(interval)
@@ -208,7 +257,7 @@ func (l *Service) StartListeningTracker(interval time.Duration) {
 		if len(l.Usernames) > 0 {
 			l.fetchAllUserTracks(context.Background())
 		} else {
-			l.logger.Println("Skipping initial fetch cycle as no users are configured.")
+			l.logger.Debug("skipping initial fetch cycle as no users are configured")
 		}
 
 		for {
@@ -216,64 +265,83 @@ func (l *Service) StartListeningTracker(interval time.Duration) {
 			case <-ticker.C:
 				// refresh usernames periodically from db
 				if err := l.loadUsernames(); err != nil {
-					l.logger.Printf("Error reloading usernames in ticker: %v", err)
+					l.logger.Error("error reloading usernames", logging.Err(err))
 					// Continue ticker loop even if reload fails? Or log and potentially stop?
 					continue // Continue for now
 				}
 				if len(l.Usernames) > 0 {
 					l.fetchAllUserTracks(context.Background())
 				} else {
-					l.logger.Println("No Last.fm users configured. Skipping fetch cycle.")
+					l.logger.Debug("no Last.fm users configured, skipping fetch cycle")
 				}
 				// TODO: Implement graceful shutdown using context cancellation
 				// case <-ctx.Done():
-				//  l.logger.Println("Stopping Last.fm listening tracker.")
+				//  l.logger.Info("stopping Last.fm listening tracker")
 				//	ticker.Stop()
 				//  return
 			}
 		}
 	}()
 
-	l.logger.Printf("Last.fm Listening Tracker started with interval %v", interval)
+	l.logger.Info("listening tracker started", "interval", interval)
 }
 
 // fetchAllUserTracks iterates through users and fetches their tracks.
 func (l *Service) fetchAllUserTracks(ctx context.Context) {
-	l.logger.Printf("Starting fetch cycle for %d users...", len(l.Usernames))
+	l.logger.DebugContext(ctx, "starting fetch cycle", "users", len(l.Usernames))
 	var wg sync.WaitGroup                             // Use WaitGroup to fetch concurrently (optional)
 	fetchErrors := make(chan error, len(l.Usernames)) // Channel for errors
+	workers := make(chan struct{}, l.workers)         // Bounds how many users are fetched at once
 
 	for _, username := range l.Usernames {
 		if ctx.Err() != nil {
-			l.logger.Printf("Context cancelled before starting fetch for user %s.", username)
+			l.logger.InfoContext(ctx, "context cancelled before starting fetch", "lastfm_user", username)
 			break // Exit loop if context is cancelled
 		}
 
+		workers <- struct{}{}
+		if l.rateLimited() {
+			// the rest are fetched in a later cycle, nothing is missed as fetches start from the last scrobble
+			<-workers
+			break
+		}
+
 		wg.Add(1)
 		go func(uname string) { // Launch fetch and process in a goroutine per user
 			defer wg.Done()
+			defer func() { <-workers }()
 			if ctx.Err() != nil {
-				l.logger.Printf("Context cancelled during fetch cycle for user %s.", uname)
+				l.logger.InfoContext(ctx, "context cancelled during fetch cycle", "lastfm_user", uname)
 				return // Exit goroutine if context is cancelled
 			}
 
 			// Fetch slightly more than 1 track to better handle edge cases
 			// where the latest is 'now playing' or duplicates exist.
+			start := time.Now()
 			recentTracks, err := l.getRecentTracks(ctx, uname)
+			metrics.ObservePoll(events.SourceLastFM, start, err)
+			l.health.Observe(err)
+			var limited *ratelimit.RetryAfterError
+			if errors.As(err, &limited) {
+				l.backOff(ctx, limited.RetryAfter)
+				return
+			}
 			if err != nil {
-				l.logger.Printf("Error fetching tracks for %s: %v", uname, err)
+				l.logger.ErrorContext(ctx, "error fetching tracks", "lastfm_user", uname, logging.Err(err))
+				l.connections.Failure(ctx, l.userIDs[uname], err)
 				fetchErrors <- fmt.Errorf("fetch failed for %s: %w", uname, err) // Report error
 				return
 			}
+			l.connections.Success(ctx, l.userIDs[uname])
 
 			if recentTracks == nil || len(recentTracks.RecentTracks.Tracks) == 0 {
-				l.logger.Printf("No tracks returned for user %s", uname)
+				l.logger.DebugContext(ctx, "no tracks returned", "lastfm_user", uname)
 				return
 			}
 
 			// Process the fetched tracks
 			if err := l.processTracks(ctx, uname, recentTracks.RecentTracks.Tracks); err != nil {
-				l.logger.Printf("Error processing tracks for %s: %v", uname, err)
+				l.logger.ErrorContext(ctx, "error processing tracks", "lastfm_user", uname, logging.Err(err))
 				fetchErrors <- fmt.Errorf("process failed for %s: %w", uname, err) // Report error
 			}
 		}(username)
@@ -285,14 +353,14 @@ func (l *Service) fetchAllUserTracks(ctx context.Context) {
 	// Log any errors that occurred during the fetch cycle
 	errorCount := 0
 	for err := range fetchErrors {
-		l.logger.Printf("Fetch cycle error: %v", err)
+		l.logger.DebugContext(ctx, "fetch cycle error", logging.Err(err))
 		errorCount++
 	}
 
 	if errorCount > 0 {
-		l.logger.Printf("Finished fetch cycle with %d errors.", errorCount)
+		l.logger.WarnContext(ctx, "finished fetch cycle with errors", "errors", errorCount)
 	} else {
-		l.logger.Println("Finished fetch cycle successfully.")
+		l.logger.DebugContext(ctx, "finished fetch cycle")
 	}
 }
 
@@ -310,11 +378,12 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 	if err != nil {
 		return fmt.Errorf("failed to get last scrobble timestamp for %s: %w", username, err)
 	}
+	logger := l.logger.With(logging.UserID(user.ID), "lastfm_user", username)
 
 	if lastKnownTimestamp == nil {
-		l.logger.Printf("no previous scrobble timestamp found for user %s. processing latest track.", username)
+		logger.DebugContext(ctx, "no previous scrobble timestamp found, processing latest track")
 	} else {
-		l.logger.Printf("last known scrobble for %s was at %s", username, lastKnownTimestamp.Format(time.RFC3339))
+		logger.DebugContext(ctx, "last known scrobble", "at", lastKnownTimestamp.Format(time.RFC3339))
 	}
 
 	var (
@@ -325,15 +394,15 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 	// handle now playing track separately
 	if len(tracks) > 0 && tracks[0].Attr != nil && tracks[0].Attr.NowPlaying == "true" {
 		nowPlayingTrack := tracks[0]
-		l.logger.Printf("now playing track for %s: %s - %s", username, nowPlayingTrack.Artist.Text, nowPlayingTrack.Name)
+		logger.DebugContext(ctx, "now playing", "track", nowPlayingTrack.Artist.Text+" - "+nowPlayingTrack.Name)
 		l.mu.Lock()
 		lastSeen, existed := l.lastSeenNowPlaying[username]
 		// if our current track matches with last seen
 		// just compare artist/album/name for now
 		if existed && lastSeen.Album == nowPlayingTrack.Album && lastSeen.Name == nowPlayingTrack.Name && lastSeen.Artist == nowPlayingTrack.Artist {
-			l.logger.Printf("current track matches last seen track for %s", username)
+			logger.DebugContext(ctx, "current track matches last seen track")
 		} else {
-			l.logger.Printf("current track does not match last seen track for %s", username)
+			logger.DebugContext(ctx, "current track does not match last seen track")
 			// aha! we record this!
 			l.lastSeenNowPlaying[username] = nowPlayingTrack
 
@@ -342,7 +411,7 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 				// Convert Last.fm track to models.Track format
 				piperTrack := l.convertLastFMTrackToModelsTrack(nowPlayingTrack)
 				if err := l.playingNowService.PublishPlayingNow(ctx, user.ID, piperTrack); err != nil {
-					l.logger.Printf("Error publishing playing now for user %s: %v", username, err)
+					logger.ErrorContext(ctx, "error publishing playing now", logging.Err(err))
 				}
 			}
 		}
@@ -351,7 +420,7 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 		// No now playing track - clear playing now status
 		if l.playingNowService != nil {
 			if err := l.playingNowService.ClearPlayingNow(ctx, user.ID); err != nil {
-				l.logger.Printf("Error clearing playing now for user %s: %v", username, err)
+				logger.ErrorContext(ctx, "error clearing playing now", logging.Err(err))
 			}
 		}
 	}
@@ -366,24 +435,22 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 	}
 
 	if lastNonNowPlaying == nil {
-		l.logger.Printf("no non-now-playing tracks found for user %s.", username)
+		logger.DebugContext(ctx, "no non-now-playing tracks found")
 		return nil
 	}
 
 	latestTrackTime := lastNonNowPlaying.Date
 
-	// print both
-	l.logger.Printf("latestTrackTime: %s\n", latestTrackTime)
-	l.logger.Printf("lastKnownTimestamp: %s\n", lastKnownTimestamp)
+	logger.DebugContext(ctx, "comparing latest scrobble", "latest", latestTrackTime, "last_known", lastKnownTimestamp)
 
 	if lastKnownTimestamp != nil && lastKnownTimestamp.Equal(latestTrackTime.Time) {
-		l.logger.Printf("no new tracks to process for user %s.", username)
+		logger.DebugContext(ctx, "no new tracks to process")
 		return nil
 	}
 
 	for _, track := range tracks {
 		if track.Date == nil {
-			l.logger.Printf("skipping track without timestamp for %s: %s - %s", username, track.Artist.Text, track.Name)
+			logger.DebugContext(ctx, "skipping track without timestamp", "track", track.Artist.Text+" - "+track.Name)
 			continue
 		}
 
@@ -391,8 +458,7 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 		// before or at last known
 		if lastKnownTimestamp != nil && (trackTime.Before(*lastKnownTimestamp) || trackTime.Equal(*lastKnownTimestamp)) {
 			if processedCount == 0 {
-				l.logger.Printf("reached already known scrobbles for user %s (track time: %s, last known: %s).",
-					username, trackTime.Format(time.RFC3339), lastKnownTimestamp.Format(time.RFC3339))
+				logger.DebugContext(ctx, "reached already known scrobbles", "track_time", trackTime.Format(time.RFC3339), "last_known", lastKnownTimestamp.Format(time.RFC3339))
 			}
 			break
 		}
@@ -414,18 +480,25 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 
 		hydratedTrack, err := musicbrainz.HydrateTrack(l.musicBrainzService, baseTrack)
 		if err != nil {
-			l.logger.Printf("error hydrating track for user %s: %s - %s: %v", username, track.Artist.Text, track.Name, err)
+			logger.WarnContext(ctx, "error hydrating track", "track", track.Artist.Text+" - "+track.Name, logging.Err(err))
 			// we can use the track without MBIDs, it's still valid
 			hydratedTrack = &baseTrack
 		}
+		// scrobbles are finished plays, but the duration MusicBrainz found may
+		// still be too short for the user's policy
+		if !stamping.Stamps(l.stamping.For(ctx, user.ID, events.SourceLastFM), hydratedTrack.DurationMs, hydratedTrack.DurationMs) {
+			logger.DebugContext(ctx, "scrobble not stamped under stamping policy", logging.Track(hydratedTrack))
+			continue
+		}
 		_, err = l.db.SaveTrack(user.ID, hydratedTrack)
 		if err != nil {
 			return err
 		}
-		l.logger.Printf("Submitting track")
+		l.events.PublishStamped(user.ID, hydratedTrack, events.SourceLastFM)
+		logger.DebugContext(ctx, "submitting track", logging.Track(hydratedTrack))
 		err = l.SubmitTrackToPDS(*user.ATProtoDID, *user.MostRecentAtProtoSessionID, hydratedTrack, ctx)
 		if err != nil {
-			l.logger.Printf("error submitting track for user %s: %s - %s: %v", username, track.Artist.Text, track.Name, err)
+			logger.ErrorContext(ctx, "error submitting track", logging.Track(hydratedTrack), logging.Err(err))
 		}
 		processedCount++
 
@@ -439,13 +512,55 @@ func (l *Service) processTracks(ctx context.Context, username string, tracks []T
 	}
 
 	if processedCount > 0 {
-		l.logger.Printf("processed %d new track(s) for user %s. latest timestamp: %s",
-			processedCount, username, latestProcessedTime.Format(time.RFC3339))
+		logger.InfoContext(ctx, "processed new tracks", "count", processedCount, "latest", latestProcessedTime.Format(time.RFC3339))
 	}
 
 	return nil
 }
 
+// WithEvents publishes imported tracks to bus
+func (l *Service) WithEvents(bus *events.Bus) *Service {
+	l.events = bus
+	return l
+}
+
+// WithHealth reports the outcome of each poll to t
+func (l *Service) WithHealth(t *health.Tracker) *Service {
+	l.health = t
+	return l
+}
+
+// WithStamping judges scrobbles under each user's policy from r instead of the default
+func (l *Service) WithStamping(r *stamping.Resolver) *Service {
+	l.stamping = r
+	return l
+}
+
+// WithWorkers bounds how many users are fetched at once
+func (l *Service) WithWorkers(n int) *Service {
+	if n > 0 {
+		l.workers = n
+	}
+	return l
+}
+
+func (l *Service) rateLimited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.retryAfter)
+}
+
+// backOff stops fetching until Last.fm's Retry-After has passed
+func (l *Service) backOff(ctx context.Context, d time.Duration) {
+	l.mu.Lock()
+	until := time.Now().Add(d)
+	if until.After(l.retryAfter) {
+		l.retryAfter = until
+	}
+	l.mu.Unlock()
+	l.logger.WarnContext(ctx, "rate limited by Last.fm, pausing fetches", "retry_after", d)
+}
+
 func (l *Service) SubmitTrackToPDS(did string, mostRecentAtProtoSessionID string, track *models.Track, ctx context.Context) error {
 	// Use shared atproto service for submission
 	return atprotoservice.SubmitPlayToPDS(ctx, did, mostRecentAtProtoSessionID, track, l.atprotoService)
@@ -6,10 +6,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"sort"
 	"strings"
 	"sync"
@@ -18,6 +17,8 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/models"
 )
 
@@ -87,7 +88,7 @@ type Service struct {
 	cacheMutex  sync.RWMutex          // Mutex to protect the cache
 	cacheTTL    time.Duration         // Time-to-live for cache entries
 	cleaner     MetadataCleaner       // Cleaner for cleaning up expired cache entries
-	logger      *log.Logger           // Logger for logging
+	logger      *slog.Logger          // Logger for logging
 }
 
 // NewMusicBrainzService creates a new service instance with rate limiting and caching.
@@ -96,11 +97,12 @@ func NewMusicBrainzService(db *db.DB) *Service {
 	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
 	// Set a default cache TTL (e.g., 1 hour)
 	defaultCacheTTL := 1 * time.Hour
-	logger := log.New(os.Stdout, "musicbrainz: ", log.LstdFlags|log.Lmsgprefix)
+	logger := logging.For("musicbrainz")
 	return &Service{
 		db: db,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: metrics.NewTransport("musicbrainz", nil),
 		},
 		limiter:     limiter,
 		searchCache: make(map[string]cacheEntry),  // Initialize the cache map
@@ -194,41 +196,71 @@ func (s *Service) SearchMusicBrainz(ctx context.Context, params SearchParams) ([
 	s.cacheMutex.RLock()
 	if recordings, found := getCacheEntry(s.searchCache, cacheKey); found {
 		s.cacheMutex.RUnlock()
-		s.logger.Printf("Cache hit for MusicBrainz search: key=%s", cacheKey)
+		metrics.ObserveMusicBrainzCache(true)
+		s.logger.DebugContext(ctx, "search cache hit", "query", cacheKey)
 		return recordings, nil
 	}
 	s.cacheMutex.RUnlock()
 
-	s.logger.Printf("Cache miss for MusicBrainz search: key=%s", cacheKey)
+	metrics.ObserveMusicBrainzCache(false)
+	s.logger.DebugContext(ctx, "search cache miss", "query", cacheKey)
 
 	query := buildSearchQuery(params)
 	endpoint := buildSearchEndpoint(query)
 
+	start := time.Now()
+	result, err := s.search(ctx, endpoint)
+	metrics.ObserveMusicBrainzRequest(start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cacheMutex.Lock()
+	setCacheEntry(s.searchCache, cacheKey, result.Recordings, s.cacheTTL)
+	s.cacheMutex.Unlock()
+	s.logger.DebugContext(ctx, "cached search result", "query", cacheKey, "ttl", s.cacheTTL)
+
+	return result.Recordings, nil
+}
+
+// search waits for the rate limiter and runs a single search request
+func (s *Service) search(ctx context.Context, endpoint string) (SearchResponse, error) {
 	if err := s.limiter.Wait(ctx); err != nil {
-		return nil, fmt.Errorf("rate limiter error: %w", err)
+		return SearchResponse{}, fmt.Errorf("rate limiter error: %w", err)
 	}
 
 	resp, err := executeRequest(ctx, s.httpClient, endpoint)
 	if err != nil {
-		return nil, err
+		return SearchResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("MusicBrainz API request to %s returned status %d", endpoint, resp.StatusCode)
+		return SearchResponse{}, fmt.Errorf("MusicBrainz API request to %s returned status %d", endpoint, resp.StatusCode)
 	}
 
-	result, err := decodeResponse(resp, endpoint)
-	if err != nil {
-		return nil, err
+	return decodeResponse(resp, endpoint)
+}
+
+// pingEndpoint is a small lookup used to check MusicBrainz is reachable
+const pingEndpoint = "https://musicbrainz.org/ws/2/genre/all?limit=1&fmt=json"
+
+// Ping checks that the MusicBrainz API answers, sharing the search rate limit
+func (s *Service) Ping(ctx context.Context) error {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("rate limiter error: %w", err)
 	}
 
-	s.cacheMutex.Lock()
-	setCacheEntry(s.searchCache, cacheKey, result.Recordings, s.cacheTTL)
-	s.cacheMutex.Unlock()
-	s.logger.Printf("Cached MusicBrainz search result for key=%s, TTL=%s", cacheKey, s.cacheTTL)
+	resp, err := executeRequest(ctx, s.httpClient, pingEndpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	return result.Recordings, nil
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MusicBrainz API returned status %d", resp.StatusCode)
+	}
+	return nil
 }
 
 // isOfficialAlbum checks if a release is an official album (not a compilation, EP, promo, etc.)
@@ -337,7 +369,7 @@ func (s *Service) GetBestRelease(releases []Release, trackTitle string, expected
 	}
 
 	// 6. If none found, return the oldest release overall (which is the first one after sorting)
-	s.logger.Printf("Could not find a suitable release for '%s', picking oldest: '%s' (%s)", trackTitle, releases[0].Title, releases[0].ID)
+	s.logger.Debug("no suitable release found, picking oldest", "track", trackTitle, "release", releases[0].Title, "release_id", releases[0].ID)
 	r := releases[0]
 	return &r
 }
@@ -359,11 +391,11 @@ func HydrateTrack(mb *Service, track models.Track) (*models.Track, error) {
 	if track.ISRC != "" {
 		isrcRes, err := mb.SearchMusicBrainz(ctx, SearchParams{ISRC: track.ISRC})
 		if err != nil {
-			mb.logger.Printf("ISRC lookup failed for %s, falling back to full query: %v", track.ISRC, err)
+			mb.logger.Warn("ISRC lookup failed, falling back to full query", "isrc", track.ISRC, logging.Err(err))
 		} else if len(isrcRes) > 0 {
 			res = isrcRes
 		} else {
-			mb.logger.Printf("ISRC lookup returned no results for %s, falling back to full query", track.ISRC)
+			mb.logger.Debug("ISRC lookup returned no results, falling back to full query", "isrc", track.ISRC)
 		}
 	}
 
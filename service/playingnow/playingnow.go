@@ -4,8 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -16,8 +15,10 @@ import (
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/teal-fm/piper/api/teal"
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/service/events"
 	"github.com/teal-fm/piper/service/musicbrainz"
 )
 
@@ -25,27 +26,102 @@ import (
 type Service struct {
 	db             *db.DB
 	atprotoService *atprotoauth.AuthService
-	logger         *log.Logger
+	logger         *slog.Logger
 	mu             sync.RWMutex
 	mb             *musicbrainz.Service
 	clearedStatus  map[int64]bool // tracks if a user's status has been cleared on their repo
+	nowPlaying     map[int64]nowPlaying
+	watchers       map[int64]map[chan State]struct{} // now playing streams per user
+	events         *events.Bus
 }
 
+// nowPlaying is the last track a user started playing, kept until it should have finished
+type nowPlaying struct {
+	track     models.Track
+	expiresAt time.Time
+}
+
+// defaultNowPlayingExpiry is used when the track has no known duration
+const defaultNowPlayingExpiry = 10 * time.Minute
+
 // NewPlayingNowService creates a new playing now service
 func NewPlayingNowService(database *db.DB, atprotoService *atprotoauth.AuthService, mb *musicbrainz.Service) *Service {
-	logger := log.New(os.Stdout, "playingnow: ", log.LstdFlags|log.Lmsgprefix)
+	logger := logging.For("playingnow")
 
 	return &Service{
 		db:             database,
 		atprotoService: atprotoService,
 		logger:         logger,
 		clearedStatus:  make(map[int64]bool),
+		nowPlaying:     make(map[int64]nowPlaying),
+		watchers:       make(map[int64]map[chan State]struct{}),
 		mb:             mb,
 	}
 }
 
+// WithEvents publishes now playing changes to bus
+func (p *Service) WithEvents(bus *events.Bus) *Service {
+	p.events = bus
+	return p
+}
+
+// ForgetUser drops the cached status state for a user
+func (p *Service) ForgetUser(user *models.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.clearedStatus, user.ID)
+	delete(p.nowPlaying, user.ID)
+}
+
+// GetPlayingNow returns the track a user is currently playing, if any
+func (p *Service) GetPlayingNow(userID int64) (*models.Track, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	playing, ok := p.nowPlaying[userID]
+	if !ok || time.Now().After(playing.expiresAt) {
+		return nil, false
+	}
+	track := playing.track
+	return &track, true
+}
+
+func (p *Service) setPlayingNow(userID int64, track *models.Track) {
+	expiry := defaultNowPlayingExpiry
+	if remaining := track.DurationMs - track.ProgressMs; remaining > 0 {
+		expiry = time.Duration(remaining) * time.Millisecond
+	}
+	now := time.Now()
+
+	p.mu.Lock()
+	previous, ok := p.nowPlaying[userID]
+	p.nowPlaying[userID] = nowPlaying{track: *track, expiresAt: now.Add(expiry)}
+	p.mu.Unlock()
+	p.notify(userID)
+
+	// sources report the same track again while it plays, only a new one is a change
+	if !ok || now.After(previous.expiresAt) || !sameTrack(&previous.track, track) {
+		p.events.Publish(events.Event{Type: events.NowPlayingChanged, UserID: userID, Track: *track})
+	}
+}
+
+func sameTrack(a, b *models.Track) bool {
+	if a.Name != b.Name || len(a.Artist) != len(b.Artist) {
+		return false
+	}
+	for i := range a.Artist {
+		if a.Artist[i].Name != b.Artist[i].Name {
+			return false
+		}
+	}
+	return a.URL == b.URL
+}
+
 // PublishPlayingNow publishes a currently playing track as actor status
 func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *models.Track) error {
+	// Remember the track even when the user has no repo to publish it to
+	p.setPlayingNow(userID, track)
+
 	// Get user information to find their DID
 	user, err := p.db.GetUserByID(userID)
 	if err != nil {
@@ -56,7 +132,7 @@ func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *mo
 	}
 
 	if user.ATProtoDID == nil {
-		p.logger.Printf("User %d has no ATProto DID, skipping playing now", userID)
+		p.logger.DebugContext(ctx, "user has no ATProto DID, skipping playing now", logging.UserID(userID))
 		return nil
 	}
 
@@ -70,9 +146,9 @@ func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *mo
 
 	hydratedTrack, err := musicbrainz.HydrateTrack(p.mb, *track)
 	if err != nil {
-		p.logger.Printf("User %d: Error hydrating track '%s' with MusicBrainz: %v", userID, track.Name, err)
+		p.logger.WarnContext(ctx, "error hydrating track with MusicBrainz", logging.UserID(userID), logging.Track(track), logging.Err(err))
 	} else {
-		p.logger.Printf("User %d: Successfully hydrated track '%s'", userID, track.Name)
+		p.logger.DebugContext(ctx, "hydrated track", logging.UserID(userID), logging.Track(track))
 		track = hydratedTrack
 	}
 
@@ -104,7 +180,7 @@ func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *mo
 		swapCid = swapRecord.Cid
 	}
 
-	p.logger.Printf("Publishing playing now status for user %d (DID: %s): %s - %s", userID, did, track.Artist[0].Name, track.Name)
+	p.logger.InfoContext(ctx, "publishing playing now status", logging.UserID(userID), logging.DID(did), logging.Track(track))
 
 	// Create the record input
 	input := comatproto.RepoPutRecord_Input{
@@ -117,7 +193,7 @@ func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *mo
 
 	// Submit to PDS
 	if _, err := comatproto.RepoPutRecord(ctx, atProtoClient, &input); err != nil {
-		p.logger.Printf("Error creating playing now status for DID %s: %v", did, err)
+		p.logger.ErrorContext(ctx, "error creating playing now status", logging.UserID(userID), logging.DID(did), logging.Err(err))
 		return fmt.Errorf("failed to create playing now status for DID %s: %w", did, err)
 	}
 
@@ -133,9 +209,16 @@ func (p *Service) PublishPlayingNow(ctx context.Context, userID int64, track *mo
 // ClearPlayingNow removes the current playing status by setting an expired status
 func (p *Service) ClearPlayingNow(ctx context.Context, userID int64) error {
 	// Check if status is already cleared to avoid clearing on the users repo over and over
-	p.mu.RLock()
+	p.mu.Lock()
+	previous, wasPlaying := p.nowPlaying[userID]
+	delete(p.nowPlaying, userID)
 	alreadyCleared := p.clearedStatus[userID]
-	p.mu.RUnlock()
+	p.mu.Unlock()
+
+	if wasPlaying && time.Now().Before(previous.expiresAt) {
+		p.notify(userID)
+		p.events.Publish(events.Event{Type: events.NowPlayingCleared, UserID: userID, Track: previous.track})
+	}
 
 	if alreadyCleared {
 		return nil
@@ -152,7 +235,7 @@ func (p *Service) ClearPlayingNow(ctx context.Context, userID int64) error {
 	}
 
 	if user.ATProtoDID == nil {
-		p.logger.Printf("User %d has no ATProto DID, skipping clear playing now", userID)
+		p.logger.DebugContext(ctx, "user has no ATProto DID, skipping clear playing now", logging.UserID(userID))
 		return nil
 	}
 
@@ -203,11 +286,11 @@ func (p *Service) ClearPlayingNow(ctx context.Context, userID int64) error {
 	}
 
 	if _, err := comatproto.RepoPutRecord(ctx, atProtoClient, &input); err != nil {
-		p.logger.Printf("Error clearing playing now status for DID %s: %v", did, err)
+		p.logger.ErrorContext(ctx, "error clearing playing now status", logging.UserID(userID), logging.DID(did), logging.Err(err))
 		return fmt.Errorf("failed to clear playing now status for DID %s: %w", did, err)
 	}
 
-	p.logger.Printf("Successfully cleared playing now status for user %d (DID: %s)", userID, did)
+	p.logger.InfoContext(ctx, "cleared playing now status", logging.UserID(userID), logging.DID(did))
 
 	// Mark status as cleared so we don't clear again until user starts playing a song again
 	p.mu.Lock()
@@ -271,6 +354,13 @@ func (p *Service) trackToPlayView(track *models.Track) (*teal.AlphaFeedDefs_Play
 	if submissionAgent == "" {
 		submissionAgent = models.SubmissionAgent
 	}
+	// plays relayed from another scrobbler keep the client that submitted them
+	if track.SubmissionClient != "" {
+		submissionAgent = track.SubmissionClient
+		if track.SubmissionClientVersion != "" {
+			submissionAgent += "/" + track.SubmissionClientVersion
+		}
+	}
 
 	playView := &teal.AlphaFeedDefs_PlayView{
 		TrackName:              track.Name,
@@ -279,6 +369,7 @@ func (p *Service) trackToPlayView(track *models.Track) (*teal.AlphaFeedDefs_Play
 		PlayedTime:             playedTimeStr,
 		RecordingMbId:          track.RecordingMBID,
 		ReleaseMbId:            track.ReleaseMBID,
+		TrackMbId:              track.ReleaseTrackMBID,
 		ReleaseName:            releaseNamePtr,
 		Isrc:                   isrcPtr,
 		OriginUrl:              originUrlPtr,
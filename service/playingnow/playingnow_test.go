@@ -1,11 +1,13 @@
 package playingnow
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/teal-fm/piper/db"
 	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/service/events"
 )
 
 func TestTrackToPlayView(t *testing.T) {
@@ -142,3 +144,37 @@ func TestTrackToPlayViewMinimal(t *testing.T) {
 		t.Errorf("Expected release name to be nil for minimal track")
 	}
 }
+
+func TestNowPlayingEvents(t *testing.T) {
+	bus := events.NewBus()
+	var got []events.Type
+	bus.Subscribe(func(e events.Event) { got = append(got, e.Type) })
+
+	p := NewPlayingNowService(nil, nil, nil).WithEvents(bus)
+	// already cleared, so ClearPlayingNow doesn't need a PDS
+	p.clearedStatus[1] = true
+
+	track := &models.Track{Name: "Get Lucky", Artist: []models.Artist{{Name: "Daft Punk"}}, DurationMs: 248000}
+	p.setPlayingNow(1, track)
+	progressed := *track
+	progressed.ProgressMs = 60000
+	p.setPlayingNow(1, &progressed)
+	p.setPlayingNow(1, &models.Track{Name: "Instant Crush", Artist: []models.Artist{{Name: "Daft Punk"}}, DurationMs: 337000})
+	if err := p.ClearPlayingNow(context.Background(), 1); err != nil {
+		t.Fatalf("ClearPlayingNow failed: %v", err)
+	}
+	// nothing playing, nothing to clear
+	if err := p.ClearPlayingNow(context.Background(), 1); err != nil {
+		t.Fatalf("ClearPlayingNow failed: %v", err)
+	}
+
+	want := []events.Type{events.NowPlayingChanged, events.NowPlayingChanged, events.NowPlayingCleared}
+	if len(got) != len(want) {
+		t.Fatalf("Got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Event %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
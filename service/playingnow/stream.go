@@ -0,0 +1,182 @@
+package playingnow
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/session"
+)
+
+// streamKeepAlive how often a comment is sent on idle streams so proxies don't close them
+const streamKeepAlive = 25 * time.Second
+
+// State a user's now playing state, as served by the now playing API
+type State struct {
+	Playing bool          `json:"playing"`
+	Track   *models.Track `json:"track,omitempty"`
+	// ExpiresAt when the track should have finished if no update comes in
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// GetState returns what a user is playing right now
+func (p *Service) GetState(userID int64) State {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.stateLocked(userID, time.Now())
+}
+
+func (p *Service) stateLocked(userID int64, now time.Time) State {
+	playing, ok := p.nowPlaying[userID]
+	if !ok || now.After(playing.expiresAt) {
+		return State{UpdatedAt: now.UTC()}
+	}
+	track := playing.track
+	expiresAt := playing.expiresAt.UTC()
+	return State{Playing: true, Track: &track, ExpiresAt: &expiresAt, UpdatedAt: now.UTC()}
+}
+
+// Watch returns a channel receiving a user's state every time it is updated by
+// any source. Only the latest state is kept for slow readers. stop has to be
+// called once the caller is done
+func (p *Service) Watch(userID int64) (updates <-chan State, stop func()) {
+	ch := make(chan State, 1)
+
+	p.mu.Lock()
+	if p.watchers[userID] == nil {
+		p.watchers[userID] = make(map[chan State]struct{})
+	}
+	p.watchers[userID][ch] = struct{}{}
+	p.mu.Unlock()
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.watchers[userID], ch)
+		if len(p.watchers[userID]) == 0 {
+			delete(p.watchers, userID)
+		}
+	}
+}
+
+// notify sends the current state to everyone watching the user
+func (p *Service) notify(userID int64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	watchers := p.watchers[userID]
+	if len(watchers) == 0 {
+		return
+	}
+	state := p.stateLocked(userID, time.Now())
+	for ch := range watchers {
+		// replace a state the reader hasn't picked up yet
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if data != nil {
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			slog.Error("error encoding JSON response", logging.Err(err))
+		}
+	}
+}
+
+// HandleNowPlaying returns what the user is playing on any connected service
+func (p *Service) HandleNowPlaying(w http.ResponseWriter, r *http.Request) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+	jsonResponse(w, http.StatusOK, p.GetState(userID))
+}
+
+// HandleStream streams the user's now playing state as Server-Sent Events. The
+// current state is sent straight away as a "now-playing" event, followed by
+// another one whenever it changes or the track runs out
+func (p *Service) HandleStream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream outlives the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		p.logger.ErrorContext(r.Context(), "error clearing write deadline for now playing stream", logging.Err(err))
+	}
+
+	updates, stop := p.Watch(userID)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	expiry := time.NewTimer(0)
+	if !expiry.Stop() {
+		<-expiry.C
+	}
+	defer expiry.Stop()
+
+	send := func(state State) bool {
+		data, err := json.Marshal(state)
+		if err != nil {
+			p.logger.DebugContext(r.Context(), "error writing now playing state", logging.Err(err))
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: now-playing\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		expiry.Stop()
+		if state.Playing && state.ExpiresAt != nil {
+			expiry.Reset(time.Until(*state.ExpiresAt))
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send(p.GetState(userID)) {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case state := <-updates:
+			if !send(state) {
+				return
+			}
+		case <-expiry.C:
+			// the track ran out without an update
+			if !send(p.GetState(userID)) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package playingnow
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/session"
+)
+
+// readEvent reads the next now-playing event off a stream, skipping keep-alives
+func readEvent(t *testing.T, r *bufio.Reader) State {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var state State
+			if err := json.Unmarshal([]byte(data), &state); err != nil {
+				t.Fatalf("Invalid event data %q: %v", data, err)
+			}
+			return state
+		}
+	}
+}
+
+func TestNowPlayingStream(t *testing.T) {
+	p := NewPlayingNowService(nil, nil, nil)
+	p.clearedStatus[1] = true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.HandleStream(w, r.WithContext(session.WithUserID(r.Context(), 1)))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	if state := readEvent(t, reader); state.Playing {
+		t.Fatalf("Expected nothing playing initially, got %+v", state)
+	}
+
+	p.setPlayingNow(1, &models.Track{Name: "Get Lucky", Artist: []models.Artist{{Name: "Daft Punk"}}, DurationMs: 248000})
+	state := readEvent(t, reader)
+	if !state.Playing || state.Track == nil || state.Track.Name != "Get Lucky" || state.ExpiresAt == nil {
+		t.Fatalf("Expected Get Lucky to be playing, got %+v", state)
+	}
+
+	// updates for other users don't reach the stream
+	p.setPlayingNow(2, &models.Track{Name: "Other", DurationMs: 1000})
+
+	if err := p.ClearPlayingNow(t.Context(), 1); err != nil {
+		t.Fatalf("ClearPlayingNow failed: %v", err)
+	}
+	if state := readEvent(t, reader); state.Playing {
+		t.Fatalf("Expected the stream to report the track cleared, got %+v", state)
+	}
+}
+
+func TestNowPlayingStreamExpiry(t *testing.T) {
+	p := NewPlayingNowService(nil, nil, nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.HandleStream(w, r.WithContext(session.WithUserID(r.Context(), 1)))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	readEvent(t, reader)
+
+	start := time.Now()
+	p.setPlayingNow(1, &models.Track{Name: "Short", DurationMs: 200})
+	if state := readEvent(t, reader); !state.Playing {
+		t.Fatalf("Expected the track to be playing, got %+v", state)
+	}
+	if state := readEvent(t, reader); state.Playing {
+		t.Fatalf("Expected the track to run out, got %+v", state)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Errorf("Track ran out too early")
+	}
+	p.mu.RLock()
+	watching := len(p.watchers[1])
+	p.mu.RUnlock()
+	if watching != 1 {
+		t.Errorf("Expected one watcher, got %d", watching)
+	}
+}
@@ -0,0 +1,281 @@
+package profile
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+// feedSize how many stamped plays a feed carries
+const feedSize = 50
+
+// feedItem a play as it appears in every feed format
+type feedItem struct {
+	ID       string
+	Title    string
+	Content  string
+	URL      string
+	PlayedAt time.Time
+}
+
+// feed a user's recent plays before being written in one of the formats
+type feed struct {
+	Title   string
+	Author  string
+	Home    string
+	Self    string
+	Updated time.Time
+	Items   []feedItem
+}
+
+func (s *Service) loadFeed(user *profileUser, self string) (*feed, error) {
+	stamped := true
+	tracks, err := s.db.QueryTracks(user.ID, db.TrackQuery{Stamped: &stamped, Limit: feedSize})
+	if err != nil {
+		return nil, err
+	}
+
+	urls := urlsFor(user.Handle)
+	f := &feed{
+		Title:  user.Handle + "'s plays on Piper",
+		Author: user.Handle,
+		Home:   urls.Profile,
+		Self:   self,
+	}
+	for _, track := range tracks {
+		f.Items = append(f.Items, newFeedItem(track, urls.Profile))
+	}
+	if len(tracks) > 0 {
+		f.Updated = tracks[0].Timestamp.UTC()
+	}
+	return f, nil
+}
+
+func newFeedItem(track *models.Track, profileURL string) feedItem {
+	view := newTrackView(track)
+	title := view.Name
+	if view.Artists != "" {
+		title = view.Name + " – " + view.Artists
+	}
+	content := title
+	if view.Album != "" {
+		content += " (" + view.Album + ")"
+	}
+	link := view.URL
+	if link == "" {
+		link = profileURL
+	}
+	return feedItem{
+		ID:       profileURL + "#play-" + strconv.FormatInt(track.PlayID, 10),
+		Title:    title,
+		Content:  content,
+		URL:      link,
+		PlayedAt: track.Timestamp.UTC(),
+	}
+}
+
+// notModified sets the caching headers for a feed and reports whether the client's copy is current
+func notModified(w http.ResponseWriter, r *http.Request, format string, f *feed) bool {
+	if f.Updated.IsZero() {
+		return false
+	}
+	etag := fmt.Sprintf(`"%s-%d-%d"`, format, f.Updated.UnixNano(), len(f.Items))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", f.Updated.Format(http.TimeFormat))
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return match == etag || match == "*"
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !f.Updated.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// handleFeed resolves the user, loads their feed and writes it with write unless the client has it already
+func (s *Service) handleFeed(format, contentType string, self func(profileURLs) string, write func(http.ResponseWriter, *feed) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.publicUser(w, r)
+		if !ok {
+			return
+		}
+		f, err := s.loadFeed(user, self(urlsFor(user.Handle)))
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading feed", logging.UserID(user.ID), logging.Err(err))
+			http.Error(w, "Failed to load feed", http.StatusInternalServerError)
+			return
+		}
+
+		setCacheControl(w, user)
+		if notModified(w, r, format, f) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if err := write(w, f); err != nil {
+			s.logger.ErrorContext(r.Context(), "error writing feed", "format", format, logging.UserID(user.ID), logging.Err(err))
+		}
+	}
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Content string   `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func writeAtom(w http.ResponseWriter, f *feed) error {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
+	out := atomFeed{
+		ID:      f.Home,
+		Title:   f.Title,
+		Updated: updated.Format(time.RFC3339),
+		Author:  f.Author,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+			{Rel: "alternate", Type: "text/html", Href: f.Home},
+		},
+	}
+	for _, item := range f.Items {
+		out.Entries = append(out.Entries, atomEntry{
+			ID:      item.ID,
+			Title:   item.Title,
+			Link:    atomLink{Href: item.URL},
+			Updated: item.PlayedAt.Format(time.RFC3339),
+			Content: item.Content,
+		})
+	}
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(out)
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+func writeRSS(w http.ResponseWriter, f *feed) error {
+	out := rssFeed{Version: "2.0"}
+	out.Channel.Title = f.Title
+	out.Channel.Link = f.Home
+	out.Channel.Description = "Tracks " + f.Author + " listened to, as recorded by Piper"
+	if !f.Updated.IsZero() {
+		out.Channel.LastBuildDate = f.Updated.Format(time.RFC1123Z)
+	}
+	for _, item := range f.Items {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        rssGUID{Value: item.ID},
+			PubDate:     item.PlayedAt.Format(time.RFC1123Z),
+			Description: item.Content,
+		})
+	}
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(out)
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentText   string `json:"content_text"`
+	DatePublished string `json:"date_published"`
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Authors     []map[string]any `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+func writeJSONFeed(w http.ResponseWriter, f *feed) error {
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Home,
+		FeedURL:     f.Self,
+		Authors:     []map[string]any{{"name": f.Author, "url": f.Home}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, item := range f.Items {
+		out.Items = append(out.Items, jsonFeedItem{
+			ID:            item.ID,
+			URL:           item.URL,
+			Title:         item.Title,
+			ContentText:   item.Content,
+			DatePublished: item.PlayedAt.Format(time.RFC3339),
+		})
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// HandleAtomFeed serves the user's recent stamped plays as an Atom feed
+func (s *Service) HandleAtomFeed() http.HandlerFunc {
+	return s.handleFeed("atom", "application/atom+xml; charset=utf-8", func(u profileURLs) string { return u.Atom }, writeAtom)
+}
+
+// HandleRSSFeed serves the user's recent stamped plays as an RSS 2.0 feed
+func (s *Service) HandleRSSFeed() http.HandlerFunc {
+	return s.handleFeed("rss", "application/rss+xml; charset=utf-8", func(u profileURLs) string { return u.RSS }, writeRSS)
+}
+
+// HandleJSONFeed serves the user's recent stamped plays as a JSON Feed
+func (s *Service) HandleJSONFeed() http.HandlerFunc {
+	return s.handleFeed("json", "application/feed+json; charset=utf-8", func(u profileURLs) string { return u.JSON }, writeJSONFeed)
+}
@@ -0,0 +1,101 @@
+package profile
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/models"
+)
+
+func TestFeeds(t *testing.T) {
+	database, s, userID, _, mux := setup(t)
+	defer database.Close()
+	mux.HandleFunc("GET /u/{id}/feed.atom", s.HandleAtomFeed())
+	mux.HandleFunc("GET /u/{id}/feed.rss", s.HandleRSSFeed())
+	mux.HandleFunc("GET /u/{id}/feed.json", s.HandleJSONFeed())
+
+	if rr := get(mux, "/u/alice.test/feed.atom", 0); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected feeds of private profiles to 404, got %d", rr.Code)
+	}
+	if rr := get(mux, "/u/alice.test/feed.atom", userID); rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "private, no-store" {
+		t.Fatalf("Owner's private feed = %d, Cache-Control %q, want it uncached", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+	if err := database.SetPublicProfile(userID, true); err != nil {
+		t.Fatalf("SetPublicProfile failed: %v", err)
+	}
+
+	// the track from setup isn't stamped, only this one should show up
+	played := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	if _, err := database.SaveTrack(userID, &models.Track{
+		Name:       "One More Time",
+		Artist:     []models.Artist{{Name: "Daft Punk"}},
+		Album:      "Discovery",
+		URL:        "https://open.spotify.com/track/0DiWol3AO6WpXZgp0goxAV",
+		Timestamp:  played,
+		HasStamped: true,
+	}); err != nil {
+		t.Fatalf("Failed to save track: %v", err)
+	}
+
+	rr := get(mux, "/u/alice.test/feed.atom", 0)
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("Atom feed = %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("Invalid Atom feed: %v", err)
+	}
+	if len(atom.Entries) != 1 || atom.Entries[0].Title != "One More Time – Daft Punk" ||
+		atom.Entries[0].Link.Href != "https://open.spotify.com/track/0DiWol3AO6WpXZgp0goxAV" ||
+		atom.Entries[0].Updated != "2024-05-01T12:30:00Z" {
+		t.Errorf("Unexpected Atom entries: %+v", atom.Entries)
+	}
+
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Last-Modified") != played.Format(http.TimeFormat) {
+		t.Fatalf("Expected caching headers, got ETag %q Last-Modified %q", etag, rr.Header().Get("Last-Modified"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/u/alice.test/feed.atom", nil)
+	req.Header.Set("If-None-Match", etag)
+	cached := httptest.NewRecorder()
+	mux.ServeHTTP(cached, req)
+	if cached.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", cached.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/u/alice.test/feed.atom", nil)
+	req.Header.Set("If-Modified-Since", played.Add(-time.Minute).Format(http.TimeFormat))
+	stale := httptest.NewRecorder()
+	mux.ServeHTTP(stale, req)
+	if stale.Code != http.StatusOK {
+		t.Errorf("Expected the feed for an older copy, got %d", stale.Code)
+	}
+
+	rr = get(mux, "/u/alice.test/feed.rss", 0)
+	var rss rssFeed
+	if err := xml.Unmarshal(rr.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("Invalid RSS feed: %v", err)
+	}
+	if rss.Version != "2.0" || len(rss.Channel.Items) != 1 || rss.Channel.Items[0].PubDate != "Wed, 01 May 2024 12:30:00 +0000" {
+		t.Errorf("Unexpected RSS feed: %+v", rss)
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected each format to have its own ETag")
+	}
+
+	rr = get(mux, "/u/alice.test/feed.json", 0)
+	var jf jsonFeed
+	if err := json.Unmarshal(rr.Body.Bytes(), &jf); err != nil {
+		t.Fatalf("Invalid JSON Feed: %v", err)
+	}
+	if jf.Version != "https://jsonfeed.org/version/1.1" || len(jf.Items) != 1 || jf.Items[0].ContentText != "One More Time – Daft Punk (Discovery)" ||
+		!strings.HasSuffix(jf.FeedURL, "/u/alice.test/feed.json") {
+		t.Errorf("Unexpected JSON Feed: %+v", jf)
+	}
+}
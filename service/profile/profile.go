@@ -0,0 +1,506 @@
+// Package profile serves opt-in public profile pages and the embeddable now
+// playing badge and card for them
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/service/stamping"
+	"github.com/teal-fm/piper/service/stats"
+	"github.com/teal-fm/piper/session"
+)
+
+const (
+	recentPlays    = 20
+	topArtists     = 10
+	topArtistRange = "30d"
+	// how long embeds may be cached, they are polled by pages showing them
+	embedMaxAge = 30
+	// longest text shown on the badge before it is cut off
+	badgeMaxText = 60
+)
+
+// IdentityResolver resolves handles and DIDs, implemented by the ATProto auth service
+type IdentityResolver interface {
+	LookupIdentity(ctx context.Context, identifier string) (*identity.Identity, error)
+}
+
+// NowPlayingSource what a user is playing right now
+type NowPlayingSource interface {
+	GetPlayingNow(userID int64) (*models.Track, bool)
+}
+
+type Service struct {
+	db         *db.DB
+	identities IdentityResolver
+	playingNow NowPlayingSource
+	stats      *stats.Service
+	stamping   *stamping.Resolver
+	logger     *slog.Logger
+}
+
+func NewProfileService(database *db.DB, identities IdentityResolver, playingNow NowPlayingSource, statsService *stats.Service) *Service {
+	return &Service{
+		db:         database,
+		identities: identities,
+		playingNow: playingNow,
+		stats:      statsService,
+		logger:     logging.For("profile"),
+	}
+}
+
+// WithStamping lets users pick their stamping policy on the settings page
+func (s *Service) WithStamping(r *stamping.Resolver) *Service {
+	s.stamping = r
+	return s
+}
+
+// profileUser a user found from the handle or DID in a profile URL
+type profileUser struct {
+	*models.User
+	DID string
+	// Handle the verified handle, the DID if there isn't one
+	Handle string
+	Public bool
+}
+
+// resolve looks up the user for a handle or DID, nil if there is no such piper user
+func (s *Service) resolve(ctx context.Context, identifier string) (*profileUser, error) {
+	atid, err := syntax.ParseAtIdentifier(identifier)
+	if err != nil {
+		return nil, nil
+	}
+
+	var did, handle string
+	if atid.IsDID() {
+		did = atid.String()
+	} else if s.identities != nil {
+		ident, err := s.identities.LookupIdentity(ctx, identifier)
+		if err != nil {
+			s.logger.WarnContext(ctx, "error resolving handle", "handle", identifier, logging.Err(err))
+			return nil, nil
+		}
+		did = ident.DID.String()
+		handle = ident.Handle.String()
+	} else {
+		return nil, nil
+	}
+
+	user, err := s.db.GetUserByDID(did)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	public, err := s.db.IsPublicProfile(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if handle == "" && s.identities != nil {
+		// best effort, the page still works with the DID
+		if ident, err := s.identities.LookupIdentity(ctx, did); err == nil {
+			handle = ident.Handle.String()
+		}
+	}
+	if handle == "" || handle == syntax.HandleInvalid.String() {
+		handle = did
+	}
+	return &profileUser{User: user, DID: did, Handle: handle, Public: public}, nil
+}
+
+// publicUser resolves the profile in the request path, writing a 404 if it doesn't exist or isn't public.
+// Owners can always see their own profile, so they can check it before publishing it
+func (s *Service) publicUser(w http.ResponseWriter, r *http.Request) (*profileUser, bool) {
+	user, err := s.resolve(r.Context(), r.PathValue("id"))
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error loading profile", "profile", r.PathValue("id"), logging.Err(err))
+		http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user != nil && !user.Public {
+		if viewerID, ok := session.GetUserID(r.Context()); !ok || viewerID != user.ID {
+			user = nil
+		}
+	}
+	if user == nil {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// setCacheControl lets caches keep public profiles for embedMaxAge. Private ones are
+// only ever shown to their owner, so they must not be stored by shared caches
+func setCacheControl(w http.ResponseWriter, user *profileUser) {
+	if !user.Public {
+		w.Header().Set("Cache-Control", "private, no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", embedMaxAge))
+}
+
+// trackView a play as shown on profile pages and embeds
+type trackView struct {
+	Name     string
+	Artists  string
+	Album    string
+	URL      string
+	PlayedAt time.Time
+}
+
+func newTrackView(track *models.Track) trackView {
+	names := make([]string, 0, len(track.Artist))
+	for _, artist := range track.Artist {
+		names = append(names, artist.Name)
+	}
+	return trackView{
+		Name:     track.Name,
+		Artists:  strings.Join(names, ", "),
+		Album:    track.Album,
+		URL:      track.URL,
+		PlayedAt: track.Timestamp,
+	}
+}
+
+// URLs of a profile, its embeds and feeds
+type profileURLs struct {
+	Profile string
+	Badge   string
+	Embed   string
+	Atom    string
+	RSS     string
+	JSON    string
+}
+
+func urlsFor(identifier string) profileURLs {
+	root := strings.TrimSuffix(viper.GetString("server.root_url"), "/")
+	base := root + "/u/" + url.PathEscape(identifier)
+	return profileURLs{
+		Profile: base,
+		Badge:   base + "/now-playing.svg",
+		Embed:   base + "/embed",
+		Atom:    base + "/feed.atom",
+		RSS:     base + "/feed.rss",
+		JSON:    base + "/feed.json",
+	}
+}
+
+// current returns the track the user is playing, or else their last play
+func (s *Service) current(userID int64) (*trackView, bool, error) {
+	if s.playingNow != nil {
+		if track, ok := s.playingNow.GetPlayingNow(userID); ok {
+			view := newTrackView(track)
+			return &view, true, nil
+		}
+	}
+	recent, err := s.db.GetRecentTracks(userID, 1)
+	if err != nil || len(recent) == 0 {
+		return nil, false, err
+	}
+	view := newTrackView(recent[0])
+	return &view, false, nil
+}
+
+func navBar(database *db.DB, r *http.Request) pages.NavBar {
+	nav := pages.NavBar{
+		SpotifyEnabled:    viper.GetBool("enable_spotify"),
+		LastFMEnabled:     viper.GetBool("enable_lastfm"),
+		AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+	}
+	if userID, ok := session.GetUserID(r.Context()); ok {
+		nav.IsLoggedIn = true
+		if user, err := database.GetUserByID(userID); err == nil && user != nil && user.LastFMUsername != nil {
+			nav.LastFMUsername = *user.LastFMUsername
+		}
+	}
+	return nav
+}
+
+// HandleProfile shows a user's public profile page with what they're playing, recent plays and top artists
+func (s *Service) HandleProfile(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := s.publicUser(w, r)
+		if !ok {
+			return
+		}
+
+		current, playing, err := s.current(user.ID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading current track", logging.UserID(user.ID), logging.Err(err))
+		}
+		tracks, err := s.db.GetRecentTracks(user.ID, recentPlays)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading recent tracks", logging.UserID(user.ID), logging.Err(err))
+		}
+		recent := make([]trackView, 0, len(tracks))
+		for _, track := range tracks {
+			recent = append(recent, newTrackView(track))
+		}
+		var artists []stats.Entry
+		if s.stats != nil {
+			overview, err := s.stats.GetStats(user.ID, topArtistRange, time.UTC)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error loading stats", logging.UserID(user.ID), logging.Err(err))
+			} else {
+				artists = overview.TopArtists[:min(topArtists, len(overview.TopArtists))]
+			}
+		}
+
+		data := struct {
+			Handle     string
+			DID        string
+			Public     bool
+			Current    *trackView
+			Playing    bool
+			Recent     []trackView
+			TopArtists []stats.Entry
+			URLs       profileURLs
+			NavBar     pages.NavBar
+		}{
+			Handle:     user.Handle,
+			DID:        user.DID,
+			Public:     user.Public,
+			Current:    current,
+			Playing:    playing,
+			Recent:     recent,
+			TopArtists: artists,
+			URLs:       urlsFor(user.Handle),
+			NavBar:     navBar(s.db, r),
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := pg.Execute("profile", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
+
+// embedData what the badge and card show
+type embedData struct {
+	Handle  string
+	Current *trackView
+	Playing bool
+	Text    string
+	// badge widths, estimated from the text length
+	LabelWidth int
+	TextWidth  int
+	URLs       profileURLs
+}
+
+func (s *Service) embed(w http.ResponseWriter, r *http.Request) (*embedData, bool) {
+	user, ok := s.publicUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	current, playing, err := s.current(user.ID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error loading current track", logging.UserID(user.ID), logging.Err(err))
+	}
+
+	text := "nothing right now"
+	if playing {
+		text = current.Name
+		if current.Artists != "" {
+			text = current.Artists + " – " + current.Name
+		}
+		if utf8.RuneCountInString(text) > badgeMaxText {
+			text = string([]rune(text)[:badgeMaxText-1]) + "…"
+		}
+	}
+
+	setCacheControl(w, user)
+	return &embedData{
+		Handle:     user.Handle,
+		Current:    current,
+		Playing:    playing,
+		Text:       text,
+		LabelWidth: 84,
+		TextWidth:  utf8.RuneCountInString(text)*7 + 20,
+		URLs:       urlsFor(user.Handle),
+	}, true
+}
+
+// HandleBadge renders an SVG now playing badge for READMEs and other pages
+func (s *Service) HandleBadge(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ok := s.embed(w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		if err := pg.ExecuteFragment("embed/nowPlayingBadge", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
+
+// HandleEmbed renders a small HTML now playing card meant for iframes on other sites
+func (s *Service) HandleEmbed(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, ok := s.embed(w, r)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+		if err := pg.ExecuteFragment("embed/nowPlayingCard", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
+
+// spotifySettings what the settings page shows of a Spotify user's filters
+type spotifySettings struct {
+	*models.SpotifySettings
+	// Devices the devices the user's plays were recorded on
+	Devices []string
+}
+
+// HandleSettings lets users make their profile public or private, pick a
+// stamping policy and filter Spotify playback (POST) and shows the embed snippets (GET)
+func (s *Service) HandleSettings(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := session.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "Invalid form", http.StatusBadRequest)
+				return
+			}
+			if policy, ok := r.PostForm["stamp_policy"]; ok && s.stamping != nil {
+				err := s.stamping.SetUserPolicy(userID, policy[0])
+				if errors.Is(err, stamping.ErrUnknownPolicy) {
+					http.Error(w, "Unknown stamping policy", http.StatusBadRequest)
+					return
+				}
+				if err != nil {
+					s.logger.ErrorContext(r.Context(), "error updating stamping policy", logging.UserID(userID), logging.Err(err))
+					http.Error(w, "Failed to update stamping policy", http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(w, r, "/profile", http.StatusSeeOther)
+				return
+			}
+			if _, ok := r.PostForm["spotify_settings"]; ok {
+				settings := &models.SpotifySettings{
+					SkipPrivateSessions: r.FormValue("skip_private_sessions") == "true",
+					IgnoredDevices:      []string{},
+					Episodes:            r.FormValue("episodes"),
+				}
+				if !models.ValidEpisodeMode(settings.Episodes) {
+					http.Error(w, "Unknown podcast episode setting", http.StatusBadRequest)
+					return
+				}
+				for _, device := range strings.Split(r.FormValue("ignored_devices"), "\n") {
+					if device = strings.TrimSpace(device); device != "" {
+						settings.IgnoredDevices = append(settings.IgnoredDevices, device)
+					}
+				}
+				if err := s.db.SetSpotifySettings(userID, settings); err != nil {
+					s.logger.ErrorContext(r.Context(), "error updating spotify settings", logging.UserID(userID), logging.Err(err))
+					http.Error(w, "Failed to update Spotify settings", http.StatusInternalServerError)
+					return
+				}
+				http.Redirect(w, r, "/profile", http.StatusSeeOther)
+				return
+			}
+			if err := s.db.SetPublicProfile(userID, r.FormValue("public") == "true"); err != nil {
+				s.logger.ErrorContext(r.Context(), "error updating profile visibility", logging.UserID(userID), logging.Err(err))
+				http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/profile", http.StatusSeeOther)
+			return
+		case http.MethodGet:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := s.db.GetUserByID(userID)
+		if err != nil || user == nil {
+			s.logger.ErrorContext(r.Context(), "error loading user for profile settings", logging.UserID(userID), logging.Err(err))
+			http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+			return
+		}
+		public, err := s.db.IsPublicProfile(userID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading profile visibility", logging.UserID(userID), logging.Err(err))
+			http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+			return
+		}
+
+		var spotify *spotifySettings
+		if user.SpotifyID != nil {
+			settings, err := s.db.GetSpotifySettings(userID)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error loading spotify settings", logging.UserID(userID), logging.Err(err))
+				http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+				return
+			}
+			devices, err := s.db.GetTrackDevices(userID)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error loading track devices", logging.UserID(userID), logging.Err(err))
+				http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+				return
+			}
+			spotify = &spotifySettings{SpotifySettings: settings, Devices: devices}
+		}
+
+		var urls *profileURLs
+		if user.ATProtoDID != nil {
+			identifier := *user.ATProtoDID
+			if s.identities != nil {
+				if ident, err := s.identities.LookupIdentity(r.Context(), identifier); err == nil && ident.Handle != syntax.HandleInvalid {
+					identifier = ident.Handle.String()
+				}
+			}
+			u := urlsFor(identifier)
+			urls = &u
+		}
+
+		data := struct {
+			Public        bool
+			URLs          *profileURLs
+			StampPolicy   string
+			DefaultPolicy string
+			StampPolicies []string
+			CanPickPolicy bool
+			Spotify       *spotifySettings
+			NavBar        pages.NavBar
+		}{
+			Public:        public,
+			URLs:          urls,
+			StampPolicy:   s.stamping.UserPolicy(r.Context(), userID),
+			DefaultPolicy: s.stamping.Default(),
+			StampPolicies: stamping.Names(),
+			CanPickPolicy: s.stamping != nil,
+			Spotify:       spotify,
+			NavBar:        navBar(s.db, r),
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := pg.Execute("profileSettings", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/service/stats"
+	"github.com/teal-fm/piper/session"
+)
+
+const testDID = "did:plc:abcdefghijklmnopqrstuvwx"
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+type fakeIdentities map[string]string // handle -> DID
+
+func (f fakeIdentities) LookupIdentity(_ context.Context, identifier string) (*identity.Identity, error) {
+	for handle, did := range f {
+		if identifier == handle || identifier == did {
+			return &identity.Identity{DID: syntax.DID(did), Handle: syntax.Handle(handle)}, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+type fakePlayingNow map[int64]*models.Track
+
+func (f fakePlayingNow) GetPlayingNow(userID int64) (*models.Track, bool) {
+	track, ok := f[userID]
+	return track, ok
+}
+
+func setup(t *testing.T) (*db.DB, *Service, int64, fakePlayingNow, *http.ServeMux) {
+	database := setupTestDB(t)
+	user, err := database.FindOrCreateUserByDID(testDID)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := database.SaveTrack(user.ID, &models.Track{
+		Name:      "Around the World",
+		Artist:    []models.Artist{{Name: "Daft Punk"}},
+		Album:     "Homework",
+		Timestamp: time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("Failed to save track: %v", err)
+	}
+
+	playing := fakePlayingNow{}
+	s := NewProfileService(database, fakeIdentities{"alice.test": testDID}, playing, stats.NewStatsService(database, 0))
+	pg := pages.NewPages()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /u/{id}", s.HandleProfile(pg))
+	mux.HandleFunc("GET /u/{id}/now-playing.svg", s.HandleBadge(pg))
+	mux.HandleFunc("GET /u/{id}/embed", s.HandleEmbed(pg))
+	return database, s, user.ID, playing, mux
+}
+
+func get(mux http.Handler, path string, userID int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if userID != 0 {
+		req = req.WithContext(session.WithUserID(req.Context(), userID))
+	}
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestProfilePrivacy(t *testing.T) {
+	database, _, userID, _, mux := setup(t)
+	defer database.Close()
+
+	for _, path := range []string{"/u/alice.test", "/u/" + testDID, "/u/alice.test/now-playing.svg", "/u/alice.test/embed"} {
+		if rr := get(mux, path, 0); rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s on a private profile = %d, want 404", path, rr.Code)
+		}
+	}
+
+	// the owner can preview it
+	rr := get(mux, "/u/alice.test", userID)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Only you can see this page") {
+		t.Errorf("Expected the owner to see a preview, got %d", rr.Code)
+	}
+	for _, path := range []string{"/u/alice.test/now-playing.svg", "/u/alice.test/embed"} {
+		rr := get(mux, path, userID)
+		if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "private, no-store" {
+			t.Errorf("GET %s by the owner = %d, Cache-Control %q, want an uncached preview", path, rr.Code, rr.Header().Get("Cache-Control"))
+		}
+	}
+
+	if err := database.SetPublicProfile(userID, true); err != nil {
+		t.Fatalf("SetPublicProfile failed: %v", err)
+	}
+	for _, path := range []string{"/u/alice.test", "/u/" + testDID} {
+		rr := get(mux, path, 0)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s on a public profile = %d", path, rr.Code)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "alice.test") || !strings.Contains(body, "Around the World") || !strings.Contains(body, "Daft Punk") {
+			t.Errorf("Expected the profile to show the handle, plays and top artists")
+		}
+		if strings.Contains(body, "Only you can see this page") {
+			t.Errorf("Didn't expect the private notice on a public profile")
+		}
+	}
+
+	if rr := get(mux, "/u/nobody.test", 0); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown handles to 404, got %d", rr.Code)
+	}
+}
+
+func TestEmbeds(t *testing.T) {
+	database, _, userID, playing, mux := setup(t)
+	defer database.Close()
+	if err := database.SetPublicProfile(userID, true); err != nil {
+		t.Fatalf("SetPublicProfile failed: %v", err)
+	}
+
+	rr := get(mux, "/u/alice.test/now-playing.svg", 0)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("Badge = %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=30" {
+		t.Errorf("Badge Cache-Control = %q, want it cacheable", cc)
+	}
+	if !strings.Contains(rr.Body.String(), "nothing right now") {
+		t.Errorf("Expected an idle badge, got %s", rr.Body.String())
+	}
+
+	playing[userID] = &models.Track{Name: "Veridis Quo & <Co>", Artist: []models.Artist{{Name: "Daft Punk"}}}
+	rr = get(mux, "/u/alice.test/now-playing.svg", 0)
+	body := rr.Body.String()
+	if !strings.HasPrefix(body, "<svg") || !strings.Contains(body, "Daft Punk – Veridis Quo &amp; &lt;Co&gt;") {
+		t.Errorf("Expected the badge to show the escaped playing track, got %s", body)
+	}
+
+	rr = get(mux, "/u/alice.test/embed", 0)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Security-Policy") != "frame-ancestors *" {
+		t.Fatalf("Embed = %d, CSP %q", rr.Code, rr.Header().Get("Content-Security-Policy"))
+	}
+	if !strings.Contains(rr.Body.String(), "Listening now") {
+		t.Errorf("Expected the card to show the track playing")
+	}
+
+	delete(playing, userID)
+	rr = get(mux, "/u/alice.test/embed", 0)
+	if !strings.Contains(rr.Body.String(), "Last played") || !strings.Contains(rr.Body.String(), "Around the World") {
+		t.Errorf("Expected the card to fall back to the last play")
+	}
+}
@@ -0,0 +1,169 @@
+package relay
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// Audioscrobbler 2.0 error codes, shared by Last.fm and GNU FM
+const (
+	asErrAuthFailed       = 4
+	asErrInvalidSession   = 9
+	asErrInvalidAPIKey    = 10
+	asErrServiceOffline   = 11
+	asErrTemporary        = 16
+	asErrSuspendedAPIKey  = 26
+	asErrRateLimitReached = 29
+)
+
+type audioscrobblerError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *audioscrobblerError) Error() string {
+	return fmt.Sprintf("error %d: %s", e.Code, e.Message)
+}
+
+// sign computes api_sig: every parameter except format, sorted by name, followed by the secret
+func sign(params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(params.Get(k))
+	}
+	b.WriteString(secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// call makes a signed POST request, decoding a successful response into out
+func (s *Service) call(ctx context.Context, root string, params url.Values, out any) error {
+	params.Set("api_key", s.apiKey)
+	params.Set("api_sig", sign(params, s.apiSecret))
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, root, strings.NewReader(params.Encode()))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	var apiErr audioscrobblerError
+	if json.Unmarshal(raw, &apiErr) == nil && apiErr.Code != 0 {
+		switch apiErr.Code {
+		case asErrServiceOffline, asErrTemporary, asErrRateLimitReached:
+			return &apiErr
+		case asErrAuthFailed, asErrInvalidSession, asErrInvalidAPIKey, asErrSuspendedAPIKey:
+			return &permanentError{err: &apiErr, disable: true}
+		default:
+			return &permanentError{err: &apiErr}
+		}
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s returned %d", root, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &permanentError{err: fmt.Errorf("%s returned %d", root, resp.StatusCode)}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return &permanentError{err: fmt.Errorf("unexpected response from %s: %w", root, err)}
+		}
+	}
+	return nil
+}
+
+// getMobileSession exchanges a username and password for a session key
+func (s *Service) getMobileSession(ctx context.Context, root, username, password string) (string, string, error) {
+	var result struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	err := s.call(ctx, root, url.Values{
+		"method":   {"auth.getMobileSession"},
+		"username": {username},
+		"password": {password},
+	}, &result)
+
+	var apiErr *audioscrobblerError
+	if errors.As(err, &apiErr) && apiErr.Code == asErrAuthFailed {
+		return "", "", errors.New("the username or password was rejected")
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("could not sign in: %w", err)
+	}
+	if result.Session.Key == "" {
+		return "", "", errors.New("no session key was returned")
+	}
+	return result.Session.Name, result.Session.Key, nil
+}
+
+// scrobble submits a single play with track.scrobble
+func (s *Service) scrobble(ctx context.Context, target *models.RelayTarget, track *models.Track) error {
+	var artists []string
+	for _, artist := range track.Artist {
+		if artist.Name != "" {
+			artists = append(artists, artist.Name)
+		}
+	}
+	if track.Name == "" || len(artists) == 0 {
+		return &permanentError{err: errors.New("track has no name or artist")}
+	}
+
+	params := url.Values{
+		"method":    {"track.scrobble"},
+		"sk":        {target.Secret},
+		"artist":    {strings.Join(artists, ", ")},
+		"track":     {track.Name},
+		"timestamp": {strconv.FormatInt(track.Timestamp.Unix(), 10)},
+	}
+	if track.Album != "" {
+		params.Set("album", track.Album)
+	}
+	if track.DurationMs > 0 {
+		params.Set("duration", strconv.FormatInt(track.DurationMs/1000, 10))
+	}
+	if track.RecordingMBID != nil {
+		params.Set("mbid", *track.RecordingMBID)
+	}
+	if track.TrackNumber != nil {
+		params.Set("trackNumber", strconv.Itoa(*track.TrackNumber))
+	}
+
+	return s.call(ctx, target.URL, params, nil)
+}
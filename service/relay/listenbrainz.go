@@ -0,0 +1,108 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/teal-fm/piper/models"
+)
+
+// listenBrainzEndpoint joins the API root and path, the root may be a Maloja style sub path
+func listenBrainzEndpoint(root, path string) string {
+	return strings.TrimRight(root, "/") + path
+}
+
+// submitListenBrainz sends a single listen to a ListenBrainz compatible server
+func (s *Service) submitListenBrainz(ctx context.Context, target *models.RelayTarget, track *models.Track) error {
+	listen := models.ListenFromTrack(track, "")
+	info := listen.TrackMetadata.AdditionalInfo
+	client := SubmissionClient
+	_, version, _ := strings.Cut(models.SubmissionAgent, "/")
+	info.SubmissionClient = &client
+	info.SubmissionClientVersion = &version
+
+	body, err := json.Marshal(models.ListenBrainzSubmission{
+		ListenType: "single",
+		Payload: []models.ListenBrainzPayload{{
+			ListenedAt:    listen.ListenedAt,
+			TrackMetadata: listen.TrackMetadata,
+		}},
+	})
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, listenBrainzEndpoint(target.URL, "/1/submit-listens"), bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+target.Secret)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return listenBrainzStatusError(resp)
+}
+
+// listenBrainzStatusError maps a response to nil, a retryable error or a permanent one
+func listenBrainzStatusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(raw))
+	if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error != "" {
+		message = apiErr.Error
+	}
+	err := fmt.Errorf("ListenBrainz returned %d: %s", resp.StatusCode, message)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return &permanentError{err: errors.New("the ListenBrainz token was rejected, add the target again"), disable: true}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return err
+	default:
+		return &permanentError{err: err}
+	}
+}
+
+// validateListenBrainzToken checks a token, returning the user name it belongs to
+func (s *Service) validateListenBrainzToken(ctx context.Context, root, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listenBrainzEndpoint(root, "/1/validate-token"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not reach %s: %w", root, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&result); err != nil {
+		return "", fmt.Errorf("unexpected response from %s", root)
+	}
+	if !result.Valid {
+		return "", errors.New("the ListenBrainz token is not valid")
+	}
+	return result.UserName, nil
+}
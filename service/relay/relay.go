@@ -0,0 +1,399 @@
+// Package relay forwards stamped plays to other scrobbling services a user has
+// configured, such as ListenBrainz, Last.fm or Libre.fm. Deliveries go through
+// a queue in the database so they survive restarts and outages on the other end
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/service/events"
+	"github.com/teal-fm/piper/service/webhook"
+	"github.com/teal-fm/piper/session"
+)
+
+const (
+	// SubmissionClient is sent as submission_client on relayed ListenBrainz
+	// listens. Listens coming in with it were relayed by a piper and are not relayed again
+	SubmissionClient = "piper"
+
+	maxAttempts    = 10
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = 6 * time.Hour
+	batchSize      = 50
+)
+
+// Default endpoints for each kind of target
+var defaultURLs = map[string]string{
+	models.RelayKindListenBrainz: "https://api.listenbrainz.org",
+	models.RelayKindLastFM:       "https://ws.audioscrobbler.com/2.0/",
+	models.RelayKindGNUFM:        "https://libre.fm/2.0/",
+}
+
+type Service struct {
+	db         *db.DB
+	httpClient *http.Client
+	// Last.fm API credentials, Last.fm and GNU FM requests are signed with these
+	apiKey    string
+	apiSecret string
+	wake      chan struct{}
+	logger    *slog.Logger
+}
+
+// NewRelayService creates the service. Unless allowPrivate is set, relays
+// can't reach loopback or private network addresses
+func NewRelayService(database *db.DB, apiKey, apiSecret string, allowPrivate bool) *Service {
+	// targets are URLs users give, like webhooks they can't reach into the network piper runs in
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = webhook.PublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &Service{
+		db: database,
+		httpClient: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: transport,
+			// a redirect would be a way around the address check
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		wake:      make(chan struct{}, 1),
+		logger:    logging.For("relay"),
+	}
+}
+
+// AudioscrobblerEnabled reports whether Last.fm style targets can be used, they need API credentials
+func (s *Service) AudioscrobblerEnabled() bool {
+	return s.apiKey != "" && s.apiSecret != ""
+}
+
+// HandleEvent queues stamped tracks for each of the user's enabled targets
+func (s *Service) HandleEvent(e events.Event) {
+	if e.Type != events.TrackStamped {
+		return
+	}
+	// relayed here by another piper, it has already been everywhere it should go
+	if e.Source == events.SourceListenBrainz && e.Track.SubmissionClient == SubmissionClient {
+		return
+	}
+
+	targets, err := s.db.GetUserRelayTargets(e.UserID)
+	if err != nil {
+		s.logger.Error("error loading relay targets", logging.UserID(e.UserID), logging.Err(err))
+		return
+	}
+
+	queued := 0
+	for _, target := range targets {
+		if !target.Enabled || echoesSource(target, e.Source) {
+			continue
+		}
+		if err := s.db.EnqueueRelayDelivery(target.ID, e.UserID, &e.Track); err != nil {
+			s.logger.Error("error queueing relay", "target_id", target.ID, logging.UserID(e.UserID), logging.Err(err))
+			continue
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// echoesSource reports whether relaying a track from source to target would send it back where it came from
+func echoesSource(target *models.RelayTarget, source string) bool {
+	return source == events.SourceLastFM && target.Kind == models.RelayKindLastFM
+}
+
+// StartWorker delivers queued plays, checking for due retries every interval
+func (s *Service) StartWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		s.processDue(context.Background())
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+			s.processDue(context.Background())
+		}
+	}()
+}
+
+// processDue sends every delivery that is due, in batches
+func (s *Service) processDue(ctx context.Context) {
+	for {
+		deliveries, err := s.db.GetDueRelayDeliveries(time.Now(), batchSize)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error loading relay queue", logging.Err(err))
+			return
+		}
+		for _, d := range deliveries {
+			s.process(ctx, d)
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *Service) process(ctx context.Context, d *models.RelayDelivery) {
+	target, err := s.db.GetRelayTarget(d.TargetID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error loading relay target", "target_id", d.TargetID, logging.Err(err))
+		return
+	}
+	if target == nil || !target.Enabled {
+		// the target was removed or needs the user to sign in again
+		s.dropDelivery(d)
+		return
+	}
+
+	err = s.deliver(ctx, target, &d.Track)
+	if err == nil {
+		s.dropDelivery(d)
+		if err := s.db.RecordRelaySuccess(target.ID); err != nil {
+			s.logger.ErrorContext(ctx, "error recording relay success", "target_id", target.ID, logging.Err(err))
+		}
+		return
+	}
+
+	var permanent *permanentError
+	isPermanent := errors.As(err, &permanent)
+	attempts := d.Attempts + 1
+	s.logger.WarnContext(ctx, "relay failed", logging.Track(&d.Track), "kind", target.Kind, "target_id", target.ID,
+		logging.UserID(d.UserID), "attempt", attempts, logging.Err(err))
+
+	switch {
+	case isPermanent:
+		s.dropDelivery(d)
+		s.recordFailure(target.ID, err.Error(), permanent.disable)
+	case attempts >= maxAttempts:
+		s.dropDelivery(d)
+		s.recordFailure(target.ID, fmt.Sprintf("gave up after %d attempts: %v", attempts, err), false)
+	default:
+		if err := s.db.RescheduleRelayDelivery(d.ID, attempts, time.Now().Add(retryDelay(attempts)), err.Error()); err != nil {
+			s.logger.ErrorContext(ctx, "error rescheduling relay delivery", "delivery_id", d.ID, logging.Err(err))
+		}
+		s.recordFailure(target.ID, err.Error(), false)
+	}
+}
+
+func (s *Service) dropDelivery(d *models.RelayDelivery) {
+	if err := s.db.DeleteRelayDelivery(d.ID); err != nil {
+		s.logger.Error("error removing relay delivery", "delivery_id", d.ID, logging.Err(err))
+	}
+}
+
+func (s *Service) recordFailure(targetID int64, message string, disable bool) {
+	if err := s.db.RecordRelayFailure(targetID, message, disable); err != nil {
+		s.logger.Error("error recording relay failure", "target_id", targetID, logging.Err(err))
+	}
+}
+
+// retryDelay doubles with every attempt up to maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// permanentError a delivery that will never succeed as is. disable is set when the
+// target's credentials were rejected, so nothing else is sent until the user fixes them
+type permanentError struct {
+	err     error
+	disable bool
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func (s *Service) deliver(ctx context.Context, target *models.RelayTarget, track *models.Track) error {
+	switch target.Kind {
+	case models.RelayKindListenBrainz:
+		return s.submitListenBrainz(ctx, target, track)
+	case models.RelayKindLastFM, models.RelayKindGNUFM:
+		return s.scrobble(ctx, target, track)
+	default:
+		return &permanentError{err: fmt.Errorf("unknown relay kind %q", target.Kind), disable: true}
+	}
+}
+
+// AddTarget checks the credentials for a new target and stores it. For
+// ListenBrainz secret is the user token, for Last.fm and GNU FM it is the
+// account password, which is exchanged for a session key and not kept
+func (s *Service) AddTarget(ctx context.Context, userID int64, kind, targetURL, username, secret string) (*models.RelayTarget, error) {
+	defaultURL, ok := defaultURLs[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown relay kind %q", kind)
+	}
+	targetURL = strings.TrimSpace(targetURL)
+	if targetURL == "" {
+		targetURL = defaultURL
+	}
+	if !strings.HasPrefix(targetURL, "https://") && !strings.HasPrefix(targetURL, "http://") {
+		return nil, errors.New("the URL must start with https:// or http://")
+	}
+	if strings.TrimSpace(secret) == "" {
+		return nil, errors.New("credentials are required")
+	}
+
+	target := &models.RelayTarget{UserID: userID, Kind: kind, URL: targetURL, Enabled: true}
+	switch kind {
+	case models.RelayKindListenBrainz:
+		name, err := s.validateListenBrainzToken(ctx, targetURL, strings.TrimSpace(secret))
+		if err != nil {
+			return nil, err
+		}
+		target.Username = name
+		target.Secret = strings.TrimSpace(secret)
+	default:
+		if !s.AudioscrobblerEnabled() {
+			return nil, errors.New("Last.fm API credentials are not configured on this server")
+		}
+		username = strings.TrimSpace(username)
+		if username == "" {
+			return nil, errors.New("a username is required")
+		}
+		if kind == models.RelayKindLastFM {
+			user, err := s.db.GetUserByID(userID)
+			if err != nil {
+				return nil, err
+			}
+			// plays from the linked Last.fm account are imported, relaying to it would duplicate every play
+			if user != nil && user.LastFMUsername != nil && strings.EqualFold(*user.LastFMUsername, username) {
+				return nil, errors.New("piper already imports plays from this Last.fm account")
+			}
+		}
+		name, key, err := s.getMobileSession(ctx, targetURL, username, secret)
+		if err != nil {
+			return nil, err
+		}
+		target.Username = name
+		target.Secret = key
+	}
+
+	if err := s.db.CreateRelayTarget(target); err != nil {
+		return nil, fmt.Errorf("failed to save relay target: %w", err)
+	}
+	return target, nil
+}
+
+// targetView a target with its queue length for the relays page
+type targetView struct {
+	*models.RelayTarget
+	Pending int
+}
+
+// HandleRelays shows a user's relay targets (GET) and adds or removes them (POST)
+func (s *Service) HandleRelays(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := session.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var formError string
+		switch r.Method {
+		case http.MethodPost:
+			var err error
+			switch r.FormValue("action") {
+			case "add":
+				_, err = s.AddTarget(r.Context(), userID, r.FormValue("kind"), r.FormValue("url"), r.FormValue("username"), r.FormValue("secret"))
+			case "delete":
+				var targetID int64
+				targetID, err = strconv.ParseInt(r.FormValue("id"), 10, 64)
+				if err == nil {
+					err = s.db.DeleteRelayTarget(userID, targetID)
+				}
+			default:
+				err = errors.New("unknown action")
+			}
+			if err == nil {
+				http.Redirect(w, r, "/relays", http.StatusSeeOther)
+				return
+			}
+			s.logger.ErrorContext(r.Context(), "error updating relays", logging.UserID(userID), logging.Err(err))
+			formError = err.Error()
+		case http.MethodGet:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		targets, err := s.db.GetUserRelayTargets(userID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading relay targets", logging.UserID(userID), logging.Err(err))
+			http.Error(w, "Failed to load relays", http.StatusInternalServerError)
+			return
+		}
+		views := make([]targetView, 0, len(targets))
+		for _, target := range targets {
+			pending, err := s.db.CountPendingRelayDeliveries(target.ID)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error counting pending relays", "target_id", target.ID, logging.Err(err))
+			}
+			views = append(views, targetView{RelayTarget: target, Pending: pending})
+		}
+
+		lastfmUsername := ""
+		user, err := s.db.GetUserByID(userID)
+		if err == nil && user != nil && user.LastFMUsername != nil {
+			lastfmUsername = *user.LastFMUsername
+		} else if err != nil {
+			s.logger.ErrorContext(r.Context(), "error fetching user details for relays page", logging.UserID(userID), logging.Err(err))
+		}
+
+		data := struct {
+			Targets               []targetView
+			Error                 string
+			AudioscrobblerEnabled bool
+			NavBar                pages.NavBar
+		}{
+			Targets:               views,
+			Error:                 formError,
+			AudioscrobblerEnabled: s.AudioscrobblerEnabled(),
+			NavBar: pages.NavBar{
+				IsLoggedIn:        true,
+				LastFMUsername:    lastfmUsername,
+				SpotifyEnabled:    viper.GetBool("enable_spotify"),
+				LastFMEnabled:     viper.GetBool("enable_lastfm"),
+				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if formError != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		if err := pg.Execute("relays", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/service/events"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+func createTestUser(t *testing.T, database *db.DB) int64 {
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	return userID
+}
+
+func createTarget(t *testing.T, database *db.DB, userID int64, kind, targetURL string) *models.RelayTarget {
+	target := &models.RelayTarget{UserID: userID, Kind: kind, URL: targetURL, Secret: "secret", Enabled: true}
+	if err := database.CreateRelayTarget(target); err != nil {
+		t.Fatalf("Failed to create relay target: %v", err)
+	}
+	return target
+}
+
+func testTrack() models.Track {
+	recording := "9a3f0b36-2d86-4e4b-9c44-8b7cbd2f3b1c"
+	return models.Track{
+		Name:          "Get Lucky",
+		Artist:        []models.Artist{{Name: "Daft Punk"}},
+		Album:         "Random Access Memories",
+		RecordingMBID: &recording,
+		DurationMs:    248000,
+		Timestamp:     time.Unix(1704067200, 0),
+	}
+}
+
+func pending(t *testing.T, database *db.DB, targetID int64) int {
+	count, err := database.CountPendingRelayDeliveries(targetID)
+	if err != nil {
+		t.Fatalf("Failed to count deliveries: %v", err)
+	}
+	return count
+}
+
+func TestHandleEventLoopPrevention(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID := createTestUser(t, database)
+	lb := createTarget(t, database, userID, models.RelayKindListenBrainz, "https://lb.example")
+	lfm := createTarget(t, database, userID, models.RelayKindLastFM, "https://lfm.example")
+	s := NewRelayService(database, "key", "secret", true)
+
+	track := testTrack()
+	s.HandleEvent(events.Event{Type: events.TrackStamped, UserID: userID, Track: track, Source: events.SourceSpotify})
+	if pending(t, database, lb.ID) != 1 || pending(t, database, lfm.ID) != 1 {
+		t.Fatalf("Expected a Spotify play to be queued for both targets")
+	}
+
+	s.HandleEvent(events.Event{Type: events.TrackStamped, UserID: userID, Track: track, Source: events.SourceLastFM})
+	if pending(t, database, lb.ID) != 2 || pending(t, database, lfm.ID) != 1 {
+		t.Errorf("Expected a Last.fm play to only be queued for ListenBrainz")
+	}
+
+	track.SubmissionClient = SubmissionClient
+	s.HandleEvent(events.Event{Type: events.TrackStamped, UserID: userID, Track: track, Source: events.SourceListenBrainz})
+	if pending(t, database, lb.ID) != 2 || pending(t, database, lfm.ID) != 1 {
+		t.Errorf("Expected a listen relayed by piper not to be relayed again")
+	}
+}
+
+func TestProcessListenBrainz(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	var received models.ListenBrainzSubmission
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("Unexpected request %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	userID := createTestUser(t, database)
+	target := createTarget(t, database, userID, models.RelayKindListenBrainz, server.URL)
+	s := NewRelayService(database, "", "", true)
+
+	track := testTrack()
+	if err := database.EnqueueRelayDelivery(target.ID, userID, &track); err != nil {
+		t.Fatalf("Failed to queue delivery: %v", err)
+	}
+
+	// the first attempt fails and is retried later
+	s.processDue(context.Background())
+	deliveries, err := database.GetDueRelayDeliveries(time.Now().Add(time.Hour), 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Expected the delivery to be rescheduled, got %d (%v)", len(deliveries), err)
+	}
+	if deliveries[0].Attempts != 1 || !deliveries[0].NextAttemptAt.After(time.Now()) {
+		t.Errorf("Expected one attempt and a retry in the future, got %+v", deliveries[0])
+	}
+	if target, _ := database.GetRelayTarget(target.ID); target.LastError == nil || !target.Enabled {
+		t.Errorf("Expected the error to be recorded without disabling the target, got %+v", target)
+	}
+
+	// once it is due again and the server is back it goes through
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	if err := database.RescheduleRelayDelivery(deliveries[0].ID, 1, time.Now().Add(-time.Second), "retry"); err != nil {
+		t.Fatalf("Failed to reschedule: %v", err)
+	}
+	s.processDue(context.Background())
+
+	if pending(t, database, target.ID) != 0 {
+		t.Errorf("Expected the delivery to be sent")
+	}
+	if target, _ := database.GetRelayTarget(target.ID); target.LastError != nil || target.LastSuccessAt == nil {
+		t.Errorf("Expected the target to be healthy again, got %+v", target)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received.Payload) != 1 || *received.Payload[0].ListenedAt != 1704067200 {
+		t.Fatalf("Unexpected submission: %+v", received)
+	}
+	info := received.Payload[0].TrackMetadata.AdditionalInfo
+	if info == nil || info.SubmissionClient == nil || *info.SubmissionClient != SubmissionClient {
+		t.Errorf("Expected the listen to be marked as relayed by piper, got %+v", info)
+	}
+}
+
+func TestProcessRejectedCredentialsDisableTarget(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("method") != "track.scrobble" || r.PostForm.Get("api_sig") == "" {
+			t.Errorf("Unexpected request: %v", r.PostForm)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": asErrInvalidSession, "message": "Invalid session key"})
+	}))
+	defer server.Close()
+
+	userID := createTestUser(t, database)
+	target := createTarget(t, database, userID, models.RelayKindLastFM, server.URL)
+	s := NewRelayService(database, "key", "secret", true)
+
+	track := testTrack()
+	for range 2 {
+		if err := database.EnqueueRelayDelivery(target.ID, userID, &track); err != nil {
+			t.Fatalf("Failed to queue delivery: %v", err)
+		}
+	}
+	s.processDue(context.Background())
+
+	if pending(t, database, target.ID) != 0 {
+		t.Errorf("Expected deliveries for a disabled target to be dropped")
+	}
+	if target, _ := database.GetRelayTarget(target.ID); target.Enabled || target.LastError == nil {
+		t.Errorf("Expected the target to be disabled with an error, got %+v", target)
+	}
+}
+
+func TestAddTargetBlocksPrivateAddresses(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		_ = json.NewEncoder(w).Encode(map[string]any{"valid": true, "user_name": "rj"})
+	}))
+	defer server.Close()
+
+	userID := createTestUser(t, database)
+	s := NewRelayService(database, "", "", false)
+	_, err := s.AddTarget(context.Background(), userID, models.RelayKindListenBrainz, server.URL, "", "secret")
+	if err == nil || !strings.Contains(err.Error(), "can't be sent to") {
+		t.Fatalf("Expected a loopback target to be refused, got %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("Expected no request to reach the loopback server, got %d", hits)
+	}
+}
+
+func TestSign(t *testing.T) {
+	params := url.Values{"method": {"auth.getMobileSession"}, "username": {"u"}, "password": {"p"}, "api_key": {"k"}, "format": {"json"}}
+	// md5("api_keykmethodauth.getMobileSessionpasswordpusernameus")
+	if got := sign(params, "s"); got != "9cdeaa639a2fd1ea5deb8daeee625799" {
+		t.Errorf("Unexpected signature %s", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	if retryDelay(1) != baseRetryDelay || retryDelay(2) != 2*baseRetryDelay {
+		t.Errorf("Expected the delay to double, got %v and %v", retryDelay(1), retryDelay(2))
+	}
+	if retryDelay(50) != maxRetryDelay {
+		t.Errorf("Expected the delay to be capped, got %v", retryDelay(50))
+	}
+}
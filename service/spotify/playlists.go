@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+
+	"github.com/teal-fm/piper/logging"
 )
 
 type Playlist struct {
@@ -38,7 +39,7 @@ func (s *Service) getUserPlaylists(userID int64) (*PlaylistResponse, error) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			log.Fatal("failed to close resp.Body")
+			s.logger.Error("failed to close playlists response body", logging.Err(err))
 		}
 	}(resp.Body)
 
@@ -47,7 +48,7 @@ func (s *Service) getUserPlaylists(userID int64) (*PlaylistResponse, error) {
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal("failed to read resp.Body")
+		return nil, fmt.Errorf("failed to read playlists response: %w", err)
 	}
 
 	var playlistResponse PlaylistResponse
@@ -0,0 +1,206 @@
+package spotify
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/service/events"
+)
+
+// Polling configures the adaptive scheduler. Users are polled every tracker
+// interval while listening, sooner when a track is about to be stamped or end,
+// and every IdleInterval once nothing has played for IdleAfter
+type Polling struct {
+	IdleAfter    time.Duration
+	IdleInterval time.Duration
+	// Workers bounds how many users are polled at once
+	Workers int
+}
+
+const (
+	// schedulerTick how often the scheduler looks for users due a poll
+	schedulerTick = time.Second
+	// minPollInterval keeps fast polling near a stamp threshold from hammering the API
+	minPollInterval = 5 * time.Second
+	// pollJitter spreads each poll by up to this fraction of its delay either way
+	pollJitter = 0.1
+	// defaultRetryAfter is used when a 429 comes without a usable Retry-After
+	defaultRetryAfter = 30 * time.Second
+)
+
+func defaultPolling() Polling {
+	return Polling{
+		IdleAfter:    time.Hour,
+		IdleInterval: 5 * time.Minute,
+		Workers:      4,
+	}
+}
+
+// pollSchedule when a user is next due, and when they were last seen playing
+type pollSchedule struct {
+	next       time.Time
+	lastActive time.Time
+}
+
+// WithPolling overrides the adaptive scheduler defaults, zero values are ignored
+func (s *Service) WithPolling(p Polling) *Service {
+	if p.IdleAfter > 0 {
+		s.polling.IdleAfter = p.IdleAfter
+	}
+	if p.IdleInterval > 0 {
+		s.polling.IdleInterval = p.IdleInterval
+	}
+	if p.Workers > 0 {
+		s.polling.Workers = p.Workers
+	}
+	return s
+}
+
+// stampThreshold is how long track has to be listened to before it is stamped
+// under the user's policy, ok is false when the policy never stamps it
+func (s *Service) stampThreshold(userID int64, track *models.Track) (int64, bool) {
+	return s.stamping.For(context.Background(), userID, events.SourceSpotify).Threshold(track.DurationMs)
+}
+
+// pollDelay picks how long to wait before polling a user again. thresholdMs is
+// when the playing track will be stamped, 0 if it won't be
+func pollDelay(state *userPlayState, thresholdMs int64, lastActive, now time.Time, interval time.Duration, p Polling) time.Duration {
+	if state == nil || state.isPaused || state.track == nil {
+		if now.Sub(lastActive) >= p.IdleAfter {
+			return max(p.IdleInterval, interval)
+		}
+		return interval
+	}
+
+	delay := interval
+	// poll just after the track would be stamped, and just after it ends to
+	// catch the next one from its start
+	if !state.hasStamped && thresholdMs > 0 {
+		if remaining := time.Duration(thresholdMs-state.accumulatedMs) * time.Millisecond; remaining > 0 {
+			delay = min(delay, remaining+time.Second)
+		}
+	}
+	if remaining := time.Duration(state.track.DurationMs-state.track.ProgressMs) * time.Millisecond; remaining > 0 {
+		delay = min(delay, remaining+time.Second)
+	}
+	return max(delay, minPollInterval)
+}
+
+// jitter spreads d by up to pollJitter either way so users polled together drift apart
+func jitter(d time.Duration) time.Duration {
+	spread := float64(d) * pollJitter
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// syncSchedules adds newly loaded users, spread over the first interval, and
+// drops users that are no longer loaded
+func (s *Service) syncSchedules(now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userID := range s.userTokens {
+		if _, ok := s.schedules[userID]; !ok {
+			s.schedules[userID] = &pollSchedule{
+				next:       now.Add(time.Duration(rand.Int64N(int64(interval)))),
+				lastActive: now,
+			}
+		}
+	}
+	for userID := range s.schedules {
+		if _, ok := s.userTokens[userID]; !ok {
+			delete(s.schedules, userID)
+		}
+	}
+}
+
+// reschedule sets when a user is next due based on what they were just seen playing
+func (s *Service) reschedule(userID int64, now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sched, ok := s.schedules[userID]
+	if !ok {
+		return
+	}
+	state := s.userPlayStates[userID]
+	var threshold int64
+	if state != nil && !state.isPaused && state.track != nil {
+		sched.lastActive = now
+		if ms, ok := s.stampThreshold(userID, state.track); ok {
+			threshold = ms
+		}
+	}
+	sched.next = now.Add(jitter(pollDelay(state, threshold, sched.lastActive, now, interval, s.polling)))
+}
+
+// pollDue polls every user whose next poll is due, at most Workers at once,
+// and stops dispatching while Spotify has asked us to back off
+func (s *Service) pollDue(ctx context.Context, interval time.Duration) {
+	now := time.Now()
+
+	s.mu.RLock()
+	if now.Before(s.retryAfter) {
+		s.mu.RUnlock()
+		return
+	}
+	var due []int64
+	for userID, sched := range s.schedules {
+		if !now.Before(sched.next) {
+			due = append(due, userID)
+		}
+	}
+	s.mu.RUnlock()
+
+	if len(due) == 0 {
+		return
+	}
+	s.logger.DebugContext(ctx, "polling due users", "count", len(due))
+
+	jobs := make(chan int64)
+	var wg sync.WaitGroup
+	for range min(s.polling.Workers, len(due)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range jobs {
+				s.fetchTrackForUser(ctx, userID)
+				s.reschedule(userID, time.Now(), interval)
+			}
+		}()
+	}
+
+	for _, userID := range due {
+		if ctx.Err() != nil {
+			s.logger.InfoContext(ctx, "context cancelled before starting fetch", logging.UserID(userID))
+			break
+		}
+		if s.rateLimited() {
+			// the rest stay due and are polled once Spotify lets us
+			break
+		}
+		jobs <- userID
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func (s *Service) rateLimited() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Now().Before(s.retryAfter)
+}
+
+// backOff stops polling anyone until Spotify's Retry-After has passed
+func (s *Service) backOff(ctx context.Context, d time.Duration) {
+	s.mu.Lock()
+	until := time.Now().Add(d)
+	if until.After(s.retryAfter) {
+		s.retryAfter = until
+	}
+	s.mu.Unlock()
+	s.logger.WarnContext(ctx, "rate limited by Spotify, pausing polling", "retry_after", d)
+}
@@ -0,0 +1,159 @@
+package spotify
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPollDelay(t *testing.T) {
+	now := time.Now()
+	interval := 30 * time.Second
+	p := defaultPolling()
+
+	tests := []struct {
+		name       string
+		state      *userPlayState
+		lastActive time.Time
+		want       time.Duration
+	}{
+		{
+			name:       "nothing playing recently",
+			lastActive: now.Add(-10 * time.Minute),
+			want:       interval,
+		},
+		{
+			name:       "idle for hours",
+			lastActive: now.Add(-3 * time.Hour),
+			want:       p.IdleInterval,
+		},
+		{
+			name: "paused counts as idle",
+			state: &userPlayState{
+				track:    createTestTrack("Song", "Artist", "url", 200000, 50000),
+				isPaused: true,
+			},
+			lastActive: now.Add(-2 * time.Hour),
+			want:       p.IdleInterval,
+		},
+		{
+			name: "playing far from threshold",
+			state: &userPlayState{
+				track:         createTestTrack("Song", "Artist", "url", 300000, 10000),
+				accumulatedMs: 10000,
+			},
+			lastActive: now,
+			want:       interval,
+		},
+		{
+			name: "about to be stamped",
+			state: &userPlayState{
+				track:         createTestTrack("Song", "Artist", "url", 200000, 88000),
+				accumulatedMs: 88000,
+			},
+			lastActive: now,
+			want:       13 * time.Second,
+		},
+		{
+			name: "stamped and ending",
+			state: &userPlayState{
+				track:         createTestTrack("Song", "Artist", "url", 200000, 190000),
+				accumulatedMs: 190000,
+				hasStamped:    true,
+			},
+			lastActive: now,
+			want:       11 * time.Second,
+		},
+		{
+			name: "never faster than the minimum",
+			state: &userPlayState{
+				track:         createTestTrack("Song", "Artist", "url", 200000, 199500),
+				accumulatedMs: 190000,
+				hasStamped:    true,
+			},
+			lastActive: now,
+			want:       minPollInterval,
+		},
+	}
+
+	s := newTestService(nil, &mockPlayingNowService{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var threshold int64
+			if tt.state != nil && tt.state.track != nil {
+				threshold, _ = s.stampThreshold(1, tt.state.track)
+			}
+			if got := pollDelay(tt.state, threshold, tt.lastActive, now, interval, p); got != tt.want {
+				t.Errorf("pollDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitterStaysInBounds(t *testing.T) {
+	d := 30 * time.Second
+	for range 100 {
+		got := jitter(d)
+		if got < 27*time.Second || got > 33*time.Second {
+			t.Fatalf("jitter(%v) = %v, outside ±10%%", d, got)
+		}
+	}
+}
+
+func TestSyncSchedules(t *testing.T) {
+	s := newTestService(nil, &mockPlayingNowService{})
+	now := time.Now()
+	interval := 30 * time.Second
+	s.userTokens[1] = "a"
+	s.userTokens[2] = "b"
+	s.schedules[3] = &pollSchedule{next: now}
+
+	s.syncSchedules(now, interval)
+
+	if _, ok := s.schedules[3]; ok {
+		t.Error("expected users no longer loaded to be dropped")
+	}
+	for _, userID := range []int64{1, 2} {
+		sched, ok := s.schedules[userID]
+		if !ok {
+			t.Fatalf("expected user %d to be scheduled", userID)
+		}
+		if sched.next.Before(now) || !sched.next.Before(now.Add(interval)) {
+			t.Errorf("expected user %d's first poll within the first interval, got %v", userID, sched.next.Sub(now))
+		}
+	}
+}
+
+func TestPollDueHonorsRetryAfter(t *testing.T) {
+	s := newTestService(nil, &mockPlayingNowService{})
+	now := time.Now()
+	s.userTokens[1] = "a"
+	s.schedules[1] = &pollSchedule{next: now.Add(-time.Second), lastActive: now}
+	s.backOff(context.Background(), time.Minute)
+
+	s.pollDue(context.Background(), 30*time.Second)
+
+	if next := s.schedules[1].next; next.After(now) {
+		t.Errorf("expected the user to stay due while rate limited, next poll moved to %v", next)
+	}
+}
+
+func TestRescheduleTracksActivity(t *testing.T) {
+	s := newTestService(nil, &mockPlayingNowService{})
+	now := time.Now()
+	s.schedules[1] = &pollSchedule{next: now, lastActive: now.Add(-2 * time.Hour)}
+	s.userPlayStates[1] = &userPlayState{
+		track:         createTestTrack("Song", "Artist", "url", 300000, 10000),
+		accumulatedMs: 10000,
+	}
+
+	s.reschedule(1, now, 30*time.Second)
+
+	sched := s.schedules[1]
+	if !sched.lastActive.Equal(now) {
+		t.Error("expected playback to mark the user active")
+	}
+	if delay := sched.next.Sub(now); delay < 27*time.Second || delay > 33*time.Second {
+		t.Errorf("expected an active user to be polled about every interval, got %v", delay)
+	}
+}
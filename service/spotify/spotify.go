@@ -7,10 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -22,10 +21,18 @@ import (
 	// Added for xrpc.Client
 	"github.com/spf13/viper" // Added for teal.AlphaFeedPlay
 	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/health"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/metrics"
 	"github.com/teal-fm/piper/models"
 	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/ratelimit"
 	atprotoservice "github.com/teal-fm/piper/service/atproto"
+	"github.com/teal-fm/piper/service/connection"
+	"github.com/teal-fm/piper/service/events"
+	"github.com/teal-fm/piper/service/history"
 	"github.com/teal-fm/piper/service/musicbrainz"
+	"github.com/teal-fm/piper/service/stamping"
 	"github.com/teal-fm/piper/session"
 )
 
@@ -38,17 +45,32 @@ const maxSkipDeltaMs int64 = 30000
 // userPlayState tracks the listening state for a user, including accumulated
 // listening time per track
 type userPlayState struct {
-	track         *models.Track // Full track info for now-playing and stamping
-	accumulatedMs int64         // Accumulated listening time in ms
-	lastPollTime  time.Time     // When we last polled (for delta calculation)
-	hasStamped    bool          // Whether we've stamped this "play cycle"
-	isPaused      bool          // Whether currently paused
+	track         *models.Track   // Full track info for now-playing and stamping
+	episode       *models.Episode // The podcast episode track stands in for, if any
+	accumulatedMs int64           // Accumulated listening time in ms
+	lastPollTime  time.Time       // When we last polled (for delta calculation)
+	hasStamped    bool            // Whether we've stamped this "play cycle"
+	isPaused      bool            // Whether currently paused
 }
 
 // SpotifyTrackResponse contains track info and playback state from Spotify
 type SpotifyTrackResponse struct {
-	Track     *models.Track
+	Track *models.Track
+	// Episode the podcast episode playing instead of a track
+	Episode   *models.Episode
 	IsPlaying bool
+	// Device what it is playing on, nil when Spotify didn't say
+	Device *Device
+}
+
+// Device a Spotify Connect device
+type Device struct {
+	ID   string
+	Name string
+	// Type such as Computer, Smartphone or Speaker
+	Type string
+	// PrivateSession the user started a private session on it
+	PrivateSession bool
 }
 
 // stateAction describes what external actions to take after state computation
@@ -57,7 +79,9 @@ type stateAction struct {
 	publishNowPlaying bool
 	stampTrack        bool
 	track             *models.Track
-	accumulatedMs     int64
+	// episode set when the playback is a podcast episode, track then only carries its timing
+	episode       *models.Episode
+	accumulatedMs int64
 }
 
 type Service struct {
@@ -70,15 +94,24 @@ type Service struct {
 	} // Added field for playing now service
 	userPlayStates map[int64]*userPlayState
 	userTokens     map[int64]string
-	mu             sync.RWMutex
-	logger         *log.Logger
+	// noPlaybackState users whose link predates the user-read-playback-state scope
+	noPlaybackState map[int64]bool
+	events          *events.Bus
+	health          *health.Tracker
+	connections     *connection.Monitor
+	stamping        *stamping.Resolver
+	polling         Polling
+	schedules       map[int64]*pollSchedule
+	retryAfter      time.Time // no polls until then, set by a 429
+	mu              sync.RWMutex
+	logger          *slog.Logger
 }
 
 func NewSpotifyService(database *db.DB, atprotoAuthService *atprotoauth.AuthService, musicBrainzService *musicbrainz.Service, playingNowService interface {
 	PublishPlayingNow(ctx context.Context, userID int64, track *models.Track) error
 	ClearPlayingNow(ctx context.Context, userID int64) error
 }) *Service {
-	logger := log.New(os.Stdout, "spotify: ", log.LstdFlags|log.Lmsgprefix)
+	logger := logging.For("spotify")
 
 	return &Service{
 		DB:                 database,
@@ -87,14 +120,36 @@ func NewSpotifyService(database *db.DB, atprotoAuthService *atprotoauth.AuthServ
 		playingNowService:  playingNowService,
 		userPlayStates:     make(map[int64]*userPlayState),
 		userTokens:         make(map[int64]string),
+		noPlaybackState:    make(map[int64]bool),
+		connections:        connection.NewMonitor(database, events.SourceSpotify),
+		polling:            defaultPolling(),
+		schedules:          make(map[int64]*pollSchedule),
 		logger:             logger,
 	}
 }
 
+// WithEvents publishes stamped tracks to bus
+func (s *Service) WithEvents(bus *events.Bus) *Service {
+	s.events = bus
+	return s
+}
+
+// WithHealth reports the outcome of each poll to t
+func (s *Service) WithHealth(t *health.Tracker) *Service {
+	s.health = t
+	return s
+}
+
+// WithStamping stamps plays under each user's policy from r instead of the default
+func (s *Service) WithStamping(r *stamping.Resolver) *Service {
+	s.stamping = r
+	return s
+}
+
 func (s *Service) SubmitTrackToPDS(did string, mostRecentAtProtoSessionID string, track *models.Track, ctx context.Context) error {
 	//Had a empty feed.play get submitted not sure why. Tracking here
 	if track.Name == "" {
-		s.logger.Println("Track name is empty. Skipping submission. Please record the logs before and send to the teal.fm Discord")
+		s.logger.WarnContext(ctx, "track name is empty, skipping submission", logging.DID(did))
 		return nil
 	}
 
@@ -105,7 +160,7 @@ func (s *Service) SubmitTrackToPDS(did string, mostRecentAtProtoSessionID string
 func (s *Service) SetAccessToken(token string, refreshToken string, userId int64, hasSession bool) (int64, error) {
 	userID, err := s.identifyAndStoreUser(token, refreshToken, userId, hasSession)
 	if err != nil {
-		s.logger.Printf("Error identifying and storing user: %v", err)
+		s.logger.Error("error identifying and storing user", logging.UserID(userId), logging.Err(err))
 		return 0, err
 	}
 	return userID, nil
@@ -114,16 +169,16 @@ func (s *Service) SetAccessToken(token string, refreshToken string, userId int64
 func (s *Service) identifyAndStoreUser(token string, refreshToken string, userId int64, hasSession bool) (int64, error) {
 	userProfile, err := s.fetchSpotifyProfile(token)
 	if err != nil {
-		s.logger.Printf("Error fetching Spotify profile: %v", err)
+		s.logger.Error("error fetching Spotify profile", logging.UserID(userId), logging.Err(err))
 		return 0, err
 	}
 
-	s.logger.Printf("uid: %d hasSession: %t", userId, hasSession)
+	s.logger.Debug("identifying user", logging.UserID(userId), "has_session", hasSession)
 
 	user, err := s.DB.GetUserBySpotifyID(userProfile.ID)
 	if err != nil {
 		// This error might mean DB connection issue, not just user not found.
-		s.logger.Printf("Error checking for user by Spotify ID %s: %v", userProfile.ID, err)
+		s.logger.Error("error checking for user by Spotify ID", "spotify_id", userProfile.ID, logging.Err(err))
 		return 0, err
 	}
 
@@ -132,23 +187,23 @@ func (s *Service) identifyAndStoreUser(token string, refreshToken string, userId
 	// We don't intend users to log in via spotify!
 	if user == nil {
 		if !hasSession {
-			s.logger.Printf("User does not seem to exist")
+			s.logger.Warn("user does not seem to exist", logging.UserID(userId))
 			return 0, fmt.Errorf("user does not seem to exist")
 		}
 
 		// overwrite prev user
 		user, err = s.DB.AddSpotifySession(userId, userProfile.DisplayName, userProfile.Email, userProfile.ID, token, refreshToken, tokenExpiryTime)
 		if err != nil {
-			s.logger.Printf("Error adding Spotify session for user ID %d: %v", userId, err)
+			s.logger.Error("error adding Spotify session", logging.UserID(userId), logging.Err(err))
 			return 0, err
 		}
 	} else {
 		err = s.DB.UpdateUserToken(user.ID, token, refreshToken, tokenExpiryTime)
 		if err != nil {
 			// for now log and continue
-			s.logger.Printf("Error updating user token for user ID %d: %v", user.ID, err)
+			s.logger.Error("error updating user token", logging.UserID(user.ID), logging.Err(err))
 		} else {
-			s.logger.Printf("Updated token for existing user: %s (ID: %d)", *user.Username, user.ID)
+			s.logger.Info("updated token for existing user", logging.UserID(user.ID))
 		}
 	}
 	if user == nil {
@@ -160,9 +215,12 @@ func (s *Service) identifyAndStoreUser(token string, refreshToken string, userId
 
 	s.mu.Lock()
 	s.userTokens[user.ID] = token
+	// a new link may grant the player state
+	delete(s.noPlaybackState, user.ID)
 	s.mu.Unlock()
+	s.connections.Reset(context.Background(), user.ID)
 
-	s.logger.Printf("User authenticated via Spotify: %s (ID: %d)", *user.Username, user.ID)
+	s.logger.Info("user authenticated via Spotify", logging.UserID(user.ID))
 	return user.ID, nil
 }
 
@@ -177,12 +235,17 @@ func (s *Service) LoadAllUsers() error {
 	if err != nil {
 		return fmt.Errorf("error loading users: %v", err)
 	}
+	// users backing off after errors or waiting to link Spotify again
+	paused := s.connections.Paused(context.Background())
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	count := 0
 	for _, user := range users {
+		if paused[user.ID] {
+			continue
+		}
 		// load users with valid tokens
 		if user.AccessToken != nil && user.TokenExpiry.After(time.Now().UTC()) {
 			s.userTokens[user.ID] = *user.AccessToken
@@ -193,8 +256,8 @@ func (s *Service) LoadAllUsers() error {
 			//We do not need to use the output of refreshTokenInner since it is added to the list inside the function
 			_, err := s.refreshTokenInner(user.ID)
 			if err != nil {
-				//Probably should remove the access token and refresh in long run?
-				s.logger.Printf("Error refreshing token for user %d: %v", user.ID, err)
+				s.logger.Error("error refreshing token", logging.UserID(user.ID), logging.Err(err))
+				s.connections.Failure(context.Background(), user.ID, err)
 				s.mu.Lock()
 				continue
 			}
@@ -202,7 +265,8 @@ func (s *Service) LoadAllUsers() error {
 			s.mu.Lock()
 		}
 	}
-	s.logger.Printf("Loaded %d active users with valid tokens", count)
+	s.logger.Debug("loaded active users with valid tokens", "count", count)
+	metrics.SetActiveUsers(events.SourceSpotify, count)
 	return nil
 }
 
@@ -213,6 +277,15 @@ func (s *Service) UnloadAllUsers() error {
 	return nil
 }
 
+// ForgetUser drops the user's cached token and play state, e.g. after their account was deleted
+func (s *Service) ForgetUser(user *models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.userTokens, user.ID)
+	delete(s.userPlayStates, user.ID)
+	delete(s.noPlaybackState, user.ID)
+}
+
 // refreshTokenInner handles the actual Spotify token refresh logic.
 // It returns the new access token or an error.
 func (s *Service) refreshTokenInner(userID int64) (string, error) {
@@ -230,7 +303,7 @@ func (s *Service) refreshTokenInner(userID int64) (string, error) {
 		s.mu.Lock()
 		delete(s.userTokens, userID)
 		s.mu.Unlock()
-		return "", fmt.Errorf("no refresh token available for user %d", userID)
+		return "", fmt.Errorf("%w: no refresh token available for user %d", connection.ErrReauthRequired, userID)
 	}
 
 	clientID := viper.GetString("spotify.client_id")
@@ -260,7 +333,7 @@ func (s *Service) refreshTokenInner(userID int64) (string, error) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			s.logger.Printf("Failed to close refresh response body: %v", err)
+			s.logger.Error("failed to close refresh response body", logging.Err(err))
 		}
 	}(resp.Body)
 
@@ -270,16 +343,20 @@ func (s *Service) refreshTokenInner(userID int64) (string, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		// If refresh fails (e.g., bad refresh token), remove tokens from cache
+		// a 400/401 is Spotify saying the refresh token was revoked (invalid_grant);
+		// anything else (5xx, 429) is transient, so keep the tokens and let backoff retry
+		if resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusUnauthorized {
+			return "", fmt.Errorf("spotify token refresh failed (%d): %s", resp.StatusCode, string(body))
+		}
 		s.mu.Lock()
 		delete(s.userTokens, userID)
 		s.mu.Unlock()
 		// Also clear the bad refresh token from the DB
 		updateErr := s.DB.UpdateUserToken(userID, "", "", time.Now().UTC()) // Clear tokens
 		if updateErr != nil {
-			s.logger.Printf("Failed to clear bad refresh token for user %d: %v", userID, updateErr)
+			s.logger.Error("failed to clear bad refresh token", logging.UserID(userID), logging.Err(updateErr))
 		}
-		return "", fmt.Errorf("spotify token refresh failed (%d): %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("%w: spotify token refresh failed (%d): %s", connection.ErrReauthRequired, resp.StatusCode, string(body))
 	}
 
 	var tokenResponse struct {
@@ -303,7 +380,7 @@ func (s *Service) refreshTokenInner(userID int64) (string, error) {
 	// Update DB
 	if err := s.DB.UpdateUserToken(userID, tokenResponse.AccessToken, newRefreshToken, newExpiry); err != nil {
 		// Log error but continue, as we have the token in memory
-		s.logger.Printf("Error updating user token in DB for user %d after refresh: %v", userID, err)
+		s.logger.Error("error updating user token after refresh", logging.UserID(userID), logging.Err(err))
 	}
 
 	// Update in-memory cache
@@ -311,7 +388,7 @@ func (s *Service) refreshTokenInner(userID int64) (string, error) {
 	s.userTokens[userID] = tokenResponse.AccessToken
 	s.mu.Unlock()
 
-	s.logger.Printf("Successfully refreshed token for user %d", userID)
+	s.logger.Debug("refreshed token", logging.UserID(userID))
 	return tokenResponse.AccessToken, nil
 }
 
@@ -326,7 +403,7 @@ func (s *Service) RefreshToken(userID int64) error {
 func (s *Service) RefreshExpiredTokens() {
 	users, err := s.DB.GetUsersWithExpiredTokens()
 	if err != nil {
-		s.logger.Printf("Error fetching users with expired tokens: %v", err)
+		s.logger.Error("error fetching users with expired tokens", logging.Err(err))
 		return
 	}
 
@@ -341,14 +418,14 @@ func (s *Service) RefreshExpiredTokens() {
 
 		if err != nil {
 			// just print out errors here for now
-			s.logger.Printf("Error from service/spotify/spotify.go when refreshing tokens: %s", err.Error())
+			s.logger.Error("error refreshing tokens", logging.Err(err))
 		}
 
 		refreshed++
 	}
 
 	if refreshed > 0 {
-		s.logger.Printf("Refreshed tokens for %d users", refreshed)
+		s.logger.Info("refreshed tokens", "users", refreshed)
 	}
 }
 
@@ -367,7 +444,7 @@ func (s *Service) fetchSpotifyProfile(token string) (*spotifyProfile, error) {
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			s.logger.Printf("Failed to close spotify profile response body: %v", err)
+			s.logger.Error("failed to close profile response body", logging.Err(err))
 		}
 	}(resp.Body)
 
@@ -398,40 +475,27 @@ func (s *Service) HandleCurrentTrack(w http.ResponseWriter, r *http.Request) {
 	if !exists || state == nil || state.track == nil {
 		_, err := fmt.Fprintf(w, "No track currently playing")
 		if err != nil {
-			s.logger.Printf("Error writing response: %v", err)
+			s.logger.ErrorContext(r.Context(), "error writing response", logging.Err(err))
 			return
 		}
 		return
 	}
 
+	var current any = state.track
+	if state.episode != nil {
+		current = state.episode
+	}
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(state.track)
+	err := json.NewEncoder(w).Encode(current)
 	if err != nil {
-		s.logger.Printf("Error encoding response: %v", err)
+		s.logger.ErrorContext(r.Context(), "error encoding response", logging.Err(err))
 		return
 	}
 }
 
+// HandleTrackHistory serves the history with the same filters and cursors as /api/v1/history, 20 tracks per page
 func (s *Service) HandleTrackHistory(w http.ResponseWriter, r *http.Request) {
-	userID, ok := session.GetUserID(r.Context())
-	if !ok {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	tracks, err := s.DB.GetRecentTracks(userID, 20)
-	if err != nil {
-		http.Error(w, "Error retrieving track history", http.StatusInternalServerError)
-		s.logger.Printf("Error retrieving track history: %v", err)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(tracks)
-	if err != nil {
-		s.logger.Printf("Error encoding response: %v", err)
-		return
-	}
+	history.Serve(w, r, s.DB, 20)
 }
 
 func generateLocalHash(track *models.Track) string {
@@ -440,7 +504,42 @@ func generateLocalHash(track *models.Track) string {
 	return fmt.Sprintf("sp_local_%x", hash)
 }
 
+const (
+	// playerEndpoint the player state, the currently playing track plus the device it is on
+	playerEndpoint = "https://api.spotify.com/v1/me/player?additional_types=episode"
+	// currentlyPlayingEndpoint the track alone, for links made before piper
+	// asked for user-read-playback-state
+	currentlyPlayingEndpoint = "https://api.spotify.com/v1/me/player/currently-playing?additional_types=episode"
+)
+
+// errNoPlaybackState the user's link doesn't grant reading the player state
+var errNoPlaybackState = errors.New("spotify link lacks the user-read-playback-state scope")
+
+// FetchCurrentTrack returns what the user is playing and on which device. Users
+// who linked Spotify before devices were read get the track without its device
+// until they reconnect
 func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error) {
+	s.mu.RLock()
+	noPlaybackState := s.noPlaybackState[userID]
+	s.mu.RUnlock()
+
+	if !noPlaybackState {
+		resp, err := s.fetchPlayback(userID, playerEndpoint)
+		if !errors.Is(err, errNoPlaybackState) {
+			return resp, err
+		}
+		s.logger.Info("Spotify link can't read devices until it is reconnected", logging.UserID(userID))
+		s.mu.Lock()
+		if s.noPlaybackState == nil {
+			s.noPlaybackState = make(map[int64]bool)
+		}
+		s.noPlaybackState[userID] = true
+		s.mu.Unlock()
+	}
+	return s.fetchPlayback(userID, currentlyPlayingEndpoint)
+}
+
+func (s *Service) fetchPlayback(userID int64, endpoint string) (*SpotifyTrackResponse, error) {
 	s.mu.RLock()
 	token, exists := s.userTokens[userID]
 	s.mu.RUnlock()
@@ -449,13 +548,13 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		return nil, fmt.Errorf("no access token for user %d", userID)
 	}
 
-	req, rErr := http.NewRequest("GET", "https://api.spotify.com/v1/me/player/currently-playing", nil)
+	req, rErr := http.NewRequest("GET", endpoint, nil)
 	if rErr != nil {
 		return nil, rErr
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
-	client := &http.Client{}
+	client := &http.Client{Transport: metrics.NewTransport("spotify", nil)}
 	var resp *http.Response
 	var err error
 
@@ -474,20 +573,32 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 
 		// oops, token expired or other client error
 		if resp.StatusCode == 401 && attempt == 0 { // Only refresh on 401 on the first attempt
-			s.logger.Printf("Spotify token potentially expired for user %d, attempting refresh...", userID)
+			s.logger.Info("token potentially expired, attempting refresh", logging.UserID(userID))
 			newAccessToken, refreshErr := s.refreshTokenInner(userID)
 			if refreshErr != nil {
-				s.logger.Printf("Token refresh failed for user %d: %v", userID, refreshErr)
+				s.logger.Error("token refresh failed", logging.UserID(userID), logging.Err(refreshErr))
 				// No point retrying if refresh failed
 				return nil, fmt.Errorf("spotify token expired or invalid for user %d and refresh failed: %w", userID, refreshErr)
 			}
-			s.logger.Printf("Token refreshed for user %d, retrying request...", userID)
+			s.logger.Debug("token refreshed, retrying request", logging.UserID(userID))
 			token = newAccessToken                           // Update token for the next attempt
 			req.Header.Set("Authorization", "Bearer "+token) // Update header for retry
 			continue                                         // Go to next attempt in the loop
 		}
 
 		// If it's not 200 or 204, or if it's 401 on the second attempt, break and return error
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			return nil, ratelimit.NewRetryAfterError(events.SourceSpotify, resp, defaultRetryAfter)
+		}
+		if resp.StatusCode == http.StatusForbidden && endpoint == playerEndpoint {
+			resp.Body.Close()
+			return nil, errNoPlaybackState
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			body, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("%w: spotify API error (%d) for user %d: %s", connection.ErrReauthRequired, resp.StatusCode, userID, string(body))
+		}
 		if resp.StatusCode != 200 && resp.StatusCode != 204 {
 			body, _ := io.ReadAll(resp.Body)
 			return nil, fmt.Errorf("spotify API error (%d) for user %d after %d attempts: %s", resp.StatusCode, userID, attempt+1, string(body))
@@ -502,7 +613,7 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		defer func(Body io.ReadCloser) {
 			err := Body.Close()
 			if err != nil {
-				s.logger.Printf("Failed to close spotify response body: %v", err)
+				s.logger.Error("failed to close response body", logging.Err(err))
 			}
 		}(resp.Body)
 	}
@@ -522,8 +633,21 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		return nil, fmt.Errorf("failed to read successful spotify response body: %w", err)
 	}
 
+	return parsePlayback(bodyBytes)
+}
+
+// parsePlayback reads a player state response into the playing track and the
+// device it is playing on
+func parsePlayback(body []byte) (*SpotifyTrackResponse, error) {
 	var response struct {
+		Device *struct {
+			ID             string `json:"id"`
+			Name           string `json:"name"`
+			Type           string `json:"type"`
+			PrivateSession bool   `json:"is_private_session"`
+		} `json:"device"`
 		Item struct {
+			Type    string `json:"type"`
 			Name    string `json:"name"`
 			Artists []struct {
 				Name string `json:"name"`
@@ -539,16 +663,49 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 				Spotify string `json:"spotify"`
 			} `json:"external_urls"`
 			DurationMs int `json:"duration_ms"`
+			// episodes only
+			Show struct {
+				Name      string `json:"name"`
+				Publisher string `json:"publisher"`
+			} `json:"show"`
 		} `json:"item"`
 		ProgressMS int  `json:"progress_ms"`
 		IsPlaying  bool `json:"is_playing"`
 	}
 
-	err = json.Unmarshal(bodyBytes, &response) // Use bodyBytes here
+	err := json.Unmarshal(body, &response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal spotify response: %w", err)
 	}
 
+	var device *Device
+	if response.Device != nil {
+		device = &Device{
+			ID:             response.Device.ID,
+			Name:           response.Device.Name,
+			Type:           response.Device.Type,
+			PrivateSession: response.Device.PrivateSession,
+		}
+	}
+
+	if response.Item.Type == "episode" {
+		episode := &models.Episode{
+			Name:           response.Item.Name,
+			Show:           response.Item.Show.Name,
+			Publisher:      response.Item.Show.Publisher,
+			URL:            response.Item.ExternalURLs.Spotify,
+			DurationMs:     int64(response.Item.DurationMs),
+			ProgressMs:     int64(response.ProgressMS),
+			ServiceBaseUrl: "open.spotify.com",
+			Timestamp:      time.Now().UTC(),
+		}
+		if device != nil {
+			episode.DeviceName = device.Name
+			episode.DeviceType = device.Type
+		}
+		return &SpotifyTrackResponse{Episode: episode, IsPlaying: response.IsPlaying, Device: device}, nil
+	}
+
 	var artists []models.Artist
 	for _, artist := range response.Item.Artists {
 		artists = append(artists, models.Artist{
@@ -557,9 +714,10 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		})
 	}
 
-	// ignore tracks with no artists (podcasts, audiobooks, etc)
+	// ignore tracks with no artists (ads, etc). Audiobooks aren't supported: the player API
+	// only reports tracks and episodes, additional_types can't ask for audiobook chapters
 	if len(artists) == 0 {
-		return &SpotifyTrackResponse{Track: nil, IsPlaying: response.IsPlaying}, nil
+		return &SpotifyTrackResponse{Track: nil, IsPlaying: response.IsPlaying, Device: device}, nil
 	}
 
 	// assemble Track
@@ -575,6 +733,10 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		HasStamped:     false,
 		Timestamp:      time.Now().UTC(),
 	}
+	if device != nil {
+		track.DeviceName = device.Name
+		track.DeviceType = device.Type
+	}
 
 	// Local files have no URL. We hash the song name, album name, and
 	// artist name to form a consistent URL.
@@ -582,7 +744,33 @@ func (s *Service) FetchCurrentTrack(userID int64) (*SpotifyTrackResponse, error)
 		track.URL = generateLocalHash(track)
 	}
 
-	return &SpotifyTrackResponse{Track: track, IsPlaying: response.IsPlaying}, nil
+	return &SpotifyTrackResponse{Track: track, IsPlaying: response.IsPlaying, Device: device}, nil
+}
+
+// filterPlayback drops playback the user left out of tracking: private
+// sessions, ignored devices and podcast episodes unless they opted in are
+// treated as if nothing were playing
+func (s *Service) filterPlayback(ctx context.Context, userID int64, resp *SpotifyTrackResponse) *SpotifyTrackResponse {
+	if resp == nil || (resp.Device == nil && resp.Episode == nil) {
+		return resp
+	}
+	settings, err := s.DB.GetSpotifySettings(userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error loading Spotify settings", logging.UserID(userID), logging.Err(err))
+		// err on the side of privacy
+		settings = models.DefaultSpotifySettings()
+	}
+	switch {
+	case resp.Device != nil && settings.SkipPrivateSessions && resp.Device.PrivateSession:
+		s.logger.DebugContext(ctx, "skipping private session", logging.UserID(userID))
+	case resp.Device != nil && settings.IgnoresDevice(resp.Device.ID, resp.Device.Name):
+		s.logger.DebugContext(ctx, "skipping ignored device", logging.UserID(userID), "device", resp.Device.Name)
+	case resp.Episode != nil && !settings.TracksEpisodes():
+		s.logger.DebugContext(ctx, "skipping podcast episode", logging.UserID(userID))
+	default:
+		return resp
+	}
+	return &SpotifyTrackResponse{IsPlaying: resp.IsPlaying, Device: resp.Device}
 }
 
 func getFirstArtist(track *models.Track) string {
@@ -606,8 +794,18 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 
 	state := s.userPlayStates[userID]
 
+	// episodes go through the same play cycle as tracks, keyed by their URL
+	var track *models.Track
+	if resp != nil {
+		track = resp.Track
+		if track == nil && resp.Episode != nil {
+			track = episodeTiming(resp.Episode)
+			action.episode = resp.Episode
+		}
+	}
+
 	// No track from Spotify (nothing playing, not even paused)
-	if resp == nil || resp.Track == nil {
+	if track == nil {
 		// If the user already has some state, pause it
 		if state != nil {
 			state.isPaused = true
@@ -616,7 +814,6 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 		return action
 	}
 
-	track := resp.Track
 	action.track = track
 
 	// Track is paused
@@ -632,6 +829,7 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 			// used to prevent instant skips past the stamping point
 			s.userPlayStates[userID] = &userPlayState{
 				track:         track,
+				episode:       action.episode,
 				accumulatedMs: min(track.ProgressMs, maxSkipDeltaMs),
 				lastPollTime:  now,
 				hasStamped:    false,
@@ -653,6 +851,7 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 		// used to prevent instant skips past the stamping point
 		s.userPlayStates[userID] = &userPlayState{
 			track:         track,
+			episode:       action.episode,
 			accumulatedMs: min(track.ProgressMs, maxSkipDeltaMs),
 			lastPollTime:  now,
 			hasStamped:    false,
@@ -660,10 +859,11 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 		}
 		state = s.userPlayStates[userID]
 		action.publishNowPlaying = true
-		s.logger.Printf("Track changed for user %d: %s by %s", userID, track.Name, getFirstArtist(track))
+		s.logger.Debug("track changed", logging.UserID(userID), logging.Track(track))
 	} else {
 		// Same song continuing
 		state.track = track
+		state.episode = action.episode
 		if state.isPaused {
 			// Resuming from pause - just mark as playing, don't add delta yet
 			//
@@ -689,33 +889,59 @@ func (s *Service) computeStateUpdate(userID int64, resp *SpotifyTrackResponse) s
 		// polling latency (leaves acc > 0 after in many cases).
 		state.accumulatedMs -= track.DurationMs
 		state.hasStamped = false
-		s.logger.Printf(
-			"Song repeat detected for user %d: %s (acc: %dms, dur: %dms)",
-			userID, track.Name, state.accumulatedMs, state.track.DurationMs,
-		)
+		s.logger.Debug("song repeat detected", logging.UserID(userID), logging.Track(track),
+			"accumulated_ms", state.accumulatedMs, "duration_ms", state.track.DurationMs)
 	}
 
-	// Check for stamp threshold
-	// We stamp a track iff we've played more than half or 30 seconds, whichever is greater
-	stampThreshold := max(track.DurationMs/2, 30000)
-	if state.accumulatedMs > stampThreshold && !state.hasStamped {
+	// Check for stamp threshold, set by the user's stamping policy
+	if threshold, ok := s.stampThreshold(userID, track); ok && state.accumulatedMs > threshold && !state.hasStamped {
 		state.hasStamped = true
 		action.stampTrack = true
 		action.accumulatedMs = state.accumulatedMs
 	}
 
+	// now playing is about music, an episode clears the track it replaced
+	if action.episode != nil && action.publishNowPlaying {
+		action.publishNowPlaying = false
+		action.clearNowPlaying = true
+	}
+
 	return action
 }
 
+// episodeTiming the parts of an episode the play cycle needs, as a track
+func episodeTiming(episode *models.Episode) *models.Track {
+	return &models.Track{
+		Name:           episode.Name,
+		URL:            episode.URL,
+		DurationMs:     episode.DurationMs,
+		ProgressMs:     episode.ProgressMs,
+		ServiceBaseUrl: episode.ServiceBaseUrl,
+		Timestamp:      episode.Timestamp,
+	}
+}
+
 // fetchTrackForUser fetches the current track from Spotify, computes the
 // state update, and executes any required external actions.
 func (s *Service) fetchTrackForUser(ctx context.Context, userID int64) {
 	// Fetch from Spotify
+	start := time.Now()
 	resp, err := s.FetchCurrentTrack(userID)
+	metrics.ObservePoll(events.SourceSpotify, start, err)
+	s.health.Observe(err)
+	var limited *ratelimit.RetryAfterError
+	if errors.As(err, &limited) {
+		// the limit is for the whole app, not this user's connection
+		s.backOff(ctx, limited.RetryAfter)
+		return
+	}
 	if err != nil {
-		s.logger.Printf("Error fetching track for user %d: %v", userID, err)
+		s.logger.ErrorContext(ctx, "error fetching track", logging.UserID(userID), logging.Err(err))
+		s.connections.Failure(ctx, userID, err)
 		return
 	}
+	s.connections.Success(ctx, userID)
+	resp = s.filterPlayback(ctx, userID, resp)
 
 	// Compute state changes (holds lock internally)
 	action := s.computeStateUpdate(userID, resp)
@@ -723,45 +949,27 @@ func (s *Service) fetchTrackForUser(ctx context.Context, userID int64) {
 	// Execute external calls based on computed actions (no lock held)
 	if action.clearNowPlaying && s.playingNowService != nil {
 		if err := s.playingNowService.ClearPlayingNow(ctx, userID); err != nil {
-			s.logger.Printf("Error clearing playing now for user %d: %v", userID, err)
+			s.logger.ErrorContext(ctx, "error clearing playing now", logging.UserID(userID), logging.Err(err))
 		}
 	}
 
 	if action.publishNowPlaying && s.playingNowService != nil {
 		if err := s.playingNowService.PublishPlayingNow(ctx, userID, action.track); err != nil {
-			s.logger.Printf("Error publishing playing now for user %d: %v", userID, err)
+			s.logger.ErrorContext(ctx, "error publishing playing now", logging.UserID(userID), logging.Err(err))
 		}
 	}
 
-	if action.stampTrack {
-		s.logger.Printf(
-			"User %d stamped track: %s by %s (acc: %dms, dur: %dms)",
-			userID, action.track.Name, getFirstArtist(action.track),
-			action.accumulatedMs, action.track.DurationMs,
-		)
+	if action.stampTrack && action.episode != nil {
+		s.logger.InfoContext(ctx, "stamped episode", logging.UserID(userID), "show", action.episode.Show, "episode", action.episode.Name,
+			"accumulated_ms", action.accumulatedMs, "duration_ms", action.episode.DurationMs)
+		s.stampEpisode(ctx, userID, action.episode)
+	} else if action.stampTrack {
+		s.logger.InfoContext(ctx, "stamped track", logging.UserID(userID), logging.Track(action.track),
+			"accumulated_ms", action.accumulatedMs, "duration_ms", action.track.DurationMs)
 		s.stampTrack(ctx, userID, action.track)
 	}
 }
 
-func (s *Service) fetchAllUserTracks(ctx context.Context) {
-	// copy userIDs to avoid holding the lock too long
-	s.mu.RLock()
-	userIDs := make([]int64, 0, len(s.userTokens))
-	for userID := range s.userTokens {
-		userIDs = append(userIDs, userID)
-	}
-	s.mu.RUnlock()
-
-	for _, userID := range userIDs {
-		if ctx.Err() != nil {
-			s.logger.Printf("Context cancelled before starting fetch for user id %d.", userID)
-			break // Exit loop if context is cancelled
-		}
-
-		s.fetchTrackForUser(ctx, userID)
-	}
-}
-
 // stampTrack handles MusicBrainz hydration, DB save, and PDS submission for a stamped track.
 func (s *Service) stampTrack(ctx context.Context, userID int64, track *models.Track) {
 	track.HasStamped = true
@@ -770,29 +978,30 @@ func (s *Service) stampTrack(ctx context.Context, userID int64, track *models.Tr
 	if s.mb != nil {
 		hydratedTrack, err := musicbrainz.HydrateTrack(s.mb, *track)
 		if err != nil {
-			s.logger.Printf("User %d: Error hydrating track '%s' with MusicBrainz: %v", userID, track.Name, err)
+			s.logger.WarnContext(ctx, "error hydrating track with MusicBrainz", logging.UserID(userID), logging.Track(track), logging.Err(err))
 		} else {
-			s.logger.Printf("User %d: Successfully hydrated track '%s'", userID, track.Name)
+			s.logger.DebugContext(ctx, "hydrated track", logging.UserID(userID), logging.Track(track))
 			trackToSubmit = hydratedTrack
 		}
 	}
 
 	// Save the track now that it is stamped and hydrated
 	if _, err := s.DB.SaveTrack(userID, trackToSubmit); err != nil {
-		s.logger.Printf("Error saving track for user %d: %v", userID, err)
+		s.logger.ErrorContext(ctx, "error saving track", logging.UserID(userID), logging.Err(err))
 		return
 	}
+	s.events.PublishStamped(userID, trackToSubmit, events.SourceSpotify)
 
 	// Submit play record to ATProto PDS
 
 	// Fetch the user
 	dbUser, err := s.DB.GetUserByID(userID)
 	if err != nil {
-		s.logger.Printf("User %d: Error fetching user for PDS: %v", userID, err)
+		s.logger.ErrorContext(ctx, "error fetching user for PDS", logging.UserID(userID), logging.Err(err))
 		return
 	}
 	if dbUser == nil {
-		s.logger.Printf("User %d: User not found in DB. Skipping PDS submission.", userID)
+		s.logger.WarnContext(ctx, "user not found, skipping PDS submission", logging.UserID(userID))
 		return
 	}
 	if dbUser.ATProtoDID == nil || *dbUser.ATProtoDID == "" {
@@ -801,55 +1010,76 @@ func (s *Service) stampTrack(ctx context.Context, userID int64, track *models.Tr
 	}
 
 	// Perform submission to PDS
-	s.logger.Printf("User %d: Submitting track '%s' to PDS (DID: %s)", userID, trackToSubmit.Name, *dbUser.ATProtoDID)
+	s.logger.DebugContext(ctx, "submitting track to PDS", logging.UserID(userID), logging.DID(*dbUser.ATProtoDID), logging.Track(trackToSubmit))
 	if err := s.SubmitTrackToPDS(*dbUser.ATProtoDID, *dbUser.MostRecentAtProtoSessionID, trackToSubmit, ctx); err != nil {
-		s.logger.Printf("User %d: Error submitting to PDS: %v", userID, err)
+		s.logger.ErrorContext(ctx, "error submitting to PDS", logging.UserID(userID), logging.DID(*dbUser.ATProtoDID), logging.Err(err))
 	} else {
-		s.logger.Printf("User %d: Successfully submitted track '%s' to PDS", userID, trackToSubmit.Name)
+		s.logger.DebugContext(ctx, "submitted track to PDS", logging.UserID(userID), logging.Track(trackToSubmit))
 	}
 }
 
-func (s *Service) StartListeningTracker(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+// stampEpisode saves a stamped podcast episode and publishes it as a
+// feed.episode record when the user asked for that
+func (s *Service) stampEpisode(ctx context.Context, userID int64, episode *models.Episode) {
+	episode.HasStamped = true
+	if _, err := s.DB.SaveEpisode(userID, episode); err != nil {
+		s.logger.ErrorContext(ctx, "error saving episode", logging.UserID(userID), logging.Err(err))
+		return
+	}
 
-	go func() {
-		if err := s.LoadAllUsers(); err != nil {
-			s.logger.Printf("Error loading spotify users: %v", err)
-		}
+	settings, err := s.DB.GetSpotifySettings(userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error loading Spotify settings", logging.UserID(userID), logging.Err(err))
+		return
+	}
+	if settings.Episodes != models.EpisodesPublish || s.atprotoAuthService == nil {
+		return
+	}
 
-		if len(s.userTokens) > 0 {
-			s.fetchAllUserTracks(context.Background())
-		} else {
-			s.logger.Printf("No users to fetch tracks for.")
-		}
+	dbUser, err := s.DB.GetUserByID(userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error fetching user for PDS", logging.UserID(userID), logging.Err(err))
+		return
+	}
+	if dbUser == nil || dbUser.ATProtoDID == nil || *dbUser.ATProtoDID == "" || dbUser.MostRecentAtProtoSessionID == nil {
+		return
+	}
+	if err := atprotoservice.SubmitEpisodeToPDS(ctx, *dbUser.ATProtoDID, *dbUser.MostRecentAtProtoSessionID, episode, s.atprotoAuthService); err != nil {
+		s.logger.ErrorContext(ctx, "error submitting episode to PDS", logging.UserID(userID), logging.DID(*dbUser.ATProtoDID), logging.Err(err))
+	}
+}
 
-		//unloading users to save memory and make sure we get new signups
-		err := s.UnloadAllUsers()
-		if err != nil {
-			log.Printf("Error loading spotify users: %v", err)
-		}
+// StartListeningTracker reloads users every interval and polls each of them
+// when due, see Polling
+func (s *Service) StartListeningTracker(interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
 
-		for range ticker.C {
-			s.logger.Printf("Fetching tracks...")
-			err := s.LoadAllUsers()
-			if err != nil {
-				s.logger.Printf("Error loading spotify users: %v", err)
-				continue
-			}
-			if len(s.userTokens) > 0 {
-				s.fetchAllUserTracks(context.Background())
-			} else {
-				s.logger.Printf("No users to fetch tracks for.")
-				continue
-			}
-			//unloading users to save memory and make sure we get new signups
-			err = s.UnloadAllUsers()
-			if err != nil {
-				log.Printf("Error loading spotify users: %v", err)
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+
+		var reloaded time.Time
+		for now := time.Now(); ; now = <-ticker.C {
+			if now.Sub(reloaded) >= interval {
+				s.reloadUsers(now, interval)
+				reloaded = now
 			}
-			s.logger.Printf("Finished fetch cycle suscessfully.")
-
+			s.pollDue(ctx, interval)
 		}
 	}()
+}
 
+// reloadUsers picks up new signups and refreshed tokens, and drops users who
+// unlinked Spotify or are paused after errors
+func (s *Service) reloadUsers(now time.Time, interval time.Duration) {
+	if err := s.UnloadAllUsers(); err != nil {
+		s.logger.Error("error unloading users", logging.Err(err))
+	}
+	if err := s.LoadAllUsers(); err != nil {
+		s.logger.Error("error loading users", logging.Err(err))
+	}
+	s.syncSchedules(now, interval)
 }
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -90,7 +90,9 @@ func newTestService(database *db.DB, playingNow *mockPlayingNowService) *Service
 		playingNowService:  playingNow,
 		userPlayStates:     make(map[int64]*userPlayState),
 		userTokens:         make(map[int64]string),
-		logger:             log.New(io.Discard, "", 0),
+		polling:            defaultPolling(),
+		schedules:          make(map[int64]*pollSchedule),
+		logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
 	}
 }
 
@@ -1413,3 +1415,172 @@ func TestGenerateLocalHash(t *testing.T) {
 		}
 	})
 }
+
+// ===== device filtering Tests =====
+
+func TestParsePlaybackDevice(t *testing.T) {
+	body := []byte(`{
+		"device": {"id": "abc", "name": "Living Room", "type": "Speaker", "is_private_session": true},
+		"item": {
+			"name": "One More Time",
+			"artists": [{"name": "Daft Punk", "id": "4tZwfgrHOc3mvqYlEYSvVi"}],
+			"album": {"name": "Discovery"},
+			"external_urls": {"spotify": "https://open.spotify.com/track/0DiWol3AO6WpXZgp0goxAV"},
+			"duration_ms": 320357
+		},
+		"progress_ms": 1000,
+		"is_playing": true
+	}`)
+
+	resp, err := parsePlayback(body)
+	if err != nil {
+		t.Fatalf("parsePlayback: %v", err)
+	}
+	if resp.Device == nil || resp.Device.ID != "abc" || !resp.Device.PrivateSession {
+		t.Fatalf("Expected the private session device, got %+v", resp.Device)
+	}
+	if resp.Track == nil || resp.Track.DeviceName != "Living Room" || resp.Track.DeviceType != "Speaker" {
+		t.Fatalf("Expected the track to carry its device, got %+v", resp.Track)
+	}
+
+	// the currently playing endpoint leaves the device out
+	resp, err = parsePlayback([]byte(`{"item": {"name": "x", "artists": [{"name": "y"}]}, "is_playing": true}`))
+	if err != nil {
+		t.Fatalf("parsePlayback: %v", err)
+	}
+	if resp.Device != nil || resp.Track.DeviceName != "" {
+		t.Errorf("Expected no device, got %+v", resp.Device)
+	}
+}
+
+func TestFilterPlayback(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := createTestUser(t, database)
+	service := newTestService(database, &mockPlayingNowService{})
+	ctx := context.Background()
+
+	playback := func(device *Device) *SpotifyTrackResponse {
+		return &SpotifyTrackResponse{
+			Track:     createTestTrack("Test Song", "Test Artist", "https://open.spotify.com/track/1", 180000, 1000),
+			IsPlaying: true,
+			Device:    device,
+		}
+	}
+
+	if resp := service.filterPlayback(ctx, userID, playback(&Device{Name: "Phone", PrivateSession: true})); resp.Track != nil {
+		t.Error("Expected private sessions to be skipped by default")
+	}
+	if resp := service.filterPlayback(ctx, userID, playback(&Device{Name: "Phone"})); resp.Track == nil {
+		t.Error("Expected playback on other devices to be kept")
+	}
+	if resp := service.filterPlayback(ctx, userID, playback(nil)); resp.Track == nil {
+		t.Error("Expected playback without a device to be kept")
+	}
+
+	err := database.SetSpotifySettings(userID, &models.SpotifySettings{IgnoredDevices: []string{"living room"}})
+	if err != nil {
+		t.Fatalf("SetSpotifySettings: %v", err)
+	}
+	if resp := service.filterPlayback(ctx, userID, playback(&Device{Name: "Living Room"})); resp.Track != nil {
+		t.Error("Expected the ignored device to be skipped")
+	}
+	if resp := service.filterPlayback(ctx, userID, playback(&Device{Name: "Phone", PrivateSession: true})); resp.Track == nil {
+		t.Error("Expected private sessions to be kept once the user allows them")
+	}
+	episode := &SpotifyTrackResponse{Episode: &models.Episode{Name: "Episode 1", Show: "Show"}, IsPlaying: true}
+	if resp := service.filterPlayback(ctx, userID, episode); resp.Episode != nil {
+		t.Error("Expected podcast episodes to be ignored by default")
+	}
+	if err := database.SetSpotifySettings(userID, &models.SpotifySettings{Episodes: models.EpisodesLocal}); err != nil {
+		t.Fatalf("SetSpotifySettings: %v", err)
+	}
+	if resp := service.filterPlayback(ctx, userID, episode); resp.Episode == nil {
+		t.Error("Expected podcast episodes to be kept once the user opts in")
+	}
+}
+
+// ===== podcast episode Tests =====
+
+func TestParsePlaybackEpisode(t *testing.T) {
+	body := []byte(`{
+		"currently_playing_type": "episode",
+		"item": {
+			"type": "episode",
+			"name": "The Making of Discovery",
+			"duration_ms": 3600000,
+			"external_urls": {"spotify": "https://open.spotify.com/episode/abc"},
+			"show": {"name": "Song Exploder", "publisher": "Hrishikesh Hirway"}
+		},
+		"progress_ms": 60000,
+		"is_playing": true
+	}`)
+
+	resp, err := parsePlayback(body)
+	if err != nil {
+		t.Fatalf("parsePlayback: %v", err)
+	}
+	if resp.Track != nil {
+		t.Fatalf("Expected no track for an episode, got %+v", resp.Track)
+	}
+	e := resp.Episode
+	if e == nil || e.Name != "The Making of Discovery" || e.Show != "Song Exploder" || e.Publisher != "Hrishikesh Hirway" ||
+		e.DurationMs != 3600000 || e.URL != "https://open.spotify.com/episode/abc" {
+		t.Fatalf("Unexpected episode %+v", e)
+	}
+}
+
+func TestComputeStateUpdate_Episode(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	svc := newTestService(database, nil)
+	userID := int64(1)
+
+	episode := &models.Episode{Name: "Episode 1", Show: "Show", URL: "https://open.spotify.com/episode/1", DurationMs: 600000, ProgressMs: 1000}
+	action := svc.computeStateUpdate(userID, &SpotifyTrackResponse{Episode: episode, IsPlaying: true})
+	if action.episode != episode {
+		t.Fatal("Expected the action to carry the episode")
+	}
+	if action.publishNowPlaying || !action.clearNowPlaying {
+		t.Error("Expected an episode to clear now playing rather than publish it")
+	}
+
+	// past half of the episode
+	svc.userPlayStates[userID].accumulatedMs = 300001
+	action = svc.computeStateUpdate(userID, &SpotifyTrackResponse{Episode: episode, IsPlaying: true})
+	if !action.stampTrack || action.episode != episode {
+		t.Errorf("Expected the episode to be stamped, got %+v", action)
+	}
+}
+
+func TestStampEpisode(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := createTestUser(t, database)
+	svc := newTestService(database, nil)
+
+	settings := models.DefaultSpotifySettings()
+	settings.Episodes = models.EpisodesLocal
+	if err := database.SetSpotifySettings(userID, settings); err != nil {
+		t.Fatalf("SetSpotifySettings: %v", err)
+	}
+
+	episode := &models.Episode{Name: "Episode 1", Show: "Show", URL: "https://open.spotify.com/episode/1", DurationMs: 600000,
+		ServiceBaseUrl: "open.spotify.com", Timestamp: time.Now().UTC()}
+	svc.stampEpisode(context.Background(), userID, episode)
+
+	episodes, err := database.GetRecentEpisodes(userID, 10)
+	if err != nil {
+		t.Fatalf("GetRecentEpisodes: %v", err)
+	}
+	if len(episodes) != 1 || episodes[0].Show != "Show" || !episodes[0].HasStamped {
+		t.Fatalf("Expected the stamped episode to be saved, got %+v", episodes)
+	}
+	tracks, err := database.GetRecentTracks(userID, 10)
+	if err != nil {
+		t.Fatalf("GetRecentTracks: %v", err)
+	}
+	if len(tracks) != 0 {
+		t.Errorf("Expected episodes to stay out of tracks, got %d tracks", len(tracks))
+	}
+}
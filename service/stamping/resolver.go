@@ -0,0 +1,137 @@
+package stamping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+)
+
+// ErrUnknownPolicy is returned when a user picks a policy that isn't registered
+var ErrUnknownPolicy = errors.New("unknown stamping policy")
+
+// Resolver picks the policy for a user's plays from a service: the user's own
+// choice, then the instance's policy for that service, then its default. A
+// nil Resolver always uses DefaultPolicy
+type Resolver struct {
+	db       *db.DB
+	fallback string
+	services map[string]string
+	logger   *slog.Logger
+
+	mu sync.RWMutex
+	// users each user's chosen policy, "" when they follow the instance
+	users map[int64]string
+}
+
+// NewResolver uses fallback, or DefaultPolicy when empty, for services without
+// an entry in services
+func NewResolver(database *db.DB, fallback string, services map[string]string) (*Resolver, error) {
+	if fallback == "" {
+		fallback = DefaultPolicy
+	}
+	if _, ok := Lookup(fallback); !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPolicy, fallback)
+	}
+	return &Resolver{
+		db:       database,
+		fallback: fallback,
+		services: services,
+		logger:   logging.For("stamping"),
+		users:    make(map[int64]string),
+	}, nil
+}
+
+// For returns the policy to stamp userID's plays from service with
+func (r *Resolver) For(ctx context.Context, userID int64, service string) Policy {
+	p, _ := Lookup(r.Name(ctx, userID, service))
+	return p
+}
+
+// Name returns the name of the policy For would use
+func (r *Resolver) Name(ctx context.Context, userID int64, service string) string {
+	if r == nil {
+		return DefaultPolicy
+	}
+	if name := r.userPolicy(ctx, userID); name != "" {
+		if _, ok := Lookup(name); ok {
+			return name
+		}
+	}
+	return r.ServiceDefault(service)
+}
+
+// Default returns the instance's policy for services without their own
+func (r *Resolver) Default() string {
+	if r == nil {
+		return DefaultPolicy
+	}
+	return r.fallback
+}
+
+// ServiceDefault returns the instance's policy for service
+func (r *Resolver) ServiceDefault(service string) string {
+	if r == nil {
+		return DefaultPolicy
+	}
+	if name, ok := r.services[service]; ok {
+		return name
+	}
+	return r.fallback
+}
+
+// UserPolicy returns the policy the user picked, "" when they follow the instance
+func (r *Resolver) UserPolicy(ctx context.Context, userID int64) string {
+	if r == nil {
+		return ""
+	}
+	return r.userPolicy(ctx, userID)
+}
+
+func (r *Resolver) userPolicy(ctx context.Context, userID int64) string {
+	r.mu.RLock()
+	name, ok := r.users[userID]
+	r.mu.RUnlock()
+	if ok {
+		return name
+	}
+
+	name, err := r.db.GetStampPolicy(userID)
+	if err != nil {
+		// not cached, so the next play tries again
+		r.logger.ErrorContext(ctx, "error loading stamping policy", logging.UserID(userID), logging.Err(err))
+		return ""
+	}
+	r.mu.Lock()
+	r.users[userID] = name
+	r.mu.Unlock()
+	return name
+}
+
+// SetUserPolicy saves the policy a user picked, "" to follow the instance again
+func (r *Resolver) SetUserPolicy(userID int64, name string) error {
+	if name != "" {
+		if _, ok := Lookup(name); !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownPolicy, name)
+		}
+	}
+	if err := r.db.SetStampPolicy(userID, name); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.users[userID] = name
+	r.mu.Unlock()
+	return nil
+}
+
+// ForgetUser drops the cached policy for a user
+func (r *Resolver) ForgetUser(user *models.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, user.ID)
+}
@@ -0,0 +1,136 @@
+package stamping
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("failed to initialize test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestThresholds(t *testing.T) {
+	tests := []struct {
+		policy     string
+		durationMs int64
+		want       int64
+		wantOK     bool
+	}{
+		{DefaultPolicy, 20000, 30000, true},
+		{DefaultPolicy, 200000, 100000, true},
+		{LexiconPolicy, 90000, 90000, true},
+		{LexiconPolicy, 180000, 120000, true},
+		{LexiconPolicy, 360000, 180000, true},
+		{LexiconPolicy, 600000, 240000, true},
+		{LastFMPolicy, 30000, 0, false},
+		{LastFMPolicy, 200000, 100000, true},
+		{LastFMPolicy, 600000, 240000, true},
+	}
+	for _, tt := range tests {
+		p, ok := Lookup(tt.policy)
+		if !ok {
+			t.Fatalf("policy %q not registered", tt.policy)
+		}
+		got, gotOK := p.Threshold(tt.durationMs)
+		if got != tt.want || gotOK != tt.wantOK {
+			t.Errorf("%s.Threshold(%d) = %d, %v, want %d, %v", tt.policy, tt.durationMs, got, gotOK, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStamps(t *testing.T) {
+	lastfm, _ := Lookup(LastFMPolicy)
+	if Stamps(lastfm, 25000, 25000) {
+		t.Error("expected a full play of a 25 second track not to be stamped under lastfm")
+	}
+	if !Stamps(lastfm, 0, 0) {
+		t.Error("expected tracks of unknown duration to be stamped")
+	}
+	if !Stamps(lastfm, 200000, 100000) || Stamps(lastfm, 200000, 99999) {
+		t.Error("expected half a 200 second track to be the threshold")
+	}
+}
+
+func TestParseServicePolicies(t *testing.T) {
+	got, err := ParseServicePolicies("applemusic=lexicon, listenbrainz=lastfm")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got["applemusic"] != LexiconPolicy || got["listenbrainz"] != LastFMPolicy {
+		t.Errorf("unexpected policies: %v", got)
+	}
+	for _, bad := range []string{"applemusic", "=lexicon", "spotify=never"} {
+		if _, err := ParseServicePolicies(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	database := setupTestDB(t)
+	ctx := context.Background()
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	r, err := NewResolver(database, LexiconPolicy, map[string]string{"applemusic": LastFMPolicy})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := r.Name(ctx, userID, "spotify"); got != LexiconPolicy {
+		t.Errorf("expected the instance default, got %q", got)
+	}
+	if got := r.Name(ctx, userID, "applemusic"); got != LastFMPolicy {
+		t.Errorf("expected the service's policy, got %q", got)
+	}
+
+	if err := r.SetUserPolicy(userID, "never"); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("expected ErrUnknownPolicy, got %v", err)
+	}
+	if err := r.SetUserPolicy(userID, DefaultPolicy); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := r.Name(ctx, userID, "applemusic"); got != DefaultPolicy {
+		t.Errorf("expected the user's policy to win, got %q", got)
+	}
+
+	// a fresh resolver reads the choice back from the database
+	r2, _ := NewResolver(database, "", nil)
+	if got := r2.UserPolicy(ctx, userID); got != DefaultPolicy {
+		t.Errorf("expected the saved policy, got %q", got)
+	}
+	if err := r2.SetUserPolicy(userID, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := database.GetStampPolicy(userID); got != "" {
+		t.Errorf("expected the policy to be cleared, got %q", got)
+	}
+
+	if _, err := NewResolver(database, "never", nil); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("expected an unknown default to be rejected, got %v", err)
+	}
+}
+
+func TestNilResolver(t *testing.T) {
+	var r *Resolver
+	if got := r.Name(context.Background(), 1, "spotify"); got != DefaultPolicy {
+		t.Errorf("expected a nil resolver to use the default policy, got %q", got)
+	}
+	if r.For(context.Background(), 1, "spotify") == nil {
+		t.Error("expected a policy from a nil resolver")
+	}
+}
@@ -0,0 +1,463 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/rivo/uniseg"
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/api/bsky"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/session"
+)
+
+// recapTopLimit entries shown per top list in a recap
+const recapTopLimit = 10
+
+// recapHashtag tagged on recaps posted to Bluesky
+const recapHashtag = "tealfm"
+
+// Streak consecutive days with at least one play
+type Streak struct {
+	Days int    `json:"days"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// ServiceShare plays that came from one service
+type ServiceShare struct {
+	Service     string `json:"service"`
+	Plays       int    `json:"plays"`
+	ListeningMs int64  `json:"listening_ms"`
+}
+
+// Recap a year or month in review
+type Recap struct {
+	// Period the year (2024) or month (2024-05) covered
+	Period           string         `json:"period"`
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
+	TotalPlays       int            `json:"total_plays"`
+	ListeningMinutes int64          `json:"listening_minutes"`
+	UniqueArtists    int            `json:"unique_artists"`
+	UniqueTracks     int            `json:"unique_tracks"`
+	TopArtists       []Entry        `json:"top_artists"`
+	TopTracks        []Entry        `json:"top_tracks"`
+	TopReleases      []Entry        `json:"top_releases"`
+	NewArtistCount   int            `json:"new_artist_count"`
+	NewArtists       []Entry        `json:"new_artists"`
+	LongestStreak    Streak         `json:"longest_streak"`
+	BusiestDay       *Bucket        `json:"busiest_day,omitempty"`
+	Services         []ServiceShare `json:"services"`
+}
+
+// parsePeriod turns a year (2024) or month (2024-05) into the range it covers in loc
+func parsePeriod(period string, loc *time.Location) (from, to time.Time, err error) {
+	if t, err := time.ParseInLocation("2006", period, loc); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006-01", period, loc); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, errors.New("period must be a year like 2024 or a month like 2024-05")
+}
+
+// serviceName maps the service_base_url values tracks have been stored with to one name per service
+func serviceName(base string) string {
+	switch base {
+	case "open.spotify.com", "spotify":
+		return "spotify"
+	case "last.fm", "lastfm":
+		return "lastfm"
+	case "music.apple.com":
+		return "applemusic"
+	case "":
+		return "unknown"
+	default:
+		return base
+	}
+}
+
+// GetRecap computes a user's recap for a year or month, with days in loc
+func (s *Service) GetRecap(userID int64, period string, loc *time.Location) (*Recap, error) {
+	from, to, err := parsePeriod(period, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := newAggregator(loc)
+	// artists heard before the period, anything else in it is new
+	known := make(map[string]bool)
+	services := make(map[string]*ServiceShare)
+	if err := s.db.ForEachTrack(userID, func(track *models.Track) error {
+		switch {
+		case track.Timestamp.Before(from):
+			for _, artist := range track.Artist {
+				known[groupKey(artist.MBID, artist.Name)] = true
+			}
+		case track.Timestamp.Before(to):
+			agg.add(track)
+			name := serviceName(track.ServiceBaseUrl)
+			share, ok := services[name]
+			if !ok {
+				share = &ServiceShare{Service: name}
+				services[name] = share
+			}
+			share.Plays++
+			share.ListeningMs += track.DurationMs
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read tracks for user %d: %w", userID, err)
+	}
+
+	newArtists := make(map[string]*Entry)
+	for key, entry := range agg.artists {
+		if !known[key] {
+			newArtists[key] = entry
+		}
+	}
+
+	stats := agg.result()
+	recap := &Recap{
+		Period:           period,
+		From:             from,
+		To:               to,
+		TotalPlays:       stats.TotalPlays,
+		ListeningMinutes: stats.TotalListeningMs / int64(time.Minute/time.Millisecond),
+		UniqueArtists:    stats.UniqueArtists,
+		UniqueTracks:     stats.UniqueTracks,
+		TopArtists:       limitEntries(stats.TopArtists, recapTopLimit),
+		TopTracks:        limitEntries(stats.TopTracks, recapTopLimit),
+		TopReleases:      limitEntries(stats.TopReleases, recapTopLimit),
+		NewArtistCount:   len(newArtists),
+		NewArtists:       limitEntries(top(newArtists), recapTopLimit),
+		LongestStreak:    longestStreak(stats.PlaysPerDay),
+		Services:         make([]ServiceShare, 0, len(services)),
+	}
+	for _, day := range stats.PlaysPerDay {
+		if recap.BusiestDay == nil || day.Plays > recap.BusiestDay.Plays {
+			busiest := day
+			recap.BusiestDay = &busiest
+		}
+	}
+	for _, share := range services {
+		recap.Services = append(recap.Services, *share)
+	}
+	sort.Slice(recap.Services, func(i, j int) bool {
+		if recap.Services[i].Plays != recap.Services[j].Plays {
+			return recap.Services[i].Plays > recap.Services[j].Plays
+		}
+		return recap.Services[i].Service < recap.Services[j].Service
+	})
+	return recap, nil
+}
+
+// longestStreak finds the longest run of consecutive days in chronological day buckets
+func longestStreak(days []Bucket) Streak {
+	var best, current Streak
+	var previous time.Time
+	for _, day := range days {
+		date, err := time.Parse("2006-01-02", day.Period)
+		if err != nil {
+			continue
+		}
+		if current.Days > 0 && date.Equal(previous.AddDate(0, 0, 1)) {
+			current.Days++
+			current.To = day.Period
+		} else {
+			current = Streak{Days: 1, From: day.Period, To: day.Period}
+		}
+		if current.Days > best.Days {
+			best = current
+		}
+		previous = date
+	}
+	return best
+}
+
+// recapTitle a readable name for the period, "2024" or "May 2024"
+func recapTitle(recap *Recap) string {
+	if len(recap.Period) == len("2006") {
+		return recap.Period
+	}
+	return recap.From.Format("January 2006")
+}
+
+// postGraphemeLimit the most graphemes Bluesky allows in a post
+const postGraphemeLimit = 300
+
+// shorten cuts names to max graphemes so a post stays within Bluesky's length limit
+func shorten(s string, max int) string {
+	if uniseg.GraphemeClusterCount(s) <= max {
+		return s
+	}
+	var b strings.Builder
+	g := uniseg.NewGraphemes(s)
+	for i := 0; i < max-1 && g.Next(); i++ {
+		b.WriteString(g.Str())
+	}
+	return b.String() + "…"
+}
+
+// recapPost builds the text and facets of a Bluesky post summarising a recap,
+// linking to profileURL when it isn't empty. Names are shortened, then left out,
+// until the post fits in postGraphemeLimit. Facet offsets are in UTF-8 bytes
+func recapPost(recap *Recap, profileURL string) (string, []*bsky.RichtextFacet) {
+	for _, nameMax := range []int{50, 30, 15, 0} {
+		text, facets := buildRecapPost(recap, profileURL, nameMax)
+		if uniseg.GraphemeClusterCount(text) <= postGraphemeLimit {
+			return text, facets
+		}
+	}
+	return buildRecapPost(recap, "", 0)
+}
+
+// buildRecapPost the post text with names cut to nameMax graphemes, or without the top
+// artist and track when nameMax is 0
+func buildRecapPost(recap *Recap, profileURL string, nameMax int) (string, []*bsky.RichtextFacet) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "My %s in music: %d plays, %d minutes listened", recapTitle(recap), recap.TotalPlays, recap.ListeningMinutes)
+	if len(recap.TopArtists) > 0 && nameMax > 0 {
+		fmt.Fprintf(&b, "\nTop artist: %s", shorten(recap.TopArtists[0].Name, nameMax))
+	}
+	if len(recap.TopTracks) > 0 && nameMax > 0 {
+		track := recap.TopTracks[0]
+		name := shorten(track.Name, nameMax)
+		if track.Artist != "" {
+			name += " by " + shorten(track.Artist, nameMax*4/5)
+		}
+		fmt.Fprintf(&b, "\nTop track: %s", name)
+	}
+	if recap.NewArtistCount > 0 {
+		fmt.Fprintf(&b, "\nNew artists discovered: %d", recap.NewArtistCount)
+	}
+	b.WriteString("\n")
+
+	var facets []*bsky.RichtextFacet
+	addFacet := func(text string, feature *bsky.RichtextFacet_Features_Elem) {
+		if len(facets) > 0 {
+			b.WriteString(" ")
+		}
+		start := int64(b.Len())
+		b.WriteString(text)
+		facets = append(facets, &bsky.RichtextFacet{
+			Index:    &bsky.RichtextFacet_ByteSlice{ByteStart: start, ByteEnd: int64(b.Len())},
+			Features: []*bsky.RichtextFacet_Features_Elem{feature},
+		})
+	}
+	if profileURL != "" {
+		addFacet(strings.TrimPrefix(strings.TrimPrefix(profileURL, "https://"), "http://"),
+			&bsky.RichtextFacet_Features_Elem{RichtextFacet_Link: &bsky.RichtextFacet_Link{Uri: profileURL}})
+	}
+	addFacet("#"+recapHashtag, &bsky.RichtextFacet_Features_Elem{RichtextFacet_Tag: &bsky.RichtextFacet_Tag{Tag: recapHashtag}})
+
+	return b.String(), facets
+}
+
+// ErrPostingNotAllowed the user's ATProto session wasn't granted the scope to create posts
+var ErrPostingNotAllowed = errors.New("posting to Bluesky hasn't been allowed yet")
+
+// feedPost an app.bsky.feed.post record
+type feedPost struct {
+	LexiconTypeID string                `json:"$type"`
+	Text          string                `json:"text"`
+	Facets        []*bsky.RichtextFacet `json:"facets,omitempty"`
+	CreatedAt     string                `json:"createdAt"`
+}
+
+// PostRecap publishes a summary of a recap to the user's Bluesky account, returning the post's AT URI
+func (s *Service) PostRecap(ctx context.Context, userID int64, recap *Recap) (string, error) {
+	if s.atproto == nil {
+		return "", errors.New("posting is not available on this server")
+	}
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil || user.ATProtoDID == nil || user.MostRecentAtProtoSessionID == nil {
+		return "", errors.New("sign in with ATProto to post your recap")
+	}
+	canPost, err := s.atproto.HasScope(ctx, *user.ATProtoDID, *user.MostRecentAtProtoSessionID, atprotoauth.PostingScope)
+	if err != nil {
+		return "", fmt.Errorf("failed to load ATProto session: %w", err)
+	}
+	if !canPost {
+		return "", ErrPostingNotAllowed
+	}
+	client, err := s.atproto.GetATProtoClient(*user.ATProtoDID, *user.MostRecentAtProtoSessionID, ctx)
+	if err != nil || client == nil {
+		return "", fmt.Errorf("failed to get ATProto client: %w", err)
+	}
+
+	profileURL := ""
+	if public, err := s.db.IsPublicProfile(userID); err == nil && public {
+		profileURL = strings.TrimSuffix(viper.GetString("server.root_url"), "/") + "/u/" + *user.ATProtoDID
+	}
+	text, facets := recapPost(recap, profileURL)
+	body := map[string]any{
+		"repo":       *user.ATProtoDID,
+		"collection": "app.bsky.feed.post",
+		"record": feedPost{
+			LexiconTypeID: "app.bsky.feed.post",
+			Text:          text,
+			Facets:        facets,
+			CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	var out struct {
+		URI string `json:"uri"`
+	}
+	if err := client.Post(ctx, syntax.NSID("com.atproto.repo.createRecord"), body, &out); err != nil {
+		return "", fmt.Errorf("failed to post recap: %w", err)
+	}
+	return out.URI, nil
+}
+
+// recapForRequest reads the period and tz and computes the recap, writing an error response on failure.
+// The period defaults to the current year
+func (s *Service) recapForRequest(w http.ResponseWriter, r *http.Request, period string) (int64, *Recap, bool) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return 0, nil, false
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Unknown time zone: " + tz})
+			return 0, nil, false
+		}
+	}
+	if period == "" {
+		period = strconv.Itoa(time.Now().In(loc).Year())
+	}
+	if _, _, err := parsePeriod(period, loc); err != nil {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return 0, nil, false
+	}
+
+	recap, err := s.GetRecap(userID, period, loc)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error computing recap", logging.UserID(userID), logging.Err(err))
+		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to compute recap"})
+		return 0, nil, false
+	}
+	return userID, recap, true
+}
+
+// HandleRecap returns the recap for the year or month in the path
+func (s *Service) HandleRecap(w http.ResponseWriter, r *http.Request) {
+	if _, recap, ok := s.recapForRequest(w, r, r.PathValue("period")); ok {
+		jsonResponse(w, http.StatusOK, recap)
+	}
+}
+
+// HandleRecapPage shows a recap (GET) and posts it to Bluesky (POST). Posting first asks
+// the user to allow it when their session can't create posts yet
+func (s *Service) HandleRecapPage(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userID, recap, ok := s.recapForRequest(w, r, r.FormValue("period"))
+		if !ok {
+			return
+		}
+
+		recapURL := "/recap?period=" + url.QueryEscape(recap.Period)
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			recapURL += "&tz=" + url.QueryEscape(tz)
+		}
+
+		var postError string
+		postURI := r.URL.Query().Get("posted")
+		if r.Method == http.MethodPost {
+			uri, err := s.PostRecap(r.Context(), userID, recap)
+			if err == nil {
+				http.Redirect(w, r, recapURL+"&posted="+url.QueryEscape(uri), http.StatusSeeOther)
+				return
+			}
+			if errors.Is(err, ErrPostingNotAllowed) {
+				user, err := s.db.GetUserByID(userID)
+				if err == nil && user != nil && user.ATProtoDID != nil {
+					s.atproto.RequestScopes(w, r, *user.ATProtoDID, recapURL, atprotoauth.PostingScope)
+					return
+				}
+			}
+			s.logger.ErrorContext(r.Context(), "error posting recap", logging.UserID(userID), logging.Err(err))
+			postError = err.Error()
+		}
+
+		// years with plays, newest first, to switch between
+		var years []string
+		oldest, latest, err := s.db.GetTrackTimeRange(userID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading history range", logging.UserID(userID), logging.Err(err))
+		} else if oldest != nil && latest != nil {
+			for year := latest.Year(); year >= oldest.Year(); year-- {
+				years = append(years, strconv.Itoa(year))
+			}
+		}
+		var months []string
+		for month := recap.From; month.Year() == recap.From.Year() && month.Before(time.Now()); month = month.AddDate(0, 1, 0) {
+			months = append(months, month.Format("2006-01"))
+		}
+
+		lastfmUsername := ""
+		user, err := s.db.GetUserByID(userID)
+		if err == nil && user != nil && user.LastFMUsername != nil {
+			lastfmUsername = *user.LastFMUsername
+		}
+		postText, _ := recapPost(recap, "")
+
+		data := struct {
+			Recap     *Recap
+			Title     string
+			Years     []string
+			Months    []string
+			PostText  string
+			PostError string
+			PostURI   string
+			NavBar    pages.NavBar
+		}{
+			Recap:     recap,
+			Title:     recapTitle(recap),
+			Years:     years,
+			Months:    months,
+			PostText:  postText,
+			PostError: postError,
+			PostURI:   postURI,
+			NavBar: pages.NavBar{
+				IsLoggedIn:        true,
+				LastFMUsername:    lastfmUsername,
+				SpotifyEnabled:    viper.GetBool("enable_spotify"),
+				LastFMEnabled:     viper.GetBool("enable_lastfm"),
+				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if postError != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		if err := pg.Execute("recap", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
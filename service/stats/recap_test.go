@@ -0,0 +1,194 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rivo/uniseg"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/session"
+)
+
+func TestGetRecap(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	play := func(artist, name, service string, at time.Time) {
+		saveTrack(t, database, userID, &models.Track{
+			Name:           name,
+			Artist:         []models.Artist{{Name: artist}},
+			Album:          name + " (single)",
+			DurationMs:     180000,
+			ServiceBaseUrl: service,
+			Timestamp:      at,
+		})
+	}
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 20, 0, 0, 0, time.UTC) }
+
+	// heard the year before, so not a discovery in 2024
+	play("Daft Punk", "Da Funk", "open.spotify.com", time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC))
+
+	play("Daft Punk", "One More Time", "open.spotify.com", day(3, 1))
+	play("Daft Punk", "One More Time", "spotify", day(3, 2))
+	play("Justice", "D.A.N.C.E.", "last.fm", day(3, 3))
+	play("Justice", "Genesis", "last.fm", day(3, 3))
+	play("Justice", "Genesis", "music.apple.com", day(3, 3))
+	play("Air", "La femme d'argent", "listenbrainz", day(5, 10))
+	// next year, outside the recap
+	play("Air", "Sexy Boy", "listenbrainz", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s := NewStatsService(database, 0)
+	recap, err := s.GetRecap(userID, "2024", time.UTC)
+	if err != nil {
+		t.Fatalf("GetRecap failed: %v", err)
+	}
+
+	if recap.TotalPlays != 6 || recap.ListeningMinutes != 18 {
+		t.Errorf("Totals = %d plays %d minutes, want 6 and 18", recap.TotalPlays, recap.ListeningMinutes)
+	}
+	if recap.TopArtists[0].Name != "Justice" || recap.TopArtists[0].Plays != 3 {
+		t.Errorf("Unexpected top artist: %+v", recap.TopArtists[0])
+	}
+	if recap.NewArtistCount != 2 || len(recap.NewArtists) != 2 || recap.NewArtists[0].Name != "Justice" {
+		t.Errorf("Expected Justice and Air as discoveries, got %+v", recap.NewArtists)
+	}
+	if recap.LongestStreak != (Streak{Days: 3, From: "2024-03-01", To: "2024-03-03"}) {
+		t.Errorf("Unexpected streak: %+v", recap.LongestStreak)
+	}
+	if recap.BusiestDay == nil || recap.BusiestDay.Period != "2024-03-03" || recap.BusiestDay.Plays != 3 {
+		t.Errorf("Unexpected busiest day: %+v", recap.BusiestDay)
+	}
+	want := []ServiceShare{
+		{Service: "lastfm", Plays: 2, ListeningMs: 360000},
+		{Service: "spotify", Plays: 2, ListeningMs: 360000},
+		{Service: "applemusic", Plays: 1, ListeningMs: 180000},
+		{Service: "listenbrainz", Plays: 1, ListeningMs: 180000},
+	}
+	if len(recap.Services) != len(want) {
+		t.Fatalf("Services = %+v, want %+v", recap.Services, want)
+	}
+	for i := range want {
+		if recap.Services[i] != want[i] {
+			t.Errorf("Service %d = %+v, want %+v", i, recap.Services[i], want[i])
+		}
+	}
+
+	month, err := s.GetRecap(userID, "2024-05", time.UTC)
+	if err != nil {
+		t.Fatalf("GetRecap for a month failed: %v", err)
+	}
+	if month.TotalPlays != 1 || month.NewArtistCount != 1 || recapTitle(month) != "May 2024" {
+		t.Errorf("Unexpected month recap: %+v", month)
+	}
+
+	if _, err := s.GetRecap(userID, "last-year", time.UTC); err == nil {
+		t.Errorf("Expected an invalid period to be rejected")
+	}
+}
+
+func TestRecapPostFacets(t *testing.T) {
+	recap := &Recap{
+		Period:           "2024",
+		TotalPlays:       1234,
+		ListeningMinutes: 4321,
+		TopArtists:       []Entry{{Name: "Sigur Rós"}},
+		TopTracks:        []Entry{{Name: "Hoppípolla", Artist: "Sigur Rós"}},
+		NewArtistCount:   12,
+	}
+	text, facets := recapPost(recap, "https://piper.example/u/did:plc:abc")
+
+	if !strings.HasPrefix(text, "My 2024 in music: 1234 plays, 4321 minutes listened\nTop artist: Sigur Rós") {
+		t.Errorf("Unexpected post text: %q", text)
+	}
+	if len(facets) != 2 {
+		t.Fatalf("Expected a link and a tag facet, got %d", len(facets))
+	}
+	link := text[facets[0].Index.ByteStart:facets[0].Index.ByteEnd]
+	if link != "piper.example/u/did:plc:abc" || facets[0].Features[0].RichtextFacet_Link.Uri != "https://piper.example/u/did:plc:abc" {
+		t.Errorf("Link facet covers %q", link)
+	}
+	if tag := text[facets[1].Index.ByteStart:facets[1].Index.ByteEnd]; tag != "#tealfm" || facets[1].Features[0].RichtextFacet_Tag.Tag != "tealfm" {
+		t.Errorf("Tag facet covers %q", tag)
+	}
+	if !strings.HasSuffix(text, "#tealfm") {
+		t.Errorf("Expected the post to end with the hashtag: %q", text)
+	}
+}
+
+func TestRecapPostFitsBlueskyLimit(t *testing.T) {
+	long := strings.Repeat("👩‍👩‍👧‍👦", 120)
+	recap := &Recap{
+		Period:         "2024",
+		TotalPlays:     1234,
+		TopArtists:     []Entry{{Name: long}},
+		TopTracks:      []Entry{{Name: long, Artist: long}},
+		NewArtistCount: 12,
+	}
+	text, facets := recapPost(recap, "https://piper.example/u/did:plc:abc")
+
+	if n := uniseg.GraphemeClusterCount(text); n > postGraphemeLimit {
+		t.Errorf("Expected at most %d graphemes, got %d: %q", postGraphemeLimit, n, text)
+	}
+	if !strings.Contains(text, "Top artist: 👩‍👩‍👧‍👦") || !strings.Contains(text, "…") {
+		t.Errorf("Expected a shortened top artist: %q", text)
+	}
+	if tag := text[facets[len(facets)-1].Index.ByteStart:facets[len(facets)-1].Index.ByteEnd]; tag != "#tealfm" {
+		t.Errorf("Tag facet covers %q", tag)
+	}
+
+	text, _ = recapPost(recap, "https://piper.example/u/did:plc:"+strings.Repeat("a", 200))
+	if n := uniseg.GraphemeClusterCount(text); n > postGraphemeLimit {
+		t.Errorf("Expected at most %d graphemes with a long link, got %d", postGraphemeLimit, n)
+	}
+	if got := shorten(long, 3); got != "👩‍👩‍👧‍👦👩‍👩‍👧‍👦…" {
+		t.Errorf("Expected whole graphemes to be kept, got %q", got)
+	}
+}
+
+func TestHandleRecap(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+	userID := seedTracks(t, database)
+	s := NewStatsService(database, 0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/stats/recap/{period}", s.HandleRecap)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(session.WithUserID(req.Context(), userID))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/v1/stats/recap/" + time.Now().UTC().Format("2006"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var recap Recap
+	if err := json.Unmarshal(rr.Body.Bytes(), &recap); err != nil {
+		t.Fatalf("Invalid recap JSON: %v", err)
+	}
+	if recap.Period != time.Now().UTC().Format("2006") {
+		t.Errorf("Unexpected period %q", recap.Period)
+	}
+
+	if rr := get("/api/v1/stats/recap/2024-13"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid month to be rejected, got %d", rr.Code)
+	}
+
+	mux.HandleFunc("/recap", s.HandleRecapPage(pages.NewPages()))
+	rr = get("/recap")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Top Artists") {
+		t.Errorf("Expected the recap page to render, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,455 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	atprotoauth "github.com/teal-fm/piper/oauth/atproto"
+	"github.com/teal-fm/piper/session"
+)
+
+// maxTopEntries how many entries are kept per top list, and the highest limit the API accepts
+const maxTopEntries = 100
+
+// defaultTopLimit entries returned per top list when no limit is given
+const defaultTopLimit = 10
+
+// ranges supported by the API, mapped to how far back they reach. 0 is all-time
+var ranges = map[string]time.Duration{
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+	"1y":  365 * 24 * time.Hour,
+	"all": 0,
+}
+
+// Entry a single artist, track or release in a top list
+type Entry struct {
+	Name        string  `json:"name"`
+	Artist      string  `json:"artist,omitempty"`
+	MBID        *string `json:"mbid,omitempty"`
+	Plays       int     `json:"plays"`
+	ListeningMs int64   `json:"listening_ms"`
+}
+
+// Bucket plays within a day, week or month
+type Bucket struct {
+	Period      string `json:"period"`
+	Plays       int    `json:"plays"`
+	ListeningMs int64  `json:"listening_ms"`
+}
+
+// Stats aggregates for a user over a range
+type Stats struct {
+	Range            string     `json:"range"`
+	From             *time.Time `json:"from,omitempty"`
+	To               time.Time  `json:"to"`
+	TotalPlays       int        `json:"total_plays"`
+	TotalListeningMs int64      `json:"total_listening_ms"`
+	UniqueArtists    int        `json:"unique_artists"`
+	UniqueTracks     int        `json:"unique_tracks"`
+	UniqueReleases   int        `json:"unique_releases"`
+	TopArtists       []Entry    `json:"top_artists"`
+	TopTracks        []Entry    `json:"top_tracks"`
+	TopReleases      []Entry    `json:"top_releases"`
+	PlaysPerDay      []Bucket   `json:"plays_per_day,omitempty"`
+	PlaysPerWeek     []Bucket   `json:"plays_per_week,omitempty"`
+	PlaysPerMonth    []Bucket   `json:"plays_per_month,omitempty"`
+	HourOfDay        [24]int    `json:"hour_of_day"`
+}
+
+type cachedStats struct {
+	stats     *Stats
+	expiresAt time.Time
+}
+
+type Service struct {
+	db       *db.DB
+	cacheTTL time.Duration
+	// cache per user, keyed by range and time zone
+	cache  map[int64]map[string]cachedStats
+	mu     sync.Mutex
+	logger *slog.Logger
+	// atproto posts recaps to Bluesky, optional
+	atproto *atprotoauth.AuthService
+}
+
+func NewStatsService(database *db.DB, cacheTTL time.Duration) *Service {
+	return &Service{
+		db:       database,
+		cacheTTL: cacheTTL,
+		cache:    make(map[int64]map[string]cachedStats),
+		logger:   logging.For("stats"),
+	}
+}
+
+// WithATProto lets users post their recaps to Bluesky through their ATProto session
+func (s *Service) WithATProto(atprotoService *atprotoauth.AuthService) *Service {
+	s.atproto = atprotoService
+	return s
+}
+
+// GetStats returns the stats for a user over a range, bucketed by days in loc.
+// Results are cached for the service's cache TTL
+func (s *Service) GetStats(userID int64, rangeName string, loc *time.Location) (*Stats, error) {
+	window, ok := ranges[rangeName]
+	if !ok {
+		return nil, fmt.Errorf("unknown range %q", rangeName)
+	}
+
+	key := rangeName + "/" + loc.String()
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	cached, hit := s.cache[userID][key]
+	s.mu.Unlock()
+	if hit && now.Before(cached.expiresAt) {
+		return cached.stats, nil
+	}
+
+	var from time.Time
+	if window > 0 {
+		from = now.Add(-window)
+	}
+
+	agg := newAggregator(loc)
+	if err := s.db.ForEachTrackSince(userID, from, func(track *models.Track) error {
+		agg.add(track)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read tracks for user %d: %w", userID, err)
+	}
+
+	stats := agg.result()
+	stats.Range = rangeName
+	stats.To = now
+	if !from.IsZero() {
+		stats.From = &from
+	}
+
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		if s.cache[userID] == nil {
+			s.cache[userID] = make(map[string]cachedStats)
+		}
+		s.cache[userID][key] = cachedStats{stats: stats, expiresAt: now.Add(s.cacheTTL)}
+		s.mu.Unlock()
+	}
+
+	return stats, nil
+}
+
+// ForgetUser drops the cached stats for a user
+func (s *Service) ForgetUser(user *models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, user.ID)
+}
+
+// ------- Aggregation -------
+
+// normalize lower cases and collapses whitespace so the same name from different services groups together
+func normalize(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// groupKey prefers the MBID and falls back to the normalized names
+func groupKey(mbid *string, names ...string) string {
+	if mbid != nil && *mbid != "" {
+		return "mbid:" + *mbid
+	}
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = normalize(name)
+	}
+	return "name:" + strings.Join(normalized, "\x00")
+}
+
+type aggregator struct {
+	loc      *time.Location
+	stats    Stats
+	artists  map[string]*Entry
+	tracks   map[string]*Entry
+	releases map[string]*Entry
+	days     map[string]*Bucket
+	weeks    map[string]*Bucket
+	months   map[string]*Bucket
+}
+
+func newAggregator(loc *time.Location) *aggregator {
+	return &aggregator{
+		loc:      loc,
+		artists:  make(map[string]*Entry),
+		tracks:   make(map[string]*Entry),
+		releases: make(map[string]*Entry),
+		days:     make(map[string]*Bucket),
+		weeks:    make(map[string]*Bucket),
+		months:   make(map[string]*Bucket),
+	}
+}
+
+func count(entries map[string]*Entry, key string, entry Entry, durationMs int64) {
+	e, ok := entries[key]
+	if !ok {
+		e = &entry
+		entries[key] = e
+	}
+	e.Plays++
+	e.ListeningMs += durationMs
+}
+
+func countBucket(buckets map[string]*Bucket, period string, durationMs int64) {
+	b, ok := buckets[period]
+	if !ok {
+		b = &Bucket{Period: period}
+		buckets[period] = b
+	}
+	b.Plays++
+	b.ListeningMs += durationMs
+}
+
+func (a *aggregator) add(track *models.Track) {
+	a.stats.TotalPlays++
+	a.stats.TotalListeningMs += track.DurationMs
+
+	artistName := ""
+	if len(track.Artist) > 0 {
+		artistName = track.Artist[0].Name
+	}
+
+	for _, artist := range track.Artist {
+		if artist.Name == "" {
+			continue
+		}
+		count(a.artists, groupKey(artist.MBID, artist.Name), Entry{Name: artist.Name, MBID: artist.MBID}, track.DurationMs)
+	}
+
+	if track.Name != "" {
+		count(a.tracks, groupKey(track.RecordingMBID, artistName, track.Name),
+			Entry{Name: track.Name, Artist: artistName, MBID: track.RecordingMBID}, track.DurationMs)
+	}
+
+	if track.Album != "" {
+		count(a.releases, groupKey(track.ReleaseMBID, artistName, track.Album),
+			Entry{Name: track.Album, Artist: artistName, MBID: track.ReleaseMBID}, track.DurationMs)
+	}
+
+	if track.Timestamp.IsZero() {
+		return
+	}
+	played := track.Timestamp.In(a.loc)
+	year, week := played.ISOWeek()
+	countBucket(a.days, played.Format("2006-01-02"), track.DurationMs)
+	countBucket(a.weeks, fmt.Sprintf("%04d-W%02d", year, week), track.DurationMs)
+	countBucket(a.months, played.Format("2006-01"), track.DurationMs)
+	a.stats.HourOfDay[played.Hour()]++
+}
+
+// top sorts entries by plays, then listening time and name, keeping at most maxTopEntries
+func top(entries map[string]*Entry) []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Plays != list[j].Plays {
+			return list[i].Plays > list[j].Plays
+		}
+		if list[i].ListeningMs != list[j].ListeningMs {
+			return list[i].ListeningMs > list[j].ListeningMs
+		}
+		return list[i].Name < list[j].Name
+	})
+	if len(list) > maxTopEntries {
+		list = list[:maxTopEntries]
+	}
+	return list
+}
+
+// chronological returns buckets sorted by period, the period formats sort lexically
+func chronological(buckets map[string]*Bucket) []Bucket {
+	list := make([]Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Period < list[j].Period })
+	return list
+}
+
+func (a *aggregator) result() *Stats {
+	stats := a.stats
+	stats.UniqueArtists = len(a.artists)
+	stats.UniqueTracks = len(a.tracks)
+	stats.UniqueReleases = len(a.releases)
+	stats.TopArtists = top(a.artists)
+	stats.TopTracks = top(a.tracks)
+	stats.TopReleases = top(a.releases)
+	stats.PlaysPerDay = chronological(a.days)
+	stats.PlaysPerWeek = chronological(a.weeks)
+	stats.PlaysPerMonth = chronological(a.months)
+	return &stats
+}
+
+// ------- HTTP -------
+
+func jsonResponse(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if data != nil {
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			slog.Error("error encoding JSON response", logging.Err(err))
+		}
+	}
+}
+
+// statsForRequest reads range and tz from the query and loads the stats, writing an error response on failure
+func (s *Service) statsForRequest(w http.ResponseWriter, r *http.Request) (*Stats, bool) {
+	userID, ok := session.GetUserID(r.Context())
+	if !ok {
+		jsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	rangeName := r.URL.Query().Get("range")
+	if rangeName == "" {
+		rangeName = "30d"
+	}
+	if _, ok := ranges[rangeName]; !ok {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "range must be one of 7d, 30d, 90d, 1y or all"})
+		return nil, false
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "Unknown time zone: " + tz})
+			return nil, false
+		}
+	}
+
+	stats, err := s.GetStats(userID, rangeName, loc)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error computing stats", logging.UserID(userID), logging.Err(err))
+		jsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to compute stats"})
+		return nil, false
+	}
+	return stats, true
+}
+
+func limitEntries(entries []Entry, limit int) []Entry {
+	if len(entries) > limit {
+		return entries[:limit]
+	}
+	return entries
+}
+
+// HandleOverview returns totals, the top 10 of each list and the hour of day histogram
+func (s *Service) HandleOverview(w http.ResponseWriter, r *http.Request) {
+	stats, ok := s.statsForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	overview := *stats
+	overview.TopArtists = limitEntries(stats.TopArtists, defaultTopLimit)
+	overview.TopTracks = limitEntries(stats.TopTracks, defaultTopLimit)
+	overview.TopReleases = limitEntries(stats.TopReleases, defaultTopLimit)
+	// per period plays are served by HandleActivity
+	overview.PlaysPerDay = nil
+	overview.PlaysPerWeek = nil
+	overview.PlaysPerMonth = nil
+
+	jsonResponse(w, http.StatusOK, overview)
+}
+
+// HandleTop returns a top list, kind is one of artists, tracks or releases
+func (s *Service) HandleTop(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if kind != "artists" && kind != "tracks" && kind != "releases" {
+		jsonResponse(w, http.StatusNotFound, map[string]string{"error": "Unknown stats list, use artists, tracks or releases"})
+		return
+	}
+
+	limit := defaultTopLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > maxTopEntries {
+			jsonResponse(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", maxTopEntries)})
+			return
+		}
+		limit = l
+	}
+
+	stats, ok := s.statsForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var entries []Entry
+	switch kind {
+	case "artists":
+		entries = stats.TopArtists
+	case "tracks":
+		entries = stats.TopTracks
+	case "releases":
+		entries = stats.TopReleases
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"range":       stats.Range,
+		"from":        stats.From,
+		"to":          stats.To,
+		"total_plays": stats.TotalPlays,
+		kind:          limitEntries(entries, limit),
+	})
+}
+
+// HandleActivity returns plays per day, week or month (interval) and the hour of day histogram
+func (s *Service) HandleActivity(w http.ResponseWriter, r *http.Request) {
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if interval != "day" && interval != "week" && interval != "month" {
+		jsonResponse(w, http.StatusBadRequest, map[string]string{"error": "interval must be day, week or month"})
+		return
+	}
+
+	stats, ok := s.statsForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var buckets []Bucket
+	switch interval {
+	case "day":
+		buckets = stats.PlaysPerDay
+	case "week":
+		buckets = stats.PlaysPerWeek
+	case "month":
+		buckets = stats.PlaysPerMonth
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"range":              stats.Range,
+		"from":               stats.From,
+		"to":                 stats.To,
+		"interval":           interval,
+		"total_plays":        stats.TotalPlays,
+		"total_listening_ms": stats.TotalListeningMs,
+		"plays":              buckets,
+		"hour_of_day":        stats.HourOfDay,
+	})
+}
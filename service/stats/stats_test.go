@@ -0,0 +1,220 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/session"
+)
+
+func setupTestDB(t *testing.T) *db.DB {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	return database
+}
+
+func strPtr(s string) *string { return &s }
+
+func saveTrack(t *testing.T, database *db.DB, userID int64, track *models.Track) {
+	if _, err := database.SaveTrack(userID, track); err != nil {
+		t.Fatalf("Failed to save track: %v", err)
+	}
+}
+
+// seedTracks stores plays that only group correctly on MBIDs and normalized names
+func seedTracks(t *testing.T, database *db.DB) int64 {
+	userID, err := database.CreateUser(&models.User{})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	now := time.Now().UTC()
+	artistMBID := strPtr("artist-mbid")
+	// same recording, names differ between services but the MBID matches
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Song A", RecordingMBID: strPtr("rec-a"), Album: "Album", ReleaseMBID: strPtr("rel-a"),
+		Artist: []models.Artist{{Name: "The Artist", MBID: artistMBID}}, DurationMs: 200000,
+		Timestamp: now.Add(-1 * time.Hour), URL: "u", ServiceBaseUrl: "open.spotify.com",
+	})
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Song A (Remastered)", RecordingMBID: strPtr("rec-a"), Album: "Album", ReleaseMBID: strPtr("rel-a"),
+		Artist: []models.Artist{{Name: "Artist, The", MBID: artistMBID}}, DurationMs: 200000,
+		Timestamp: now.Add(-2 * time.Hour), URL: "u", ServiceBaseUrl: "last.fm",
+	})
+	// no MBIDs, grouped on normalized names
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Song B", Album: "Other", Artist: []models.Artist{{Name: "Someone"}}, DurationMs: 100000,
+		Timestamp: now.Add(-3 * time.Hour), URL: "u",
+	})
+	saveTrack(t, database, userID, &models.Track{
+		Name: "song  b", Album: "other", Artist: []models.Artist{{Name: "SOMEONE"}}, DurationMs: 100000,
+		Timestamp: now.Add(-4 * time.Hour), URL: "u",
+	})
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Song C", Album: "Other", Artist: []models.Artist{{Name: "Someone"}}, DurationMs: 100000,
+		Timestamp: now.Add(-5 * time.Hour), URL: "u",
+	})
+	// outside of the 30 day range
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Old Song", Album: "Old", Artist: []models.Artist{{Name: "Old Artist"}}, DurationMs: 50000,
+		Timestamp: now.Add(-60 * 24 * time.Hour), URL: "u",
+	})
+	return userID
+}
+
+func TestGetStats(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID := seedTracks(t, database)
+	svc := NewStatsService(database, time.Minute)
+
+	stats, err := svc.GetStats(userID, "30d", time.UTC)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+
+	if stats.TotalPlays != 5 {
+		t.Errorf("Expected 5 plays in the last 30 days, got %d", stats.TotalPlays)
+	}
+	if stats.TotalListeningMs != 700000 {
+		t.Errorf("Expected 700000ms listened, got %d", stats.TotalListeningMs)
+	}
+	if stats.UniqueArtists != 2 || stats.UniqueTracks != 3 || stats.UniqueReleases != 2 {
+		t.Errorf("Expected 2 artists, 3 tracks and 2 releases, got %d, %d and %d", stats.UniqueArtists, stats.UniqueTracks, stats.UniqueReleases)
+	}
+	if top := stats.TopArtists[0]; top.Name != "Someone" || top.Plays != 3 {
+		t.Errorf("Expected Someone with 3 plays on top, got %+v", top)
+	}
+	if top := stats.TopTracks[0]; top.Plays != 2 {
+		t.Errorf("Expected the top track to have 2 plays, got %+v", top)
+	}
+	if top := stats.TopReleases[0]; top.Name != "Other" || top.Plays != 3 {
+		t.Errorf("Expected Other with 3 plays on top, got %+v", top)
+	}
+
+	hours := 0
+	for _, n := range stats.HourOfDay {
+		hours += n
+	}
+	if hours != 5 {
+		t.Errorf("Expected 5 plays in the hour histogram, got %d", hours)
+	}
+
+	all, err := svc.GetStats(userID, "all", time.UTC)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if all.TotalPlays != 6 || all.From != nil {
+		t.Errorf("Expected all 6 plays without a start, got %d from %v", all.TotalPlays, all.From)
+	}
+
+	if _, err := svc.GetStats(userID, "2w", time.UTC); err == nil {
+		t.Error("Expected an error for an unknown range")
+	}
+}
+
+func TestGetStatsCache(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID := seedTracks(t, database)
+	svc := NewStatsService(database, time.Minute)
+
+	first, err := svc.GetStats(userID, "7d", time.UTC)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	saveTrack(t, database, userID, &models.Track{
+		Name: "New", Album: "New", Artist: []models.Artist{{Name: "New"}}, Timestamp: time.Now().UTC(), URL: "u",
+	})
+
+	cached, _ := svc.GetStats(userID, "7d", time.UTC)
+	if cached.TotalPlays != first.TotalPlays {
+		t.Errorf("Expected cached stats, got %d plays instead of %d", cached.TotalPlays, first.TotalPlays)
+	}
+
+	svc.ForgetUser(&models.User{ID: userID})
+	fresh, _ := svc.GetStats(userID, "7d", time.UTC)
+	if fresh.TotalPlays != first.TotalPlays+1 {
+		t.Errorf("Expected fresh stats after forgetting the user, got %d plays", fresh.TotalPlays)
+	}
+}
+
+func TestActivityTimeZone(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID, _ := database.CreateUser(&models.User{})
+	played := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour).Add(23 * time.Hour) // 23:00 UTC yesterday
+	saveTrack(t, database, userID, &models.Track{
+		Name: "Late", Album: "Late", Artist: []models.Artist{{Name: "Late"}}, Timestamp: played, URL: "u",
+	})
+	svc := NewStatsService(database, 0)
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	stats, err := svc.GetStats(userID, "7d", tokyo)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.HourOfDay[8] != 1 {
+		t.Errorf("Expected the play at 08:00 in Tokyo, got %v", stats.HourOfDay)
+	}
+	if len(stats.PlaysPerDay) != 1 || stats.PlaysPerDay[0].Period != played.In(tokyo).Format("2006-01-02") {
+		t.Errorf("Expected the play on the Tokyo day, got %+v", stats.PlaysPerDay)
+	}
+}
+
+func TestHandleTop(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	userID := seedTracks(t, database)
+	svc := NewStatsService(database, time.Minute)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/stats/{kind}", svc.HandleTop)
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(session.WithUserID(req.Context(), userID))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/v1/stats/artists?range=all&limit=1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Artists []Entry `json:"artists"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Artists) != 1 || body.Artists[0].Name != "Someone" {
+		t.Errorf("Expected only the top artist, got %+v", body.Artists)
+	}
+
+	for _, target := range []string{"/api/v1/stats/artists?range=2w", "/api/v1/stats/artists?limit=0", "/api/v1/stats/artists?tz=Not/AZone"} {
+		if rr := get(target); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, rr.Code)
+		}
+	}
+	if rr := get("/api/v1/stats/genres"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown list, got %d", rr.Code)
+	}
+}
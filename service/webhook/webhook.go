@@ -0,0 +1,477 @@
+// Package webhook posts a user's listening events to URLs they configure.
+// Deliveries are signed with a per webhook secret, logged in the database and
+// retried with backoff when the receiving end is down
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/teal-fm/piper/db"
+	"github.com/teal-fm/piper/logging"
+	"github.com/teal-fm/piper/models"
+	"github.com/teal-fm/piper/pages"
+	"github.com/teal-fm/piper/service/events"
+	"github.com/teal-fm/piper/session"
+)
+
+// EventPing is sent by the test button on the webhooks page
+const EventPing = "ping"
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Piper-Event"
+	HeaderDelivery  = "X-Piper-Delivery"
+	HeaderTimestamp = "X-Piper-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the webhook's secret
+	HeaderSignature = "X-Piper-Signature"
+)
+
+const (
+	maxAttempts     = 8
+	baseRetryDelay  = 30 * time.Second
+	maxRetryDelay   = 2 * time.Hour
+	batchSize       = 50
+	deliveryLogSize = 10
+	// finished deliveries are kept this long for the delivery log
+	deliveryRetention  = 7 * 24 * time.Hour
+	maxWebhooksPerUser = 10
+)
+
+// Events users can subscribe to, in the order they are shown
+var Events = []string{
+	string(events.TrackStamped),
+	string(events.NowPlayingChanged),
+	string(events.NowPlayingCleared),
+}
+
+// Payload the JSON body of a delivery
+type Payload struct {
+	Event     string        `json:"event"`
+	CreatedAt time.Time     `json:"created_at"`
+	Source    string        `json:"source,omitempty"`
+	Track     *models.Track `json:"track,omitempty"`
+}
+
+type Service struct {
+	db         *db.DB
+	httpClient *http.Client
+	wake       chan struct{}
+	logger     *slog.Logger
+}
+
+// NewWebhookService creates the service. Unless allowPrivate is set, webhooks
+// can't reach loopback or private network addresses
+func NewWebhookService(database *db.DB, allowPrivate bool) *Service {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = PublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &Service{
+		db: database,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			// a redirect would be a way around the address check, and receivers should answer directly anyway
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake:   make(chan struct{}, 1),
+		logger: logging.For("webhook"),
+	}
+}
+
+// PublicOnly refuses connections to addresses that aren't on the public internet,
+// as a net.Dialer Control for anything that sends requests to URLs users give
+func PublicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("requests can't be sent to %s", host)
+	}
+	return nil
+}
+
+// HandleEvent queues the event for each of the user's webhooks subscribed to it
+func (s *Service) HandleEvent(e events.Event) {
+	switch e.Type {
+	case events.TrackStamped, events.NowPlayingChanged, events.NowPlayingCleared:
+	default:
+		return
+	}
+
+	hooks, err := s.db.GetUserWebhooks(e.UserID)
+	if err != nil {
+		s.logger.Error("error loading webhooks", logging.UserID(e.UserID), logging.Err(err))
+		return
+	}
+
+	created := e.Time
+	if created.IsZero() {
+		created = time.Now()
+	}
+	track := e.Track
+	body, err := json.Marshal(Payload{Event: string(e.Type), CreatedAt: created.UTC(), Source: e.Source, Track: &track})
+	if err != nil {
+		s.logger.Error("error encoding webhook payload", "event", e.Type, logging.UserID(e.UserID), logging.Err(err))
+		return
+	}
+
+	queued := 0
+	for _, hook := range hooks {
+		if !hook.Enabled || !hook.Wants(string(e.Type)) {
+			continue
+		}
+		d := &models.WebhookDelivery{WebhookID: hook.ID, UserID: e.UserID, Event: string(e.Type), Payload: string(body)}
+		if err := s.db.CreateWebhookDelivery(d); err != nil {
+			s.logger.Error("error queueing webhook delivery", "event", e.Type, "webhook_id", hook.ID, logging.Err(err))
+			continue
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StartWorker sends queued deliveries, checking for due retries every interval
+func (s *Service) StartWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		s.processDue(context.Background())
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.db.DeleteWebhookDeliveriesBefore(time.Now().Add(-deliveryRetention)); err != nil {
+					s.logger.Error("error pruning webhook deliveries", logging.Err(err))
+				}
+			case <-s.wake:
+			}
+			s.processDue(context.Background())
+		}
+	}()
+}
+
+// processDue sends every delivery that is due, in batches
+func (s *Service) processDue(ctx context.Context) {
+	for {
+		deliveries, err := s.db.GetDueWebhookDeliveries(time.Now(), batchSize)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error loading webhook queue", logging.Err(err))
+			return
+		}
+		for _, d := range deliveries {
+			s.process(ctx, d)
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// process makes one attempt at a delivery and records the outcome
+func (s *Service) process(ctx context.Context, d *models.WebhookDelivery) {
+	hook, err := s.db.GetWebhook(d.WebhookID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error loading webhook", "webhook_id", d.WebhookID, logging.Err(err))
+		return
+	}
+
+	d.Attempts++
+	d.ResponseCode = nil
+	var permanent bool
+	switch {
+	case hook == nil || (!hook.Enabled && d.Event != EventPing):
+		err, permanent = errors.New("webhook was disabled"), true
+	default:
+		var status int
+		status, err = s.send(ctx, hook, d)
+		if status != 0 {
+			d.ResponseCode = &status
+		}
+		// the receiver says it is gone for good, stop sending to it
+		if status == http.StatusGone {
+			permanent = true
+			if err := s.db.SetWebhookEnabled(hook.UserID, hook.ID, false); err != nil {
+				s.logger.ErrorContext(ctx, "error disabling webhook", "webhook_id", hook.ID, logging.Err(err))
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		d.Status = models.WebhookDeliveryDelivered
+		d.LastError = nil
+		d.DeliveredAt = &now
+	case permanent || d.Attempts >= maxAttempts || d.Event == EventPing:
+		// pings are only useful right away, so they aren't retried
+		msg := err.Error()
+		d.Status = models.WebhookDeliveryFailed
+		d.LastError = &msg
+	default:
+		msg := err.Error()
+		d.Status = models.WebhookDeliveryPending
+		d.LastError = &msg
+		d.NextAttemptAt = now.Add(retryDelay(d.Attempts))
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "webhook delivery failed", "delivery_id", d.ID, "event", d.Event, "webhook_id", d.WebhookID, "attempt", d.Attempts, logging.Err(err))
+	}
+
+	if err := s.db.UpdateWebhookDelivery(d); err != nil {
+		s.logger.ErrorContext(ctx, "error saving webhook delivery", "delivery_id", d.ID, logging.Err(err))
+	}
+}
+
+// retryDelay doubles with every attempt up to maxRetryDelay
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts a delivery, returning the response status if there was one.
+// Any 2xx status is a success
+func (s *Service) send(ctx context.Context, hook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "piper-webhooks")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// AddWebhook validates and stores a new webhook with a freshly generated secret
+func (s *Service) AddWebhook(userID int64, rawURL string, subscribed []string) (*models.Webhook, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("the URL must be a full http:// or https:// address")
+	}
+	if u.User != nil {
+		return nil, errors.New("the URL can't contain credentials, use the signing secret to authenticate deliveries")
+	}
+	for _, e := range subscribed {
+		if !slices.Contains(Events, e) {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil, errors.New("pick at least one event")
+	}
+
+	existing, err := s.db.GetUserWebhooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		return nil, fmt.Errorf("you can have at most %d webhooks", maxWebhooksPerUser)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	hook := &models.Webhook{
+		UserID:  userID,
+		URL:     u.String(),
+		Secret:  hex.EncodeToString(secret),
+		Events:  subscribed,
+		Enabled: true,
+	}
+	if err := s.db.CreateWebhook(hook); err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// TestFire sends a ping to one of a user's webhooks right away, the result shows up in its delivery log
+func (s *Service) TestFire(ctx context.Context, userID, webhookID int64) error {
+	hook, err := s.db.GetWebhook(webhookID)
+	if err != nil {
+		return err
+	}
+	if hook == nil || hook.UserID != userID {
+		return errors.New("webhook not found")
+	}
+
+	body, err := json.Marshal(Payload{Event: EventPing, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	// sent here rather than by the worker, which would otherwise pick it up too
+	d := &models.WebhookDelivery{WebhookID: hook.ID, UserID: userID, Event: EventPing, Payload: string(body), Status: models.WebhookDeliverySending}
+	if err := s.db.CreateWebhookDelivery(d); err != nil {
+		return err
+	}
+	s.process(ctx, d)
+	return nil
+}
+
+// webhookView a webhook with its recent deliveries for the webhooks page
+type webhookView struct {
+	*models.Webhook
+	Deliveries []*models.WebhookDelivery
+}
+
+// HandleWebhooks shows a user's webhooks (GET) and adds, removes, pauses or tests them (POST)
+func (s *Service) HandleWebhooks(pg *pages.Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := session.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var formError string
+		switch r.Method {
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "Invalid form", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if r.FormValue("action") == "add" {
+				_, err = s.AddWebhook(userID, r.FormValue("url"), r.Form["events"])
+			} else {
+				var webhookID int64
+				webhookID, err = strconv.ParseInt(r.FormValue("id"), 10, 64)
+				if err == nil {
+					switch r.FormValue("action") {
+					case "delete":
+						err = s.db.DeleteWebhook(userID, webhookID)
+					case "pause":
+						err = s.db.SetWebhookEnabled(userID, webhookID, false)
+					case "resume":
+						err = s.db.SetWebhookEnabled(userID, webhookID, true)
+					case "test":
+						err = s.TestFire(r.Context(), userID, webhookID)
+					default:
+						err = errors.New("unknown action")
+					}
+				}
+			}
+			if err == nil {
+				http.Redirect(w, r, "/webhooks", http.StatusSeeOther)
+				return
+			}
+			s.logger.ErrorContext(r.Context(), "error updating webhooks", logging.UserID(userID), logging.Err(err))
+			formError = err.Error()
+		case http.MethodGet:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hooks, err := s.db.GetUserWebhooks(userID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error loading webhooks", logging.UserID(userID), logging.Err(err))
+			http.Error(w, "Failed to load webhooks", http.StatusInternalServerError)
+			return
+		}
+		views := make([]webhookView, 0, len(hooks))
+		for _, hook := range hooks {
+			deliveries, err := s.db.GetWebhookDeliveries(hook.ID, deliveryLogSize)
+			if err != nil {
+				s.logger.ErrorContext(r.Context(), "error loading webhook deliveries", "webhook_id", hook.ID, logging.Err(err))
+			}
+			views = append(views, webhookView{Webhook: hook, Deliveries: deliveries})
+		}
+
+		lastfmUsername := ""
+		user, err := s.db.GetUserByID(userID)
+		if err == nil && user != nil && user.LastFMUsername != nil {
+			lastfmUsername = *user.LastFMUsername
+		} else if err != nil {
+			s.logger.ErrorContext(r.Context(), "error fetching user details for webhooks page", logging.UserID(userID), logging.Err(err))
+		}
+
+		data := struct {
+			Webhooks []webhookView
+			Events   []string
+			Error    string
+			NavBar   pages.NavBar
+		}{
+			Webhooks: views,
+			Events:   Events,
+			Error:    formError,
+			NavBar: pages.NavBar{
+				IsLoggedIn:        true,
+				LastFMUsername:    lastfmUsername,
+				SpotifyEnabled:    viper.GetBool("enable_spotify"),
+				LastFMEnabled:     viper.GetBool("enable_lastfm"),
+				AppleMusicEnabled: viper.GetBool("enable_applemusic"),
+			},
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if formError != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		if err := pg.Execute("webhooks", w, data); err != nil {
+			s.logger.ErrorContext(r.Context(), "error executing template", logging.Err(err))
+		}
+	}
+}
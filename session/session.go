@@ -6,13 +6,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/teal-fm/piper/db"
 	"github.com/teal-fm/piper/db/apikey"
+	"github.com/teal-fm/piper/logging"
 )
 
 // Session session/session.go. Web session for Piper
@@ -29,9 +30,11 @@ type Manager struct {
 	sessions  map[string]*Session // use in memory cache if necessary
 	ApiKeyMgr *apikey.Manager
 	mu        sync.RWMutex
+	logger    *slog.Logger
 }
 
 func NewSessionManager(database *db.DB) *Manager {
+	logger := logging.For("session")
 
 	_, err := database.Exec(`
 	CREATE TABLE IF NOT EXISTS sessions (
@@ -44,7 +47,7 @@ func NewSessionManager(database *db.DB) *Manager {
 	)`)
 
 	if err != nil {
-		log.Printf("Error creating sessions table: %v", err)
+		logger.Error("error creating sessions table", logging.Err(err))
 	}
 
 	apiKeyMgr := apikey.NewApiKeyManager(database)
@@ -53,6 +56,7 @@ func NewSessionManager(database *db.DB) *Manager {
 		db:        database,
 		sessions:  make(map[string]*Session),
 		ApiKeyMgr: apiKeyMgr,
+		logger:    logger,
 	}
 }
 
@@ -89,7 +93,7 @@ func (sm *Manager) CreateSession(userID int64, atProtoSessionId string) *Session
 			sessionID, userID, atProtoSessionId, now, expiresAt)
 
 		if err != nil {
-			log.Printf("Error storing session in database: %v", err)
+			sm.logger.Error("error storing session", logging.UserID(userID), logging.Err(err))
 		}
 	}
 
@@ -150,7 +154,18 @@ func (sm *Manager) DeleteSession(sessionID string) {
 	if sm.db != nil {
 		_, err := sm.db.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
 		if err != nil {
-			log.Printf("Error deleting session from database: %v", err)
+			sm.logger.Error("error deleting session", logging.Err(err))
+		}
+	}
+}
+
+// EvictUser drops every cached session belonging to a user. Used when the user's rows are already gone
+func (sm *Manager) EvictUser(userID int64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for id, session := range sm.sessions {
+		if session.UserID == userID {
+			delete(sm.sessions, id)
 		}
 	}
 }
@@ -164,6 +179,7 @@ func (sm *Manager) SetSessionCookie(w http.ResponseWriter, session *Session) {
 		HttpOnly: true,
 		Secure:   false,
 		Expires:  session.ExpiresAt,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, cookie)
 }
@@ -177,6 +193,7 @@ func (sm *Manager) ClearSessionCookie(w http.ResponseWriter) {
 		HttpOnly: true,
 		Secure:   false,
 		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, cookie)
 }
@@ -223,6 +240,7 @@ func WithAuth(handler http.HandlerFunc, sm *Manager) http.HandlerFunc {
 		}
 
 		ctx := WithUserID(r.Context(), session.UserID)
+		ctx = WithSessionID(ctx, session.ID)
 		r = r.WithContext(ctx)
 
 		handler(w, r)
@@ -254,6 +272,7 @@ func WithPossibleAuth(handler http.HandlerFunc, sm *Manager) http.HandlerFunc {
 				session, exists := sm.GetSession(cookie.Value)
 				if exists {
 					ctx = WithUserID(ctx, session.UserID)
+					ctx = WithSessionID(ctx, session.ID)
 					authenticated = true
 				}
 			}
@@ -273,7 +292,7 @@ func WithAPIAuth(handler http.HandlerFunc, sm *Manager) http.HandlerFunc {
 			w.WriteHeader(http.StatusUnauthorized)
 			_, err := w.Write([]byte(`{"error": "API key is required"}`))
 			if err != nil {
-				log.Printf("Error writing error response: %v", err)
+				sm.logger.ErrorContext(r.Context(), "error writing error response", logging.Err(err))
 				return
 			}
 			return
@@ -285,7 +304,7 @@ func WithAPIAuth(handler http.HandlerFunc, sm *Manager) http.HandlerFunc {
 			w.WriteHeader(http.StatusUnauthorized)
 			_, err := w.Write([]byte(`{"error": "Invalid or expired API key"}`))
 			if err != nil {
-				log.Printf("Error writing error response: %v", err)
+				sm.logger.ErrorContext(r.Context(), "error writing error response", logging.Err(err))
 				return
 			}
 			return
@@ -307,7 +326,7 @@ func (sm *Manager) HandleDebug(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusUnauthorized)
 		_, err := w.Write([]byte(`{"error": "User ID not found in context"}`))
 		if err != nil {
-			log.Printf("Error writing error response: %v", err)
+			sm.logger.ErrorContext(r.Context(), "error writing error response", logging.Err(err))
 			return
 		}
 		return
@@ -319,7 +338,7 @@ func (sm *Manager) HandleDebug(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		_, err := w.Write([]byte(fmt.Sprintf(`{"error": "Failed to retrieve user information: %v"}`, err)))
 		if err != nil {
-			log.Printf("Error writing error response: %v", err)
+			sm.logger.ErrorContext(r.Context(), "error writing error response", logging.Err(err))
 			return
 		}
 		return
@@ -329,7 +348,7 @@ func (sm *Manager) HandleDebug(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+		sm.logger.ErrorContext(r.Context(), "error encoding JSON response", logging.Err(err))
 		return
 	}
 }
@@ -340,6 +359,7 @@ const (
 	userIDKey contextKey = iota
 	apiRequestKey
 	authStatusKey
+	sessionIDKey
 )
 
 func WithUserID(ctx context.Context, userID int64) context.Context {
@@ -351,6 +371,17 @@ func GetUserID(ctx context.Context) (int64, bool) {
 	return userID, ok
 }
 
+// WithSessionID stores the id of the web session the request was authenticated with.
+// Not set for API key requests
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+func GetSessionID(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey).(string)
+	return sessionID, ok && sessionID != ""
+}
+
 func WithAuthStatus(ctx context.Context, isAuthed bool) context.Context {
 	return context.WithValue(ctx, authStatusKey, isAuthed)
 }